@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// defaultAccessLogMaxSize is the default size (in bytes) a volume's access log is rotated at (see
+// `WithAccessLogMaxSize`)
+const defaultAccessLogMaxSize = 1 << 20
+
+// AccessLogEntry is a line of a volume's access log: a successful mount or unmount of the volume (see
+// `DockerOnTop.TailAccessLog`)
+type AccessLogEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Operation is either "Mount" or "Unmount"
+	Operation string `json:"operation"`
+	// ContainerID identifies the container. The plugin API identifies it only by the mount ID, so that's what it is
+	ContainerID string `json:"containerID"`
+}
+
+// accessLogPath returns the path of the volume's access log. When it's rotated, the previous entries are kept in the
+// same file with the ".1" suffix.
+func (d *DockerOnTop) accessLogPath(volumeName string) string {
+	return d.volumeDir(volumeName) + "/access.log"
+}
+
+// recordAccess appends an entry to the volume's access log, rotating the log if it would grow larger than
+// `d.accessLogMaxSize`. Failing to write the entry is only logged.
+func (d *DockerOnTop) recordAccess(operation string, volumeName string, containerID string) {
+	if d.DryRun {
+		// Nothing was done in a dry run
+		return
+	}
+	payload, err := json.Marshal(AccessLogEntry{
+		Timestamp:   time.Now().UTC(),
+		Operation:   operation,
+		ContainerID: containerID,
+	})
+	if err != nil {
+		// Can't happen
+		d.logger.Error("Failed to marshal an access log entry", "error", err)
+		return
+	}
+	payload = append(payload, '\n')
+
+	path := d.accessLogPath(volumeName)
+	d.accessLogMutex.Lock()
+	defer d.accessLogMutex.Unlock()
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(payload)) > d.accessLogMaxSize {
+		// The rotated log is in the same directory, so it's a simple rename
+		if err := os.Rename(path, path+".1"); err != nil {
+			d.logger.Error("Failed to rotate the access log", "volume", volumeName, "error", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err == nil {
+		_, err = f.Write(payload)
+		err = errors.Join(err, f.Close())
+	}
+	if err != nil {
+		d.logger.Error("Failed to write to the access log", "operation", operation, "volume", volumeName, "error", err)
+	}
+}
+
+// TailAccessLog returns the last `lines` entries of the volume's access log (including the rotated part), oldest
+// first. If `lines` is not positive, all the entries are returned.
+func (d *DockerOnTop) TailAccessLog(volumeName string, lines int) ([]AccessLogEntry, error) {
+	d.logger.Debug("Request TailAccessLog", "volume", volumeName, "lines", lines)
+
+	if _, err := d.getVolumeInfoOrNotFound(volumeName); err != nil {
+		return nil, err
+	}
+
+	path := d.accessLogPath(volumeName)
+	d.accessLogMutex.Lock()
+	defer d.accessLogMutex.Unlock()
+	var entries []AccessLogEntry
+	for _, file := range []string{path + ".1", path} {
+		fileEntries, err := readAccessLog(d.logger, file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, d.internalError("failed to read the access log", err, "volume", volumeName, "path", file)
+		}
+		entries = append(entries, fileEntries...)
+	}
+	if lines > 0 && len(entries) > lines {
+		entries = entries[len(entries)-lines:]
+	}
+	return entries, nil
+}
+
+// readAccessLog parses the access log file at `path`. Malformed lines (e.g. the last one, if a write was
+// interrupted) are skipped with a warning.
+func readAccessLog(logger *slog.Logger, path string) ([]AccessLogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AccessLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AccessLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Warn("Skipping malformed access log entry", "path", path, "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
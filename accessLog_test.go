@@ -0,0 +1,117 @@
+//go:build dottest
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// accessLogOperations returns the entries as "Operation ContainerID" strings.
+func accessLogOperations(entries []AccessLogEntry) []string {
+	operations := make([]string, len(entries))
+	for i, entry := range entries {
+		operations[i] = entry.Operation + " " + entry.ContainerID
+	}
+	return operations
+}
+
+func TestAccessLog(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	if entries, err := d.TailAccessLog("vol", 0); err != nil || len(entries) != 0 {
+		t.Errorf("For a new volume, TailAccessLog = %v, %v; want no entries", entries, err)
+	}
+
+	start := time.Now()
+	MustMountVolume(t, d, "vol", "first")
+	MustMountVolume(t, d, "vol", "second")
+	MustUnmountVolume(t, d, "vol", "first")
+	MustUnmountVolume(t, d, "vol", "second")
+	MustMountVolume(t, d, "vol", "third")
+
+	entries, err := d.TailAccessLog("vol", 0)
+	if err != nil {
+		t.Fatalf("TailAccessLog failed: %v", err)
+	}
+	want := []string{"Mount first", "Mount second", "Unmount first", "Unmount second", "Mount third"}
+	if operations := accessLogOperations(entries); !reflect.DeepEqual(operations, want) {
+		t.Errorf("The access log has %q, want %q", operations, want)
+	}
+	for i, entry := range entries {
+		if entry.Timestamp.Before(start.Add(-time.Second)) || entry.Timestamp.After(time.Now()) ||
+			i > 0 && entry.Timestamp.Before(entries[i-1].Timestamp) {
+			t.Errorf("Entry %d has the timestamp %v", i, entry.Timestamp)
+		}
+	}
+
+	if entries, err := d.TailAccessLog("vol", 2); err != nil ||
+		!reflect.DeepEqual(accessLogOperations(entries), want[3:]) {
+		t.Errorf("TailAccessLog(2) = %q, %v; want %q", accessLogOperations(entries), err, want[3:])
+	}
+
+	// A malformed line (like an interrupted write) is skipped
+	f, err := os.OpenFile(d.accessLogPath("vol"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"timestamp": "2026-`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if entries, err := d.TailAccessLog("vol", 1); err != nil ||
+		!reflect.DeepEqual(accessLogOperations(entries), want[4:]) {
+		t.Errorf("With a malformed line, TailAccessLog(1) = %q, %v; want %q", accessLogOperations(entries), err,
+			want[4:])
+	}
+	MustUnmountVolume(t, d, "vol", "third")
+
+	if _, err := d.TailAccessLog("missing", 0); err == nil {
+		t.Error("TailAccessLog succeeded for a missing volume")
+	}
+}
+
+func TestAccessLogRotation(t *testing.T) {
+	const maxSize = 500
+	d := NewTestDockerOnTop(t, WithAccessLogMaxSize(maxSize))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+
+	const mounts = 20
+	for i := 0; i < mounts; i++ {
+		MustMountVolume(t, d, "vol", fmt.Sprintf("container%02d", i))
+		MustUnmountVolume(t, d, "vol", fmt.Sprintf("container%02d", i))
+	}
+
+	path := d.accessLogPath("vol")
+	for _, file := range []string{path, path + ".1"} {
+		if info, err := os.Stat(file); err != nil || info.Size() > maxSize {
+			t.Errorf("Stat(%s) = %v, %v; want a file of at most %d bytes", file, info, err, maxSize)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("More than one rotated log is kept (%v)", err)
+	}
+
+	entries, err := d.TailAccessLog("vol", 0)
+	if err != nil {
+		t.Fatalf("TailAccessLog failed: %v", err)
+	}
+	// The oldest entries are dropped, the newest ones are in order, whichever file they're in
+	if len(entries) == 0 || len(entries) >= 2*mounts {
+		t.Fatalf("The access log has %d entries, want fewer than %d", len(entries), 2*mounts)
+	}
+	operations := accessLogOperations(entries)
+	for i := range operations {
+		j := 2*mounts - len(operations) + i
+		want := fmt.Sprintf("Mount container%02d", j/2)
+		if j%2 == 1 {
+			want = fmt.Sprintf("Unmount container%02d", j/2)
+		}
+		if operations[i] != want {
+			t.Errorf("Entry %d of the access log is %q, want %q", i, operations[i], want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"sort"
+)
+
+// ActiveMountSummary describes a container using a volume, as reported by `ListActiveMounts`
+type ActiveMountSummary struct {
+	VolumeName string
+	// ContainerID is the ID of the mount request (the name of the active mount file)
+	ContainerID string
+	// UsageCount is the number of times the container has mounted the volume. Always 1, as repeated mounts by the same
+	// container are not tracked separately
+	UsageCount  int
+	BaseDirPath string
+}
+
+// ListActiveMounts returns the active mounts of all the volumes, sorted by volume name and then by container ID.
+//
+// No locks are taken, so the result is only a snapshot that may get outdated at any moment (and may miss the volumes
+// being mounted or unmounted at the time). The volumes that cannot be read, e.g. because they are being removed, are
+// skipped.
+func (d *DockerOnTop) ListActiveMounts() ([]ActiveMountSummary, error) {
+	volumeNames, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(volumeNames)
+
+	var summaries []ActiveMountSummary
+	for _, volumeName := range volumeNames {
+		dir, err := os.Open(d.activemountsdir(volumeName))
+		if err != nil {
+			continue
+		}
+		ids, err := dir.Readdirnames(-1)
+		_ = dir.Close()
+		if err != nil || len(ids) == 0 {
+			continue
+		}
+
+		var baseDirPath string
+		if thisVol, err := d.getVolumeInfo(volumeName); err != nil {
+			d.logger.Warn("Failed to retrieve metadata for the volume", "volume", volumeName, "error", err)
+		} else {
+			baseDirPath = thisVol.BaseDirPath
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			summaries = append(summaries, ActiveMountSummary{
+				VolumeName:  volumeName,
+				ContainerID: id,
+				UsageCount:  1,
+				BaseDirPath: baseDirPath,
+			})
+		}
+	}
+	return summaries, nil
+}
@@ -0,0 +1,58 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListActiveMounts(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	if summaries, err := d.ListActiveMounts(); err != nil || len(summaries) != 0 {
+		t.Errorf("Without volumes, ListActiveMounts = %v, %v; want nothing", summaries, err)
+	}
+
+	baseA, baseC := t.TempDir(), t.TempDir()
+	MustCreateVolume(t, d, "a", baseA)
+	MustCreateVolume(t, d, "b", t.TempDir())
+	MustCreateVolume(t, d, "c", baseC)
+	MustMountVolume(t, d, "a", "second")
+	MustMountVolume(t, d, "a", "first")
+	// An active mount file written by something else than `Mount`
+	if err := os.WriteFile(d.activemountsdir("c")+"fake", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := d.lockVolume("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	type result struct {
+		summaries []ActiveMountSummary
+		err       error
+	}
+	done := make(chan result)
+	go func() {
+		summaries, err := d.ListActiveMounts()
+		done <- result{summaries, err}
+	}()
+	var got result
+	select {
+	case got = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListActiveMounts waits for the lock of a volume")
+	}
+
+	want := []ActiveMountSummary{
+		{VolumeName: "a", ContainerID: "first", UsageCount: 1, BaseDirPath: baseA},
+		{VolumeName: "a", ContainerID: "second", UsageCount: 1, BaseDirPath: baseA},
+		{VolumeName: "c", ContainerID: "fake", UsageCount: 1, BaseDirPath: baseC},
+	}
+	if got.err != nil || !reflect.DeepEqual(got.summaries, want) {
+		t.Errorf("ListActiveMounts = %+v, %v; want %+v", got.summaries, got.err, want)
+	}
+}
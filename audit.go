@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// auditRecord is a line of the audit log (see `DockerOnTop.AuditLog`)
+type auditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Operation string    `json:"operation"`
+	Volume    string    `json:"volumeName"`
+	MountID   string    `json:"mountID,omitempty"`
+	// ContainerID identifies the container using the volume. The plugin API identifies it only by the mount ID, so
+	// it's the same as `MountID` (like in `VolumeEvent`)
+	ContainerID string `json:"containerID,omitempty"`
+	BaseDirPath string `json:"baseDirPath,omitempty"`
+}
+
+// NewFileAuditLog opens the file at `path` for appending the audit log records to it, creating it if needed. The file
+// is meant to be used as `DockerOnTop.AuditLog`.
+func NewFileAuditLog(path string) (io.WriteCloser, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+}
+
+// audit appends a record of a successful operation to `d.AuditLog` (if it's set). `baseDirPath` is looked up in the
+// volume's metadata if it's empty. Failing to write the record is only logged.
+func (d *DockerOnTop) audit(operation string, volumeName string, mountID string, baseDirPath string) {
+	if d.AuditLog == nil || d.DryRun {
+		// Nothing was done in a dry run
+		return
+	}
+	if baseDirPath == "" {
+		if thisVol, err := d.getVolumeInfo(volumeName); err == nil {
+			baseDirPath = thisVol.BaseDirPath
+		}
+	}
+	payload, err := json.Marshal(auditRecord{
+		Timestamp:   time.Now().UTC(),
+		Operation:   operation,
+		Volume:      volumeName,
+		MountID:     mountID,
+		ContainerID: mountID,
+		BaseDirPath: baseDirPath,
+	})
+	if err != nil {
+		// Can't happen
+		d.logger.Error("Failed to marshal an audit record", "error", err)
+		return
+	}
+
+	d.auditMutex.Lock()
+	defer d.auditMutex.Unlock()
+	// A single write per record, so that the lines of concurrent operations aren't interleaved
+	if _, err := d.AuditLog.Write(append(payload, '\n')); err != nil {
+		d.logger.Error("Failed to write to the audit log", "operation", operation, "volume", volumeName, "error", err)
+	}
+}
@@ -0,0 +1,123 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// decodeAuditRecords parses the JSON lines of an audit log.
+func decodeAuditRecords(t *testing.T, log []byte) []auditRecord {
+	t.Helper()
+	var records []auditRecord
+	for _, line := range strings.Split(strings.TrimSuffix(string(log), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var record auditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("The audit log line %q isn't valid JSON: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	var log bytes.Buffer
+	d.AuditLog = &log
+	base := t.TempDir()
+
+	start := time.Now().UTC()
+	MustCreateVolume(t, d, "vol", base)
+	MustMountVolume(t, d, "vol", "container")
+	MustUnmountVolume(t, d, "vol", "container")
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed operations and the removal of a nonexistent volume aren't recorded
+	err := d.Create(&volume.CreateRequest{Name: "bad", Options: map[string]string{"base": base + "/missing"}})
+	if err == nil {
+		t.Error("Creating a volume with a missing base directory succeeded")
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"}); err == nil {
+		t.Error("Mounting a removed volume succeeded")
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Errorf("Removing a nonexistent volume failed: %v", err)
+	}
+
+	want := []auditRecord{
+		{Operation: "Create", Volume: "vol", BaseDirPath: base},
+		{Operation: "Mount", Volume: "vol", MountID: "container", ContainerID: "container", BaseDirPath: base},
+		{Operation: "Unmount", Volume: "vol", MountID: "container", ContainerID: "container", BaseDirPath: base},
+		{Operation: "Remove", Volume: "vol", BaseDirPath: base},
+	}
+	records := decodeAuditRecords(t, log.Bytes())
+	if len(records) != len(want) {
+		t.Fatalf("The audit log has %d records, want %d:\n%s", len(records), len(want), log.String())
+	}
+	for i, record := range records {
+		if record.Timestamp.Before(start.Truncate(time.Second)) || record.Timestamp.After(time.Now().UTC()) {
+			t.Errorf("Record %d has the timestamp %v, not during the test", i, record.Timestamp)
+		}
+		record.Timestamp = time.Time{}
+		if record != want[i] {
+			t.Errorf("Record %d = %+v, want %+v", i, record, want[i])
+		}
+	}
+}
+
+func TestAuditLogDryRun(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	var log bytes.Buffer
+	d.AuditLog = &log
+	d.DryRun = true
+
+	base := t.TempDir()
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"base": base}}); err != nil {
+		t.Fatalf("Create failed in a dry run: %v", err)
+	}
+	if log.Len() != 0 {
+		t.Errorf("A dry run wrote to the audit log: %q", log.String())
+	}
+}
+
+func TestNewFileAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for _, name := range []string{"first", "second"} {
+		auditLog, err := NewFileAuditLog(path)
+		if err != nil {
+			t.Fatalf("NewFileAuditLog failed: %v", err)
+		}
+		d := NewTestDockerOnTop(t)
+		d.AuditLog = auditLog
+		MustCreateVolume(t, d, name, t.TempDir())
+		if err := auditLog.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reopening the file appends to it
+	records := decodeAuditRecords(t, contents)
+	if len(records) != 2 || records[0].Volume != "first" || records[1].Volume != "second" {
+		t.Errorf("The audit log contains %+v; want the creation of first and second", records)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("The audit log's mode is %v, %v; want 0600", info.Mode(), err)
+	}
+}
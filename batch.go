@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// BatchCreate creates the volumes in order, so that either all of them are created or none. If a creation fails, the
+// volumes created so far are removed (in reverse order) and the error is returned. The rollback is best-effort: its
+// failures are only logged.
+//
+// No lock is taken across the volumes: every creation is safe on its own, but other requests may see a part of the
+// batch in the meantime.
+func (d *DockerOnTop) BatchCreate(requests []volume.CreateRequest) error {
+	for i := range requests {
+		err := d.Create(&requests[i])
+		if err == nil {
+			continue
+		}
+
+		d.logger.Warn("Failed to create a volume of the batch. Removing the ones created so far", "volume",
+			requests[i].Name, "created", i, "error", err)
+		for j := i - 1; j >= 0; j-- {
+			d.rollbackCreate(requests[j].Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// rollbackCreate removes the volume that has just been created, including its custom upperdir (which is empty, unlike
+// that of a volume that has been used). Errors are logged.
+func (d *DockerOnTop) rollbackCreate(volumeName string) {
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		d.logger.Warn("Failed to retrieve metadata for the volume being rolled back", "volume", volumeName,
+			"error", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: volumeName}); err != nil {
+		d.logger.Error("Failed to remove the volume on rollback", "volume", volumeName, "error", err)
+		return
+	}
+	if thisVol.CustomUpperDir != "" {
+		if err := os.Remove(thisVol.CustomUpperDir); err != nil {
+			d.logger.Warn("Failed to remove the custom upperdir on rollback", "volume", volumeName, "path",
+				thisVol.CustomUpperDir, "error", err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestBatchCreate(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	var requests []volume.CreateRequest
+	for _, name := range []string{"first", "second", "third"} {
+		requests = append(requests, volume.CreateRequest{Name: name, Options: map[string]string{"base": base}})
+	}
+	if err := d.BatchCreate(requests); err != nil {
+		t.Fatalf("BatchCreate failed: %v", err)
+	}
+	for _, request := range requests {
+		if _, err := d.Get(&volume.GetRequest{Name: request.Name}); err != nil {
+			t.Errorf("Get(%s) failed: %v", request.Name, err)
+		}
+	}
+}
+
+func TestBatchCreateRollback(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	customDirs := t.TempDir()
+	requests := []volume.CreateRequest{
+		{Name: "vol1", Options: map[string]string{"base": base}},
+		{Name: "vol2", Options: map[string]string{
+			"base":  base,
+			"upper": customDirs + "/upper",
+			"work":  customDirs + "/work",
+		}},
+		{Name: "vol3", Options: map[string]string{"base": base + "/missing"}},
+		{Name: "vol4", Options: map[string]string{"base": base}},
+		{Name: "vol5", Options: map[string]string{"base": base}},
+	}
+	if err := d.BatchCreate(requests); err == nil {
+		t.Fatal("BatchCreate succeeded although a creation failed")
+	}
+	for _, request := range requests {
+		if _, err := d.Get(&volume.GetRequest{Name: request.Name}); err == nil {
+			t.Errorf("%s exists after the failed batch", request.Name)
+		}
+		if _, err := os.Stat(d.volumeDir(request.Name)); !os.IsNotExist(err) {
+			t.Errorf("The main directory of %s exists after the failed batch (%v)", request.Name, err)
+		}
+	}
+	if _, err := os.Stat(customDirs + "/upper"); !os.IsNotExist(err) {
+		t.Errorf("The custom upperdir is left after the rollback (%v)", err)
+	}
+
+	// The names are free again
+	if err := d.BatchCreate(requests[:2]); err != nil {
+		t.Errorf("Creating the rolled back volumes again failed: %v", err)
+	}
+}
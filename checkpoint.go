@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// checkpointsdir returns the directory the volume's checkpoints are stored in (see `CreateCheckpoint`).
+func (d *DockerOnTop) checkpointsdir(volumeName string) string {
+	return d.volumeDir(volumeName) + "/checkpoints/"
+}
+
+// checkCheckpointName checks that the checkpoint name is valid: it has the same format as the volume names.
+func checkCheckpointName(checkpointName string) error {
+	if !volNameFormat.MatchString(checkpointName) {
+		return errors.New("checkpoint name contains illegal characters: it should comply to " +
+			"\"[a-zA-Z0-9][a-zA-Z0-9_.-]*\"")
+	}
+	return nil
+}
+
+// CreateCheckpoint saves the current state of the volume's upperdir (that is, the changes made to the volume) as the
+// checkpoint `checkpointName`, stored in `checkpoints/<checkpointName>/` inside the volume's main directory. The
+// checkpoint keeps the file modes, ownership, timestamps, extended attributes, and whiteouts. The files are reflinked
+// where the filesystem supports it (so that checkpoints are cheap) and copied otherwise. Hard links are not used, as
+// the overlay modifies the files of the upperdir in place, which would change the checkpoint as well.
+//
+// The checkpoint appears atomically: it is first copied under a temporary name. If the volume is in use, the
+// checkpoint is still created (with a warning), but it may be inconsistent if the volume is being written to.
+// Checkpoints survive `Reset` and are removed together with the volume.
+func (d *DockerOnTop) CreateCheckpoint(volumeName, checkpointName string) error {
+	d.logger.Debug("Request CreateCheckpoint", "volume", volumeName, "checkpoint", checkpointName)
+
+	thisVol, err := d.getVolumeInfoOrNotFound(volumeName)
+	if err != nil {
+		return err
+	}
+	if err := checkCheckpointName(checkpointName); err != nil {
+		return err
+	}
+
+	// The lock keeps the upperdir from being replaced (by `Reset` and the like) while it's being copied
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
+		return err
+	}
+	defer unlock()
+	if mounted, err := d.volumeIsMounted(volumeName); err == nil && mounted {
+		d.logger.Warn("Creating a checkpoint of the volume while it is in use. The checkpoint may be inconsistent",
+			"volume", volumeName, "checkpoint", checkpointName)
+	}
+
+	checkpoint := d.checkpointsdir(volumeName) + checkpointName
+	if _, err := os.Lstat(checkpoint); err == nil {
+		return fmt.Errorf("checkpoint %s already exists", checkpointName)
+	}
+	if err := os.MkdirAll(d.checkpointsdir(volumeName), os.ModePerm); err != nil {
+		return d.internalError("failed to create the checkpoints directory", err, "volume", volumeName)
+	}
+	tmpCheckpoint, err := os.MkdirTemp(d.checkpointsdir(volumeName), scratchDirPrefix)
+	if err != nil {
+		return d.internalError("failed to create a temporary checkpoint directory", err, "volume", volumeName)
+	}
+	if err := copyTree(d.upperdir(volumeName, &thisVol), tmpCheckpoint, cloneFile, nil, d.logger); err != nil {
+		_ = os.RemoveAll(tmpCheckpoint)
+		return d.internalError("failed to copy the upperdir", err, "volume", volumeName, "checkpoint", checkpointName)
+	}
+	if err := os.Rename(tmpCheckpoint, checkpoint); err != nil {
+		_ = os.RemoveAll(tmpCheckpoint)
+		return d.internalError("failed to store the checkpoint", err, "volume", volumeName, "checkpoint",
+			checkpointName)
+	}
+	d.logger.Info("Created checkpoint", "volume", volumeName, "checkpoint", checkpointName)
+	return nil
+}
+
+// ListCheckpoints returns the names of the volume's checkpoints, sorted.
+func (d *DockerOnTop) ListCheckpoints(volumeName string) ([]string, error) {
+	d.logger.Debug("Request ListCheckpoints", "volume", volumeName)
+
+	if _, err := d.getVolumeInfoOrNotFound(volumeName); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(d.checkpointsdir(volumeName))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, d.internalError("failed to list the checkpoints", err, "volume", volumeName)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && !isScratchDir(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RestoreCheckpoint replaces the volume's upperdir with a copy of the checkpoint `checkpointName` (which is kept), so
+// that the volume shows the state it had when the checkpoint was created. The current changes are discarded.
+//
+// The volume must not be in use.
+func (d *DockerOnTop) RestoreCheckpoint(volumeName, checkpointName string) error {
+	d.logger.Debug("Request RestoreCheckpoint", "volume", volumeName, "checkpoint", checkpointName)
+
+	thisVol, err := d.getVolumeInfoOrNotFound(volumeName)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := d.existingCheckpoint(volumeName, checkpointName)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lockIdleVolume(volumeName, "restore a checkpoint")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	upperdir := strings.TrimSuffix(d.upperdir(volumeName, &thisVol), "/")
+	restored := upperdir + ".restore"
+	discarded := upperdir + ".discard"
+	// Leftovers of an interrupted restore, if any
+	_ = os.RemoveAll(restored)
+	_ = os.RemoveAll(discarded)
+
+	if err := copyTree(checkpoint, restored, cloneFile, nil, d.logger); err != nil {
+		_ = os.RemoveAll(restored)
+		return d.internalError("failed to copy the checkpoint", err, "volume", volumeName, "checkpoint", checkpointName)
+	}
+	if err := os.Rename(upperdir, discarded); err != nil {
+		_ = os.RemoveAll(restored)
+		return d.internalError("failed to move the upperdir aside", err, "volume", volumeName)
+	}
+	if err := os.Rename(restored, upperdir); err != nil {
+		d.logger.Error("Failed to put the restored upperdir in place. Restoring the old one", "volume", volumeName,
+			"error", err)
+		if restoreErr := os.Rename(discarded, upperdir); restoreErr != nil {
+			d.logCritical("Failed to restore the upperdir. Human interaction is required", "volume", volumeName,
+				"path", discarded, "error", restoreErr)
+		}
+		_ = os.RemoveAll(restored)
+		return d.internalError("failed to put the restored upperdir in place", err)
+	}
+	if err := os.RemoveAll(discarded); err != nil {
+		d.logger.Warn("Failed to remove the discarded upperdir", "volume", volumeName, "path", discarded,
+			"error", err)
+	}
+	// The workdir may contain leftovers referring to the old upperdir
+	if err := os.RemoveAll(d.workdir(volumeName, &thisVol)); err != nil {
+		d.logger.Warn("Failed to remove the workdir", "volume", volumeName, "error", err)
+	}
+	d.logger.Info("Restored checkpoint", "volume", volumeName, "checkpoint", checkpointName)
+	return nil
+}
+
+// DeleteCheckpoint removes the checkpoint `checkpointName` of the volume.
+func (d *DockerOnTop) DeleteCheckpoint(volumeName, checkpointName string) error {
+	d.logger.Debug("Request DeleteCheckpoint", "volume", volumeName, "checkpoint", checkpointName)
+
+	if _, err := d.getVolumeInfoOrNotFound(volumeName); err != nil {
+		return err
+	}
+	checkpoint, err := d.existingCheckpoint(volumeName, checkpointName)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(checkpoint); err != nil {
+		return d.internalError("failed to remove the checkpoint", err, "volume", volumeName, "checkpoint",
+			checkpointName)
+	}
+	d.logger.Info("Deleted checkpoint", "volume", volumeName, "checkpoint", checkpointName)
+	return nil
+}
+
+// existingCheckpoint returns the directory of the volume's checkpoint, or an error if there's no such checkpoint.
+func (d *DockerOnTop) existingCheckpoint(volumeName, checkpointName string) (string, error) {
+	if err := checkCheckpointName(checkpointName); err != nil {
+		return "", err
+	}
+	checkpoint := d.checkpointsdir(volumeName) + checkpointName
+	if info, err := os.Stat(checkpoint); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("no such checkpoint %s", checkpointName)
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,157 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestCheckpoints(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upperdir := d.upperdir("vol", &vol)
+	writeFiles(t, upperdir, map[string]string{"file": "first", "dir/nested": "first"})
+	if err := os.Chmod(upperdir+"dir/nested", 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(upperdir+"file", "trusted.test", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	makeWhiteouts(t, upperdir, "deleted")
+	first := describeTree(t, upperdir)
+
+	if names, err := d.ListCheckpoints("vol"); err != nil || len(names) != 0 {
+		t.Errorf("Before creating checkpoints, ListCheckpoints = %v, %v", names, err)
+	}
+	if err := d.CreateCheckpoint("vol", "one"); err != nil {
+		t.Fatalf("CreateCheckpoint failed: %v", err)
+	}
+	// Modifying a file in place (as the overlay does) doesn't modify the checkpoint
+	writeFiles(t, upperdir, map[string]string{"file": "second"})
+	if err := d.CreateCheckpoint("vol", "two"); err != nil {
+		t.Fatalf("CreateCheckpoint failed: %v", err)
+	}
+	second := describeTree(t, upperdir)
+	if names, err := d.ListCheckpoints("vol"); err != nil || !slices.Equal(names, []string{"one", "two"}) {
+		t.Errorf("ListCheckpoints = %v, %v; want [one two]", names, err)
+	}
+
+	// The checkpoints survive a reset
+	if err := d.Reset("vol", false); err != nil {
+		t.Fatal(err)
+	}
+	if tree := describeTree(t, upperdir); len(tree) != 0 {
+		t.Fatalf("After Reset, the upperdir contains %v", tree)
+	}
+	if names, err := d.ListCheckpoints("vol"); err != nil || !slices.Equal(names, []string{"one", "two"}) {
+		t.Errorf("After Reset, ListCheckpoints = %v, %v; want [one two]", names, err)
+	}
+
+	for _, checkpoint := range []struct {
+		name string
+		want map[string]string
+	}{{"one", first}, {"two", second}, {"one", first}} {
+		if err := d.RestoreCheckpoint("vol", checkpoint.name); err != nil {
+			t.Fatalf("RestoreCheckpoint(%s) failed: %v", checkpoint.name, err)
+		}
+		if tree := describeTree(t, upperdir); !reflect.DeepEqual(tree, checkpoint.want) {
+			t.Errorf("After restoring %s, the upperdir is %v, want %v", checkpoint.name, tree, checkpoint.want)
+		}
+	}
+
+	if err := d.DeleteCheckpoint("vol", "two"); err != nil {
+		t.Fatalf("DeleteCheckpoint failed: %v", err)
+	}
+	if names, err := d.ListCheckpoints("vol"); err != nil || !slices.Equal(names, []string{"one"}) {
+		t.Errorf("After deleting two, ListCheckpoints = %v, %v; want [one]", names, err)
+	}
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.checkpointsdir("vol")); !os.IsNotExist(err) {
+		t.Errorf("The checkpoints were not removed together with the volume (%v)", err)
+	}
+}
+
+func TestCheckpointsValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	if err := d.CreateCheckpoint("vol", "existing"); err != nil {
+		t.Fatal(err)
+	}
+	for name, err := range map[string]error{
+		"duplicate":            d.CreateCheckpoint("vol", "existing"),
+		"invalid name":         d.CreateCheckpoint("vol", "../escape"),
+		"missing volume":       d.CreateCheckpoint("missing", "checkpoint"),
+		"restore missing":      d.RestoreCheckpoint("vol", "missing"),
+		"restore invalid name": d.RestoreCheckpoint("vol", ".."),
+		"delete missing":       d.DeleteCheckpoint("vol", "missing"),
+		"delete invalid name":  d.DeleteCheckpoint("vol", "."),
+	} {
+		if err == nil {
+			t.Errorf("%s: the call succeeded", name)
+		}
+	}
+	if _, err := d.ListCheckpoints("missing"); err == nil {
+		t.Error("ListCheckpoints succeeded for a missing volume")
+	}
+
+	// A mounted volume can be checkpointed, but not restored
+	MustMountVolume(t, d, "vol", "container")
+	if err := d.CreateCheckpoint("vol", "mounted"); err != nil {
+		t.Errorf("CreateCheckpoint failed for a mounted volume: %v", err)
+	}
+	if err := d.RestoreCheckpoint("vol", "existing"); err == nil {
+		t.Error("RestoreCheckpoint succeeded for a mounted volume")
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
+
+func TestCheckpointsWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"base-file": "base", "deleted": "base"})
+	MustCreateVolume(t, d, "vol", base)
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	writeFiles(t, mountpoint, map[string]string{"base-file": "checkpointed", "added": "checkpointed"})
+	if err := os.Remove(mountpoint + "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+	if err := d.CreateCheckpoint("vol", "checkpoint"); err != nil {
+		t.Fatal(err)
+	}
+
+	mountpoint = MustMountVolume(t, d, "vol", "container")
+	writeFiles(t, mountpoint, map[string]string{"added": "later"})
+	MustUnmountVolume(t, d, "vol", "container")
+	if err := d.Reset("vol", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RestoreCheckpoint("vol", "checkpoint"); err != nil {
+		t.Fatal(err)
+	}
+
+	mountpoint = MustMountVolume(t, d, "vol", "container")
+	for file, want := range map[string]string{"base-file": "checkpointed", "added": "checkpointed"} {
+		if contents, err := os.ReadFile(mountpoint + file); err != nil || string(contents) != want {
+			t.Errorf("After restoring, %s contains %q, %v; want %q", file, contents, err, want)
+		}
+	}
+	if _, err := os.Stat(mountpoint + "deleted"); !os.IsNotExist(err) {
+		t.Errorf("After restoring, the deleted file is back (%v)", err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
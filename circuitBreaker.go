@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Default parameters of `mountCircuitBreaker`
+const (
+	defaultMountBreakerThreshold = 5
+	defaultMountBreakerWindow    = 60 * time.Second
+	defaultMountBreakerCooldown  = 30 * time.Second
+)
+
+// errMountCircuitOpen is returned by `Mount` when the overlay isn't even attempted to be mounted because of the
+// previous failures (see `mountCircuitBreaker`)
+var errMountCircuitOpen = errors.New("overlay mounts are temporarily disabled after repeated failures (the " +
+	"overlay filesystem seems to be unavailable on the host); try again later")
+
+// mountCircuitBreaker stops the attempts to mount overlays for a while if they keep failing (for example, when the
+// overlay filesystem is unavailable because of a kernel bug or resource exhaustion), so that the failures don't flood
+// the logs and waste resources.
+//
+// After `threshold` consecutive failures within `window`, the circuit "opens": mounts are refused without attempting
+// the syscall. After `cooldown`, the circuit closes again and mounts are attempted as usual.
+type mountCircuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mutex sync.Mutex
+	// failures is the number of consecutive failures since `firstFailureAt`
+	failures       int
+	firstFailureAt time.Time
+	// openedAt is the time the circuit was opened at. Zero if the circuit is closed
+	openedAt time.Time
+}
+
+// allow reports whether a mount may be attempted, i.e. whether the circuit is closed.
+func (cb *mountCircuitBreaker) allow(logger *slog.Logger) bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.openedAt.IsZero() {
+		return true
+	}
+	if time.Since(cb.openedAt) < cb.cooldown {
+		return false
+	}
+	logger.Info("Mount circuit breaker cooled down. Attempting overlay mounts again")
+	cb.openedAt = time.Time{}
+	cb.failures = 0
+	return true
+}
+
+// record registers the outcome of a mount attempt.
+func (cb *mountCircuitBreaker) record(logger *slog.Logger, err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if err == nil {
+		cb.failures = 0
+		return
+	}
+
+	now := time.Now()
+	if cb.failures == 0 || now.Sub(cb.firstFailureAt) > cb.window {
+		cb.failures = 0
+		cb.firstFailureAt = now
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold && cb.openedAt.IsZero() {
+		logger.Error("Too many consecutive overlay mount failures. Refusing to mount overlays for a while",
+			"failures", cb.failures, "cooldown", cb.cooldown.String())
+		cb.openedAt = now
+	}
+}
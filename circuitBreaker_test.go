@@ -0,0 +1,103 @@
+//go:build dottest
+
+package main
+
+import (
+	"errors"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestMountCircuitBreaker(t *testing.T) {
+	var attempts atomic.Int32
+	var mountFails atomic.Bool
+	mountFails.Store(true)
+	mock := NewMockSyscallMount()
+	failingMount := func(d *DockerOnTop) {
+		d.mountSyscall = func(source, target, fstype string, flags uintptr, data string) error {
+			attempts.Add(1)
+			if mountFails.Load() {
+				return syscall.ENODEV
+			}
+			return mock.Mount(source, target, fstype, flags, data)
+		}
+		d.unmountSyscall = mock.Unmount
+	}
+	cooldown := 200 * time.Millisecond
+	d := NewTestDockerOnTop(t, failingMount, WithMountCircuitBreaker(5, time.Minute, cooldown))
+	addr := freeAddr(t)
+	if err := d.StartMetricsServer(addr); err != nil {
+		t.Fatal(err)
+	}
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	mount := func() error {
+		_, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"})
+		return err
+	}
+
+	for i := 1; i <= 5; i++ {
+		if err := mount(); err == nil || errors.Is(err, errMountCircuitOpen) {
+			t.Fatalf("Mount %d returned %v, want the mount failure", i, err)
+		}
+	}
+	if attempts.Load() != 5 {
+		t.Fatalf("The mount syscall was attempted %d times, want 5", attempts.Load())
+	}
+
+	start := time.Now()
+	if err := mount(); !errors.Is(err, errMountCircuitOpen) {
+		t.Errorf("With the circuit open, Mount returned %v, want %v", err, errMountCircuitOpen)
+	}
+	if elapsed := time.Since(start); elapsed > cooldown/2 {
+		t.Errorf("With the circuit open, Mount took %v", elapsed)
+	}
+	if attempts.Load() != 5 {
+		t.Errorf("With the circuit open, the mount syscall was attempted (%d attempts in total)", attempts.Load())
+	}
+	if samples := scrapeMetrics(t, addr); !slices.Contains(samples, "docker_on_top_mounts_short_circuited_total 1") {
+		t.Errorf("The short-circuited mount is not counted: %q", samples)
+	}
+
+	// After the cooldown, the mounts are attempted again
+	time.Sleep(cooldown)
+	mountFails.Store(false)
+	if err := mount(); err != nil {
+		t.Errorf("After the cooldown, Mount failed: %v", err)
+	}
+	if attempts.Load() != 6 {
+		t.Errorf("After the cooldown, the mount syscall was attempted %d times in total, want 6", attempts.Load())
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
+
+func TestMountCircuitBreakerWindow(t *testing.T) {
+	cb := &mountCircuitBreaker{threshold: 3, window: 50 * time.Millisecond, cooldown: time.Minute}
+	logger := newTestLogger(t)
+	mountErr := syscall.ENODEV
+
+	// The failures separated by a success are not consecutive
+	cb.record(logger, mountErr)
+	cb.record(logger, mountErr)
+	cb.record(logger, nil)
+	cb.record(logger, mountErr)
+	cb.record(logger, mountErr)
+	if !cb.allow(logger) {
+		t.Error("The circuit opened after the failures interrupted by a success")
+	}
+	// Neither are the failures further apart than the window
+	time.Sleep(cb.window + 10*time.Millisecond)
+	cb.record(logger, mountErr)
+	if !cb.allow(logger) {
+		t.Error("The circuit opened after the failures spread over more than the window")
+	}
+	cb.record(logger, mountErr)
+	cb.record(logger, mountErr)
+	if cb.allow(logger) {
+		t.Error("The circuit is closed after 3 consecutive failures within the window")
+	}
+}
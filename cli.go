@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// subcommand is a command of the plugin's executable other than running the plugin itself. Subcommands work with the
+// dot root directory directly (the docker daemon is not involved).
+type subcommand struct {
+	// args is the description of the subcommand's arguments, for the usage message
+	args        string
+	description string
+	run         func(d *DockerOnTop, args []string) error
+}
+
+var subcommands = map[string]subcommand{
+	"access-log": {args: "[-n LINES] VOLUME", description: "print the last mounts and unmounts of the volume " +
+		"(10 by default, all if LINES is 0)", run: runAccessLog},
+	"checkpoint": {args: "create|list|restore|delete VOLUME [CHECKPOINT]", description: "manage the named " +
+		"checkpoints of the changes made to the volume (restore requires the volume not to be in use)",
+		run: runCheckpoint},
+	"clone": {args: "[-force] VOLUME NEW_NAME", description: "create a new volume that is a copy of the volume, " +
+		"including the changes made to it (-force allows copying a volume that is in use)", run: runClone},
+	"compact": {args: "VOLUME", description: "remove the redundant whiteouts from the changes made to the volume " +
+		"(the volume must not be in use)", run: runCompact},
+	"copy-metadata": {description: "copy the metadata of all the volumes from their directories to the configured " +
+		"metadata backend (like etcd), before switching to it", run: runCopyMetadata},
+	"diff": {args: "VOLUME", description: "list the changes made to the volume", run: runDiff},
+	"diagnose": {description: "print a report on the state of the plugin and the host, for troubleshooting",
+		run: runDiagnose},
+	"gc": {description: "remove the leftovers of interrupted volume creations", run: runGC},
+	"export-config": {description: "write the configuration of all the volumes to stdout as JSON (the changes " +
+		"made to them are not included)", run: runExportConfig},
+	"import-config": {args: "[-create-missing]", description: "create the volumes from the configuration read " +
+		"from stdin (in the export-config format) that don't exist yet. The ones whose base directories are " +
+		"missing are skipped, unless -create-missing is given", run: runImportConfig},
+	"export": {args: "VOLUME", description: "write the changes made to the volume to stdout as a tar archive",
+		run: runExport},
+	"import": {args: "VOLUME", description: "replace the changes made to the volume with the ones from the tar " +
+		"archive read from stdin (the volume must not be in use)", run: runImport},
+	"inspect": {args: "VOLUME", description: "print the volume's metadata, active mounts, upperdir, and validation " +
+		"report as JSON. Fails if the volume is unhealthy", run: runInspect},
+	"lease": {args: "acquire|release VOLUME LEASE_ID [TTL]", description: "lease the volume to the container with " +
+		"the given mount ID for TTL (like 2h), refusing to mount it for the others, or release the lease",
+		run: runLease},
+	"list": {args: "[-json]", description: "list the volumes with their base directories, volatility, numbers of " +
+		"active mounts, and sizes of the changes made to them. -json prints the plugin's List response as JSON",
+		run: runList},
+	"migrate": {args: "[-dry-run] VOLUME NEW_BASE", description: "change the base directory of the volume (the " +
+		"volume must not be in use). -dry-run only reports the changes that don't match the new base", run: runMigrate},
+	"migrate-root": {args: "NEW_ROOT", description: "move the dot root directory to NEW_ROOT (nothing may be " +
+		"mounted under it)", run: runMigrateRoot},
+	"prune": {args: "-older-than AGE [-dry-run]", description: "remove the volumes that are not in use and " +
+		"have not been created or mounted for AGE (like 7d or 12h); -dry-run only prints them", run: runPrune},
+	"recover-mounts": {description: "record the volumes whose overlays are mounted but not recorded as used by any " +
+		"container (e.g. after a crash) as used, so that the next unmount cleans them up", run: runRecoverMounts},
+	"rename": {args: "VOLUME NEW_NAME", description: "rename the volume (the volume must not be in use)",
+		run: runRename},
+	"reset": {args: "[-purge] VOLUME", description: "discard the changes made to the volume, keeping a backup of " +
+		"them unless -purge is given (the volume must not be in use)", run: runReset},
+	"set-option": {args: "VOLUME KEY VALUE", description: "change an option of the volume (volatile or tags) " +
+		"as if it was created with KEY=VALUE (the volume must not be in use)", run: runSetOption},
+	"status": {args: "[-stale-after AGE]", description: "print the numbers of volumes and active mounts, the age of " +
+		"the oldest active mount, and the free space; fails if an active mount is older than AGE (24h by default)",
+		run: runStatus},
+	"tag": {args: "VOLUME [+TAG | -TAG]...", description: "add (+) or remove (-) tags of the volume",
+		run: runTag},
+	"validate": {args: "VOLUME", description: "check the consistency of the volume without mounting it",
+		run: runValidate},
+	"verify-all": {args: "[-concurrency N]", description: "check that every volume can be mounted: validate it " +
+		"and mount its overlay at a scratch directory", run: runVerifyAll},
+	"watch": {args: "[VOLUME]", description: "print the mounts and unmounts of the volume (or all the volumes) " +
+		"as they happen, until interrupted", run: runWatch},
+}
+
+// errUsage is returned by a subcommand if it was invoked with invalid arguments
+var errUsage = errors.New("invalid usage")
+
+// printUsage prints the usage message of the executable, including the list of the subcommands.
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [SUBCOMMAND [ARGS...]]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Without a subcommand, runs the plugin. Subcommands:")
+	for _, name := range sortedKeys(subcommands) {
+		cmd := subcommands[name]
+		fmt.Fprintf(out, "  %s %s\n    \t%s\n", name, cmd.args, cmd.description)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// runSubcommand runs the subcommand specified by `args[0]` and returns the exit code.
+func runSubcommand(dotRootDir string, args []string, opts ...DockerOnTopOption) int {
+	cmd, ok := subcommands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown subcommand %s\n", args[0])
+		printUsage()
+		return 2
+	}
+
+	d, err := openDockerOnTop(dotRootDir, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open the dot root directory: %v\n", err)
+		return 1
+	}
+
+	err = cmd.run(d, args[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] %s %s\n", os.Args[0], args[0], cmd.args)
+		return 2
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runAccessLog(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("access-log", flag.ContinueOnError)
+	lines := flags.Int("n", 10, "number of entries to print (0 for all)")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	entries, err := d.TailAccessLog(flags.Arg(0), *lines)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s\t%s\t%s\n", entry.Timestamp.Local().Format(time.RFC3339), entry.Operation, entry.ContainerID)
+	}
+	return nil
+}
+
+func runCheckpoint(d *DockerOnTop, args []string) error {
+	if len(args) == 2 && args[0] == "list" {
+		names, err := d.ListCheckpoints(args[1])
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return err
+	}
+	if len(args) != 3 {
+		return errUsage
+	}
+	switch args[0] {
+	case "create":
+		return d.CreateCheckpoint(args[1], args[2])
+	case "restore":
+		return d.RestoreCheckpoint(args[1], args[2])
+	case "delete":
+		return d.DeleteCheckpoint(args[1], args[2])
+	default:
+		return errUsage
+	}
+}
+
+func runLease(d *DockerOnTop, args []string) error {
+	switch {
+	case len(args) == 4 && args[0] == "acquire":
+		ttl, err := parseAge(args[3])
+		if err != nil {
+			return err
+		}
+		return d.AcquireLease(args[1], args[2], ttl)
+	case len(args) == 3 && args[0] == "release":
+		return d.ReleaseLease(args[1], args[2])
+	default:
+		return errUsage
+	}
+}
+
+func runCopyMetadata(d *DockerOnTop, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	if _, ok := d.metadata.(*FileMetadataBackend); ok {
+		return errors.New("no metadata backend other than the volumes' directories is configured (see " +
+			"--metadata-etcd-endpoints)")
+	}
+	copied, err := CopyMetadata(d.metadata, NewFileMetadataBackend(d.dotRootDir))
+	for _, name := range copied {
+		fmt.Println(name)
+	}
+	return err
+}
+
+func runDiff(d *DockerOnTop, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	changes, err := d.Diff(args[0])
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", strings.ToUpper(string(change.Kind[:1])), change.Path)
+	}
+	return nil
+}
+
+func runList(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the List response as JSON")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+	response, err := d.List()
+	if err != nil {
+		return err
+	}
+	sort.Slice(response.Volumes, func(i, j int) bool {
+		return response.Volumes[i].Name < response.Volumes[j].Name
+	})
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(response)
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "NAME\tBASE\tVOLATILE\tACTIVE_MOUNTS\tSIZE")
+	for _, vol := range response.Volumes {
+		base, volatile, activeMounts, size := "?", "?", "?", "?"
+		if thisVol, err := d.getVolumeInfo(vol.Name); err == nil {
+			base, volatile = thisVol.BaseDirPath, strconv.FormatBool(thisVol.Volatile)
+		}
+		if mounts, err := d.listActiveMounts(vol.Name); err == nil {
+			activeMounts = strconv.Itoa(len(mounts))
+		}
+		if usage, ok := vol.Status["upperdirBytes"].(int64); ok {
+			size = formatSize(usage)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n", vol.Name, base, volatile, activeMounts, size)
+	}
+	return out.Flush()
+}
+
+// formatSize formats a number of bytes for humans, like "1.5 MiB".
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exp := float64(bytes)/unit, 0
+	for value >= unit && exp < len("KMGTPE")-1 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[exp])
+}
+
+// sortedKeys returns the keys of the map in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func runExport(d *DockerOnTop, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return d.ExportVolumeDiff(args[0], os.Stdout)
+}
+
+func runImport(d *DockerOnTop, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return d.ImportVolumeDiff(args[0], os.Stdin)
+}
+
+func runDiagnose(d *DockerOnTop, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return d.Diagnose(os.Stdout)
+}
+
+func runExportConfig(d *DockerOnTop, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	payload, err := d.ExportConfig()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(payload, '\n'))
+	return err
+}
+
+func runImportConfig(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("import-config", flag.ContinueOnError)
+	createMissing := flags.Bool("create-missing", false, "create the missing base directories")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+	payload, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	return d.ImportConfig(payload, *createMissing)
+}
+
+func runRename(d *DockerOnTop, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	return d.Rename(args[0], args[1])
+}
+
+func runClone(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("clone", flag.ContinueOnError)
+	force := flags.Bool("force", false, "clone the volume even if it is in use")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return errUsage
+	}
+	return d.Clone(flags.Arg(0), flags.Arg(1), *force)
+}
+
+func runGC(d *DockerOnTop, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	removed, err := d.GarbageCollect()
+	for _, name := range removed {
+		fmt.Println(name)
+	}
+	return err
+}
+
+func runPrune(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+	olderThan := flags.String("older-than", "", "how long the volumes must have been idle, like 7d or 12h")
+	dryRun := flags.Bool("dry-run", false, "only print the volumes that would be removed")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 || *olderThan == "" {
+		return errUsage
+	}
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		return err
+	}
+	pruned, err := d.Prune(age, *dryRun)
+	for _, name := range pruned {
+		fmt.Println(name)
+	}
+	return err
+}
+
+func runRecoverMounts(d *DockerOnTop, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	recovered, err := d.RecoverOrphanedMounts()
+	for _, name := range recovered {
+		fmt.Println(name)
+	}
+	return err
+}
+
+func runReset(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("reset", flag.ContinueOnError)
+	purge := flags.Bool("purge", false, "remove the discarded changes instead of keeping a backup")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	return d.Reset(flags.Arg(0), *purge)
+}
+
+func runVerifyAll(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("verify-all", flag.ContinueOnError)
+	concurrency := flags.Int("concurrency", 4, "number of volumes verified in parallel")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+	results, err := d.VerifyAll(*concurrency)
+	if err != nil {
+		return err
+	}
+	unhealthy := 0
+	for _, result := range results {
+		if result.Healthy {
+			fmt.Printf("%s: ok\n", result.Name)
+		} else {
+			fmt.Printf("%s: %s\n", result.Name, result.Error)
+			unhealthy++
+		}
+	}
+	if unhealthy > 0 {
+		return fmt.Errorf("%d of %d volume(s) cannot be mounted", unhealthy, len(results))
+	}
+	return nil
+}
+
+func runStatus(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	staleAfter := flags.String("stale-after", staleActivemountAge.String(), "the age of an active mount after which "+
+		"it is considered stale, like 12h or 2d")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+	maxAge, err := parseAge(*staleAfter)
+	if err != nil {
+		return err
+	}
+	report := d.Status()
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(out, "Volumes:\t%d\n", report.TotalVolumes)
+	fmt.Fprintf(out, "Mounted volumes:\t%d\n", report.MountedVolumes)
+	fmt.Fprintf(out, "Active mounts:\t%d\n", report.TotalActiveMounts)
+	if report.TotalActiveMounts > 0 {
+		fmt.Fprintf(out, "Oldest mount:\t%s ago\n", report.OldestMountAge.Round(time.Second))
+	}
+	fmt.Fprintf(out, "Free space:\t%d bytes\n", report.DotRootDirFreeBytes)
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if report.OldestMountAge > maxAge {
+		return fmt.Errorf("an active mount is older than %s (see the diagnose subcommand)", maxAge)
+	}
+	return nil
+}
+
+func runValidate(d *DockerOnTop, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	report, err := d.ValidateVolume(args[0])
+	if err != nil {
+		return err
+	}
+	for _, warning := range report.Warnings {
+		fmt.Println("warning:", warning)
+	}
+	for _, e := range report.Errors {
+		fmt.Println("error:", e)
+	}
+	if !report.Healthy {
+		return errors.New("the volume is unhealthy")
+	}
+	return nil
+}
+
+// volumeInspection is the output of the inspect subcommand
+type volumeInspection struct {
+	Name string `json:"name"`
+	// VolumeInfo is nil if the metadata cannot be read (then the validation report tells why)
+	VolumeInfo   *VolumeInfo      `json:"volumeInfo"`
+	ActiveMounts []activeMount    `json:"activeMounts"`
+	Upperdir     string           `json:"upperdir"`
+	Validation   ValidationReport `json:"validation"`
+}
+
+func runInspect(d *DockerOnTop, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	inspection := volumeInspection{Name: args[0]}
+	report, err := d.ValidateVolume(args[0])
+	if err != nil {
+		return err
+	}
+	inspection.Validation = report
+	if thisVol, err := d.getVolumeInfo(args[0]); err == nil {
+		inspection.VolumeInfo = &thisVol
+		inspection.Upperdir = d.upperdir(args[0], &thisVol)
+	}
+	if inspection.ActiveMounts, err = d.listActiveMounts(args[0]); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(inspection); err != nil {
+		return err
+	}
+	if !report.Healthy {
+		return errors.New("the volume is unhealthy")
+	}
+	return nil
+}
+
+func runSetOption(d *DockerOnTop, args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+	return d.SetVolumeOption(args[0], args[1], args[2])
+}
+
+func runWatch(d *DockerOnTop, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var events <-chan VolumeEvent
+	var err error
+	switch len(args) {
+	case 0:
+		events, err = d.WatchAll(ctx)
+	case 1:
+		events, err = d.WatchVolume(ctx, args[0])
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	for event := range events {
+		fmt.Printf("%s %s %s %s\n", event.Timestamp.Format(time.RFC3339), event.Type, event.Volume, event.ContainerID)
+	}
+	return nil
+}
+
+func runCompact(d *DockerOnTop, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	freed, err := d.Compact(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d bytes freed\n", freed)
+	return nil
+}
+
+func runMigrate(d *DockerOnTop, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return errUsage
+	}
+	mismatches, err := d.MigrateVolume(flags.Arg(0), flags.Arg(1), *dryRun)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("The base directory of %s would be changed to %s\n", flags.Arg(0), flags.Arg(1))
+	}
+	for _, mismatch := range mismatches {
+		fmt.Println("warning:", mismatch)
+	}
+	return nil
+}
+
+func runMigrateRoot(d *DockerOnTop, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return MigrateRootDir(d.dotRootDir, args[0])
+}
+
+func runTag(d *DockerOnTop, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	for _, arg := range args[1:] {
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "+"):
+			err = d.AddTag(args[0], arg[1:])
+		case strings.HasPrefix(arg, "-"):
+			err = d.RemoveTag(args[0], arg[1:])
+		default:
+			return errUsage
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
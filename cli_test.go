@@ -0,0 +1,217 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// captureStdout runs the subcommand `run` with `args` and returns what it wrote to stdout.
+func captureStdout(t *testing.T, run func(d *DockerOnTop, args []string) error, d *DockerOnTop,
+	args ...string) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	var out bytes.Buffer
+	copied := make(chan error)
+	go func() {
+		_, err := io.Copy(&out, r)
+		copied <- err
+	}()
+	runErr := run(d, args)
+	w.Close()
+	if err := <-copied; err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), runErr
+}
+
+func TestListSubcommand(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	firstBase, secondBase := t.TempDir(), t.TempDir()
+	MustCreateVolume(t, d, "second", secondBase)
+	if err := d.Create(&volume.CreateRequest{Name: "first", Options: map[string]string{
+		"base": firstBase, "volatile": "true",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	MustMountVolume(t, d, "second", "container1")
+	MustMountVolume(t, d, "second", "container2")
+	vol, err := d.getVolumeInfo("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, d.upperdir("second", &vol), map[string]string{"file": strings.Repeat("x", 3<<10)})
+
+	out, err := captureStdout(t, runList, d)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("list printed %d lines, want 3:\n%s", len(lines), out)
+	}
+	for i, want := range []string{
+		`^NAME\s+BASE\s+VOLATILE\s+ACTIVE_MOUNTS\s+SIZE$`,
+		`^first\s+` + regexp.QuoteMeta(firstBase) + `\s+true\s+0\s+0 B$`,
+		`^second\s+` + regexp.QuoteMeta(secondBase) + `\s+false\s+2\s+3\.0 KiB$`,
+	} {
+		if !regexp.MustCompile(want).MatchString(lines[i]) {
+			t.Errorf("Line %d of the list is %q, want it to match %q", i, lines[i], want)
+		}
+	}
+	// The columns are aligned
+	if column := strings.Index(lines[0], "BASE"); strings.Index(lines[1], firstBase) != column ||
+		strings.Index(lines[2], secondBase) != column {
+		t.Errorf("The BASE column is not aligned:\n%s", out)
+	}
+
+	out, err = captureStdout(t, runList, d, "-json")
+	if err != nil {
+		t.Fatalf("list -json failed: %v", err)
+	}
+	var response volume.ListResponse
+	if err := json.Unmarshal([]byte(out), &response); err != nil {
+		t.Fatalf("list -json printed invalid JSON: %v\n%s", err, out)
+	}
+	if len(response.Volumes) != 2 || response.Volumes[0].Name != "first" || response.Volumes[1].Name != "second" ||
+		response.Volumes[1].Status["upperdirBytes"] != float64(3<<10) {
+		t.Errorf("list -json printed:\n%s", out)
+	}
+	if !strings.Contains(out, "\n  \"Volumes\"") {
+		t.Errorf("list -json is not indented:\n%s", out)
+	}
+
+	for _, args := range [][]string{{"extra"}, {"-unknown"}} {
+		if _, err := captureStdout(t, runList, d, args...); !errors.Is(err, errUsage) {
+			t.Errorf("list %v returned %v, want errUsage", args, err)
+		}
+	}
+	MustUnmountVolume(t, d, "second", "container1")
+	MustUnmountVolume(t, d, "second", "container2")
+}
+
+func TestFormatSize(t *testing.T) {
+	for bytes, want := range map[int64]string{
+		0:                 "0 B",
+		1023:              "1023 B",
+		1024:              "1.0 KiB",
+		1536:              "1.5 KiB",
+		5 << 20:           "5.0 MiB",
+		3 << 30:           "3.0 GiB",
+		1 << 62:           "4.0 EiB",
+		1<<40 + 1<<39 + 1: "1.5 TiB",
+	} {
+		if got := formatSize(bytes); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", bytes, got, want)
+		}
+	}
+}
+
+func TestInspectSubcommand(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	MustCreateVolume(t, d, "vol", base)
+	MustMountVolume(t, d, "vol", "container")
+
+	out, err := captureStdout(t, runInspect, d, "vol")
+	if err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	var inspection volumeInspection
+	if err := json.Unmarshal([]byte(out), &inspection); err != nil {
+		t.Fatalf("inspect printed invalid JSON: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "\n  \"volumeInfo\"") {
+		t.Errorf("inspect is not indented:\n%s", out)
+	}
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspection.Name != "vol" || inspection.VolumeInfo == nil || inspection.VolumeInfo.BaseDirPath != base ||
+		inspection.Upperdir != d.upperdir("vol", &vol) || !inspection.Validation.Healthy {
+		t.Errorf("inspect printed:\n%s", out)
+	}
+	if len(inspection.ActiveMounts) != 1 || inspection.ActiveMounts[0].ID != "container" {
+		t.Errorf("inspect reports the active mounts %+v", inspection.ActiveMounts)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+
+	// The report is printed for an unhealthy volume too, but the subcommand fails
+	if err := os.RemoveAll(base); err != nil {
+		t.Fatal(err)
+	}
+	out, err = captureStdout(t, runInspect, d, "vol")
+	if err == nil {
+		t.Error("inspect succeeded for a volume with a missing base directory")
+	}
+	inspection = volumeInspection{}
+	if err := json.Unmarshal([]byte(out), &inspection); err != nil {
+		t.Fatalf("inspect printed invalid JSON: %v\n%s", err, out)
+	}
+	if inspection.Validation.Healthy || len(inspection.Validation.Errors) == 0 ||
+		!strings.Contains(inspection.Validation.Errors[0], base) {
+		t.Errorf("inspect reports the validation %+v, want an error about %s", inspection.Validation, base)
+	}
+
+	if out, err := captureStdout(t, runInspect, d, "missing"); err == nil || out != "" {
+		t.Errorf("inspect of a missing volume = %q, %v; want an error and no output", out, err)
+	}
+	for _, args := range [][]string{{}, {"vol", "extra"}} {
+		if _, err := captureStdout(t, runInspect, d, args...); !errors.Is(err, errUsage) {
+			t.Errorf("inspect %v returned %v, want errUsage", args, err)
+		}
+	}
+}
+
+func TestRunSubcommandExitCodes(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	MustCreateVolume(t, d, "vol", base)
+	if err := os.RemoveAll(base); err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"inspect", "vol"}, 1},
+		{[]string{"inspect"}, 2},
+		{[]string{"no-such-subcommand"}, 2},
+	} {
+		var code int
+		captureStdout(t, func(*DockerOnTop, []string) error {
+			code = runSubcommand(d.DotRootDirPath(), tc.args, WithoutOverlayProbe())
+			return nil
+		}, d)
+		if code != tc.want {
+			t.Errorf("The exit code of %v is %d, want %d", tc.args, code, tc.want)
+		}
+	}
+}
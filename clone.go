@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Clone creates a new volume `dstName` that is an independent copy of the volume `srcName`: it has the same base
+// directory and options, and its upperdir is a copy of the source's one (including file metadata, extended
+// attributes, and whiteouts). Further changes to either volume do not affect the other.
+//
+// If the source volume is mounted, its contents may change during the copy, so the clone is refused unless `force` is
+// set, in which case a potentially inconsistent snapshot is taken (with a warning).
+func (d *DockerOnTop) Clone(srcName, dstName string, force bool) error {
+	d.logger.Debug("Request Clone", "volume", srcName, "newName", dstName, "force", force)
+
+	if !volNameFormat.MatchString(dstName) {
+		d.logger.Debug("Volume name doesn't comply to the regex. Volume not cloned")
+		return fmt.Errorf("volume name must match the regex %s", volNameFormat.String())
+	}
+	srcVol, err := d.getVolumeInfo(srcName)
+	if os.IsNotExist(err) {
+		return errors.New("no such volume")
+	} else if err != nil {
+		return d.internalError("failed to retrieve the volume's metadata", err, "volume", srcName)
+	}
+
+	if mounted, err := d.volumeIsMounted(srcName); err != nil {
+		return d.internalError("failed to check whether the volume is mounted", err, "volume", srcName)
+	} else if mounted {
+		if !force {
+			return errors.New("the volume is mounted: cannot clone while it is in use (unless forced)")
+		}
+		d.logger.Warn("Cloning the volume while it is mounted. The clone may be inconsistent", "volume", srcName)
+	}
+
+	if err := d.volumeTreeCreate(dstName); err != nil {
+		if os.IsExist(err) {
+			d.logger.Debug("Volume's main directory already exists. Volume not cloned")
+			return errors.New("volume already exists")
+		}
+		// The error is already logged and wrapped in `internalError` by `d.volumeTreeCreate`
+		return err
+	}
+
+	// The upperdir is copied by streaming it through the same tar format the export/import use. The new volume's
+	// changes are stored in the default upperdir in its main directory (see `dstVol` below)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(d.writeUpperdirTar(srcName, d.upperdir(srcName, &srcVol), pw))
+	}()
+	err = d.extractTar(pr, d.upperdir(dstName, &VolumeInfo{}), !srcVol.Volatile)
+	_ = pr.CloseWithError(err) // Makes the writer stop if the extraction failed
+	if err != nil {
+		_ = d.volumeTreeDestroy(dstName) // The errors are logged, if any
+		return d.internalError("failed to copy the volume's upperdir", err, "volume", srcName, "newName", dstName)
+	}
+
+	dstVol := srcVol
+	dstVol.Stuck = false
+	dstVol.CustomUpperDir = "" // The new volume's changes are stored in its main directory
+	dstVol.CustomWorkDir = ""
+	dstVol.CreatedAt = time.Now()
+	dstVol.LastUsedAt = time.Time{}
+	dstVol.LastMountedAt = time.Time{}
+	dstVol.LastUnmountedAt = time.Time{}
+	dstVol.TotalMountCount = 0
+	if err := d.writeVolumeInfo(dstName, dstVol); err != nil {
+		_ = d.volumeTreeDestroy(dstName) // The errors are logged, if any
+		return d.internalError("failed to store metadata for the volume", err, "volume", dstName)
+	}
+	if dstVol.TmpfsSizeBytes > 0 {
+		// The changes copied above are discarded on the first mount anyway, as the volume is volatile
+		if err := d.createTmpfsUpperPlaceholder(dstName); err != nil {
+			d.logger.Warn("Failed to create the placeholder of the in-memory upperdir", "volume", dstName,
+				"error", err)
+		}
+	}
+	d.logger.Info("Cloned volume", "volume", srcName, "newName", dstName)
+	return nil
+}
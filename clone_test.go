@@ -0,0 +1,81 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestClone(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"file": "base", "deleted": "base"})
+	MustCreateVolume(t, d, "src", base)
+	mountpoint := MustMountVolume(t, d, "src", "container")
+	writeFiles(t, mountpoint, map[string]string{"file": "src"})
+	if err := os.Remove(mountpoint + "/deleted"); err != nil {
+		t.Fatal(err)
+	}
+	MustUnmountVolume(t, d, "src", "container")
+	if err := unix.Setxattr(d.VolumeUpperDir("src")+"file", "user.test", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Clone("src", "dst", false); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	if vol, err := d.getVolumeInfo("dst"); err != nil || vol.BaseDirPath != base {
+		t.Errorf("The clone's base directory is %q, %v; want %q", vol.BaseDirPath, err, base)
+	}
+	value := make([]byte, 16)
+	if n, err := unix.Getxattr(d.VolumeUpperDir("dst")+"file", "user.test", value); err != nil ||
+		string(value[:n]) != "value" {
+		t.Errorf("The clone's xattr = %q, %v; want %q", value[:n], err, "value")
+	}
+
+	mountpoint = MustMountVolume(t, d, "dst", "container")
+	if contents, err := os.ReadFile(mountpoint + "/file"); err != nil || string(contents) != "src" {
+		t.Errorf("The clone's file = %q, %v; want %q", contents, err, "src")
+	}
+	if _, err := os.Stat(mountpoint + "/deleted"); !os.IsNotExist(err) {
+		t.Errorf("The file deleted in the source is visible in the clone (%v)", err)
+	}
+	writeFiles(t, mountpoint, map[string]string{"file": "dst", "added": "dst"})
+	MustUnmountVolume(t, d, "dst", "container")
+
+	// The writes to the clone don't appear in the source
+	mountpoint = MustMountVolume(t, d, "src", "container")
+	if contents, err := os.ReadFile(mountpoint + "/file"); err != nil || string(contents) != "src" {
+		t.Errorf("After writing to the clone, the source's file = %q, %v; want %q", contents, err, "src")
+	}
+	if _, err := os.Stat(mountpoint + "/added"); !os.IsNotExist(err) {
+		t.Errorf("The file added to the clone is visible in the source (%v)", err)
+	}
+	MustUnmountVolume(t, d, "src", "container")
+}
+
+func TestCloneMountedVolume(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "src", t.TempDir())
+	writeFiles(t, d.VolumeUpperDir("src"), map[string]string{"file": "src"})
+	MustMountVolume(t, d, "src", "container")
+
+	if err := d.Clone("src", "dst", false); err == nil {
+		t.Error("The mounted volume was cloned without force")
+	}
+	if _, err := os.Stat(d.volumeDir("dst")); !os.IsNotExist(err) {
+		t.Errorf("The refused clone left its main directory (%v)", err)
+	}
+	if err := d.Clone("src", "dst", true); err != nil {
+		t.Fatalf("Forced Clone failed: %v", err)
+	}
+	if contents, err := os.ReadFile(d.VolumeUpperDir("dst") + "file"); err != nil || string(contents) != "src" {
+		t.Errorf("The clone's file = %q, %v; want %q", contents, err, "src")
+	}
+	if err := d.Clone("src", "dst", true); err == nil {
+		t.Error("Cloning to an existing volume succeeded")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Compact removes the redundant entries from the volume's upperdir:
+//   - whiteouts hiding entries that don't exist in any of the lower directories (e.g. left after a file was created
+//     and then deleted in a directory that is new or opaque);
+//   - whiteouts in the directories where every entry of the lower directories is hidden: such directories are marked
+//     opaque instead.
+//
+// The number of bytes freed on the filesystem is returned (whiteouts take little, if any, space themselves, but every
+// one of them takes an inode). Nothing is merged into the lower directories.
+//
+// The volume must not be in use.
+func (d *DockerOnTop) Compact(volumeName string) (int64, error) {
+	d.logger.Debug("Request Compact", "volume", volumeName)
+
+	thisVol, err := d.getVolumeInfoOrNotFound(volumeName)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock, err := d.lockIdleVolume(volumeName, "compact")
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	opaqueAttr := "trusted.overlay.opaque"
+	if thisVol.UserXattr {
+		opaqueAttr = "user.overlay.opaque"
+	}
+
+	var freed int64
+	upperdir := d.upperdir(volumeName, &thisVol)
+	err = filepath.WalkDir(upperdir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(upperdir, path)
+		if err != nil {
+			return err
+		}
+		dirFreed, err := compactDir(path, lowerEntryNames(thisVol.lowerDirs(), relPath), opaqueAttr)
+		freed += dirFreed
+		return err
+	})
+	if err != nil {
+		return freed, d.internalError("failed to compact the upperdir", err, "volume", volumeName)
+	}
+	d.logger.Info("Compacted volume", "volume", volumeName, "bytesFreed", freed)
+	return freed, nil
+}
+
+// lowerEntryNames returns the names of the entries of the directory `relPath` merged from the given lower
+// directories.
+func lowerEntryNames(lowerdirs []string, relPath string) map[string]bool {
+	names := map[string]bool{}
+	for _, lowerdir := range lowerdirs {
+		entries, _ := os.ReadDir(filepath.Join(lowerdir, relPath)) // The directory may not exist in the layer
+		for _, entry := range entries {
+			names[entry.Name()] = true
+		}
+	}
+	return names
+}
+
+// compactDir removes the redundant whiteouts from the upperdir's directory `path`, given the names of the entries of
+// the directory in the lower layers. If the whiteouts hide all of the lower entries, the directory is marked opaque
+// with the `opaqueAttr` xattr and the whiteouts are removed.
+func compactDir(path string, lowerNames map[string]bool, opaqueAttr string) (int64, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return 0, err
+	}
+
+	opaque := isOpaqueDir(path)
+	whiteouts := map[string]fs.FileInfo{} // By the name of the entry in the upperdir
+	hidden := map[string]bool{}           // Names of the lower entries hidden by the upperdir's entries
+	for _, entry := range entries {
+		if entry.Name() == opaqueMarker {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		if whiteout, target := isWhiteout(entry.Name(), info); whiteout {
+			whiteouts[entry.Name()] = info
+			hidden[target] = true
+		} else {
+			hidden[entry.Name()] = true
+		}
+	}
+	if len(whiteouts) == 0 {
+		return 0, nil
+	}
+
+	allHidden := len(lowerNames) > 0
+	for name := range lowerNames {
+		allHidden = allHidden && hidden[name]
+	}
+	if !opaque && allHidden {
+		if err := syscall.Setxattr(path, opaqueAttr, []byte("y"), 0); err != nil {
+			return 0, err
+		}
+		opaque = true
+	}
+
+	var freed int64
+	for name, info := range whiteouts {
+		_, target := isWhiteout(name, info)
+		if !opaque && lowerNames[target] {
+			continue // Necessary: hides a lower entry
+		}
+		if err := os.Remove(filepath.Join(path, name)); err != nil {
+			return freed, err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			freed += stat.Blocks * 512
+		}
+	}
+	return freed, nil
+}
@@ -0,0 +1,133 @@
+//go:build dottest
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+// makeWhiteouts creates overlay whiteouts (0/0 character devices) at the given paths inside `dir`, skipping the test
+// if this is not permitted.
+func makeWhiteouts(t *testing.T, dir string, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Mknod(filepath.Join(dir, path), syscall.S_IFCHR, 0); errors.Is(err, syscall.EPERM) {
+			t.Skip("Creating whiteouts is not permitted")
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listTree returns the relative paths of the entries in `dir`, sorted.
+func listTree(t *testing.T, dir string) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err == nil && relPath != "." {
+			paths = append(paths, relPath)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestCompactWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"deleted":      "base",
+		"kept":         "base",
+		"partial/a":    "base",
+		"partial/b":    "base",
+		"all-gone/x":   "base",
+		"all-gone/y":   "base",
+		"all-gone/z/w": "base",
+	})
+	MustCreateVolume(t, d, "vol", base)
+	upper := d.VolumeUpperDir("vol")
+	writeFiles(t, upper, map[string]string{"new-dir/file": "upper", "all-gone/z/w": "upper"})
+	makeWhiteouts(t, upper,
+		"deleted",          // Necessary
+		"never-existed",    // Redundant
+		"partial/a",        // Necessary: partial/b is still visible
+		"all-gone/x",       // Redundant once the directory is opaque
+		"all-gone/y",       // Same
+		"all-gone/missing", // Same
+	)
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	before := listTree(t, mountpoint)
+	MustUnmountVolume(t, d, "vol", "container")
+
+	if _, err := d.Compact("vol"); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	want := []string{
+		"all-gone", "all-gone/z", "all-gone/z/w",
+		"deleted",
+		"new-dir", "new-dir/file",
+		"partial", "partial/a",
+	}
+	if got := listTree(t, upper); !reflect.DeepEqual(got, want) {
+		t.Errorf("After compacting, the upperdir contains %v, want %v", got, want)
+	}
+	if !isOpaqueDir(upper + "all-gone") {
+		t.Error("The directory with all the lower entries hidden is not opaque")
+	}
+
+	// The volume looks the same
+	mountpoint = MustMountVolume(t, d, "vol", "container")
+	if after := listTree(t, mountpoint); !reflect.DeepEqual(after, before) {
+		t.Errorf("After compacting, the volume contains %v, want %v", after, before)
+	}
+	if contents, err := os.ReadFile(mountpoint + "/all-gone/z/w"); err != nil || string(contents) != "upper" {
+		t.Errorf("After compacting, the volume's file = %q, %v; want %q", contents, err, "upper")
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
+
+func TestCompactLegacyAndUnmergedWhiteouts(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"deleted": "base", "kept": "base"})
+	MustCreateVolume(t, d, "vol", base)
+	upper := d.VolumeUpperDir("vol")
+	writeFiles(t, upper, map[string]string{whiteoutPrefix + "deleted": "", whiteoutPrefix + "never-existed": ""})
+	// The kernel shows the whiteouts in the directories that are not merged, so they can't be tested with an overlay
+	makeWhiteouts(t, upper, "new-dir/tmp")
+
+	if _, err := d.Compact("vol"); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	want := []string{whiteoutPrefix + "deleted", "new-dir"}
+	if got := listTree(t, upper); !reflect.DeepEqual(got, want) {
+		t.Errorf("After compacting, the upperdir contains %v, want %v", got, want)
+	}
+
+	MustMountVolume(t, d, "vol", "container")
+	if _, err := d.Compact("vol"); err == nil {
+		t.Error("The mounted volume was compacted")
+	}
+	if _, err := d.Compact("missing"); err == nil {
+		t.Error("A nonexistent volume was compacted")
+	}
+}
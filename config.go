@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// BasePrefixesConfig is the format of the file with the allowed and denied base directory prefixes (see
+// `DockerOnTop.SetBaseDirAllowedPrefixes` and `DockerOnTop.SetBaseDirDeniedPrefixes`). For example:
+//
+//	{"AllowedBasePrefixes": ["/var/data"], "DeniedBasePrefixes": ["/var/data/secrets"]}
+type BasePrefixesConfig struct {
+	AllowedBasePrefixes []string
+	DeniedBasePrefixes  []string
+}
+
+// loadBasePrefixesConfig reads the JSON file with the allowed and denied base directory prefixes.
+func loadBasePrefixesConfig(path string) (BasePrefixesConfig, error) {
+	var cfg BasePrefixesConfig
+	payload, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(payload, &cfg)
+	}
+	return cfg, err
+}
+
+// applyBasePrefixesConfig sets the allowed and denied base directory prefixes of the driver. Both lists are replaced
+// at once, so concurrent volume creations see either the old lists or the new ones. If either list is invalid, none is
+// changed.
+func (d *DockerOnTop) applyBasePrefixesConfig(cfg BasePrefixesConfig) error {
+	allowed, err := cleanBasePrefixes(cfg.AllowedBasePrefixes)
+	if err != nil {
+		return err
+	}
+	denied, err := cleanBasePrefixes(cfg.DeniedBasePrefixes)
+	if err != nil {
+		return err
+	}
+	d.basePrefixesMutex.Lock()
+	defer d.basePrefixesMutex.Unlock()
+	d.allowedBasePrefixes, d.deniedBasePrefixes = allowed, denied
+	return nil
+}
+
+// Reload reloads the parts of the configuration that can be changed while the plugin is running, currently the
+// allowed and denied base directory prefixes, from the source given with `WithBasePrefixesReloader` (the plugin
+// reloads on SIGHUP). The volumes are not affected; the new prefixes only apply to the volumes created afterwards. On
+// error, the current configuration is kept.
+func (d *DockerOnTop) Reload() error {
+	if d.reloadBasePrefixes == nil {
+		return errors.New("no configuration source to reload from")
+	}
+	prefixes, err := d.reloadBasePrefixes()
+	if err != nil {
+		return err
+	}
+	if err := d.applyBasePrefixesConfig(prefixes); err != nil {
+		return err
+	}
+	d.logger.Info("Reloaded the base directory prefixes", "allowed", prefixes.AllowedBasePrefixes, "denied",
+		prefixes.DeniedBasePrefixes)
+	return nil
+}
+
+// defaultConfigPath is the configuration file that is used if `--config` is not given. Unlike an explicitly given one,
+// it is fine for it not to exist
+const defaultConfigPath = "/etc/docker-on-top/config.toml"
+
+// Config holds the plugin's startup options. It is normally loaded from a TOML file with `LoadConfig` (with the
+// command-line flags overriding the values from the file), for example:
+//
+//	dot_root_dir = "/var/lib/docker-on-top/"
+//	metrics_addr = ":9323"
+//	allowed_base_prefixes = ["/var/data"]
+//	log_format = "text"
+type Config struct {
+	// DotRootDir is the plugin's internal directory, where the volumes are stored
+	DotRootDir string `toml:"dot_root_dir" json:"dot_root_dir"`
+	// SocketPath is the UNIX socket the plugin serves the docker daemon at
+	SocketPath string `toml:"socket_path" json:"socket_path"`
+	// DefaultVolatile makes the volumes created without the `volatile` option volatile
+	DefaultVolatile bool `toml:"default_volatile" json:"default_volatile"`
+	// DefaultLazyUnmount makes the volumes created without the `lazy` option be detached lazily when busy
+	DefaultLazyUnmount bool `toml:"default_lazy_unmount" json:"default_lazy_unmount"`
+	// DefaultUserXattr makes the volumes created without the `userxattr` option use it. It's always the default when
+	// the plugin is not running as root
+	DefaultUserXattr bool `toml:"default_userxattr" json:"default_userxattr"`
+	// FuseOverlayFallback makes the plugin mount the overlays with `fuse-overlayfs` if the kernel doesn't support
+	// overlayfs
+	FuseOverlayFallback bool `toml:"fuse_overlay_fallback" json:"fuse_overlay_fallback"`
+	// DryRun makes the plugin only log the volume operations it would perform
+	DryRun bool `toml:"dry_run" json:"dry_run"`
+	// IdempotentCreate makes creating a volume that already exists with the same options succeed
+	IdempotentCreate bool `toml:"idempotent_create" json:"idempotent_create"`
+	// DisableUsageReporting makes the plugin not report the disk usage of the volumes
+	DisableUsageReporting bool `toml:"disable_usage_reporting" json:"disable_usage_reporting"`
+	// AllowNestedOverlay allows base directories located on an overlay filesystem
+	AllowNestedOverlay bool `toml:"allow_nested_overlay" json:"allow_nested_overlay"`
+	// MetricsAddr is the address to serve Prometheus metrics at. Metrics are not served if it's empty
+	MetricsAddr string `toml:"metrics_addr" json:"metrics_addr"`
+	// HealthAddr is the address to serve the health endpoint at. It is not served if it's empty
+	HealthAddr string `toml:"health_addr" json:"health_addr"`
+	// AllowedBasePrefixes and DeniedBasePrefixes restrict the base directories of new volumes (see
+	// `DockerOnTop.SetBaseDirAllowedPrefixes` and `DockerOnTop.SetBaseDirDeniedPrefixes`)
+	AllowedBasePrefixes []string `toml:"allowed_base_prefixes" json:"allowed_base_prefixes"`
+	DeniedBasePrefixes  []string `toml:"denied_base_prefixes" json:"denied_base_prefixes"`
+	// DefaultOptions are the volume options used for the volumes created without them (see
+	// `DockerOnTop.SetDefaultOptions`), like `base`
+	DefaultOptions map[string]string `toml:"default_options" json:"default_options"`
+	// AuditLog is the file to append the audit log of the volume operations to. No audit log is written if it's empty
+	AuditLog string `toml:"audit_log" json:"audit_log"`
+	// AccessLogMaxSize is the size (in bytes) the volumes' access logs are rotated at. The default is used if it's 0
+	AccessLogMaxSize int64 `toml:"access_log_max_size" json:"access_log_max_size"`
+	// MountTimeout limits the time mounting an overlay may take, as a duration string like "30s". The default is used
+	// if it's empty
+	MountTimeout string `toml:"mount_timeout" json:"mount_timeout"`
+	// HookTimeout limits the time a volume's hook may run, as a duration string like "30s". The default is used if
+	// it's empty
+	HookTimeout string `toml:"hook_timeout" json:"hook_timeout"`
+	// HooksDir is the directory the volumes' hooks (like `premount`) must be located in (see `WithHooksDir`). The
+	// volumes cannot have hooks if it's empty
+	HooksDir string `toml:"hooks_dir" json:"hooks_dir"`
+	// MountWatchdogInterval is how often to check that the overlays of the volumes in use are still mounted, as a
+	// duration string like "1m". No checks are made if it's empty
+	MountWatchdogInterval string `toml:"mount_watchdog_interval" json:"mount_watchdog_interval"`
+	// BootConcurrency is the number of volumes reset in parallel on boot. The number of CPUs is used if it's 0
+	BootConcurrency int `toml:"boot_concurrency" json:"boot_concurrency"`
+	// PermissiveBoot makes the plugin start even if some volumes fail to reset on boot
+	PermissiveBoot bool `toml:"permissive_boot" json:"permissive_boot"`
+	// GCOnStart makes the plugin remove the leftovers of interrupted volume creations on startup (see
+	// `DockerOnTop.GarbageCollect`)
+	GCOnStart bool `toml:"gc_on_start" json:"gc_on_start"`
+	// MetadataEtcdEndpoints are the client endpoints of the etcd cluster to store the volumes' metadata in (see
+	// `NewEtcdMetadataBackend`). The metadata is stored in the volumes' directories if it's empty
+	MetadataEtcdEndpoints []string `toml:"metadata_etcd_endpoints" json:"metadata_etcd_endpoints" sep:","`
+	// MetadataEtcdCAFile is the CA certificate (PEM) to verify the etcd servers' certificates with. The system's CAs
+	// are used if it's empty
+	MetadataEtcdCAFile string `toml:"metadata_etcd_ca_file" json:"metadata_etcd_ca_file"`
+	// MetadataEtcdCertFile and MetadataEtcdKeyFile are the client certificate and key (PEM) to authenticate to etcd
+	// with. Setting either of them or `MetadataEtcdCAFile` makes the endpoints without a scheme use TLS
+	MetadataEtcdCertFile string `toml:"metadata_etcd_cert_file" json:"metadata_etcd_cert_file"`
+	MetadataEtcdKeyFile  string `toml:"metadata_etcd_key_file" json:"metadata_etcd_key_file"`
+	// MetadataEtcdUsername and MetadataEtcdPassword are the credentials of the etcd user to authenticate as. No user
+	// is authenticated if they are empty
+	MetadataEtcdUsername string `toml:"metadata_etcd_username" json:"metadata_etcd_username"`
+	MetadataEtcdPassword string `toml:"metadata_etcd_password" json:"metadata_etcd_password"`
+	// LockDir is the directory of the volumes' lock files (see `FileLockManager`). The volumes' activemounts/
+	// directories are locked instead if it's empty
+	LockDir string `toml:"lock_dir" json:"lock_dir"`
+	// SocketPermissions are the mode and ownership of the UNIX socket (see `DefaultSocketPermissions`)
+	SocketPermissions SocketPermissions `toml:"socket_permissions" json:"socket_permissions"`
+	// LogLevel is the minimum level of the logged messages: "debug", "info", "warn", or "error"
+	LogLevel string `toml:"log_level" json:"log_level"`
+	// LogFormat is the format of the log messages: "json" or "text"
+	LogFormat string `toml:"log_format" json:"log_format"`
+}
+
+// DefaultConfig returns the configuration the plugin uses when neither a configuration file nor flags say otherwise.
+func DefaultConfig() Config {
+	return Config{
+		DotRootDir:        "/var/lib/docker-on-top/",
+		SocketPath:        "/run/docker/plugins/docker-on-top.sock",
+		SocketPermissions: DefaultSocketPermissions(),
+		LogLevel:          "debug",
+		LogFormat:         "json",
+	}
+}
+
+// LoadConfig reads the configuration file at `path`. The options missing from the file keep their values from
+// `DefaultConfig`. The file is parsed as TOML, unless its name ends with ".json". Unknown options are reported as an
+// error.
+//
+// The options set with the environment variables (see `LoadConfigFromEnv`) override the ones from the file.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if strings.HasSuffix(path, ".json") {
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return cfg, err
+		}
+	} else {
+		metadata, err := toml.DecodeFile(path, &cfg)
+		if err != nil {
+			return cfg, err
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return cfg, fmt.Errorf("unknown options in %s: %v", path, undecoded)
+		}
+	}
+	return cfg, applyConfigEnv(&cfg)
+}
+
+// mergeConfig loads the configuration from the file at `path` (or from the environment, if `path` is
+// `defaultConfigPath` and there's no such file), then calls the `overrides` of the flags explicitly set in `flags`
+// (by the flag name) to override the loaded values.
+func mergeConfig(path string, flags *flag.FlagSet, overrides map[string]func(cfg *Config)) (Config, error) {
+	cfg := DefaultConfig()
+	if _, err := os.Stat(path); err == nil || path != defaultConfigPath {
+		cfg, err = LoadConfig(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to load the configuration file: %w", err)
+		}
+	} else if err := applyConfigEnv(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed to load the configuration from the environment: %w", err)
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if override, ok := overrides[f.Name]; ok {
+			override(&cfg)
+		}
+	})
+	return cfg, nil
+}
+
+// configEnvPrefix is the prefix of the environment variables that set the configuration options
+const configEnvPrefix = "DOT_"
+
+// configEnvName returns the environment variable that sets the option with the given TOML name: "DOT_" followed by the
+// upper-cased name, without the duplicated "DOT" (e.g. `DOT_ROOT_DIR` for `dot_root_dir`, `DOT_LOG_LEVEL` for
+// `log_level`).
+func configEnvName(tomlName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.TrimPrefix(tomlName, "dot_"))
+}
+
+// LoadConfigFromEnv returns `DefaultConfig` with the options overridden by the `DOT_*` environment variables that are
+// set (see `configEnvName`). The lists (like `DOT_ALLOWED_BASE_PREFIXES`) are colon-separated (except for the ones
+// whose items contain colons, like `DOT_METADATA_ETCD_ENDPOINTS`, which are comma-separated), the maps (like
+// `DOT_DEFAULT_OPTIONS`) are comma-separated `key=value` pairs. The variables with invalid values are ignored;
+// `LoadConfig` reports them instead.
+func LoadConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	_ = applyConfigEnv(&cfg)
+	return cfg
+}
+
+// applyConfigEnv overrides the options of `cfg` with the `DOT_*` environment variables that are set. The variables
+// with invalid values are skipped and reported in the returned error.
+func applyConfigEnv(cfg *Config) error {
+	return applyConfigEnvStruct(reflect.ValueOf(cfg).Elem(), "")
+}
+
+// applyConfigEnvStruct is `applyConfigEnv` for a (possibly nested) struct of options. The TOML names of the options of
+// a nested struct are prefixed with the struct's own name (e.g. `DOT_SOCKET_PERMISSIONS_MODE` for the `mode` of
+// `socket_permissions`).
+func applyConfigEnvStruct(value reflect.Value, prefix string) error {
+	var errs []error
+	for i := 0; i < value.NumField(); i++ {
+		tag := value.Type().Field(i).Tag
+		tomlName := prefix + tag.Get("toml")
+		if field := value.Field(i); field.Kind() == reflect.Struct {
+			if err := applyConfigEnvStruct(field, tomlName+"_"); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		name := configEnvName(tomlName)
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		switch field := value.Field(i); field.Kind() {
+		case reflect.String:
+			field.SetString(env)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(env)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid value of %s: %q is not a boolean", name, env))
+				continue
+			}
+			field.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(env, 10, field.Type().Bits())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid value of %s: %q is not an integer", name, env))
+				continue
+			}
+			field.SetInt(n)
+		case reflect.Uint32:
+			// File modes are usually written in octal, like "0660"
+			n, err := strconv.ParseUint(env, 0, 32)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid value of %s: %q is not an unsigned integer", name, env))
+				continue
+			}
+			field.SetUint(n)
+		case reflect.Slice:
+			if field.Type() != reflect.TypeOf([]string(nil)) {
+				errs = append(errs, unsupportedConfigTypeError(name, field))
+				continue
+			}
+			if sep := tag.Get("sep"); sep != "" {
+				// The items may contain colons, like URLs do
+				field.Set(reflect.ValueOf(strings.Split(env, sep)))
+			} else {
+				field.Set(reflect.ValueOf(splitList(env)))
+			}
+		case reflect.Map:
+			if field.Type() != reflect.TypeOf(map[string]string(nil)) {
+				errs = append(errs, unsupportedConfigTypeError(name, field))
+				continue
+			}
+			pairs := map[string]string{}
+			valid := true
+			for _, pair := range strings.Split(env, ",") {
+				key, value, ok := strings.Cut(pair, "=")
+				if !ok {
+					errs = append(errs, fmt.Errorf("invalid value of %s: %q is not a key=value pair", name, pair))
+					valid = false
+					break
+				}
+				pairs[key] = value
+			}
+			if valid {
+				field.Set(reflect.ValueOf(pairs))
+			}
+		default:
+			// Can't happen unless a field of a new type is added to `Config`
+			errs = append(errs, unsupportedConfigTypeError(name, field))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unsupportedConfigTypeError is reported by `applyConfigEnvStruct` for the options whose types cannot be set with
+// environment variables.
+func unsupportedConfigTypeError(name string, field reflect.Value) error {
+	return fmt.Errorf("cannot set %s: options of type %s are not supported", name, field.Type())
+}
+
+// maxSocketPathLength is the maximum length of a UNIX socket path (the size of `sun_path` minus the terminating null)
+const maxSocketPathLength = 107
+
+// ValidateConfig checks that the configuration is usable.
+func ValidateConfig(cfg Config) error {
+	if !filepath.IsAbs(cfg.DotRootDir) {
+		return fmt.Errorf("the dot root directory %q must be an absolute path", cfg.DotRootDir)
+	}
+	if !filepath.IsAbs(cfg.SocketPath) {
+		return fmt.Errorf("the socket path %q must be an absolute path", cfg.SocketPath)
+	} else if strings.HasSuffix(cfg.SocketPath, "/") {
+		return fmt.Errorf("the socket path %q must not be a directory", cfg.SocketPath)
+	} else if len(cfg.SocketPath) > maxSocketPathLength {
+		return fmt.Errorf("the socket path %q is too long (at most %d bytes are allowed)", cfg.SocketPath,
+			maxSocketPathLength)
+	}
+	if cfg.SocketPermissions.Mode&^os.ModePerm != 0 {
+		return fmt.Errorf("the socket mode %o must only contain permission bits", uint32(cfg.SocketPermissions.Mode))
+	}
+	if _, err := cleanBasePrefixes(cfg.AllowedBasePrefixes); err != nil {
+		return err
+	}
+	if _, err := cleanBasePrefixes(cfg.DeniedBasePrefixes); err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(cfg.DefaultOptions) {
+		if !isVolumeOption(name) {
+			return fmt.Errorf("invalid default volume option %s", name)
+		}
+	}
+	if len(cfg.MetadataEtcdEndpoints) > 0 {
+		if _, err := etcdClientConfig(cfg); err != nil {
+			return err
+		}
+	}
+	if cfg.LockDir != "" {
+		if !filepath.IsAbs(cfg.LockDir) {
+			return fmt.Errorf("the lock directory must be an absolute path, got %s", cfg.LockDir)
+		} else if isPathUnder(filepath.Clean(cfg.LockDir), filepath.Clean(cfg.DotRootDir)) {
+			return fmt.Errorf("the lock directory %s must not be inside the dot root directory", cfg.LockDir)
+		}
+	}
+	if cfg.AccessLogMaxSize < 0 {
+		return fmt.Errorf("the access log size limit must not be negative, got %d", cfg.AccessLogMaxSize)
+	}
+	if cfg.MountTimeout != "" {
+		if timeout, err := time.ParseDuration(cfg.MountTimeout); err != nil {
+			return fmt.Errorf("invalid mount timeout: %w", err)
+		} else if timeout <= 0 {
+			return fmt.Errorf("the mount timeout must be positive, got %s", cfg.MountTimeout)
+		}
+	}
+	if cfg.HookTimeout != "" {
+		if timeout, err := time.ParseDuration(cfg.HookTimeout); err != nil {
+			return fmt.Errorf("invalid hook timeout: %w", err)
+		} else if timeout <= 0 {
+			return fmt.Errorf("the hook timeout must be positive, got %s", cfg.HookTimeout)
+		}
+	}
+	if cfg.HooksDir != "" && !filepath.IsAbs(cfg.HooksDir) {
+		return fmt.Errorf("the hooks directory must be an absolute path, got %s", cfg.HooksDir)
+	}
+	if cfg.MountWatchdogInterval != "" {
+		if interval, err := time.ParseDuration(cfg.MountWatchdogInterval); err != nil {
+			return fmt.Errorf("invalid mount watchdog interval: %w", err)
+		} else if interval <= 0 {
+			return fmt.Errorf("the mount watchdog interval must be positive, got %s", cfg.MountWatchdogInterval)
+		}
+	}
+	if cfg.BootConcurrency < 0 {
+		return fmt.Errorf("the boot concurrency must not be negative, got %d", cfg.BootConcurrency)
+	}
+	if cfg.LogFormat != "json" && cfg.LogFormat != "text" {
+		return fmt.Errorf("unknown log format %q (must be either \"json\" or \"text\")", cfg.LogFormat)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return fmt.Errorf("unknown log level %q: %w", cfg.LogLevel, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// exportedVolume is a volume's record in the output of `ExportConfig`
+type exportedVolume struct {
+	VolumeInfo
+	// Namespaced is set if the volume is namespaced (see namespaces.go)
+	Namespaced bool `json:",omitempty"`
+}
+
+// ExportConfig returns the metadata of all the volumes as a JSON object keyed by the volume names, so that the volumes
+// can be recreated on another host with `ImportConfig`. The changes made to the volumes are not included (see
+// `ExportVolumeDiff` for that). Volumes with unreadable metadata are skipped (with a warning).
+func (d *DockerOnTop) ExportConfig() ([]byte, error) {
+	names, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := map[string]exportedVolume{}
+	for _, name := range names {
+		thisVol, err := d.getVolumeInfo(name)
+		if err != nil {
+			d.logger.Warn("Failed to retrieve metadata for the volume. Not exporting it", "volume", name, "error", err)
+			continue
+		}
+		volumes[name] = exportedVolume{VolumeInfo: thisVol, Namespaced: d.isNamespaced(name)}
+	}
+	return json.MarshalIndent(volumes, "", "  ")
+}
+
+// ImportConfig creates the volumes from the output of `ExportConfig` with `Create`, with the options they were created
+// with originally (their statistics, like the creation time, are not preserved). The volumes that already exist are
+// left alone.
+//
+// The volumes whose base directories don't exist are skipped with a warning, unless `createMissing` is set: then the
+// base directories are created. The forks (see `ForkVolume`) are skipped with a warning as well, as their templates
+// would need to be mounted. The other failures to create volumes don't stop the import; they are joined into the
+// returned error.
+func (d *DockerOnTop) ImportConfig(data []byte, createMissing bool) error {
+	var volumes map[string]exportedVolume
+	if err := json.Unmarshal(data, &volumes); err != nil {
+		return fmt.Errorf("invalid volume configuration: %w", err)
+	}
+
+	var errs []error
+	for _, name := range sortedKeys(volumes) {
+		vol := volumes[name]
+		if _, err := os.Lstat(d.volumeDir(name)); err == nil {
+			d.logger.Debug("Volume already exists. Not importing it", "volume", name)
+			continue
+		}
+		if vol.ParentVolume != "" {
+			d.logger.Warn("Not importing a fork: fork the template volume again", "volume", name,
+				"template", vol.ParentVolume)
+			continue
+		}
+		if _, err := os.Stat(vol.BaseDirPath); os.IsNotExist(err) {
+			if !createMissing {
+				d.logger.Warn("Base directory of the volume doesn't exist. Not importing it", "volume", name,
+					"baseDir", vol.BaseDirPath)
+				continue
+			}
+			if err := os.MkdirAll(vol.BaseDirPath, os.ModePerm); err != nil {
+				errs = append(errs, fmt.Errorf("%s: failed to create the base directory: %w", name, err))
+				continue
+			}
+			d.logger.Info("Created the missing base directory", "volume", name, "baseDir", vol.BaseDirPath)
+		}
+
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: vol.createOptions()}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		d.logger.Info("Imported volume", "volume", name)
+	}
+	return errors.Join(errs...)
+}
+
+// createOptions returns the `Create` options that make a volume like this one.
+func (vol *exportedVolume) createOptions() map[string]string {
+	options := map[string]string{
+		"base":      vol.BaseDirPath,
+		"volatile":  strconv.FormatBool(vol.Volatile),
+		"readonly":  strconv.FormatBool(vol.ReadOnly),
+		"lazy":      strconv.FormatBool(vol.LazyUnmount),
+		"noexec":    strconv.FormatBool(vol.NoExec),
+		"nosuid":    strconv.FormatBool(vol.NoSuid),
+		"nodev":     strconv.FormatBool(vol.NoDev),
+		"userxattr": strconv.FormatBool(vol.UserXattr),
+	}
+	if len(vol.LowerLayers) > 0 {
+		options["layers"] = strings.Join(vol.LowerLayers, ":")
+	}
+	if len(vol.Tags) > 0 {
+		options["tags"] = strings.Join(vol.Tags, ",")
+	}
+	if vol.CustomUpperDir != "" {
+		options["upper"] = vol.CustomUpperDir
+		options["work"] = vol.CustomWorkDir
+	}
+	for name, value := range vol.OverlayOptions {
+		options[name] = value
+	}
+	if vol.PreMountHook != "" {
+		options["premount"] = vol.PreMountHook
+	}
+	if vol.PostMountHook != "" {
+		options["postmount"] = vol.PostMountHook
+	}
+	if vol.PreUnmountHook != "" {
+		options["preunmount"] = vol.PreUnmountHook
+	}
+	if vol.PostUnmountHook != "" {
+		options["postunmount"] = vol.PostUnmountHook
+	}
+	if vol.Namespaced {
+		options["namespaced"] = "true"
+	}
+	if vol.QuotaBytes > 0 {
+		options["quota"] = strconv.FormatInt(vol.QuotaBytes, 10)
+	}
+	if vol.TmpfsSizeBytes > 0 {
+		options["tmpfs-size"] = strconv.FormatInt(vol.TmpfsSizeBytes, 10)
+	}
+	if vol.WriteIOPS > 0 {
+		options["write-iops"] = strconv.FormatInt(vol.WriteIOPS, 10)
+	}
+	if vol.WriteBPS > 0 {
+		options["write-bps"] = strconv.FormatInt(vol.WriteBPS, 10)
+	}
+	return options
+}
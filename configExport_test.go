@@ -0,0 +1,98 @@
+//go:build dottest
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestExportImportConfig(t *testing.T) {
+	source := NewTestDockerOnTop(t)
+	MustCreateVolume(t, source, "plain", t.TempDir())
+	requests := map[string]map[string]string{
+		"tuned":  {"volatile": "true", "tags": "team,ci", "index": "off", "noexec": "true"},
+		"layers": {"layers": t.TempDir() + ":" + t.TempDir(), "readonly": "true"},
+		"ns.vol": {"namespaced": "true"},
+	}
+	for name, options := range requests {
+		options["base"] = t.TempDir()
+		if err := source.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	missingBase := t.TempDir() + "/base"
+	if err := os.Mkdir(missingBase, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	MustCreateVolume(t, source, "missing-base", missingBase)
+	if err := os.Remove(missingBase); err != nil {
+		t.Fatal(err)
+	}
+	// The changes are not exported
+	writeFiles(t, source.VolumeUpperDir("plain"), map[string]string{"change": "upper"})
+
+	data, err := source.ExportConfig()
+	if err != nil {
+		t.Fatalf("ExportConfig failed: %v", err)
+	}
+	var exported map[string]json.RawMessage
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("The exported configuration is not a JSON object: %v", err)
+	}
+	want := []string{"layers", "missing-base", "ns.vol", "plain", "tuned"}
+	if names := sortedKeys(exported); !slices.Equal(names, want) {
+		t.Errorf("The exported volumes are %v, want %v", names, want)
+	}
+
+	target := NewTestDockerOnTop(t)
+	if err := target.ImportConfig(data, false); err != nil {
+		t.Fatalf("ImportConfig failed: %v", err)
+	}
+	for _, name := range want {
+		if name == "missing-base" {
+			if _, err := target.Get(&volume.GetRequest{Name: name}); err == nil {
+				t.Error("The volume with a missing base directory was imported")
+			}
+			continue
+		}
+		sourceVol, err := source.getVolumeInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targetVol, err := target.getVolumeInfo(name)
+		if err != nil {
+			t.Errorf("Volume %s was not imported: %v", name, err)
+			continue
+		}
+		sourceRecord := exportedVolume{VolumeInfo: sourceVol, Namespaced: source.isNamespaced(name)}
+		targetRecord := exportedVolume{VolumeInfo: targetVol, Namespaced: target.isNamespaced(name)}
+		if !reflect.DeepEqual(targetRecord.createOptions(), sourceRecord.createOptions()) {
+			t.Errorf("Volume %s is imported with the options %v, want %v", name, targetRecord.createOptions(),
+				sourceRecord.createOptions())
+		}
+	}
+	if _, err := os.Stat(target.VolumeUpperDir("plain") + "change"); !os.IsNotExist(err) {
+		t.Errorf("The changes made to the volume were imported (%v)", err)
+	}
+
+	// Importing again leaves the existing volumes alone, and creates the missing base directory if asked to
+	if err := target.ImportConfig(data, true); err != nil {
+		t.Fatalf("ImportConfig failed: %v", err)
+	}
+	if vol, err := target.getVolumeInfo("missing-base"); err != nil || vol.BaseDirPath != missingBase {
+		t.Errorf("The volume with a missing base directory is imported as %+v, %v", vol, err)
+	}
+	if info, err := os.Stat(missingBase); err != nil || !info.IsDir() {
+		t.Errorf("The missing base directory was not created (%v)", err)
+	}
+
+	if err := target.ImportConfig([]byte("[]"), false); err == nil {
+		t.Error("ImportConfig accepted invalid JSON")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfigEnv(t *testing.T) {
+	t.Setenv("DOT_ROOT_DIR", "/srv/dot")
+	t.Setenv("DOT_DEFAULT_VOLATILE", "true")
+	t.Setenv("DOT_ACCESS_LOG_MAX_SIZE", "4096")
+	t.Setenv("DOT_SOCKET_PERMISSIONS_MODE", "0640")
+	t.Setenv("DOT_ALLOWED_BASE_PREFIXES", "/data:/srv")
+	t.Setenv("DOT_METADATA_ETCD_ENDPOINTS", "http://a:2379,http://b:2379")
+	t.Setenv("DOT_DEFAULT_OPTIONS", "volatile=true,lazy-unmount=false")
+
+	cfg := DefaultConfig()
+	if err := applyConfigEnv(&cfg); err != nil {
+		t.Fatalf("applyConfigEnv failed: %v", err)
+	}
+	if cfg.DotRootDir != "/srv/dot" {
+		t.Errorf("DotRootDir = %q, want %q", cfg.DotRootDir, "/srv/dot")
+	}
+	if !cfg.DefaultVolatile {
+		t.Error("DefaultVolatile is not set")
+	}
+	if cfg.AccessLogMaxSize != 4096 {
+		t.Errorf("AccessLogMaxSize = %d, want 4096", cfg.AccessLogMaxSize)
+	}
+	if cfg.SocketPermissions.Mode != 0o640 {
+		t.Errorf("SocketPermissions.Mode = %o, want 640", cfg.SocketPermissions.Mode)
+	}
+	if want := []string{"/data", "/srv"}; !slices.Equal(cfg.AllowedBasePrefixes, want) {
+		t.Errorf("AllowedBasePrefixes = %v, want %v", cfg.AllowedBasePrefixes, want)
+	}
+	if want := []string{"http://a:2379", "http://b:2379"}; !slices.Equal(cfg.MetadataEtcdEndpoints, want) {
+		t.Errorf("MetadataEtcdEndpoints = %v, want %v", cfg.MetadataEtcdEndpoints, want)
+	}
+	if want := map[string]string{"volatile": "true", "lazy-unmount": "false"}; !reflect.DeepEqual(cfg.DefaultOptions,
+		want) {
+		t.Errorf("DefaultOptions = %v, want %v", cfg.DefaultOptions, want)
+	}
+}
+
+func TestApplyConfigEnvInvalidValues(t *testing.T) {
+	t.Setenv("DOT_DEFAULT_VOLATILE", "sometimes")
+	t.Setenv("DOT_BOOT_CONCURRENCY", "many")
+	t.Setenv("DOT_LOG_LEVEL", "debug")
+
+	cfg := DefaultConfig()
+	err := applyConfigEnv(&cfg)
+	if err == nil {
+		t.Fatal("applyConfigEnv succeeded")
+	}
+	for _, name := range []string{"DOT_DEFAULT_VOLATILE", "DOT_BOOT_CONCURRENCY"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("The error %q doesn't mention %s", err, name)
+		}
+	}
+	// The valid variables are still applied
+	if cfg.LogLevel != "debug" {
+		t.Errorf("LogLevel = %q, want %q", cfg.LogLevel, "debug")
+	}
+	if cfg.DefaultVolatile != DefaultConfig().DefaultVolatile {
+		t.Error("DefaultVolatile was changed by an invalid value")
+	}
+}
+
+func TestApplyConfigEnvUnsupportedType(t *testing.T) {
+	var options struct {
+		Ratio  float64        `toml:"ratio"`
+		Counts map[string]int `toml:"counts"`
+		Name   string         `toml:"name"`
+	}
+	t.Setenv("DOT_RATIO", "0.5")
+	t.Setenv("DOT_COUNTS", "a=1")
+	t.Setenv("DOT_NAME", "test")
+
+	err := applyConfigEnvStruct(reflect.ValueOf(&options).Elem(), "")
+	if err == nil {
+		t.Fatal("applyConfigEnvStruct succeeded")
+	}
+	for _, name := range []string{"DOT_RATIO", "DOT_COUNTS"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("The error %q doesn't mention %s", err, name)
+		}
+	}
+	if options.Name != "test" {
+		t.Errorf("Name = %q, want %q", options.Name, "test")
+	}
+}
+
+func TestLoadBasePrefixesConfig(t *testing.T) {
+	path := t.TempDir() + "/prefixes.json"
+	payload := `{"AllowedBasePrefixes": ["/var/data"], "DeniedBasePrefixes": ["/var/data/secrets", "/var/data/keys"]}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadBasePrefixesConfig(path)
+	if err != nil {
+		t.Fatalf("loadBasePrefixesConfig failed: %v", err)
+	}
+	if want := []string{"/var/data"}; !slices.Equal(cfg.AllowedBasePrefixes, want) {
+		t.Errorf("AllowedBasePrefixes = %v, want %v", cfg.AllowedBasePrefixes, want)
+	}
+	if want := []string{"/var/data/secrets", "/var/data/keys"}; !slices.Equal(cfg.DeniedBasePrefixes, want) {
+		t.Errorf("DeniedBasePrefixes = %v, want %v", cfg.DeniedBasePrefixes, want)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"AllowedBasePrefixes": "/var/data"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadBasePrefixesConfig(path); err == nil {
+		t.Error("A malformed file was loaded")
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	if cfg := LoadConfigFromEnv(); !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Errorf("Without variables, LoadConfigFromEnv = %+v, want the defaults", cfg)
+	}
+
+	t.Setenv("DOT_LOG_LEVEL", "debug")
+	t.Setenv("DOT_ALLOWED_BASE_PREFIXES", "/data:/srv:/mnt/shared")
+	t.Setenv("DOT_BOOT_CONCURRENCY", "many")
+	cfg := LoadConfigFromEnv()
+	want := DefaultConfig()
+	want.LogLevel = "debug"
+	want.AllowedBasePrefixes = []string{"/data", "/srv", "/mnt/shared"}
+	// The invalid variables are ignored
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadConfigFromEnv = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigEnvPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml": "dot_root_dir = \"/srv/file\"\nlog_level = \"warn\"\nallowed_base_prefixes = [\"/file\"]\n",
+		"config.json": `{"dot_root_dir": "/srv/file", "log_level": "warn", "allowed_base_prefixes": ["/file"]}`,
+	}
+	t.Setenv("DOT_ROOT_DIR", "/srv/env")
+	t.Setenv("DOT_ALLOWED_BASE_PREFIXES", "/env1:/env2")
+	for name, contents := range files {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig(%s) failed: %v", name, err)
+		}
+		want := DefaultConfig()
+		want.DotRootDir = "/srv/env"
+		want.LogLevel = "warn" // Not overridden by the environment
+		want.AllowedBasePrefixes = []string{"/env1", "/env2"}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("LoadConfig(%s) = %+v, want %+v", name, cfg, want)
+		}
+	}
+
+	// Unlike `LoadConfigFromEnv`, `LoadConfig` reports the invalid variables
+	t.Setenv("DOT_BOOT_CONCURRENCY", "many")
+	_, err := LoadConfig(dir + "/config.toml")
+	if err == nil || !strings.Contains(err.Error(), "DOT_BOOT_CONCURRENCY") {
+		t.Errorf("LoadConfig = %v; want an error about DOT_BOOT_CONCURRENCY", err)
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	path := t.TempDir() + "/config.toml"
+	contents := `dot_root_dir = "/srv/dot"
+socket_path = "/run/from-file.sock"
+default_volatile = true
+allowed_base_prefixes = ["/data"]
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	socketPath := flags.String("socket-path", "", "")
+	logLevel := flags.String("log-level", "", "")
+	overrides := map[string]func(cfg *Config){
+		"socket-path": func(cfg *Config) { cfg.SocketPath = *socketPath },
+		"log-level":   func(cfg *Config) { cfg.LogLevel = *logLevel },
+	}
+	if err := flags.Parse([]string{"--socket-path", "/run/from-flag.sock"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := mergeConfig(path, flags, overrides)
+	if err != nil {
+		t.Fatalf("mergeConfig failed: %v", err)
+	}
+	want := DefaultConfig()
+	want.DotRootDir = "/srv/dot"
+	want.SocketPath = "/run/from-flag.sock" // The flag overrides the file
+	want.DefaultVolatile = true
+	want.AllowedBasePrefixes = []string{"/data"}
+	// The flags not given don't override anything
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("mergeConfig = %+v, want %+v", cfg, want)
+	}
+
+	if err := os.WriteFile(path, []byte("unknown_option = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mergeConfig(path, flags, overrides); err == nil {
+		t.Error("mergeConfig accepted a file with an unknown option")
+	}
+	if _, err := mergeConfig(path+".missing", flags, overrides); err == nil {
+		t.Error("mergeConfig accepted a missing file")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	if err := ValidateConfig(DefaultConfig()); err != nil {
+		t.Errorf("The default configuration is invalid: %v", err)
+	}
+	tests := map[string]func(cfg *Config){
+		"relative dot root dir": func(cfg *Config) { cfg.DotRootDir = "dot" },
+		"relative socket path":  func(cfg *Config) { cfg.SocketPath = "plugin.sock" },
+		"socket directory":      func(cfg *Config) { cfg.SocketPath = "/run/docker/plugins/" },
+		"long socket path":      func(cfg *Config) { cfg.SocketPath = "/" + strings.Repeat("x", maxSocketPathLength) },
+		"setuid socket mode":    func(cfg *Config) { cfg.SocketPermissions.Mode = os.ModeSetuid | 0o660 },
+	}
+	for name, modify := range tests {
+		cfg := DefaultConfig()
+		modify(&cfg)
+		if err := ValidateConfig(cfg); err == nil {
+			t.Errorf("ValidateConfig accepted the configuration with a %s", name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+)
+
+// SetDefaultOptions sets the volume options `Create` uses when they are not given in the request (e.g. a common
+// `base`), replacing the previously set ones. The options given in the request take precedence. The option names are
+// checked (see `isVolumeOption`); the values are only validated when a volume is created with them.
+//
+// Note that the defaults are used like the options given explicitly, so, for example, a default `volatile` takes
+// precedence over `DefaultVolatile`.
+func (d *DockerOnTop) SetDefaultOptions(options map[string]string) error {
+	for _, name := range sortedKeys(options) {
+		if !isVolumeOption(name) {
+			return fmt.Errorf("invalid default option %s", name)
+		}
+	}
+	d.defaultOptionsMutex.Lock()
+	defer d.defaultOptionsMutex.Unlock()
+	d.defaultOptions = maps.Clone(options)
+	return nil
+}
+
+// withDefaultOptions returns the options of a `Create` request completed with the default options (see
+// `SetDefaultOptions`). `options` is not modified.
+func (d *DockerOnTop) withDefaultOptions(options map[string]string) map[string]string {
+	d.defaultOptionsMutex.RLock()
+	defer d.defaultOptionsMutex.RUnlock()
+	merged := maps.Clone(d.defaultOptions)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, options)
+	return merged
+}
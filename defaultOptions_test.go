@@ -0,0 +1,62 @@
+//go:build dottest
+
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestDefaultOptions(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	defaultBase, requestBase := t.TempDir(), t.TempDir()
+	defaults := map[string]string{"base": defaultBase, "volatile": "true"}
+	if err := d.SetDefaultOptions(defaults); err != nil {
+		t.Fatalf("SetDefaultOptions failed: %v", err)
+	}
+	// The defaults are copied
+	defaults["base"] = "/elsewhere"
+
+	if err := d.Create(&volume.CreateRequest{Name: "default"}); err != nil {
+		t.Fatalf("Creating a volume without options failed: %v", err)
+	}
+	err := d.Create(&volume.CreateRequest{Name: "overridden", Options: map[string]string{
+		"base":     requestBase,
+		"volatile": "false",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]VolumeInfo{
+		"default":    {BaseDirPath: defaultBase, Volatile: true},
+		"overridden": {BaseDirPath: requestBase, Volatile: false},
+	} {
+		vol, err := d.getVolumeInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vol.BaseDirPath != want.BaseDirPath || vol.Volatile != want.Volatile {
+			t.Errorf("Volume %s has the base %s and volatile=%v; want %s and %v", name, vol.BaseDirPath,
+				vol.Volatile, want.BaseDirPath, want.Volatile)
+		}
+	}
+
+	// An invalid option doesn't replace the defaults
+	if err := d.SetDefaultOptions(map[string]string{"bsae": requestBase}); err == nil {
+		t.Error("SetDefaultOptions accepted an unknown option")
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "still-default"}); err != nil {
+		t.Errorf("After an invalid SetDefaultOptions, creating a volume without options failed: %v", err)
+	} else if vol, err := d.getVolumeInfo("still-default"); err != nil || vol.BaseDirPath != defaultBase {
+		t.Errorf("After an invalid SetDefaultOptions, the volume has the base %s, %v; want %s", vol.BaseDirPath, err,
+			defaultBase)
+	}
+
+	if err := d.SetDefaultOptions(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "no-base"}); err == nil {
+		t.Error("A volume was created without a base directory")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// staleActivemountAge is the age after which `Diagnose` reports an active mount file as stale
+const staleActivemountAge = 24 * time.Hour
+
+// Diagnose writes a human-readable report on the state of the plugin and the host to `w`, for troubleshooting: the
+// plugin and kernel versions, whether overlays can be mounted, the number of volumes and active mounts, the disk usage
+// of the dot root directory, the volumes whose base directories are missing, and the active mount files older than
+// `staleActivemountAge`.
+//
+// The problems found are reported in the output rather than returned; only a failure to write the report (or to list
+// the volumes) is an error. As the overlay probe mounts an overlay, the report is complete only when run as root.
+func (d *DockerOnTop) Diagnose(w io.Writer) error {
+	volumeNames, err := d.listVolumeNames()
+	if err != nil {
+		return fmt.Errorf("failed to list the volumes: %w", err)
+	}
+	activeMounts, err := d.ListActiveMounts()
+	if err != nil {
+		return fmt.Errorf("failed to list the active mounts: %w", err)
+	}
+
+	out := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	first := true
+	section := func(title string) {
+		if !first {
+			fmt.Fprintln(out)
+		}
+		first = false
+		fmt.Fprintf(out, "== %s ==\n", title)
+	}
+
+	section("Versions")
+	fmt.Fprintf(out, "Plugin:\t%s\n", version)
+	fmt.Fprintf(out, "Kernel:\t%s\n", orUnknown(kernelVersion()))
+
+	section("Overlay support")
+	if err := d.probeOverlay("overlay"); err != nil {
+		fmt.Fprintf(out, "FAILED: %v\n", err)
+	} else {
+		fmt.Fprintln(out, "OK: overlays can be mounted")
+	}
+
+	section("Volumes")
+	fmt.Fprintf(out, "Volumes:\t%d\n", len(volumeNames))
+	fmt.Fprintf(out, "Active mounts:\t%d\n", len(activeMounts))
+
+	section("Disk usage")
+	fmt.Fprintf(out, "Dot root directory:\t%s\n", d.dotRootDir)
+	if used, err := dirUsage(d.dotRootDir); err != nil {
+		fmt.Fprintf(out, "Used by the plugin:\tunknown (%v)\n", err)
+	} else {
+		fmt.Fprintf(out, "Used by the plugin:\t%d bytes\n", used)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(d.dotRootDir, &stat); err != nil {
+		fmt.Fprintf(out, "Filesystem:\tunknown (%v)\n", err)
+	} else {
+		fmt.Fprintf(out, "Filesystem:\t%d of %d bytes available\n", stat.Bavail*uint64(stat.Bsize),
+			stat.Blocks*uint64(stat.Bsize))
+	}
+
+	section("Volumes with missing base directories")
+	missing := 0
+	for _, volumeName := range volumeNames {
+		thisVol, err := d.getVolumeInfo(volumeName)
+		if err != nil {
+			fmt.Fprintf(out, "WARNING: %s:\tfailed to read the metadata: %v\n", volumeName, err)
+			continue
+		}
+		if _, err := os.Stat(thisVol.BaseDirPath); err != nil {
+			fmt.Fprintf(out, "WARNING: %s:\tbase directory %s is missing (%v)\n", volumeName, thisVol.BaseDirPath,
+				err)
+			missing++
+		}
+	}
+	if missing == 0 {
+		fmt.Fprintln(out, "None")
+	}
+
+	section(fmt.Sprintf("Active mount files older than %s", staleActivemountAge))
+	stale := 0
+	for _, activeMount := range activeMounts {
+		info, err := os.Stat(d.activemountsdir(activeMount.VolumeName) + activeMount.ContainerID)
+		if err != nil {
+			// Unmounted since it was listed
+			continue
+		}
+		if age := time.Since(info.ModTime()); age > staleActivemountAge {
+			fmt.Fprintf(out, "WARNING: %s:\tmounted by %s since %s\n", activeMount.VolumeName,
+				activeMount.ContainerID, info.ModTime().Format(time.RFC3339))
+			stale++
+		}
+	}
+	if stale == 0 {
+		fmt.Fprintln(out, "None")
+	}
+
+	return out.Flush()
+}
+
+// orUnknown returns `s`, or "unknown" if it's empty.
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// dirUsage returns the total size of the regular files under `root`. The directories on other filesystems, like the
+// volumes' mountpoints, are not walked.
+func dirUsage(root string) (int64, error) {
+	var rootStat syscall.Stat_t
+	if err := syscall.Stat(root, &rootStat); err != nil {
+		return 0, err
+	}
+	var total int64
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Removed during the walk
+				return nil
+			}
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Dev != rootStat.Dev {
+				return filepath.SkipDir
+			}
+		} else if entry.Type().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
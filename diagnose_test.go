@@ -0,0 +1,82 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDiagnose(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "healthy", t.TempDir())
+	MustMountVolume(t, d, "healthy", "fresh")
+	missingBase := t.TempDir() + "/base"
+	if err := os.Mkdir(missingBase, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	MustCreateVolume(t, d, "orphan", missingBase)
+	if err := os.Remove(missingBase); err != nil {
+		t.Fatal(err)
+	}
+	MustCreateVolume(t, d, "stale", t.TempDir())
+	MustMountVolume(t, d, "stale", "forgotten")
+	old := time.Now().Add(-2 * staleActivemountAge)
+	if err := os.Chtimes(d.activemountsdir("stale")+"forgotten", old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := d.Diagnose(&out); err != nil {
+		t.Fatalf("Diagnose failed: %v", err)
+	}
+	report := out.String()
+	for _, want := range []string{
+		`== Versions ==\nPlugin:\s+` + regexp.QuoteMeta(version) + `\n`,
+		`== Overlay support ==\nOK: overlays can be mounted\n`,
+		`Volumes:\s+3\nActive mounts:\s+2\n`,
+		`Dot root directory:\s+` + regexp.QuoteMeta(d.DotRootDirPath()) + `\n`,
+		`WARNING: orphan:\s+base directory ` + regexp.QuoteMeta(missingBase) + ` is missing`,
+		`WARNING: stale:\s+mounted by forgotten since`,
+	} {
+		if !regexp.MustCompile(want).MatchString(report) {
+			t.Errorf("The report doesn't match %q:\n%s", want, report)
+		}
+	}
+	for _, unwanted := range []string{"healthy", "fresh"} {
+		if strings.Contains(report, unwanted) {
+			t.Errorf("The report mentions %s:\n%s", unwanted, report)
+		}
+	}
+}
+
+func TestDiagnoseNoProblems(t *testing.T) {
+	failingMount := func(d *DockerOnTop) {
+		d.mountSyscall = func(string, string, string, uintptr, string) error { return syscall.ENODEV }
+	}
+	d := NewTestDockerOnTop(t, failingMount)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+
+	var out bytes.Buffer
+	if err := d.Diagnose(&out); err != nil {
+		t.Fatalf("Diagnose failed: %v", err)
+	}
+	report := out.String()
+	for _, want := range []string{
+		`== Overlay support ==\nFAILED: overlayfs is not usable: `,
+		`== Volumes with missing base directories ==\nNone\n`,
+		`== Active mount files older than 24h0m0s ==\nNone\n`,
+	} {
+		if !regexp.MustCompile(want).MatchString(report) {
+			t.Errorf("The report doesn't match %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "WARNING") {
+		t.Errorf("The report contains warnings:\n%s", report)
+	}
+}
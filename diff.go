@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ChangeKind describes how an entry of a volume differs from its base directory
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeModified ChangeKind = "modified"
+	ChangeDeleted  ChangeKind = "deleted"
+)
+
+// ChangedEntry is a single entry of the list of changes made to a volume (see `DockerOnTop.Diff`)
+type ChangedEntry struct {
+	// Path is the path of the changed entry relative to the volume's root
+	Path string
+	Kind ChangeKind
+	// Size is the size of the entry in the upper layer (zero for deleted entries)
+	Size int64
+}
+
+// Diff lists the changes made to the volume relative to its lower directories, based on the contents of its upperdir:
+//   - whiteouts are reported as deleted entries;
+//   - other files are reported as modified if they exist in any of the lower directories, and as added otherwise;
+//   - directories are only reported if they are new (added) or opaque (modified: their lower contents are hidden).
+//     Other directories only exist in the upperdir because some of their contents changed.
+//
+// The upperdir is reached through the merged view (see `upperdirWalk`) and only read, so Diff works whether the volume
+// is mounted or not (although the result may be inconsistent if the volume's contents change during the call).
+func (d *DockerOnTop) Diff(volumeName string) ([]ChangedEntry, error) {
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if os.IsNotExist(err) {
+		return nil, errors.New("no such volume")
+	} else if err != nil {
+		return nil, err
+	}
+	lowerdirs := thisVol.lowerDirs()
+
+	existsInLower := func(relPath string) bool {
+		for _, lowerdir := range lowerdirs {
+			if _, err := os.Lstat(filepath.Join(lowerdir, relPath)); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	upperdir := d.upperdir(volumeName, &thisVol)
+	changes := []ChangedEntry{}
+	err = d.upperdirWalk(volumeName, upperdir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(upperdir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." || info.Name() == opaqueMarker {
+			return nil
+		}
+
+		if whiteout, target := isWhiteout(info.Name(), info); whiteout {
+			deleted := filepath.Join(filepath.Dir(relPath), target)
+			changes = append(changes, ChangedEntry{Path: deleted, Kind: ChangeDeleted})
+			return nil
+		}
+
+		kind := ChangeAdded
+		if existsInLower(relPath) {
+			kind = ChangeModified
+			if info.IsDir() && !isOpaqueDir(path) {
+				return nil
+			}
+		}
+		changes = append(changes, ChangedEntry{Path: relPath, Kind: kind, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,92 @@
+//go:build dottest
+
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDiff(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"modified":        "base",
+		"deleted":         "base",
+		"deleted-legacy":  "base",
+		"link-over-file":  "base",
+		"dir/unchanged":   "base",
+		"opaque/hidden":   "base",
+		"opaque-marker/x": "base",
+	})
+	MustCreateVolume(t, d, "vol", base)
+
+	upper := d.VolumeUpperDir("vol")
+	writeFiles(t, upper, map[string]string{
+		"modified":                      "upper",
+		"added":                         "upper",
+		"dir/added":                     "upper",
+		"new-dir/file":                  "upper",
+		"opaque/visible":                "upper",
+		"opaque-marker/" + opaqueMarker: "",
+	})
+	if err := syscall.Mknod(upper+"deleted", syscall.S_IFCHR, 0); errors.Is(err, syscall.EPERM) {
+		t.Skip("Creating whiteouts is not permitted")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, upper, map[string]string{whiteoutPrefix + "deleted-legacy": ""})
+	if err := unix.Setxattr(upper+"opaque", "user.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("modified", upper+"link-over-file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/nowhere", upper+"dir/added-link"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := d.Diff("vol")
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	got := map[string]ChangeKind{}
+	for _, change := range changes {
+		if _, ok := got[change.Path]; ok {
+			t.Errorf("%s is reported more than once", change.Path)
+		}
+		got[change.Path] = change.Kind
+		if change.Kind == ChangeDeleted && change.Size != 0 {
+			t.Errorf("The deleted %s has the size %d", change.Path, change.Size)
+		}
+	}
+	want := map[string]ChangeKind{
+		"modified":       ChangeModified,
+		"added":          ChangeAdded,
+		"deleted":        ChangeDeleted,
+		"deleted-legacy": ChangeDeleted,
+		"link-over-file": ChangeModified,
+		"dir/added":      ChangeAdded,
+		"dir/added-link": ChangeAdded,
+		"new-dir":        ChangeAdded,
+		"new-dir/file":   ChangeAdded,
+		"opaque":         ChangeModified,
+		"opaque/visible": ChangeAdded,
+		"opaque-marker":  ChangeModified,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %v, want %v", got, want)
+	}
+}
+
+func TestDiffNonexistentVolume(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	if _, err := d.Diff("missing"); err == nil {
+		t.Error("Diff succeeded for a nonexistent volume")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// defaultDockerPidFile is where the docker daemon stores its PID by default
+const defaultDockerPidFile = "/run/docker.pid"
+
+// dockerDaemonShuttingDown makes a guess whether the docker daemon is shutting down, based on its PID file
+// (`d.DockerPidFile`): the daemon is considered to be shutting down if the PID file disappeared after it had been
+// seen, or if the process it points to no longer exists.
+//
+// As the plugin may be started before the daemon (and the daemon may be configured to use a different PID file), the
+// absence of the PID file alone is not enough: if it has never been seen, the daemon is assumed to be running.
+func (d *DockerOnTop) dockerDaemonShuttingDown() bool {
+	payload, err := os.ReadFile(d.DockerPidFile)
+	if os.IsNotExist(err) {
+		return d.dockerPidFileSeen.Load()
+	} else if err != nil {
+		d.logger.Debug("Failed to read the docker daemon's PID file", "error", err)
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(payload)))
+	if err != nil || pid <= 0 {
+		d.logger.Debug("The docker daemon's PID file is malformed", "path", d.DockerPidFile,
+			"contents", string(payload))
+		return false
+	}
+	if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+		return true
+	}
+
+	d.dockerPidFileSeen.Store(true)
+	return false
+}
@@ -1,18 +1,60 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
-// internalError wraps the given error in the "docker-on-top internal error: #{help}: #{err}" message. It is useful for
-// when the error is reported to the docker daemon so that the end user knows it's not their mistake but an internal
-// error.
-func internalError(help string, err error) error {
+// newUUID generates a random (version 4) UUID using `crypto/rand`.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// internalError wraps the given error in the "docker-on-top internal error [id=#{uuid}]: #{help}: #{err}" message. It
+// is useful for when the error is reported to the docker daemon so that the end user knows it's not their mistake but
+// an internal error.
+//
+// Each call generates a new error ID, which is also logged (at Error level) together with the error, so that the
+// error the user sees can be matched with the plugin's logs. This is the only place the error is logged: the callers
+// must not log it themselves, and pass the context worth logging (e.g. the volume's name) as `logArgs` instead.
+func (d *DockerOnTop) internalError(help string, err error, logArgs ...any) error {
+	return internalError(d.logger, help, err, logArgs...)
+}
+
+// internalError is `DockerOnTop.internalError` for the code that runs without a driver: the error is logged with the
+// given logger.
+func internalError(logger *slog.Logger, help string, err error, logArgs ...any) error {
 	// Maybe make a custom error type instead?
-	return fmt.Errorf("docker-on-top internal error: %s: %w", help, err)
+	id, idErr := newUUID()
+	if idErr != nil {
+		// Extremely unlikely. The error is still worth reporting, just without an ID
+		logger.Error("Failed to generate an error ID", "error", idErr)
+		id = "unknown"
+	}
+	logger.Error("Internal error", append([]any{"errorId", id, "help", help, "error", err}, logArgs...)...)
+	return fmt.Errorf("docker-on-top internal error [id=%s]: %s: %w", id, help, err)
 }
 
 // DockerOnTop contains internal data of the docker-on-top volume driver and implements the `volume.Driver` interface
@@ -23,12 +65,141 @@ type DockerOnTop struct {
 	// dotRootDir is the base directory of docker-on-top, where all the internal information is stored.
 	// Must contain a trailing slash (ensured by `NewDockerOnTop`).
 	dotRootDir string
+
+	// AllowNestedOverlay makes `Create` accept base directories located on an overlay filesystem (only a warning is
+	// logged then). Nested overlays only work on kernel 5.11+ and with correct options, so they are rejected by default
+	AllowNestedOverlay bool
+
+	// basePrefixesMutex protects `allowedBasePrefixes` and `deniedBasePrefixes`
+	basePrefixesMutex sync.RWMutex
+	// allowedBasePrefixes restricts the base directories of new volumes: if not empty, the (symlink-resolved) base
+	// directory must be located under one of these clean absolute paths. Set with `SetBaseDirAllowedPrefixes`
+	allowedBasePrefixes []string
+	// deniedBasePrefixes lists the clean absolute paths the base directories of new volumes must not be located
+	// under. Takes precedence over `allowedBasePrefixes`. Set with `SetBaseDirDeniedPrefixes`
+	deniedBasePrefixes []string
+	// reloadBasePrefixes provides the new prefixes to `Reload` (see `WithBasePrefixesReloader`)
+	reloadBasePrefixes func() (BasePrefixesConfig, error)
+
+	// defaultOptionsMutex protects `defaultOptions`, the options used by `Create` for the ones not given in the request
+	// (see `SetDefaultOptions`)
+	defaultOptionsMutex sync.RWMutex
+	defaultOptions      map[string]string
+
+	// DefaultVolatile is the value of the `volatile` option for the (non-read-only) volumes created without it
+	DefaultVolatile bool
+	// DefaultLazyUnmount is the value of the `lazy` option for the volumes created without it
+	DefaultLazyUnmount bool
+	// DefaultUserXattr is the value of the `userxattr` option for the volumes created without it. Set by
+	// `NewDockerOnTop` if the plugin is not running as root (rootless overlays require `userxattr`)
+	DefaultUserXattr bool
+	// TryFuseOverlayFallback makes the overlays be mounted with `fuse-overlayfs` if the kernel doesn't support
+	// overlayfs (see `mountOverlay`)
+	TryFuseOverlayFallback bool
+	// DisableUsageReporting makes `Get` and `List` not report the disk usage of the volumes (`upperdirBytes`), which
+	// requires walking their upperdirs
+	DisableUsageReporting bool
+	// DryRun makes `Create`, `Remove`, `Mount`, and `Unmount` validate the requests and log what would be done instead
+	// of doing it, so that no changes are made to the filesystem. The volumes are not mounted, but `Mount` still
+	// returns the mountpoint. To also keep `NewDockerOnTop` from resetting the volumes on boot, set it with `WithDryRun`
+	DryRun bool
+	// IdempotentCreate makes `Create` succeed without doing anything if the volume already exists with the same
+	// options (see `VolumeInfo.definition`), rather than fail
+	IdempotentCreate bool
+	// AllowForceRemove enables `ForceRemove`, which removes volumes even if they are in use
+	AllowForceRemove bool
+
+	// AuditLog, if set, receives a JSON line for every successful `Create`, `Remove`, `Mount`, and `Unmount` (see
+	// `NewFileAuditLog`)
+	AuditLog io.Writer
+	// auditMutex serializes the writes to `AuditLog`
+	auditMutex sync.Mutex
+	// accessLogMaxSize is the size the volumes' access logs are rotated at (see `WithAccessLogMaxSize`)
+	accessLogMaxSize int64
+	// accessLogMutex serializes the writes to (and rotations of) the volumes' access logs
+	accessLogMutex sync.Mutex
+
+	// usageTimeout limits the time spent computing the disk usage of a single volume (see `WithUsageTimeout`)
+	usageTimeout time.Duration
+
+	// DockerPidFile is the PID file of the docker daemon, used to detect that the daemon is shutting down (see
+	// `dockerDaemonShuttingDown`). Set to `defaultDockerPidFile` by `NewDockerOnTop`
+	DockerPidFile string
+	// dockerPidFileSeen is set once `DockerPidFile` has been found to exist
+	dockerPidFileSeen atomic.Bool
+
+	// lastUsedUpdates is the queue of pending `VolumeInfo.LastUsedAt` updates (see `markVolumeUsed`)
+	lastUsedUpdates chan lastUsedUpdate
+
+	// metrics are the Prometheus collectors of the driver (see `StartMetricsServer`)
+	metrics *driverMetrics
+
+	// mountBreaker stops the attempts to mount overlays if they keep failing (see `WithMountCircuitBreaker`)
+	mountBreaker *mountCircuitBreaker
+
+	// metadata stores the volumes' metadata (see `WithMetadataBackend`)
+	metadata MetadataBackend
+	// locks provides the volumes' locks (see `WithLockManager`)
+	locks LockManager
+	// volumeInfoCache holds the metadata read by `GetVolumeInfo`, as `cachedVolumeInfo` keyed by volume name
+	volumeInfoCache sync.Map
+	// volumeInfoCacheTTL is the time the entries of `volumeInfoCache` are trusted for (see `WithVolumeInfoCacheTTL`)
+	volumeInfoCacheTTL time.Duration
+
+	// mountFlight deduplicates the concurrent mounts of a volume, keyed by volume name (see `mount`)
+	mountFlight singleflight.Group
+
+	// mountTimeout limits the time the overlay mount syscall may take (see `WithMountTimeout`)
+	mountTimeout time.Duration
+	// hookTimeout limits the time a volume's hook may run (see `WithHookTimeout`)
+	hookTimeout time.Duration
+	// hooksDir is the directory the volumes' hooks must be located in, or empty if hooks are disabled (see
+	// `WithHooksDir`)
+	hooksDir string
+	// mountSyscall is `syscall.Mount`, replaceable for testing
+	mountSyscall mountFunc
+	// unmountSyscall is `syscall.Unmount`, replaceable for testing
+	unmountSyscall unmountFunc
+
+	// tracer traces the driver's operations (see `WithTracer`)
+	tracer trace.Tracer
+	// logger receives the driver's log messages (see `WithLogger`)
+	logger *slog.Logger
+
+	// bootConcurrency is the number of volumes reset in parallel by `NewDockerOnTop` (see `WithBootConcurrency`)
+	bootConcurrency int
+	// skipOverlayProbe makes `NewDockerOnTop` not check that overlays can be mounted (see `WithoutOverlayProbe`)
+	skipOverlayProbe bool
+	// permissiveBoot makes `NewDockerOnTop` only log the volumes that fail to reset (see `WithPermissiveBoot`)
+	permissiveBoot bool
+	// failedBootVolumes are the names of the volumes that failed to reset on boot with `permissiveBoot`, sorted. Not
+	// modified after `NewDockerOnTop` returns (see `BootErrors`)
+	failedBootVolumes []string
+
+	// ctx is cancelled by `Close` to stop the background activities, which are tracked by `background`
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
+	// closed is set by `Close`. New volumes are neither created nor mounted then
+	closed atomic.Bool
+	// closersMutex protects `closers`, the servers and listeners to be closed by `Close`
+	closersMutex sync.Mutex
+	closers      []io.Closer
 }
 
 // NewDockerOnTop creates a new `DockerOnTop` object using the given directory as the dot root directory. If it doesn't
 // exist, it is created recursively (as if with `mkdir -p`). If an error occurs, it is returned and `DockerOnTop`
 // is not created.
-func NewDockerOnTop(dotRootDir string) (*DockerOnTop, error) {
+//
+// The state of all the existing volumes is reset (see `volumeTreeOnBootReset`) in parallel, by
+// `WithBootConcurrency` workers. Unless `WithPermissiveBoot` is given, the first failure aborts the creation. With
+// `WithDryRun`, the reset is only logged.
+//
+// Unless `WithoutOverlayProbe` is given, a probe overlay is mounted first, and `*OverlayNotSupportedError` is returned
+// if that fails.
+//
+// The background activities of the object are stopped when `ctx` is cancelled or `Close` is called.
+func NewDockerOnTop(ctx context.Context, dotRootDir string, opts ...DockerOnTopOption) (*DockerOnTop, error) {
 	if len(dotRootDir) == 0 {
 		return nil, errors.New("`dotRootDir` cannot be empty")
 	}
@@ -42,52 +213,259 @@ func NewDockerOnTop(dotRootDir string) (*DockerOnTop, error) {
 		return nil, err
 	}
 
-	dot := DockerOnTop{dotRootDir: dotRootDir}
+	dot := newDockerOnTop(ctx, dotRootDir)
+	for _, opt := range opts {
+		opt(dot)
+	}
+
+	if !dot.skipOverlayProbe {
+		if err := dot.probeOverlay("overlay"); err != nil {
+			return nil, err
+		}
+	}
 
 	entries, err := os.ReadDir(dotRootDir)
 	if err != nil {
 		return nil, err
 	}
+	existingVolumes, err := dot.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+
+	// Resetting a volume whose overlay is still mounted would fail with EBUSY anyway, but it is better not to touch
+	// such volumes at all
+	mountedVolumes, err := parseProcMounts()
+	if err != nil {
+		dot.logger.Warn("Failed to read the mount table, relying on EBUSY to detect mounted volumes",
+			"path", procMounts, "error", err)
+		mountedVolumes = map[string]bool{}
+	}
+
+	volumeNames := make(chan string)
+	type bootResult struct {
+		volumeName   string
+		stillMounted bool
+		err          error
+	}
+	results := make(chan bootResult)
+	var workers sync.WaitGroup
+	for i := 0; i < dot.bootConcurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for volumeName := range volumeNames {
+				stillMounted, err := dot.bootResetVolume(volumeName, mountedVolumes[volumeName])
+				results <- bootResult{volumeName: volumeName, stillMounted: stillMounted, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, entry := range entries {
+			if isScratchDir(entry.Name()) {
+				// A leftover from an interrupted volume creation
+				if dot.DryRun {
+					dot.logger.Info("Dry run: would remove stale scratch directory", "name", entry.Name())
+					continue
+				}
+				dot.logger.Info("Removing stale scratch directory", "name", entry.Name())
+				if err := os.RemoveAll(dotRootDir + entry.Name()); err != nil {
+					dot.logger.Warn("Failed to remove stale scratch directory", "name", entry.Name(), "error", err)
+				}
+			}
+		}
+		for _, volumeName := range existingVolumes {
+			volumeNames <- volumeName
+		}
+		close(volumeNames)
+		workers.Wait()
+		close(results)
+	}()
 
 	mountedOverlaysFound := false
-	for _, entry := range entries {
-		volumeName := entry.Name()
-		err = dot.volumeTreeOnBootReset(volumeName)
-		if err == nil {
-			log.Infof("Detected volume %s. The state was dirty, cleaned successfully", volumeName)
-		} else if os.IsNotExist(err) {
-			log.Infof("Detected volume %s. The state is clean", volumeName)
-		} else if errors.Is(err, syscall.EBUSY) {
-			log.Infof("Detected volume %s. The state is dirty: it is still mounted", volumeName)
+	var bootErrors []error
+	for result := range results {
+		if result.stillMounted {
 			mountedOverlaysFound = true
-		} else {
-			log.Errorf("Failed to reset volume %s on boot: %v", volumeName, err)
-			return nil, err
 		}
+		if result.err != nil {
+			bootErrors = append(bootErrors, fmt.Errorf("volume %s: %w", result.volumeName, result.err))
+			dot.failedBootVolumes = append(dot.failedBootVolumes, result.volumeName)
+		}
+	}
+	if len(bootErrors) > 0 {
+		if !dot.permissiveBoot {
+			return nil, errors.Join(bootErrors...)
+		}
+		sort.Strings(dot.failedBootVolumes)
+		dot.logger.Warn("Some volumes failed to reset on boot. Continuing anyway (permissive boot)", "failed",
+			dot.failedBootVolumes)
 	}
 
 	if mountedOverlaysFound {
 		// Not sure which message is better, keeping both for now
 		/*
-			log.Warning("Some of the detected volumes (mentioned above as INFO logs) were already mounted when the " +
+			dot.logger.Warn("Some of the detected volumes (mentioned above as INFO logs) were already mounted when the " +
 				"plugin started. If some of the containers using it have exited and there's been over 60sec after that " +
 				"while the plugin was down, those volumes are now stuck in the mounted state until you reboot your " +
 				"machine. For non-volatile volumes it's not too bad, for volatile volumes it means their changes won't " +
 				"be discarded on container exit (they effectively lose their volatility until a reboot).")
 		*/
-		log.Warning("Some of the detected volumes were already mounted when the plugin started. If the " +
+		dot.logger.Warn("Some of the detected volumes were already mounted when the plugin started. If the " +
 			"plugin's downtime was <=60sec or you know that no containers with mounted dirty volumes have exited " +
 			"while the plugin was down, there's no problem. Otherwise the volumes mentioned above (as INFO logs) " +
 			"might get stuck in the mounted state, and for volatile volumes it prevents their changes from being " +
 			"discarded. In any case, the machine reboot will fix everything")
 	}
 
-	return &dot, nil
+	dot.goBackground(dot.lastUsedWriter)
+
+	return dot, nil
+}
+
+// BootErrors returns the names of the volumes that failed to reset on boot (sorted), which only happens with
+// `WithPermissiveBoot`: otherwise, `NewDockerOnTop` fails. Such volumes are left as they were found, so their mounts
+// may fail.
+func (d *DockerOnTop) BootErrors() []string {
+	return slices.Clone(d.failedBootVolumes)
+}
+
+// bootResetVolume resets the state of the volume on boot (see `volumeTreeOnBootReset`), unless its overlay is known to
+// be still mounted. Whether the volume turned out to be still mounted is returned. The outcome is logged.
+func (d *DockerOnTop) bootResetVolume(volumeName string, knownMounted bool) (bool, error) {
+	defer d.invalidateVolumeInfo(volumeName)
+	if knownMounted {
+		d.logger.Info("Detected volume. The state is dirty: it is still mounted", "volume", volumeName)
+		return true, nil
+	}
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		d.logger.Error("Failed to retrieve metadata for the volume. Not resetting it on boot", "volume", volumeName,
+			"error", err)
+		return false, err
+	}
+	if d.DryRun {
+		d.logDryRun("reset the state of the volume on boot (unless it is still mounted)", volumeName, "mountpoint",
+			d.mountpointdir(volumeName), "activemounts", d.activemountsdir(volumeName), "workdir",
+			d.workdir(volumeName, &thisVol))
+		return false, nil
+	}
+	err = d.volumeTreeOnBootReset(volumeName, &thisVol)
+	if err == nil {
+		d.logger.Info("Detected volume. The state was dirty, cleaned successfully", "volume", volumeName)
+	} else if os.IsNotExist(err) {
+		d.logger.Info("Detected volume. The state is clean", "volume", volumeName)
+	} else if errors.Is(err, syscall.EBUSY) {
+		d.logger.Info("Detected volume. The state is dirty: it is still mounted", "volume", volumeName)
+		return true, nil
+	} else {
+		d.logger.Error("Failed to reset volume on boot", "volume", volumeName, "error", err)
+		return false, err
+	}
+	return false, nil
+}
+
+// newDockerOnTop initializes the `DockerOnTop` object's fields. `dotRootDir` must contain a trailing slash.
+func newDockerOnTop(ctx context.Context, dotRootDir string) *DockerOnTop {
+	ctx, cancel := context.WithCancel(ctx)
+	d := &DockerOnTop{
+		ctx:                ctx,
+		cancel:             cancel,
+		dotRootDir:         dotRootDir,
+		DockerPidFile:      defaultDockerPidFile,
+		DefaultUserXattr:   os.Getuid() != 0,
+		lastUsedUpdates:    make(chan lastUsedUpdate, lastUsedQueueSize),
+		metrics:            newDriverMetrics(),
+		bootConcurrency:    runtime.NumCPU(),
+		tracer:             newNoopTracer(),
+		usageTimeout:       defaultUsageTimeout,
+		mountTimeout:       defaultMountTimeout,
+		hookTimeout:        defaultHookTimeout,
+		accessLogMaxSize:   defaultAccessLogMaxSize,
+		volumeInfoCacheTTL: defaultVolumeInfoCacheTTL,
+		mountSyscall:       syscall.Mount,
+		unmountSyscall:     syscall.Unmount,
+		logger:             defaultLogger,
+		metadata:           NewFileMetadataBackend(dotRootDir),
+		mountBreaker: &mountCircuitBreaker{
+			threshold: defaultMountBreakerThreshold,
+			window:    defaultMountBreakerWindow,
+			cooldown:  defaultMountBreakerCooldown,
+		},
+	}
+	d.locks = &dirLockManager{d: d}
+	return d
+}
+
+// openDockerOnTop creates a `DockerOnTop` object for an existing dot root directory without performing any boot-time
+// actions (the volumes' state is not reset, no background activities are started). It is meant for inspecting and
+// managing the volumes from the command line while the plugin itself may be running. Only the options that don't
+// concern the boot (like `WithMetadataBackend`) make sense in `opts`.
+func openDockerOnTop(dotRootDir string, opts ...DockerOnTopOption) (*DockerOnTop, error) {
+	if len(dotRootDir) == 0 {
+		return nil, errors.New("`dotRootDir` cannot be empty")
+	}
+	if dotRootDir[len(dotRootDir)-1] != '/' {
+		dotRootDir += "/"
+	}
+	if _, err := os.Stat(dotRootDir); err != nil {
+		return nil, err
+	}
+	d := newDockerOnTop(context.Background(), dotRootDir)
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d, nil
+}
+
+// cleanBasePrefixes checks that all the prefixes are absolute paths and returns their cleaned versions.
+func cleanBasePrefixes(prefixes []string) ([]string, error) {
+	cleaned := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if !filepath.IsAbs(prefix) {
+			return nil, fmt.Errorf("base directory prefix %q is not an absolute path", prefix)
+		}
+		cleaned = append(cleaned, filepath.Clean(prefix))
+	}
+	return cleaned, nil
+}
+
+// SetBaseDirAllowedPrefixes restricts the base directories of new volumes to the given directories (and their
+// subdirectories). An empty list allows any base directory. The prefixes must be absolute paths.
+func (d *DockerOnTop) SetBaseDirAllowedPrefixes(prefixes []string) error {
+	cleaned, err := cleanBasePrefixes(prefixes)
+	if err != nil {
+		return err
+	}
+	d.basePrefixesMutex.Lock()
+	defer d.basePrefixesMutex.Unlock()
+	d.allowedBasePrefixes = cleaned
+	return nil
+}
+
+// SetBaseDirDeniedPrefixes forbids the given directories (and their subdirectories) to be used as base directories of
+// new volumes, even if they are allowed by `SetBaseDirAllowedPrefixes`. The prefixes must be absolute paths.
+func (d *DockerOnTop) SetBaseDirDeniedPrefixes(prefixes []string) error {
+	cleaned, err := cleanBasePrefixes(prefixes)
+	if err != nil {
+		return err
+	}
+	d.basePrefixesMutex.Lock()
+	defer d.basePrefixesMutex.Unlock()
+	d.deniedBasePrefixes = cleaned
+	return nil
+}
+
+// basePrefixes returns the current lists of allowed and denied base directory prefixes.
+func (d *DockerOnTop) basePrefixes() (allowed []string, denied []string) {
+	d.basePrefixesMutex.RLock()
+	defer d.basePrefixesMutex.RUnlock()
+	return d.allowedBasePrefixes, d.deniedBasePrefixes
 }
 
 // MustNewDockerOnTop behaves as `NewDockerOnTop` but panics in case of an error
-func MustNewDockerOnTop(dotRootDir string) *DockerOnTop {
-	driver, err := NewDockerOnTop(dotRootDir)
+func MustNewDockerOnTop(ctx context.Context, dotRootDir string, opts ...DockerOnTopOption) *DockerOnTop {
+	driver, err := NewDockerOnTop(ctx, dotRootDir, opts...)
 	if err != nil {
 		panic(fmt.Errorf("the call NewDockerOnTop(%+v) failed: %v", dotRootDir, err))
 	}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestInternalError(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	cause := errors.New("disk on fire")
+
+	err := internalError(logger, "failed to do something", cause, "volume", "vol")
+	if !errors.Is(err, cause) {
+		t.Errorf("The error %v doesn't wrap the cause", err)
+	}
+	match := regexp.MustCompile(`^docker-on-top internal error \[id=([0-9a-f-]{36})\]: failed to do something: ` +
+		`disk on fire$`).FindStringSubmatch(err.Error())
+	if match == nil {
+		t.Fatalf("Unexpected error message %q", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("The error was logged %d times, want once: %q", len(lines), lines)
+	}
+	for _, want := range []string{"level=ERROR", "errorId=" + match[1], `error="disk on fire"`, "volume=vol"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("The log entry %q doesn't contain %q", lines[0], want)
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
 )
@@ -15,11 +21,69 @@ import (
 // This regex is based on the error message from docker daemon when requested to create a volume with invalid name
 var volNameFormat = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
 
+// optionDeprecation describes a deprecated volume option
+type optionDeprecation struct {
+	// Replacement is the option to use instead (empty if there's none)
+	Replacement string
+	// RemovalVersion is the plugin version in which the option is going to be removed
+	RemovalVersion string
+}
+
+// volumeOptions are the options `Create` accepts (besides the overlay tuning ones, see `isVolumeOption`)
+var volumeOptions = map[string]bool{
+	"base": true, "volatile": true, "readonly": true, "layers": true, "lazy": true,
+	"noexec": true, "nosuid": true, "nodev": true, "userxattr": true, "tags": true,
+	"upper": true, "work": true, "namespaced": true, "premount": true, "postmount": true,
+	"preunmount": true, "postunmount": true, "base-type": true, "quota": true,
+	"tmpfs-size": true, "write-iops": true, "write-bps": true,
+} // Values are meaningless, only keys matter
+
+// isVolumeOption reports whether `Create` accepts the option `name`.
+func isVolumeOption(name string) bool {
+	return volumeOptions[name] || slices.Contains(overlayTuningOptions, name)
+}
+
+// deprecatedOptions lists the deprecated volume options. No options are deprecated at the moment
+var deprecatedOptions = map[string]optionDeprecation{}
+
+// warnOnDeprecatedOptions logs a structured warning for every deprecated option among the given ones, so that users
+// can update their tooling before the option is removed.
+func (d *DockerOnTop) warnOnDeprecatedOptions(options map[string]string) {
+	for _, opt := range sortedKeys(options) {
+		deprecation, ok := deprecatedOptions[opt]
+		if !ok {
+			continue
+		}
+		d.logger.Warn("deprecated option", "option", opt, "replacement", deprecation.Replacement,
+			"plugin_version", version, "removal_version", deprecation.RemovalVersion)
+	}
+}
+
 func (d *DockerOnTop) Create(request *volume.CreateRequest) error {
-	log.Debugf("Request Create: Name=%s Options=%s", request.Name, request.Options)
+	span := d.startSpan("Create", attrVolumeName.String(request.Name), attrBaseDir.String(request.Options["base"]))
+	err := d.create(request)
+	endSpan(span, err)
+	d.metrics.creates.WithLabelValues(resultLabel(err)).Inc()
+	if err == nil {
+		d.audit("Create", request.Name, "", "")
+	}
+	return err
+}
+
+func (d *DockerOnTop) create(request *volume.CreateRequest) error {
+	d.logger.Debug("Request Create", "volume", request.Name, "options", request.Options)
+
+	if d.closed.Load() {
+		return errDriverClosed
+	}
+
+	// Not modifying the caller's request
+	request = &volume.CreateRequest{Name: request.Name, Options: d.withDefaultOptions(request.Options)}
+
+	d.warnOnDeprecatedOptions(request.Options)
 
 	if !volNameFormat.MatchString(request.Name) {
-		log.Debug("Volume name doesn't comply to the regex. Volume not created")
+		d.logger.Debug("Volume name doesn't comply to the regex. Volume not created")
 		if strings.ContainsRune(request.Name, '/') {
 			// Handle this case separately for a more specific error message
 			return errors.New("volume name cannot contain slashes (for specifying host path use " +
@@ -29,206 +93,846 @@ func (d *DockerOnTop) Create(request *volume.CreateRequest) error {
 			"it should comply to \"[a-zA-Z0-9][a-zA-Z0-9_.-]*\"")
 	}
 
-	allowedOptions := map[string]bool{"base": true, "volatile": true} // Values are meaningless, only keys matter
 	for opt := range request.Options {
-		if _, ok := allowedOptions[opt]; !ok {
-			log.Debugf("Unknown option %s. Volume not created", opt)
+		if !isVolumeOption(opt) {
+			d.logger.Debug("Unknown option. Volume not created", "option", opt)
 			return errors.New("Invalid option " + opt)
 		}
 	}
 
 	baseDir, ok := request.Options["base"]
 	if !ok {
-		log.Debug("No `base` option was provided. Volume not created")
+		d.logger.Debug("No `base` option was provided. Volume not created")
 		return errors.New("`base` option must be provided and set to an absolute path to the base directory on host")
 	}
 
-	if len(baseDir) < 1 || baseDir[0] != '/' {
-		log.Debug("`base` is not an absolute path. Volume not created")
-		return errors.New("`base` must be an absolute path")
-	} else if strings.ContainsRune(baseDir, ',') || strings.ContainsRune(baseDir, ':') {
-		log.Debug("`base` contains a comma or a colon. Volume not created")
-		return errors.New("directories with commas and/or colons in the path are not supported")
-	} else {
-		// Check that the base directory exists
-
-		f, err := os.Open(baseDir)
-		if os.IsNotExist(err) {
-			// The base directory does not exist. Note that it doesn't make sense to implicitly create it (as docker
-			// does by default with bind mounts), as the point of docker-on-top is to let containers work _on top_ of
-			// an existing host directory, so implicitly making an empty one would be pointless.
-			log.Debugf("The base directory %s does not exist. Volume not created", baseDir)
-			return errors.New("the base directory does not exist")
-		} else if err != nil {
-			log.Errorf("Failed to open base directory: %v. Volume not created", err)
-			return fmt.Errorf("the specified base directory is inaccessible: %w", err)
-		} else {
-			_ = f.Close()
+	baseDir, err := d.checkBaseDir(baseDir)
+	if err != nil {
+		d.logger.Debug("Invalid base directory. Volume not created", "error", err)
+		return err
+	}
+
+	readOnly, err := parseBoolOption(request.Options, "readonly")
+	if err != nil {
+		d.logger.Debug("Option `readonly` has an invalid value. Volume not created")
+		return err
+	}
+	// The default volatility does not apply to read-only volumes
+	volatile := d.DefaultVolatile && !readOnly
+	if _, ok := request.Options["volatile"]; ok {
+		volatile, err = parseBoolOption(request.Options, "volatile")
+		if err != nil {
+			d.logger.Debug("Option `volatile` has an invalid value. Volume not created")
+			return err
 		}
 	}
+	if volatile && readOnly {
+		d.logger.Debug("Both `volatile` and `readonly` are set. Volume not created")
+		return errors.New("options `volatile` and `readonly` are mutually exclusive")
+	}
 
-	var volatile bool
-	volatileS, ok := request.Options["volatile"]
-	if !ok {
-		volatileS = "false"
+	noExec, err := parseBoolOption(request.Options, "noexec")
+	if err != nil {
+		d.logger.Debug("Option `noexec` has an invalid value. Volume not created")
+		return err
 	}
-	volatileS = strings.ToLower(volatileS)
-	if volatileS == "no" || volatileS == "false" {
-		volatile = false
-	} else if volatileS == "yes" || volatileS == "true" {
-		volatile = true
-	} else {
-		log.Debug("Option `volatile` has an invalid value. Volume not created")
-		return errors.New("option `volatile` must be either 'true', 'false', 'yes', or 'no'")
+	noSuid, err := parseBoolOption(request.Options, "nosuid")
+	if err != nil {
+		d.logger.Debug("Option `nosuid` has an invalid value. Volume not created")
+		return err
+	}
+	noDev, err := parseBoolOption(request.Options, "nodev")
+	if err != nil {
+		d.logger.Debug("Option `nodev` has an invalid value. Volume not created")
+		return err
+	}
+	namespaced, err := parseBoolOption(request.Options, "namespaced")
+	if err != nil {
+		d.logger.Debug("Option `namespaced` has an invalid value. Volume not created")
+		return err
+	}
+
+	lazy := d.DefaultLazyUnmount
+	if _, ok := request.Options["lazy"]; ok {
+		lazy, err = parseBoolOption(request.Options, "lazy")
+		if err != nil {
+			d.logger.Debug("Option `lazy` has an invalid value. Volume not created")
+			return err
+		}
+	}
+
+	userXattr := d.DefaultUserXattr
+	if _, ok := request.Options["userxattr"]; ok {
+		userXattr, err = parseBoolOption(request.Options, "userxattr")
+		if err != nil {
+			d.logger.Debug("Option `userxattr` has an invalid value. Volume not created")
+			return err
+		}
+	}
+
+	var lowerLayers []string
+	if layersS, ok := request.Options["layers"]; ok {
+		lowerLayers = strings.Split(layersS, ":")
+		for i, layer := range lowerLayers {
+			if err := d.checkLowerLayer(layer); err != nil {
+				d.logger.Debug("Invalid lower layer. Volume not created", "layer", layer, "error", err)
+				return err
+			} else if slices.Contains(lowerLayers[:i], layer) {
+				d.logger.Debug("Duplicate lower layer. Volume not created", "layer", layer)
+				return fmt.Errorf("the lower layer %s is given more than once", layer)
+			}
+		}
+	}
+
+	switch baseType := request.Options["base-type"]; baseType {
+	case "", baseTypeDir:
+	case baseTypeOCI:
+		if lowerLayers != nil {
+			d.logger.Debug("Both `layers` and `base-type=oci` are set. Volume not created")
+			return errors.New("options `layers` and `base-type=oci` are mutually exclusive: the lower layers of an " +
+				"OCI image are detected automatically")
+		}
+		lowerLayers, err = ociParentLayers(baseDir)
+		if err != nil {
+			d.logger.Debug("Failed to read the OCI image's layers. Volume not created", "error", err)
+			return fmt.Errorf("failed to read the layers of the OCI image: %w", err)
+		}
+		for _, layer := range lowerLayers {
+			if err := d.checkLowerLayer(layer); err != nil {
+				d.logger.Debug("Invalid OCI image layer. Volume not created", "layer", layer, "error", err)
+				return err
+			}
+		}
+		d.logger.Debug("Detected the OCI image's layers", "volume", request.Name, "layers", lowerLayers)
+	default:
+		d.logger.Debug("Option `base-type` has an invalid value. Volume not created", "value", baseType)
+		return fmt.Errorf("invalid base type %q (must be either %q or %q)", baseType, baseTypeDir, baseTypeOCI)
+	}
+
+	tags, err := parseTags(request.Options["tags"])
+	if err != nil {
+		d.logger.Debug("Option `tags` has an invalid value. Volume not created", "error", err)
+		return err
+	}
+
+	var quota int64
+	if quotaS, ok := request.Options["quota"]; ok {
+		quota, err = parseSize(quotaS)
+		if err != nil {
+			d.logger.Debug("Option `quota` has an invalid value. Volume not created", "error", err)
+			return fmt.Errorf("option `quota`: %w", err)
+		}
+		if quota > 0 && readOnly {
+			d.logger.Debug("Both `quota` and `readonly` are set. Volume not created")
+			return errors.New("options `quota` and `readonly` are mutually exclusive: no changes can be made to a " +
+				"read-only volume")
+		}
+	}
+
+	var tmpfsSize int64
+	if tmpfsSizeS, ok := request.Options["tmpfs-size"]; ok {
+		tmpfsSize, err = parseSize(tmpfsSizeS)
+		if err != nil || tmpfsSize == 0 {
+			d.logger.Debug("Option `tmpfs-size` has an invalid value. Volume not created", "error", err)
+			return fmt.Errorf("option `tmpfs-size` must be a positive size, like 256M")
+		}
+		switch {
+		case !volatile:
+			d.logger.Debug("`tmpfs-size` is set for a non-volatile volume. Volume not created")
+			return errors.New("option `tmpfs-size` requires `volatile=true`: the changes kept in memory are lost on " +
+				"unmount")
+		case request.Options["upper"] != "" || request.Options["work"] != "":
+			d.logger.Debug("Both `tmpfs-size` and `upper` are set. Volume not created")
+			return errors.New("option `tmpfs-size` cannot be combined with `upper` and `work`")
+		case quota > 0:
+			d.logger.Debug("Both `tmpfs-size` and `quota` are set. Volume not created")
+			return errors.New("options `tmpfs-size` and `quota` are mutually exclusive: the size of the tmpfs " +
+				"limits the changes already")
+		}
+	}
+
+	var writeIOPS, writeBPS int64
+	if writeIOPSS, ok := request.Options["write-iops"]; ok {
+		writeIOPS, err = strconv.ParseInt(writeIOPSS, 10, 64)
+		if err != nil || writeIOPS < 0 {
+			d.logger.Debug("Option `write-iops` has an invalid value. Volume not created", "value", writeIOPSS)
+			return fmt.Errorf("option `write-iops` must be a non-negative integer, got %q", writeIOPSS)
+		}
+	}
+	if writeBPSS, ok := request.Options["write-bps"]; ok {
+		writeBPS, err = parseSize(writeBPSS)
+		if err != nil {
+			d.logger.Debug("Option `write-bps` has an invalid value. Volume not created", "error", err)
+			return fmt.Errorf("option `write-bps`: %w", err)
+		}
+	}
+	if (writeIOPS > 0 || writeBPS > 0) && (readOnly || tmpfsSize > 0) {
+		d.logger.Debug("Write limits are set for a read-only or in-memory volume. Volume not created")
+		return errors.New("options `write-iops` and `write-bps` cannot be combined with `readonly` and `tmpfs-size`: " +
+			"the volume's changes are not written to a disk")
+	}
+
+	overlayOptions, err := parseOverlayOptions(request.Options)
+	if err != nil {
+		d.logger.Debug("Invalid overlay option. Volume not created", "error", err)
+		return err
+	}
+	if overlayOptions["nfs_export"] == "on" && volatile {
+		// Not rejected (the mount works), but the NFS clients may see the changes disappear
+		d.logger.Warn("Both `nfs_export` and `volatile` are set for the volume: the changes of a volatile volume are "+
+			"discarded on unmount, which the NFS clients won't expect", "volume", request.Name)
+	}
+
+	newVol := VolumeInfo{
+		BaseDirPath:    baseDir,
+		Volatile:       volatile,
+		ReadOnly:       readOnly,
+		LowerLayers:    lowerLayers,
+		LazyUnmount:    lazy,
+		NoExec:         noExec,
+		NoSuid:         noSuid,
+		NoDev:          noDev,
+		UserXattr:      userXattr,
+		Tags:           tags,
+		CustomUpperDir: request.Options["upper"],
+		CustomWorkDir:  request.Options["work"],
+		OverlayOptions: overlayOptions,
+		QuotaBytes:     quota,
+		TmpfsSizeBytes: tmpfsSize,
+		WriteIOPS:      writeIOPS,
+		WriteBPS:       writeBPS,
+	}
+	for _, hook := range []struct {
+		option string
+		path   *string
+	}{
+		{"premount", &newVol.PreMountHook},
+		{"postmount", &newVol.PostMountHook},
+		{"preunmount", &newVol.PreUnmountHook},
+		{"postunmount", &newVol.PostUnmountHook},
+	} {
+		if value, ok := request.Options[hook.option]; ok {
+			*hook.path, err = d.checkHook(hook.option, value)
+			if err != nil {
+				d.logger.Debug("Invalid hook. Volume not created", "error", err)
+				return err
+			}
+		}
+	}
+
+	// Checked before the custom directories, which would be rejected as existing
+	if d.IdempotentCreate && d.isIdenticalRecreate(request.Name, newVol, namespaced) {
+		d.logger.Debug("Volume already exists with the same options. Nothing to do", "volume", request.Name)
+		return nil
+	}
+
+	customUpper, customWork, err := d.checkCustomDirs(newVol.CustomUpperDir, newVol.CustomWorkDir)
+	if err != nil {
+		d.logger.Debug("Invalid custom upperdir or workdir. Volume not created", "error", err)
+		return err
 	}
 
-	if err := d.volumeTreeCreate(request.Name); err != nil {
+	if d.DryRun {
+		d.logDryRun("create the volume", request.Name, "baseDir", baseDir, "options", request.Options)
+		return nil
+	}
+
+	mainDir := d.volumeDir(request.Name)
+	if namespaced {
+		mainDir, err = d.prepareNamespacedDir(request.Name)
+		if os.IsExist(err) {
+			d.logger.Debug("Volume's main directory already exists. New volume not created")
+			return errors.New("volume already exists")
+		} else if err != nil {
+			d.logger.Debug("Cannot create the namespaced volume. Volume not created", "error", err)
+			return err
+		}
+	}
+	if err := d.volumeTreeCreateAt(request.Name, mainDir); err != nil {
+		d.removeEmptyNamespaceDirs(mainDir)
 		if os.IsExist(err) {
-			log.Debug("Volume's main directory already exists. New volume not created")
+			d.logger.Debug("Volume's main directory already exists. New volume not created")
 			return errors.New("volume already exists")
 		} else {
-			// The error is already logged and wrapped in `internalError` by `d.volumeTreeCreate`
+			// The error is already logged and wrapped in `internalError` by `d.volumeTreeCreateAt`
 			return err
 		}
 	}
 
-	if err := d.writeVolumeInfo(request.Name, VolumeInfo{BaseDirPath: baseDir, Volatile: volatile}); err != nil {
-		log.Errorf("Failed to write metadata for volume %s: %v. Aborting volume creation (attempting "+
-			"to destroy the volume's tree)", request.Name, err)
+	if customUpper != "" {
+		if err := os.Mkdir(customUpper, os.ModePerm); err != nil {
+			_ = d.volumeTreeDestroy(request.Name) // The errors are logged, if any
+			return d.internalError("failed to create the custom upperdir", err, "volume", request.Name, "path",
+				customUpper)
+		}
+	}
+
+	if tmpfsSize > 0 {
+		if err := os.MkdirAll(mainDir+"/tmpfs/upper", os.ModePerm); err != nil {
+			_ = d.volumeTreeDestroy(request.Name) // The errors are logged, if any
+			return d.internalError("failed to create the in-memory upperdir placeholder", err, "volume", request.Name)
+		}
+	}
+
+	newVol.CustomUpperDir, newVol.CustomWorkDir = customUpper, customWork
+	newVol.CreatedAt = time.Now()
+	if err := d.writeVolumeInfo(request.Name, newVol); err != nil {
 		_ = d.volumeTreeDestroy(request.Name) // The errors are logged, if any
-		return internalError("failed to store metadata for the volume", err)
+		if customUpper != "" {
+			_ = os.Remove(customUpper) // Empty, just created
+		}
+		return d.internalError("failed to store metadata for the volume", err, "volume", request.Name)
+	}
+
+	return nil
+}
+
+// checkCustomDirs checks the values of the `upper` and `work` options (both empty if not given) and returns them in
+// the form to be stored in the volume's metadata. Each must be an absolute path to a directory that doesn't exist yet
+// but whose parent does. Also, as overlayfs requires, the upperdir and the workdir must be on the same filesystem.
+func (d *DockerOnTop) checkCustomDirs(upper string, work string) (string, string, error) {
+	if upper == "" && work == "" {
+		return "", "", nil
+	} else if upper == "" || work == "" {
+		return "", "", errors.New("the `upper` and `work` options must be given together")
+	}
+
+	// The device of the directory the upperdir/workdir is to be created in
+	parentDevice := func(option string, path string) (uint64, error) {
+		if path[0] != '/' {
+			return 0, fmt.Errorf("`%s` must be an absolute path", option)
+		} else if strings.ContainsRune(path, ',') || strings.ContainsRune(path, ':') {
+			return 0, errors.New("directories with commas and/or colons in the path are not supported")
+		} else if _, err := os.Lstat(path); err == nil {
+			return 0, fmt.Errorf("the `%s` directory must not exist yet", option)
+		} else {
+			path = filepath.Dir(filepath.Clean(path))
+		}
+		var stat syscall.Stat_t
+		if err := syscall.Stat(path, &stat); err != nil {
+			return 0, fmt.Errorf("the parent of the `%s` directory is inaccessible: %w", option, err)
+		} else if stat.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+			return 0, fmt.Errorf("the parent of the `%s` directory is not a directory", option)
+		}
+		return stat.Dev, nil
+	}
+	upperDevice, err := parentDevice("upper", upper)
+	if err != nil {
+		return "", "", err
+	}
+	workDevice, err := parentDevice("work", work)
+	if err != nil {
+		return "", "", err
+	}
+	if upperDevice != workDevice {
+		return "", "", errors.New("the upperdir and the workdir must be on the same filesystem")
+	}
+
+	withSlash := func(path string) string {
+		return filepath.Clean(path) + "/"
+	}
+	return withSlash(upper), withSlash(work), nil
+}
+
+// checkBaseDir checks that `baseDir` can be used as a volume's base directory. The path to be stored in the volume's
+// metadata is returned (see `validateBaseDir`).
+func (d *DockerOnTop) checkBaseDir(baseDir string) (string, error) {
+	if len(baseDir) < 1 || baseDir[0] != '/' {
+		return "", errors.New("`base` must be an absolute path")
+	} else if strings.ContainsRune(baseDir, ',') || strings.ContainsRune(baseDir, ':') {
+		return "", errors.New("directories with commas and/or colons in the path are not supported")
+	}
+
+	// Check that the base directory exists
+	f, err := os.Open(baseDir)
+	if os.IsNotExist(err) {
+		// The base directory does not exist. Note that it doesn't make sense to implicitly create it (as docker
+		// does by default with bind mounts), as the point of docker-on-top is to let containers work _on top_ of
+		// an existing host directory, so implicitly making an empty one would be pointless.
+		return "", errors.New("the base directory does not exist")
+	} else if err != nil {
+		d.logger.Error("Failed to open base directory", "baseDir", baseDir, "error", err)
+		return "", fmt.Errorf("the specified base directory is inaccessible: %w", err)
+	}
+	_ = f.Close()
+
+	baseDir, err = d.validateBaseDir(baseDir)
+	if err != nil {
+		return "", err
+	}
+
+	if err := d.checkOverlayNesting(baseDir); err != nil {
+		return "", fmt.Errorf("%w (use the plugin's --allow-nested-overlay flag to allow it)", err)
+	}
+	return baseDir, nil
+}
+
+// validateBaseDir resolves all symlinks in the path to the base directory, so that the volume keeps using the same
+// directory even if the symlinks are changed afterwards (which would otherwise let one substitute the directory
+// between the checks in `Create` and the use in `Mount`), and checks it against the allowed and denied base directory
+// prefixes (denied ones take precedence). The resolved path is returned and should be stored instead of the original
+// one.
+func (d *DockerOnTop) validateBaseDir(baseDir string) (string, error) {
+	allowed, denied := d.basePrefixes()
+	resolved, err := resolveAndValidateBase(baseDir, allowed)
+	if err != nil {
+		return "", err
+	}
+	for _, prefix := range denied {
+		if isPathUnder(resolved, prefix) {
+			return "", fmt.Errorf("the base directory %s is not allowed: it is under the denied prefix %s",
+				resolved, prefix)
+		}
+	}
+	if resolved != filepath.Clean(baseDir) {
+		d.logger.Warn("base directory path contains symlinks; using resolved path", "resolved", resolved)
+		if strings.ContainsRune(resolved, ',') || strings.ContainsRune(resolved, ':') {
+			return "", errors.New("directories with commas and/or colons in the path are not supported (the " +
+				"base directory path resolves to " + resolved + ")")
+		}
+	}
+	return resolved, nil
+}
+
+// resolveAndValidateBase resolves all symlinks and relative components (like `..`) in the path and checks that the
+// result is located under one of `allowedPrefixes` (clean absolute paths). If `allowedPrefixes` is empty, any path
+// is allowed. The resolved path is returned.
+func resolveAndValidateBase(path string, allowedPrefixes []string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the base directory path: %w", err)
+	}
+	resolved = filepath.Clean(resolved)
+
+	if len(allowedPrefixes) == 0 {
+		return resolved, nil
+	}
+	for _, prefix := range allowedPrefixes {
+		if isPathUnder(resolved, filepath.Clean(prefix)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("the base directory %s is not under any of the allowed prefixes: %s", resolved,
+		strings.Join(allowedPrefixes, ", "))
+}
+
+// parseBoolOption parses the value of a boolean volume option, which can be either "true", "false", "yes", or "no"
+// (case-insensitive). If the option is absent, it is false.
+func parseBoolOption(options map[string]string, name string) (bool, error) {
+	value, ok := options[name]
+	if !ok {
+		return false, nil
+	}
+	switch strings.ToLower(value) {
+	case "no", "false":
+		return false, nil
+	case "yes", "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("option `%s` must be either 'true', 'false', 'yes', or 'no'", name)
+	}
+}
+
+// checkLowerLayer validates a directory specified in the `layers` option: it must be an absolute path without commas
+// (colons separate the layers, so they cannot be there either) to an existing directory.
+func (d *DockerOnTop) checkLowerLayer(layer string) error {
+	if len(layer) < 1 || layer[0] != '/' {
+		return fmt.Errorf("lower layer %q must be an absolute path", layer)
+	} else if strings.ContainsRune(layer, ',') {
+		return errors.New("directories with commas and/or colons in the path are not supported")
+	}
+
+	f, err := os.Open(layer)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("the lower layer %s does not exist", layer)
+	} else if err != nil {
+		return fmt.Errorf("the lower layer %s is inaccessible: %w", layer, err)
 	}
+	_ = f.Close()
 
+	if err := d.checkOverlayNesting(filepath.Clean(layer)); err != nil {
+		return fmt.Errorf("%w (use the plugin's --allow-nested-overlay flag to allow it)", err)
+	}
 	return nil
 }
 
 func (d *DockerOnTop) List() (*volume.ListResponse, error) {
-	log.Debug("Request List")
+	span := d.startSpan("List")
+	response, err := d.list()
+	endSpan(span, err)
+	return response, err
+}
+
+func (d *DockerOnTop) list() (*volume.ListResponse, error) {
+	d.logger.Debug("Request List")
 
 	var response volume.ListResponse
-	entries, err := os.ReadDir(d.dotRootDir)
+	volumeNames, err := d.listVolumeNames()
 	if err != nil {
-		log.Errorf("Failed to list contents of the dot root directory: %v", err)
-		return nil, internalError("failed to list contents of the dot root directory", err)
+		// The error is already logged and wrapped in `internalError` by `d.listVolumeNames`
+		return nil, err
 	}
-	for _, volMainDir := range entries {
-		response.Volumes = append(response.Volumes, &volume.Volume{Name: volMainDir.Name()})
+	for _, volumeName := range volumeNames {
+		response.Volumes = append(response.Volumes, d.describeVolume(volumeName))
 	}
 	return &response, nil
 }
 
 func (d *DockerOnTop) Get(request *volume.GetRequest) (*volume.GetResponse, error) {
-	log.Debugf("Request Get: Name=%s", request.Name)
+	span := d.startSpan("Get", attrVolumeName.String(request.Name))
+	response, err := d.get(request)
+	if err == nil {
+		d.setBaseDirAttr(span, request.Name)
+	}
+	endSpan(span, err)
+	return response, err
+}
 
-	// Note: the implementation does not  ensure that `d.dotRootDir + request.Name` is a directory.
+func (d *DockerOnTop) get(request *volume.GetRequest) (*volume.GetResponse, error) {
+	d.logger.Debug("Request Get", "volume", request.Name)
+
+	// Note: the implementation does not  ensure that the volume's main directory is a directory.
 	// I don't think it's worth checking, though, as under the normal plugin operation (with no interference from
 	// third parties) only directories are created in `d.dotRootDir`
 
-	dir, err := os.Open(d.dotRootDir + request.Name)
-	if err == nil {
+	mainDir := d.volumeDir(request.Name)
+	dir, err := os.Open(mainDir)
+	if err == nil && isNamespaceDir(mainDir) {
 		_ = dir.Close()
-		log.Debug("Found volume. Listing it (just its name)")
-		return &volume.GetResponse{Volume: &volume.Volume{Name: request.Name}}, nil
+		d.logger.Debug("The requested volume is a namespace of volumes")
+		return nil, errors.New("no such volume")
+	} else if err == nil {
+		_ = dir.Close()
+		d.logger.Debug("Found volume. Listing it")
+		vol := d.describeVolume(request.Name)
+		if activeMounts, err := d.listActiveMounts(request.Name); err != nil {
+			d.logger.Warn("Failed to list active mounts of the volume", "volume", request.Name, "error", err)
+		} else if vol.Status != nil {
+			payload, _ := json.Marshal(activeMounts) // Can't fail
+			vol.Status["activeMounts"] = string(payload)
+		}
+		return &volume.GetResponse{Volume: vol}, nil
 	} else if os.IsNotExist(err) {
-		log.Debug("The requested volume does not exist")
+		d.logger.Debug("The requested volume does not exist")
 		return nil, errors.New("no such volume")
 	} else {
-		log.Errorf("Failed to open the volume's main directory: %v", err)
-		return nil, internalError("failed to open the volume's main directory", err)
+		return nil, d.internalError("failed to open the volume's main directory", err, "volume", request.Name)
+	}
+}
+
+// describeVolume makes the `volume.Volume` object for the `Get` and `List` responses. Driver-specific information is
+// put in the `Status` field. If the volume's metadata cannot be read, only the name is filled in.
+func (d *DockerOnTop) describeVolume(volumeName string) *volume.Volume {
+	vol := &volume.Volume{Name: volumeName}
+
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		d.logger.Warn("Failed to retrieve metadata for the volume. Listing just its name", "volume", volumeName, "error", err)
+		return vol
+	}
+
+	vol.Status = map[string]interface{}{}
+	if !thisVol.CreatedAt.IsZero() {
+		// Volumes created before the creation time was recorded don't have it
+		vol.CreatedAt = thisVol.CreatedAt.Format(time.RFC3339)
+		vol.Status["createdAt"] = vol.CreatedAt
 	}
+	if !thisVol.LastMountedAt.IsZero() {
+		vol.Status["lastMountedAt"] = thisVol.LastMountedAt.Format(time.RFC3339)
+	}
+	if !thisVol.LastUnmountedAt.IsZero() {
+		vol.Status["lastUnmountedAt"] = thisVol.LastUnmountedAt.Format(time.RFC3339)
+	}
+	vol.Status["totalMountCount"] = thisVol.TotalMountCount
+	if thisVol.LastMountOptions != "" {
+		vol.Status["lastMountOptions"] = thisVol.LastMountOptions
+	}
+	if thisVol.ParentVolume != "" {
+		vol.Status["parentVolume"] = thisVol.ParentVolume
+	}
+	if len(thisVol.Tags) > 0 {
+		vol.Status["tags"] = strings.Join(thisVol.Tags, ",")
+	}
+	if thisVol.QuotaBytes > 0 {
+		vol.Status["quotaBytes"] = thisVol.QuotaBytes
+	}
+	if thisVol.TmpfsSizeBytes > 0 {
+		vol.Status["tmpfsSizeBytes"] = thisVol.TmpfsSizeBytes
+	}
+	if thisVol.hasWriteLimits() {
+		vol.Status["writeIops"], vol.Status["writeBps"] = thisVol.WriteIOPS, thisVol.WriteBPS
+		vol.Status["cgroupParent"] = "/" + ioCgroupParent + "/" + volumeName
+	}
+	// The Mount response has no status, so the overlay's layers are reported here for the tools inspecting them
+	vol.Status["upperdir"] = d.upperdir(volumeName, &thisVol)
+	workdir := d.workdir(volumeName, &thisVol)
+	if _, err := os.Stat(workdir); err == nil {
+		// Only exists while the volume is mounted
+		vol.Status["workdir"] = workdir
+	}
+	if !d.DisableUsageReporting {
+		ctx, cancel := context.WithTimeout(context.Background(), d.usageTimeout)
+		defer cancel()
+		if usage, err := d.upperdirUsage(ctx, volumeName, &thisVol); err != nil {
+			d.logger.Warn("Failed to compute the disk usage of the volume", "volume", volumeName, "error", err)
+		} else {
+			vol.Status["upperdirBytes"] = usage
+		}
+	}
+	return vol
+}
+
+// activeMount describes a container using the volume, as reported by `Get`
+type activeMount struct {
+	ID string `json:"id"`
+	// UsageCount is the number of times the container has mounted the volume. Always 1, as repeated mounts by the same
+	// container are not tracked separately
+	UsageCount int `json:"usageCount"`
+}
+
+// listActiveMounts returns the volume's active mounts sorted by the container ID, taking a consistent snapshot of
+// activemounts/ under the volume's lock.
+func (d *DockerOnTop) listActiveMounts(volumeName string) ([]activeMount, error) {
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := d.readActivemounts(volumeName, -1)
+	if err != nil {
+		return nil, err
+	}
+	activeMounts := make([]activeMount, 0, len(entries))
+	for _, entry := range entries {
+		activeMounts = append(activeMounts, activeMount{ID: entry.Name(), UsageCount: 1})
+	}
+	// Unlike `os.ReadDir`, `os.File.ReadDir` returns the entries in the directory order
+	slices.SortFunc(activeMounts, func(a, b activeMount) int { return strings.Compare(a.ID, b.ID) })
+	return activeMounts, nil
 }
 
 func (d *DockerOnTop) Remove(request *volume.RemoveRequest) error {
-	log.Debugf("Request Remove: Name=%s. It will succeed regardless of the presence of the volume", request.Name)
+	span := d.startSpan("Remove", attrVolumeName.String(request.Name))
+	d.setBaseDirAttr(span, request.Name) // Before the metadata is removed
+	var baseDirPath string
+	if d.AuditLog != nil {
+		if thisVol, err := d.getVolumeInfo(request.Name); err == nil {
+			baseDirPath = thisVol.BaseDirPath
+		}
+	}
+	err := d.remove(request)
+	endSpan(span, err)
+	d.metrics.removes.WithLabelValues(resultLabel(err)).Inc()
+	if err == nil && baseDirPath != "" {
+		// Removing a nonexistent volume succeeds, but is not worth recording
+		d.audit("Remove", request.Name, "", baseDirPath)
+	}
+	return err
+}
+
+func (d *DockerOnTop) remove(request *volume.RemoveRequest) error {
+	d.logger.Debug("Request Remove. It will succeed regardless of the presence of the volume", "volume", request.Name)
+
+	if err := d.checkNoForks(request.Name, "remove"); err != nil {
+		return err
+	}
 
+	mainDir := d.volumeDir(request.Name)
+	if isNamespaceDir(mainDir) {
+		// Must not remove the volumes inside
+		return errNamespaceNotVolume
+	}
+
+	if d.DryRun {
+		d.logDryRun("remove the volume", request.Name, "path", mainDir)
+		return nil
+	}
+
+	defer d.invalidateVolumeInfo(request.Name)
 	// Expecting the volume to have been unmounted by this moment. If it isn't, the error will be reported
-	err := os.RemoveAll(d.dotRootDir + request.Name)
+	err := os.RemoveAll(mainDir)
 	if err != nil {
-		log.Errorf("Failed to RemoveAll main directory: %v", err)
-		return internalError("failed to RemoveAll volume main directory", err)
+		return d.internalError("failed to RemoveAll volume main directory", err, "volume", request.Name)
+	}
+	d.removeEmptyNamespaceDirs(mainDir)
+	if err := d.metadata.Delete(request.Name); err != nil {
+		return d.internalError("failed to delete the volume's metadata", err, "volume", request.Name)
 	}
 	return nil
 }
 
 func (d *DockerOnTop) Path(request *volume.PathRequest) (*volume.PathResponse, error) {
-	log.Debugf("Request Path: Name=%s", request.Name)
+	span := d.startSpan("Path", attrVolumeName.String(request.Name))
+	defer span.End()
+
+	d.logger.Debug("Request Path", "volume", request.Name)
 	return &volume.PathResponse{Mountpoint: d.mountpointdir(request.Name)}, nil
 }
 
 func (d *DockerOnTop) Mount(request *volume.MountRequest) (*volume.MountResponse, error) {
-	log.Debugf("Request Mount: ID=%s, Name=%s", request.ID, request.Name)
+	span := d.startSpan("Mount", attrVolumeName.String(request.Name), attrMountID.String(request.ID))
+	d.setBaseDirAttr(span, request.Name)
+	start := time.Now()
+	response, err := d.mount(request)
+	d.metrics.observeMount(time.Since(start), err)
+	endSpan(span, err)
+	if err == nil {
+		d.audit("Mount", request.Name, request.ID, "")
+		d.recordAccess("Mount", request.Name, request.ID)
+	}
+	return response, err
+}
+
+func (d *DockerOnTop) mount(request *volume.MountRequest) (*volume.MountResponse, error) {
+	d.logger.Debug("Request Mount", "id", request.ID, "volume", request.Name)
+
+	if d.closed.Load() {
+		return nil, errDriverClosed
+	}
+
+	if d.dockerDaemonShuttingDown() {
+		// Mounting now would most likely leave an orphaned mount behind, as the container won't be started
+		d.logger.Info("The docker daemon seems to be shutting down. Refusing to mount the volume",
+			"volume", request.Name)
+		return nil, errors.New("the docker daemon is shutting down, refusing to mount the volume")
+	}
 
 	thisVol, err := d.getVolumeInfo(request.Name)
 	if os.IsNotExist(err) {
-		log.Debugf("Couldn't get volume info: %v", err)
+		d.logger.Debug("Couldn't get volume info", "volume", request.Name, "error", err)
 		return nil, errors.New("no such volume")
 	} else if err != nil {
-		log.Errorf("Failed to retrieve metadata for volume %s: %v", request.Name, err)
-		return nil, internalError("failed to retrieve the volume's metadata", err)
+		return nil, d.internalError("failed to retrieve the volume's metadata", err, "volume", request.Name)
 	}
 
 	mountpoint := d.mountpointdir(request.Name)
 	response := volume.MountResponse{Mountpoint: mountpoint}
 
-	// Synchronization. Take an exclusive lock on the activemounts/ dir of the volume to ensure that no parallel
+	if d.DryRun {
+		options, flags, err := d.overlayMountOptions(request.Name, thisVol)
+		if err != nil {
+			return nil, d.internalError("invalid mount options", err, "volume", request.Name)
+		}
+		d.logDryRun("mount the overlay (unless already mounted) and record the container", request.Name, "id",
+			request.ID, "mountpoint", mountpoint, "options", options, "flags", flags)
+		return &response, nil
+	}
+
+	// Concurrent mounts of the volume are deduplicated, so that a failing (or hanging) overlay mount is only attempted
+	// once. The mount that leads the flight records its container; the others record theirs afterwards
+	leaderID, err, _ := d.mountFlight.Do(request.Name, func() (interface{}, error) {
+		return request.ID, d.activateVolume(request.Name, request.ID, thisVol)
+	})
+	// If the lease refused another container leading the flight, it may be this container's. Then it mounts the
+	// volume on its own below
+	var leaseErr *leaseHeldError
+	if err != nil && !(errors.As(err, &leaseErr) && leaderID != request.ID) {
+		// The error is already logged
+		return nil, err
+	}
+	if leaderID != request.ID {
+		d.logger.Debug("Joined a concurrent mount of the volume. Recording this container", "volume", request.Name,
+			"id", request.ID)
+		if err := d.activateVolume(request.Name, request.ID, thisVol); err != nil {
+			return nil, err
+		}
+	}
+
+	d.markVolumeUsed(request.Name)
+
+	return &response, nil
+}
+
+// activateVolume records that the container `id` uses the volume, mounting the volume's overlay if no other container
+// uses it. The errors are logged, the returned ones are meant to be shown to the end user.
+func (d *DockerOnTop) activateVolume(volumeName string, id string, thisVol VolumeInfo) error {
+	// Synchronization. Take the exclusive lock of the volume (see `lockVolume`) to ensure that no parallel
 	// mounts/unmounts interfere. Note that it is crucial that the lock is held not only during the checks on other
 	// containers using the volume, but until a complete mount/unmount is performed: if, instead, we unlocked after
 	// finding that we are the first mount request (thus responsible to mount) but before actually mounting, another
 	// thread will see that the volume is already in use and assume it is mounted (while it isn't yet),
 	// which is a race condition.
-	var activemountsdir lockedFile
-	err = activemountsdir.Open(d.activemountsdir(request.Name))
+	unlock, err := d.lockVolume(volumeName)
 	if err != nil {
-		// The error is already logged and wrapped in `internalError` in lockedFile.go
-		return nil, err
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
+		return err
+	}
+	defer unlock()
+
+	if err := d.checkLease(volumeName, id); err != nil {
+		return err
 	}
-	defer activemountsdir.Close() // There is nothing I could do about the error (logging is performed inside `Close()` anyway)
 
-	_, readDirErr := activemountsdir.ReadDir(1) // Check if there are any files inside activemounts dir
+	var info activemountInfo                                     // Content of this container's active mount file
+	otherMounts, readDirErr := d.readActivemounts(volumeName, 1) // Check if there are any files inside activemounts dir
 	if errors.Is(readDirErr, io.EOF) {
-		// No files => no other containers are using the volume. Need to mount the overlay
+		// No files => no other containers are using the volume. Need to mount the overlay, unless it is (somehow)
+		// mounted already: then it is reused, and the containers using it are looked for
 
-		lowerdir := thisVol.BaseDirPath
-		upperdir := d.upperdir(request.Name)
-		workdir := d.workdir(request.Name)
+		if thisVol.Stuck {
+			d.forceUnmountStuckOverlay(volumeName, &thisVol)
+		}
 
-		err = d.volumeTreePreMount(request.Name, thisVol.Volatile)
+		alreadyMounted, err := d.isOverlayMounted(volumeName)
 		if err != nil {
-			// The error is already logged and wrapped in `internalError` by `d.volumeTreePreMount`
-			return nil, err
+			d.logger.Warn("Failed to check whether the overlay is already mounted. Assuming it isn't", "volume",
+				volumeName, "error", err)
 		}
-
-		options := "lowerdir=" + lowerdir + ",upperdir=" + upperdir + ",workdir=" + workdir
-
-		err = syscall.Mount("docker-on-top_"+request.Name, mountpoint, "overlay", 0, options)
-		if os.IsNotExist(err) {
-			log.Errorf("Failed to mount overlay for volume %s because something does not exist: %v",
-				request.Name, err)
-			return nil, errors.New("failed to mount volume: something is missing (does the base directory " +
-				"exist?)")
-		} else if err != nil {
-			log.Errorf("Failed to mount overlay for volume %s: %v", request.Name, err)
-			return nil, internalError("failed to mount overlay", err)
+		if alreadyMounted {
+			d.logger.Warn("The overlay of the volume is already mounted although no active mounts are recorded. "+
+				"Reusing it", "volume", volumeName)
+			if _, err := d.recoverActivemountsFromProcMounts(volumeName); err != nil {
+				d.logger.Warn("Failed to recover active mounts of the volume", "volume", volumeName, "error", err)
+			}
+		} else {
+			if thisVol.ParentVolume != "" {
+				if err := d.checkTemplateMounted(thisVol.ParentVolume); err != nil {
+					return err
+				}
+			}
+			if err := d.runHook("pre-mount", thisVol.PreMountHook, volumeName, thisVol); err != nil {
+				// The error is already logged by `d.runHook`
+				return err
+			}
+			var options string
+			options, info.Fuse, err = d.mountOverlay(volumeName, thisVol)
+			if err != nil {
+				// The error is already logged by `d.mountOverlay`
+				return err
+			}
+			if err := d.runHook("post-mount", thisVol.PostMountHook, volumeName, thisVol); err != nil {
+				// The overlay is mounted fine, so the container may use it
+				d.logger.Warn("The post-mount hook failed. Using the volume anyway", "volume", volumeName)
+			}
+			err = d.updateVolumeInfo(volumeName, func(vol *VolumeInfo) {
+				vol.LastMountedAt = time.Now()
+				vol.TotalMountCount++
+				vol.LastMountOptions = options
+			})
+			if err != nil {
+				d.logger.Warn("Failed to record mount statistics of the volume", "volume", volumeName, "error", err)
+			}
+		}
+	} else if readDirErr == nil {
+		d.logger.Debug("Volume is already mounted for some other container. Indicating success without remounting",
+			"volume", volumeName)
+		// The overlay is mounted the same way for all the containers
+		info, err = readActivemountInfo(d.activemountsdir(volumeName) + otherMounts[0].Name())
+		if err != nil {
+			d.logger.Warn("Failed to read the active mount file of another container", "volume", volumeName,
+				"error", err)
 		}
-
-		log.Debugf("Mounted volume %s at %s", request.Name, mountpoint)
-	} else if err == nil {
-		log.Debugf("Volume %s is already mounted for some other container. Indicating success without remounting",
-			request.Name)
 	} else {
-		log.Errorf("Failed to list the activemounts directory: %v", err)
-		return nil, internalError("failed to list activemounts/", err)
+		return d.internalError("failed to list activemounts/", readDirErr, "volume", volumeName)
 	}
 
-	activemountFilePath := d.activemountsdir(request.Name) + request.ID
-	f, err := os.Create(activemountFilePath)
-	if err == nil {
-		// We don't care about the file's contents
-		_ = f.Close()
-	} else {
+	activemountFilePath := d.activemountsdir(volumeName) + id
+	err = createActivemountFile(activemountFilePath, info)
+	if err != nil {
 		if os.IsExist(err) {
 			// Super weird. I can't imagine why this would happen.
-			log.Warningf("Active mount %s already exists (but it shouldn't...)", activemountFilePath)
+			d.logger.Warn("Active mount already exists (but it shouldn't...)", "path", activemountFilePath)
 		} else {
 			// A really bad situation!
 			// We successfully mounted (`syscall.Mount`) the volume but failed to put information about the container
@@ -244,76 +948,302 @@ func (d *DockerOnTop) Mount(request *volume.MountRequest) (*volume.MountResponse
 			//
 			// (if it's not us who actually mounted the overlay, then the situation isn't too bad: no new container is
 			// started, the error is reported to the end user).
-			log.Criticalf("Failed to create active mount file: %v. If no other container was currently "+
-				"using the volume, this volume's state is now invalid. A human interaction or a reboot is required",
-				err)
-			return nil, fmt.Errorf("docker-on-top internal error: failed to create an active mount file: %w. "+
+			d.logCritical("Failed to create active mount file. If no other container was currently using the volume, "+
+				"this volume's state is now invalid. A human interaction or a reboot is required", "volume",
+				volumeName, "error", err)
+			return fmt.Errorf("docker-on-top internal error: failed to create an active mount file: %w. "+
 				"The volume is now locked. Make sure that no other container is using the volume, then run "+
 				"`unmount %s` to unlock it. Human interaction is required. Please, report this bug",
-				err, mountpoint)
+				err, d.mountpointdir(volumeName))
 		}
 	}
 
-	return &response, nil
+	return nil
+}
+
+// overlayMountOptions returns the options and the flags the volume's overlay is mounted with. The error is only
+// returned for a volume with broken metadata (see `BuildOptionsString`).
+func (d *DockerOnTop) overlayMountOptions(volumeName string, thisVol VolumeInfo) (string, uintptr, error) {
+	mo := MountOptions{LowerDirs: thisVol.lowerDirs(), ExtraOptions: map[string]string{}}
+	flags := thisVol.mountFlags()
+	if thisVol.ReadOnly {
+		// Without upperdir, overlayfs requires at least two lower directories. As the workdir is not used for
+		// read-only mounts, it is used as an empty bottom layer if needed
+		if len(mo.LowerDirs) == 1 {
+			mo.LowerDirs = append(mo.LowerDirs, d.workdir(volumeName, &thisVol))
+		}
+		flags |= syscall.MS_RDONLY
+	} else {
+		mo.UpperDir, mo.WorkDir = d.upperdir(volumeName, &thisVol), d.workdir(volumeName, &thisVol)
+	}
+	if thisVol.UserXattr {
+		mo.ExtraOptions["userxattr"] = ""
+	}
+	for name, value := range thisVol.OverlayOptions {
+		mo.ExtraOptions[name] = value
+	}
+	options, err := BuildOptionsString(mo)
+	return options, flags, err
+}
+
+// checkMountOptionsDrift logs a warning if the volume's overlay would now be mounted with options other than the ones
+// it was last mounted with (`VolumeInfo.LastMountOptions`), e.g. because the volume's metadata was changed meanwhile.
+// It is only informational: the overlay is unmounted the same way regardless.
+func (d *DockerOnTop) checkMountOptionsDrift(volumeName string) {
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err != nil || thisVol.LastMountOptions == "" {
+		return
+	}
+	options, _, err := d.overlayMountOptions(volumeName, thisVol)
+	if err == nil && options != thisVol.LastMountOptions {
+		d.logger.Warn("The volume's mount options have changed since it was mounted. The new ones apply on the next "+
+			"mount", "volume", volumeName, "mountedWith", thisVol.LastMountOptions, "current", options)
+	}
+}
+
+// mountOverlay prepares the volume's directory tree and mounts the volume's overlay at its mountpoint. The caller is
+// expected to hold the volume's lock (see `lockVolume`).
+//
+// If the overlay mounts keep failing, no attempt is made and `errMountCircuitOpen` is returned (see
+// `mountCircuitBreaker`). If the kernel doesn't support overlayfs and `d.TryFuseOverlayFallback` is set, the overlay is
+// mounted with `fuse-overlayfs` instead, which is reported by the returned `fuse` value. The options the overlay was
+// mounted with are returned as well.
+//
+// Errors are logged. The returned error is meant to be shown to the end user.
+func (d *DockerOnTop) mountOverlay(volumeName string, thisVol VolumeInfo) (options string, fuse bool, err error) {
+	if !d.mountBreaker.allow(d.logger) {
+		d.logger.Warn("Not mounting the overlay: the mount circuit breaker is open", "volume", volumeName)
+		d.metrics.mountsShortCircuited.Inc()
+		return "", false, errMountCircuitOpen
+	}
+
+	mountpoint := d.mountpointdir(volumeName)
+
+	if thisVol.TmpfsSizeBytes > 0 {
+		if err := d.mountUpperTmpfs(volumeName, &thisVol); err != nil {
+			return "", false, d.internalError("failed to mount the tmpfs for the changes", err, "volume", volumeName)
+		}
+		defer func() {
+			if err != nil {
+				// The overlay is not mounted, so the tmpfs is not busy
+				if cleanupErr := d.unmountUpperTmpfs(volumeName); cleanupErr != nil {
+					d.logger.Error("Failed to unmount the in-memory upper layer after a failed mount", "volume",
+						volumeName, "error", cleanupErr)
+				}
+			}
+		}()
+	}
+
+	if !thisVol.ReadOnly {
+		err := d.testWriteToUpper(volumeName, &thisVol)
+		if err != nil {
+			d.logger.Error("Pre-mount write test failed", "volume", volumeName, "error", err)
+			return "", false, err
+		}
+	}
+
+	err = d.volumeTreePreMount(volumeName, &thisVol)
+	if err != nil {
+		// The error is already logged and wrapped in `internalError` by `d.volumeTreePreMount`
+		return "", false, err
+	}
+
+	if thisVol.QuotaBytes > 0 {
+		if err := d.setUpQuota(volumeName, thisVol); err != nil {
+			d.logger.Error("Failed to set up the quota of the volume", "volume", volumeName, "error", err)
+			return "", false, fmt.Errorf("failed to mount volume: failed to set up its quota: %w", err)
+		}
+	}
+	if thisVol.hasWriteLimits() {
+		if err := d.setUpWriteLimits(volumeName, thisVol); err != nil {
+			d.logger.Error("Failed to set up the write limits of the volume", "volume", volumeName, "error", err)
+			return "", false, fmt.Errorf("failed to mount volume: failed to set up its write limits: %w", err)
+		}
+	}
+
+	options, flags, err := d.overlayMountOptions(volumeName, thisVol)
+	if err != nil {
+		return "", false, d.internalError("invalid mount options", err, "volume", volumeName)
+	}
+	err = d.mountWithTimeout(volumeName, mountpoint, flags, options)
+	if isOverlayUnsupported(err) && d.TryFuseOverlayFallback {
+		d.logger.Warn("The kernel doesn't support overlayfs. Falling back to "+fuseOverlayBinary, "volume", volumeName,
+			"error", err)
+		err = mountFuseOverlay(volumeName, mountpoint, options, flags)
+		if err != nil {
+			return "", false, d.internalError("failed to mount overlay with "+fuseOverlayBinary, err, "volume",
+				volumeName)
+		}
+		fuse = true
+	}
+	if !os.IsNotExist(err) {
+		// A missing directory is a problem of this particular volume rather than of the overlay filesystem
+		d.mountBreaker.record(d.logger, err)
+	}
+	if os.IsNotExist(err) {
+		d.logger.Error("Failed to mount overlay because something does not exist", "volume", volumeName, "error", err)
+		return "", false, errors.New("failed to mount volume: something is missing (does the base directory exist?)")
+	} else if err != nil {
+		return "", false, d.internalError("failed to mount overlay", err, "volume", volumeName)
+	}
+
+	// Detect mount namespace issues early rather than when the container reports an empty volume
+	if visible, err := d.isOverlayMounted(volumeName); err != nil {
+		d.logger.Warn("Failed to check that the overlay is visible in the mount table", "volume", volumeName, "path",
+			procSelfMountInfo, "error", err)
+	} else if !visible {
+		d.logger.Warn("mount syscall returned success but overlay is not visible in /proc/self/mountinfo; the "+
+			"container may be in a different mount namespace", "volume", volumeName)
+	}
+
+	d.logger.Debug("Mounted volume", "volume", volumeName, "mountpoint", mountpoint, "fuse", fuse)
+	return options, fuse, nil
 }
 
 func (d *DockerOnTop) Unmount(request *volume.UnmountRequest) error {
-	log.Debugf("Request Unmount: ID=%s, Name=%s", request.ID, request.Name)
+	span := d.startSpan("Unmount", attrVolumeName.String(request.Name), attrMountID.String(request.ID))
+	d.setBaseDirAttr(span, request.Name)
+	err := d.unmount(request)
+	endSpan(span, err)
+	d.metrics.unmounts.WithLabelValues(resultLabel(err)).Inc()
+	if err == nil {
+		d.audit("Unmount", request.Name, request.ID, "")
+		d.recordAccess("Unmount", request.Name, request.ID)
+	}
+	return err
+}
+
+func (d *DockerOnTop) unmount(request *volume.UnmountRequest) error {
+	d.logger.Debug("Request Unmount", "id", request.ID, "volume", request.Name)
 
 	// Assuming the volume exists: the docker daemon won't let remove a volume that is still mounted
 
-	// Synchronization. Taking an exclusive lock on activemounts/ of the volume so that parallel mounts/unmounts
-	// don't interfere.
+	if d.DryRun {
+		d.logDryRun("remove the record of the container and unmount the overlay (unless still in use)", request.Name,
+			"id", request.ID, "mountpoint", d.mountpointdir(request.Name))
+		return nil
+	}
+
+	// Synchronization. Taking the exclusive lock of the volume so that parallel mounts/unmounts don't interfere.
 	// For more details, read the comment in the beginning of `DockerOnTop.Mount`.
-	var activemountsdir lockedFile
-	err := activemountsdir.Open(d.activemountsdir(request.Name))
+	unlock, err := d.lockVolume(request.Name)
 	if err != nil {
-		// The error is already logged and wrapped in `internalError` in lockedFile.go
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
 		return err
 	}
-	defer activemountsdir.Close() // There's nothing I could do about the error if it occurs
+	defer unlock()
 
-	dirEntries, readDirErr := activemountsdir.ReadDir(2) // Check if there is any _other_ container using the volume
+	unmounted := false
+	// Check if there is any _other_ container using the volume
+	dirEntries, readDirErr := d.readActivemounts(request.Name, 2)
 	if len(dirEntries) == 1 || errors.Is(readDirErr, io.EOF) {
 		// If just one entry or directory is empty, unmount overlay and clean up
 
-		err = syscall.Unmount(d.mountpointdir(request.Name), 0)
+		d.checkMountOptionsDrift(request.Name)
+
+		info, infoErr := readActivemountInfo(d.activemountsdir(request.Name) + request.ID)
+		if infoErr != nil && !os.IsNotExist(infoErr) {
+			d.logger.Warn("Failed to read the active mount file. Assuming the overlay is mounted by the kernel",
+				"volume", request.Name, "error", infoErr)
+		}
+		// The unmount hooks are best effort: their failures never hold up the unmount
+		thisVol, volErr := d.getVolumeInfo(request.Name)
+		if volErr != nil {
+			d.logger.Warn("Failed to retrieve metadata for the volume. Not running its unmount hooks", "volume",
+				request.Name, "error", volErr)
+		} else if err := d.runHook("pre-unmount", thisVol.PreUnmountHook, request.Name, thisVol); err != nil {
+			d.logger.Warn("The pre-unmount hook failed. Unmounting anyway", "volume", request.Name)
+		}
+
+		if info.Fuse {
+			err = unmountFuseOverlay(d.mountpointdir(request.Name), d.isLazyUnmount(request.Name))
+		} else {
+			err = d.unmountSyscall(d.mountpointdir(request.Name), 0)
+			if errors.Is(err, syscall.EBUSY) && d.isLazyUnmount(request.Name) {
+				d.logger.Warn("Mountpoint is busy. Detaching it lazily", "volume", request.Name)
+				err = d.unmountSyscall(d.mountpointdir(request.Name), syscall.MNT_DETACH)
+			}
+		}
 		if err != nil {
-			log.Errorf("Failed to unmount %s: %v", d.mountpointdir(request.Name), err)
+			d.logger.Error("Failed to unmount", "mountpoint", d.mountpointdir(request.Name), "error", err)
 			return err
 		}
+		unmounted = true
+		err = d.updateVolumeInfo(request.Name, func(vol *VolumeInfo) { vol.LastUnmountedAt = time.Now() })
+		if err != nil {
+			d.logger.Warn("Failed to record the unmount time of the volume", "volume", request.Name, "error", err)
+		}
+		// Before the changes of a volatile volume are discarded, so that the hook can back them up
+		if volErr == nil {
+			// The error is already logged by `d.runHook`
+			_ = d.runHook("post-unmount", thisVol.PostUnmountHook, request.Name, thisVol)
+			d.checkQuotaOverrun(request.Name, thisVol)
+			if thisVol.hasWriteLimits() {
+				if err := d.removeWriteLimits(request.Name); err != nil {
+					d.logger.Warn("Failed to remove the volume's cgroup", "volume", request.Name, "error", err)
+				}
+			}
+		}
 
-		err = d.volumeTreePostUnmount(request.Name)
+		if volErr == nil {
+			err = d.volumeTreePostUnmount(request.Name, &thisVol)
+		} else {
+			// Where the workdir is cannot be told without the metadata
+			err = d.internalError("failed to cleanup on unmount", volErr, "volume", request.Name)
+		}
 		// Don't return yet. The above error will be returned later
+		d.checkOverlayGone(request.Name)
 	} else if readDirErr == nil {
-		log.Debugf("Volume %s is still mounted in some other container. Indicating success without unmounting",
-			request.Name)
+		d.logger.Debug("Volume is still mounted in some other container. Indicating success without unmounting",
+			"volume", request.Name)
 	} else {
-		log.Errorf("Failed to list the activemounts directory: %v", err)
-		return internalError("failed to list activemounts/", err)
+		return d.internalError("failed to list activemounts/", readDirErr, "volume", request.Name)
 	}
 
 	activemountFilePath := d.activemountsdir(request.Name) + request.ID
 	err2 := os.Remove(activemountFilePath)
 	if os.IsNotExist(err2) {
-		log.Warningf("Failed to remove %s because it does not exist (but it should...)", activemountFilePath)
+		d.logger.Warn("Failed to remove the active mount file because it does not exist (but it should...)", "path",
+			activemountFilePath)
 	} else if err2 != nil {
 		// Another pretty bad situation. Even though we are no longer using the volume, it is seemingly in use by us
 		// because we failed to remove the file corresponding to this container.
-		log.Criticalf("Failed to remove the active mount file: %v. The volume is now considered used by a container "+
-			"that no longer exists", err)
+		d.logCritical("Failed to remove the active mount file. The volume is now considered used by a container "+
+			"that no longer exists", "path", activemountFilePath, "error", err2)
 		// The user most likely won't see this error message due to daemon not showing unmount errors to the
 		// `docker run` clients :((
 		return fmt.Errorf("docker-on-top internal error: failed to remove the active mount file: %w. The volume is "+
 			"now considered used by a container that no longer exists. Human interaction is required: remove the file "+
-			"manually to fix the problem", err)
+			"manually to fix the problem", err2)
+	}
+
+	if unmounted {
+		// No one is using the volume anymore, so no active mounts must be left
+		if flushErr := d.checkActivemountsDirIsFlushed(request.Name); flushErr != nil {
+			err = errors.Join(err, flushErr)
+		}
 	}
 
 	// Report an error during cleanup, if any
 	return err
 }
 
+// isLazyUnmount reports whether the volume's overlay may be detached lazily (with `MNT_DETACH`) if it is busy on
+// unmount. If the volume's metadata cannot be read, the plugin-wide default is used.
+func (d *DockerOnTop) isLazyUnmount(volumeName string) bool {
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		d.logger.Warn("Failed to retrieve metadata for the volume. Using the default lazy unmount setting", "volume",
+			volumeName, "error", err)
+		return d.DefaultLazyUnmount
+	}
+	return thisVol.LazyUnmount
+}
+
 func (d *DockerOnTop) Capabilities() *volume.CapabilitiesResponse {
-	log.Debug("Request Capabilities: plugin discovery")
+	span := d.startSpan("Capabilities")
+	defer span.End()
+
+	d.logger.Debug("Request Capabilities: plugin discovery")
 	return &volume.CapabilitiesResponse{Capabilities: volume.Capability{Scope: "volume"}}
 }
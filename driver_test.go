@@ -0,0 +1,1385 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestLifecycle(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+
+	list, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Volumes) != 1 || list.Volumes[0].Name != "vol" {
+		t.Errorf("List returned %v, want only vol", list.Volumes)
+	}
+
+	mountpoint := MustMountVolume(t, d, "vol", "first")
+	if mountpoint != d.VolumeMountpointDir("vol") {
+		t.Errorf("The mountpoint is %s, want %s", mountpoint, d.VolumeMountpointDir("vol"))
+	}
+	if options, ok := m.Mounted(mountpoint); !ok {
+		t.Fatal("The overlay is not mounted")
+	} else if want := "upperdir=" + d.VolumeUpperDir("vol"); !containsOption(options, want) {
+		t.Errorf("The overlay is mounted with %q, want %q among the options", options, want)
+	}
+
+	// The overlay stays mounted while any container uses the volume
+	MustMountVolume(t, d, "vol", "second")
+	MustUnmountVolume(t, d, "vol", "first")
+	if _, ok := m.Mounted(mountpoint); !ok {
+		t.Error("The overlay was unmounted while the volume is still in use")
+	}
+	MustUnmountVolume(t, d, "vol", "second")
+	if _, ok := m.Mounted(mountpoint); ok {
+		t.Error("The overlay is still mounted after the last unmount")
+	}
+	if mounted, err := d.volumeIsMounted("vol"); err != nil || mounted {
+		t.Errorf("volumeIsMounted = %v, %v; want false", mounted, err)
+	}
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Fatalf("Failed to remove the volume: %v", err)
+	}
+	if _, err := os.Stat(d.volumeDir("vol")); !os.IsNotExist(err) {
+		t.Errorf("The volume's directory is left after the removal (%v)", err)
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "vol"}); err == nil {
+		t.Error("Get succeeded for the removed volume")
+	}
+}
+
+func TestMountNonexistentVolume(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	if _, err := d.Mount(&volume.MountRequest{Name: "missing", ID: "container"}); err == nil {
+		t.Error("Mounting a nonexistent volume succeeded")
+	}
+}
+
+func TestLifecycleWithOverlay(t *testing.T) {
+	for _, volatile := range []bool{false, true} {
+		t.Run(fmt.Sprintf("volatile=%v", volatile), func(t *testing.T) {
+			d := NewTestDockerOnTopWithOverlay(t)
+			base := t.TempDir()
+			if err := os.WriteFile(base+"/file", []byte("base"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+				"base":     base,
+				"volatile": strconv.FormatBool(volatile),
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mountpoint := MustMountVolume(t, d, "vol", "container")
+			if contents, err := os.ReadFile(mountpoint + "/file"); err != nil || string(contents) != "base" {
+				t.Errorf("The volume's file = %q, %v; want %q", contents, err, "base")
+			}
+			if err := os.WriteFile(mountpoint+"/file", []byte("changed"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			MustUnmountVolume(t, d, "vol", "container")
+			if contents, err := os.ReadFile(base + "/file"); err != nil || string(contents) != "base" {
+				t.Errorf("The base directory was modified: %q, %v", contents, err)
+			}
+
+			// The changes of a volatile volume are discarded when it is mounted again
+			want := "changed"
+			if volatile {
+				want = "base"
+			}
+			mountpoint = MustMountVolume(t, d, "vol", "container")
+			if contents, err := os.ReadFile(mountpoint + "/file"); err != nil || string(contents) != want {
+				t.Errorf("After remounting, the volume's file = %q, %v; want %q", contents, err, want)
+			}
+			MustUnmountVolume(t, d, "vol", "container")
+
+			if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+				t.Fatalf("Failed to remove the volume: %v", err)
+			}
+		})
+	}
+}
+
+// containsOption reports whether the comma-separated mount options contain `option`.
+func containsOption(options string, option string) bool {
+	return slices.Contains(strings.Split(options, ","), option)
+}
+
+func TestCustomUpperAndWorkDirs(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	storage := t.TempDir()
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":  t.TempDir(),
+		"upper": storage + "/upper",
+		"work":  storage + "/work",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upperdir := d.VolumeUpperDir("vol"); upperdir != storage+"/upper/" {
+		t.Errorf("The upperdir is %s, want %s", upperdir, storage+"/upper/")
+	}
+	if workdir := d.VolumeWorkDir("vol"); workdir != storage+"/work/" {
+		t.Errorf("The workdir is %s, want %s", workdir, storage+"/work/")
+	}
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	options, _ := m.Mounted(mountpoint)
+	for _, want := range []string{"upperdir=" + storage + "/upper/", "workdir=" + storage + "/work/"} {
+		if !containsOption(options, want) {
+			t.Errorf("The overlay is mounted with %q, want %q among the options", options, want)
+		}
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(storage + "/upper"); err != nil {
+		t.Errorf("The custom upperdir outside of the dot root directory was removed: %v", err)
+	}
+}
+
+func TestCustomUpperAndWorkDirsValidation(t *testing.T) {
+	storage := t.TempDir()
+	tests := []struct {
+		name  string
+		upper string
+		work  string
+	}{
+		{name: "upper without work", upper: storage + "/upper"},
+		{name: "relative path", upper: "upper", work: storage + "/work"},
+		{name: "comma", upper: storage + "/up,per", work: storage + "/work"},
+		{name: "colon", upper: storage + "/up:per", work: storage + "/work"},
+		{name: "missing parent", upper: storage + "/missing/upper", work: storage + "/work"},
+		{name: "existing directory", upper: storage, work: storage + "/work"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t)
+			options := map[string]string{"base": t.TempDir(), "upper": test.upper}
+			if test.work != "" {
+				options["work"] = test.work
+			}
+			if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err == nil {
+				t.Error("The volume was created")
+			}
+		})
+	}
+}
+
+// writeFiles creates the files in `dir` with the given (slash-separated relative) paths and contents, and the
+// directories leading to them.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, contents := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLowerLayers(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	base, layer1, layer2 := t.TempDir(), t.TempDir(), t.TempDir()
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":   base,
+		"layers": layer1 + ":" + layer2,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	options, _ := m.Mounted(mountpoint)
+	if want := "lowerdir=" + base + ":" + layer1 + ":" + layer2; !containsOption(options, want) {
+		t.Errorf("The overlay is mounted with %q, want %q among the options", options, want)
+	}
+}
+
+func TestLowerLayersValidation(t *testing.T) {
+	layer := t.TempDir()
+	for name, layers := range map[string]string{
+		"missing":   layer + "/missing",
+		"relative":  "layer",
+		"duplicate": layer + ":" + layer,
+		"comma":     layer + ",x",
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t)
+			err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+				"base":   t.TempDir(),
+				"layers": layers,
+			}})
+			if err == nil {
+				t.Error("The volume was created")
+			}
+		})
+	}
+}
+
+func TestLowerLayersWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base, layer1, layer2 := t.TempDir(), t.TempDir(), t.TempDir()
+	writeFiles(t, base, map[string]string{"all": "base", "dir/all": "base"})
+	writeFiles(t, layer1, map[string]string{"all": "layer1", "layers": "layer1", "dir/all": "layer1",
+		"dir/layers": "layer1"})
+	writeFiles(t, layer2, map[string]string{"all": "layer2", "layers": "layer2", "layer2": "layer2",
+		"dir/all": "layer2", "dir/layer2": "layer2"})
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":   base,
+		"layers": layer1 + ":" + layer2,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The base directory takes precedence over the layers, and each layer over the next ones
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	for file, want := range map[string]string{
+		"all":        "base",
+		"layers":     "layer1",
+		"layer2":     "layer2",
+		"dir/all":    "base",
+		"dir/layers": "layer1",
+		"dir/layer2": "layer2",
+	} {
+		if contents, err := os.ReadFile(filepath.Join(mountpoint, file)); err != nil || string(contents) != want {
+			t.Errorf("%s = %q, %v; want %q", file, contents, err, want)
+		}
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
+
+func TestReadOnlyVolume(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"file": "base"})
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"base": base, "readonly": "true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	if contents, err := os.ReadFile(mountpoint + "/file"); err != nil || string(contents) != "base" {
+		t.Errorf("The volume's file = %q, %v; want %q", contents, err, "base")
+	}
+	if err := os.WriteFile(mountpoint+"/file", []byte("changed"), 0o644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Modifying a file returned %v, want EROFS", err)
+	}
+	if err := os.WriteFile(mountpoint+"/new", nil, 0o644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Creating a file returned %v, want EROFS", err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
+
+func TestReadOnlyVolumeValidation(t *testing.T) {
+	for name, options := range map[string]map[string]string{
+		"volatile":      {"readonly": "true", "volatile": "true"},
+		"invalid value": {"readonly": "sometimes"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t)
+			options["base"] = t.TempDir()
+			if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err == nil {
+				t.Error("The volume was created")
+			}
+		})
+	}
+}
+
+func TestLazyUnmount(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazy=%v", lazy), func(t *testing.T) {
+			d := NewTestDockerOnTopWithOverlay(t)
+			base := t.TempDir()
+			writeFiles(t, base, map[string]string{"file": "base"})
+			err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+				"base": base,
+				"lazy": strconv.FormatBool(lazy),
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mountpoint := MustMountVolume(t, d, "vol", "container")
+
+			// A process still using the volume keeps the overlay busy
+			opened, release, done := make(chan error), make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(done)
+				f, err := os.Open(mountpoint + "/file")
+				opened <- err
+				if err != nil {
+					return
+				}
+				<-release
+				_ = f.Close()
+			}()
+			if err := <-opened; err != nil {
+				t.Fatal(err)
+			}
+			err = d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "container"})
+			close(release)
+			<-done
+
+			if !lazy {
+				if !errors.Is(err, syscall.EBUSY) {
+					t.Errorf("Unmounting the busy volume returned %v, want EBUSY", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to unmount the busy volume: %v", err)
+			}
+			if mounted, err := d.isOverlayMounted("vol"); err != nil || mounted {
+				t.Errorf("isOverlayMounted = %v, %v; want false", mounted, err)
+			}
+		})
+	}
+}
+
+func TestCreatedAt(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	before := time.Now().Truncate(time.Second)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	after := time.Now()
+	time.Sleep(time.Millisecond)
+
+	response, err := d.Get(&volume.GetRequest{Name: "vol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt, err := time.Parse(time.RFC3339, response.Volume.CreatedAt)
+	if err != nil {
+		t.Fatalf("The creation time %q is invalid: %v", response.Volume.CreatedAt, err)
+	}
+	if createdAt.Before(before) || createdAt.After(after) {
+		t.Errorf("The creation time is %v, want between %v and %v", createdAt, before, after)
+	}
+
+	payload, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded volume.GetResponse
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Volume.CreatedAt != response.Volume.CreatedAt {
+		t.Errorf("After a JSON round trip, the creation time is %q, want %q", decoded.Volume.CreatedAt,
+			response.Volume.CreatedAt)
+	}
+
+	// The creation time is stored in the metadata with its full precision
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vol.CreatedAt.Truncate(time.Second).Equal(createdAt) {
+		t.Errorf("The metadata's creation time is %v, want %v", vol.CreatedAt, createdAt)
+	}
+}
+
+func TestResolveAndValidateBase(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"allowed/sub", "allowed-not", "other"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each of the double symlinks points to another symlink, which points to the directory
+	for link, target := range map[string]string{
+		"inside1":         "inside2",
+		"inside2":         "allowed/sub",
+		"outside1":        "outside2",
+		"outside2":        "other",
+		"allowed/escape1": "escape2",
+		"allowed/escape2": "../other",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowed := []string{root + "/allowed"}
+
+	tests := []struct {
+		name     string
+		path     string
+		prefixes []string
+		want     string // Empty if the path is rejected
+	}{
+		{name: "allowed", path: root + "/allowed/sub", prefixes: allowed, want: root + "/allowed/sub"},
+		{name: "prefix itself", path: root + "/allowed", prefixes: allowed, want: root + "/allowed"},
+		{name: "not allowed", path: root + "/other", prefixes: allowed},
+		{name: "common name prefix", path: root + "/allowed-not", prefixes: allowed},
+		{name: "dot-dot inside", path: root + "/allowed/sub/../sub", prefixes: allowed, want: root + "/allowed/sub"},
+		{name: "dot-dot escaping", path: root + "/allowed/../other", prefixes: allowed},
+		{name: "dot-dot escaping and back", path: root + "/allowed/../allowed/sub", prefixes: allowed,
+			want: root + "/allowed/sub"},
+		{name: "double symlink inside", path: root + "/inside1", prefixes: allowed, want: root + "/allowed/sub"},
+		{name: "double symlink outside", path: root + "/outside1", prefixes: allowed},
+		{name: "double symlink escaping", path: root + "/allowed/escape1", prefixes: allowed},
+		{name: "no prefixes", path: root + "/other", want: root + "/other"},
+		{name: "no prefixes, double symlink", path: root + "/outside1", want: root + "/other"},
+		{name: "no prefixes, dot-dot", path: root + "/allowed/../other", want: root + "/other"},
+		{name: "missing", path: root + "/missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolved, err := resolveAndValidateBase(test.path, test.prefixes)
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("%s was accepted as %s", test.path, resolved)
+				}
+			} else if err != nil || resolved != test.want {
+				t.Errorf("resolveAndValidateBase = %q, %v; want %q", resolved, err, test.want)
+			}
+		})
+	}
+}
+
+func TestCreateStoresResolvedBase(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(base, link); err != nil {
+		t.Fatal(err)
+	}
+	MustCreateVolume(t, d, "vol", link)
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol.BaseDirPath != base {
+		t.Errorf("The base directory is %s, want %s", vol.BaseDirPath, base)
+	}
+
+	// Changing the symlink afterwards doesn't affect the volume
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Fatal(err)
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.BaseDirPath != base {
+		t.Errorf("After the symlink changed, the base directory is %s (%v), want %s", vol.BaseDirPath, err, base)
+	}
+}
+
+func TestBasePrefixes(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"data/public/sub", "data/secrets/sub", "other"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		ok      []string
+		refused []string
+	}{
+		{
+			name:    "allowed only",
+			allowed: []string{root + "/data"},
+			ok:      []string{"data", "data/public/sub", "data/secrets"},
+			refused: []string{"other"},
+		},
+		{
+			name:    "denied only",
+			denied:  []string{root + "/data/secrets"},
+			ok:      []string{"data", "data/public", "other"},
+			refused: []string{"data/secrets", "data/secrets/sub"},
+		},
+		{
+			name:    "denied inside allowed",
+			allowed: []string{root + "/data"},
+			denied:  []string{root + "/data/secrets"},
+			ok:      []string{"data", "data/public/sub"},
+			refused: []string{"data/secrets", "data/secrets/sub", "other"},
+		},
+		{
+			name:    "allowed inside denied",
+			allowed: []string{root + "/data/public"},
+			denied:  []string{root + "/data"},
+			refused: []string{"data", "data/public", "data/public/sub", "other"},
+		},
+		{
+			name:    "same prefix",
+			allowed: []string{root + "/data", root + "/other"},
+			denied:  []string{root + "/data/"},
+			ok:      []string{"other"},
+			refused: []string{"data", "data/public"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t)
+			if err := d.SetBaseDirAllowedPrefixes(test.allowed); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.SetBaseDirDeniedPrefixes(test.denied); err != nil {
+				t.Fatal(err)
+			}
+			for i, dir := range test.ok {
+				if err := d.Create(&volume.CreateRequest{Name: fmt.Sprintf("ok%d", i), Options: map[string]string{
+					"base": filepath.Join(root, dir),
+				}}); err != nil {
+					t.Errorf("The base directory %s was refused: %v", dir, err)
+				}
+			}
+			for i, dir := range test.refused {
+				if err := d.Create(&volume.CreateRequest{Name: fmt.Sprintf("refused%d", i),
+					Options: map[string]string{"base": filepath.Join(root, dir)}}); err == nil {
+					t.Errorf("The base directory %s was accepted", dir)
+				}
+			}
+		})
+	}
+}
+
+func TestBasePrefixesValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	if err := d.SetBaseDirAllowedPrefixes([]string{"relative"}); err == nil {
+		t.Error("A relative allowed prefix was accepted")
+	}
+	if err := d.SetBaseDirDeniedPrefixes([]string{"/data", "relative"}); err == nil {
+		t.Error("A relative denied prefix was accepted")
+	}
+}
+
+func TestBootSkipsMountedVolumes(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	for _, name := range []string{"mounted", "stale"} {
+		MustCreateVolume(t, d, name, t.TempDir())
+		MustMountVolume(t, d, name, "container")
+	}
+
+	// The mock mounts leave nothing in the mount table, so the stale volume looks like it was left by a crash
+	if stillMounted, err := d.bootResetVolume("mounted", true); err != nil || !stillMounted {
+		t.Errorf("bootResetVolume(mounted) = %v, %v; want true", stillMounted, err)
+	}
+	if mounted, err := d.volumeIsMounted("mounted"); err != nil || !mounted {
+		t.Errorf("The mounted volume was reset: volumeIsMounted = %v, %v", mounted, err)
+	}
+	if _, err := os.Stat(d.VolumeMountpointDir("mounted")); err != nil {
+		t.Errorf("The mounted volume's mountpoint is gone: %v", err)
+	}
+
+	if stillMounted, err := d.bootResetVolume("stale", false); err != nil || stillMounted {
+		t.Errorf("bootResetVolume(stale) = %v, %v; want false", stillMounted, err)
+	}
+	if mounted, err := d.volumeIsMounted("stale"); err != nil || mounted {
+		t.Errorf("The stale volume was not reset: volumeIsMounted = %v, %v", mounted, err)
+	}
+	if _, err := os.Stat(d.VolumeMountpointDir("stale")); !os.IsNotExist(err) {
+		t.Errorf("The stale volume's mountpoint is left (%v)", err)
+	}
+}
+
+func TestBootSkipsMountedVolumesWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	writeFiles(t, mountpoint, map[string]string{"file": "changed"})
+
+	// The plugin restarts while the container still uses the volume
+	restarted, err := NewDockerOnTop(context.Background(), d.DotRootDirPath(), WithLogger(newTestLogger(t)),
+		WithoutOverlayProbe())
+	if err != nil {
+		t.Fatalf("Failed to restart the driver: %v", err)
+	}
+	defer restarted.Close()
+
+	if mounted, err := parseProcMounts(); err != nil || !mounted["vol"] {
+		t.Errorf("The overlay is not in the mount table after the restart (%v)", err)
+	}
+	if contents, err := os.ReadFile(mountpoint + "/file"); err != nil || string(contents) != "changed" {
+		t.Errorf("After the restart, the volume's file = %q, %v; want %q", contents, err, "changed")
+	}
+	if mounted, err := restarted.volumeIsMounted("vol"); err != nil || !mounted {
+		t.Errorf("The active mounts were reset: volumeIsMounted = %v, %v", mounted, err)
+	}
+	MustUnmountVolume(t, restarted, "vol", "container")
+}
+
+func TestMountStatistics(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	status := func() map[string]interface{} {
+		t.Helper()
+		response, err := d.Get(&volume.GetRequest{Name: "vol"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Volume.Status
+	}
+	if s := status(); s["totalMountCount"] != 0 || s["lastMountedAt"] != nil || s["lastUnmountedAt"] != nil {
+		t.Errorf("Before any mounts, the status is %v", s)
+	}
+
+	start := time.Now().Truncate(time.Second)
+	for cycle := 1; cycle <= 3; cycle++ {
+		MustMountVolume(t, d, "vol", "first")
+		// The containers sharing the mounted overlay are not counted
+		MustMountVolume(t, d, "vol", "second")
+		vol, err := d.getVolumeInfo("vol")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vol.TotalMountCount != cycle || vol.LastMountedAt.Before(start) {
+			t.Errorf("After %d mounts, TotalMountCount = %d, LastMountedAt = %v", cycle, vol.TotalMountCount,
+				vol.LastMountedAt)
+		}
+		MustUnmountVolume(t, d, "vol", "first")
+		MustUnmountVolume(t, d, "vol", "second")
+		vol, err = d.getVolumeInfo("vol")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vol.LastUnmountedAt.Before(vol.LastMountedAt) {
+			t.Errorf("After %d unmounts, LastUnmountedAt = %v is before LastMountedAt = %v", cycle,
+				vol.LastUnmountedAt, vol.LastMountedAt)
+		}
+	}
+
+	s := status()
+	if s["totalMountCount"] != 3 {
+		t.Errorf("Get reports totalMountCount = %v, want 3", s["totalMountCount"])
+	}
+	for _, key := range []string{"lastMountedAt", "lastUnmountedAt"} {
+		if at, err := time.Parse(time.RFC3339, fmt.Sprint(s[key])); err != nil || at.Before(start) {
+			t.Errorf("Get reports %s = %v (%v)", key, s[key], err)
+		}
+	}
+}
+
+func TestMountStatisticsOfOldVolumes(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	// The metadata written before the statistics were recorded
+	path := d.volumeDir("vol") + "/metadata.json"
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"LastMountedAt", "LastUnmountedAt", "TotalMountCount"} {
+		if _, ok := fields[key]; !ok {
+			t.Fatalf("The metadata has no %s field", key)
+		}
+		delete(fields, key)
+	}
+	if contents, err = json.Marshal(fields); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, contents, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.invalidateVolumeInfo("vol")
+
+	MustMountVolume(t, d, "vol", "container")
+	MustUnmountVolume(t, d, "vol", "container")
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.TotalMountCount != 1 || vol.LastUnmountedAt.IsZero() {
+		t.Errorf("After a mount, the metadata is %+v, %v", vol, err)
+	}
+}
+
+func TestGetActiveMounts(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	activeMounts := func() []activeMount {
+		t.Helper()
+		response, err := d.Get(&volume.GetRequest{Name: "vol"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mounts []activeMount
+		if err := json.Unmarshal([]byte(fmt.Sprint(response.Volume.Status["activeMounts"])), &mounts); err != nil {
+			t.Fatalf("Failed to decode activeMounts %v: %v", response.Volume.Status["activeMounts"], err)
+		}
+		return mounts
+	}
+	if mounts := activeMounts(); len(mounts) != 0 {
+		t.Errorf("The unused volume has the active mounts %v", mounts)
+	}
+
+	for _, id := range []string{"second-container", "first-container"} {
+		if err := createActivemountFile(d.activemountsdir("vol")+id, activemountInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []activeMount{{ID: "first-container", UsageCount: 1}, {ID: "second-container", UsageCount: 1}}
+	if mounts := activeMounts(); !slices.Equal(mounts, want) {
+		t.Errorf("Get reports the active mounts %v, want %v", mounts, want)
+	}
+}
+
+func TestLayerPathsInStatus(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	status := func() map[string]interface{} {
+		t.Helper()
+		response, err := d.Get(&volume.GetRequest{Name: "vol"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Volume.Status
+	}
+
+	upperdir, ok := status()["upperdir"].(string)
+	if info, err := os.Stat(upperdir); !ok || err != nil || !info.IsDir() {
+		t.Errorf("The unmounted volume's upperdir is %v (%v), want a directory", status()["upperdir"], err)
+	}
+	if workdir, ok := status()["workdir"]; ok {
+		t.Errorf("The unmounted volume has the workdir %v", workdir)
+	}
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	mounted := status()
+	options, _ := m.Mounted(mountpoint)
+	for _, key := range []string{"upperdir", "workdir"} {
+		dir, ok := mounted[key].(string)
+		if info, err := os.Stat(dir); !ok || err != nil || !info.IsDir() {
+			t.Errorf("The mounted volume's %s is %v (%v), want a directory", key, mounted[key], err)
+		}
+		// The layers are the ones of the overlay
+		if !containsOption(options, key+"="+dir) {
+			t.Errorf("The overlay is mounted with %q, want %s=%v among the options", options, key, mounted[key])
+		}
+	}
+	if mounted["upperdir"] != upperdir {
+		t.Errorf("While mounted, the upperdir is %v, want %s", mounted["upperdir"], upperdir)
+	}
+
+	MustUnmountVolume(t, d, "vol", "container")
+	if workdir, ok := status()["workdir"]; ok {
+		t.Errorf("After Unmount, the volume has the workdir %v", workdir)
+	}
+}
+
+func TestLayerPathsInStatusWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	writeFiles(t, mountpoint, map[string]string{"file": "written"})
+	response, err := d.Get(&volume.GetRequest{Name: "vol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The changes made in the container are found in the reported upperdir
+	upperdir, _ := response.Volume.Status["upperdir"].(string)
+	if contents, err := os.ReadFile(filepath.Join(upperdir, "file")); err != nil || string(contents) != "written" {
+		t.Errorf("In the upperdir %v, the file = %q, %v; want %q", upperdir, contents, err, "written")
+	}
+	workdir, _ := response.Volume.Status["workdir"].(string)
+	if info, err := os.Stat(workdir); err != nil || !info.IsDir() {
+		t.Errorf("The workdir %v is %v, %v; want a directory", workdir, info, err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
+
+func TestMountFlagsWithOverlay(t *testing.T) {
+	for _, restricted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("restricted=%v", restricted), func(t *testing.T) {
+			d := NewTestDockerOnTopWithOverlay(t)
+			options := map[string]string{"base": t.TempDir()}
+			for _, option := range []string{"noexec", "nosuid", "nodev"} {
+				options[option] = strconv.FormatBool(restricted)
+			}
+			if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err != nil {
+				t.Fatal(err)
+			}
+			mountpoint := MustMountVolume(t, d, "vol", "container")
+			defer MustUnmountVolume(t, d, "vol", "container")
+
+			entries, err := readMountInfo(procSelfMountInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i := slices.IndexFunc(entries, func(entry mountInfoEntry) bool {
+				return entry.MountPoint == filepath.Clean(mountpoint)
+			})
+			if i < 0 {
+				t.Fatal("The overlay is not in the mount table")
+			}
+			for _, flag := range []string{"noexec", "nosuid", "nodev"} {
+				if containsOption(entries[i].Options, flag) != restricted {
+					t.Errorf("The overlay is mounted with %q", entries[i].Options)
+				}
+			}
+
+			writeHook(t, mountpoint, "script", "true")
+			err = exec.Command(mountpoint + "/script").Run()
+			if restricted && !errors.Is(err, syscall.EACCES) {
+				t.Errorf("Running a script from the noexec volume returned %v, want EACCES", err)
+			} else if !restricted && err != nil {
+				t.Errorf("Failed to run a script from the volume: %v", err)
+			}
+		})
+	}
+}
+
+func TestMountFlagsValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	for _, option := range []string{"noexec", "nosuid", "nodev"} {
+		err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+			"base": t.TempDir(),
+			option: "maybe",
+		}})
+		if err == nil || !strings.Contains(err.Error(), option) {
+			t.Errorf("Creating a volume with %s=maybe returned %v, want an error about the option", option, err)
+		}
+	}
+}
+
+func BenchmarkBoot(b *testing.B) {
+	withoutLogs := WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, volumes := range []int{100, 1000} {
+		dotRootDir := b.TempDir()
+		d, err := NewDockerOnTop(context.Background(), dotRootDir, WithoutOverlayProbe(), withoutLogs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		base := b.TempDir()
+		for i := 0; i < volumes; i++ {
+			if err := d.Create(&volume.CreateRequest{Name: fmt.Sprintf("vol%d", i), Options: map[string]string{
+				"base": base,
+			}}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		_ = d.Close()
+
+		// Sequential and the default concurrency
+		for _, concurrency := range slices.Compact([]int{1, runtime.NumCPU()}) {
+			b.Run(fmt.Sprintf("volumes=%d/concurrency=%d", volumes, concurrency), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					d, err := NewDockerOnTop(context.Background(), dotRootDir, WithoutOverlayProbe(),
+						WithBootConcurrency(concurrency), withoutLogs)
+					if err != nil {
+						b.Fatal(err)
+					}
+					_ = d.Close()
+				}
+			})
+		}
+	}
+}
+
+func TestUserXattr(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaultXattr  bool
+		option        string
+		wantUserXattr bool
+	}{
+		{name: "default", wantUserXattr: false},
+		{name: "option", option: "true", wantUserXattr: true},
+		{name: "global default", defaultXattr: true, wantUserXattr: true},
+		{name: "option overrides global default", defaultXattr: true, option: "false", wantUserXattr: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMockSyscallMount()
+			d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+			d.DefaultUserXattr = test.defaultXattr
+			options := map[string]string{"base": t.TempDir()}
+			if test.option != "" {
+				options["userxattr"] = test.option
+			}
+			if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err != nil {
+				t.Fatal(err)
+			}
+			mountpoint := MustMountVolume(t, d, "vol", "container")
+			if options, _ := m.Mounted(mountpoint); containsOption(options, "userxattr") != test.wantUserXattr {
+				t.Errorf("The overlay is mounted with %q, want userxattr: %v", options, test.wantUserXattr)
+			}
+		})
+	}
+
+	d := NewTestDockerOnTop(t)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":      t.TempDir(),
+		"userxattr": "maybe",
+	}}); err == nil {
+		t.Error("A volume with userxattr=maybe was created")
+	}
+}
+
+func TestCorruptedMetadata(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol.CustomUpperDir = "/upper"
+	if err := d.metadata.Write("vol", vol); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.getVolumeInfo("vol"); err == nil || !strings.Contains(err.Error(), "invalid metadata") {
+		t.Errorf("getVolumeInfo = %v; want an invalid metadata error", err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"}); err == nil {
+		t.Error("A volume with corrupted metadata was mounted")
+	}
+}
+
+func TestOldMetadata(t *testing.T) {
+	var logs bytes.Buffer
+	d := NewTestDockerOnTop(t, WithLogger(newLogger(&logs, "json")))
+	base := t.TempDir()
+	MustCreateVolume(t, d, "vol", base)
+	path := d.volumeDir("vol") + "/metadata.json"
+	if err := os.WriteFile(path, []byte(`{"BaseDirPath": "`+base+`", "Volatile": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatalf("getVolumeInfo failed for version 0 metadata: %v", err)
+	}
+	if vol.SchemaVersion != volumeInfoSchemaVersion || vol.BaseDirPath != base || !vol.Volatile ||
+		!vol.CreatedAt.Equal(modTime) || vol.Tags != nil || vol.LowerLayers != nil || vol.OverlayOptions != nil {
+		t.Errorf("The version 0 metadata is read as %+v", vol)
+	}
+	MustMountVolume(t, d, "vol", "container")
+	MustUnmountVolume(t, d, "vol", "container")
+	// Updating the metadata writes the current version
+	if contents, err := os.ReadFile(path); err != nil || !strings.Contains(string(contents), `"SchemaVersion":1`) {
+		t.Errorf("The updated metadata is %s, %v", contents, err)
+	}
+
+	vol.SchemaVersion = volumeInfoSchemaVersion + 1
+	payload, err := json.Marshal(vol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, payload, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logs.Reset()
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.SchemaVersion != volumeInfoSchemaVersion+1 {
+		t.Errorf("getVolumeInfo = %+v, %v for metadata of a newer version", vol, err)
+	}
+	warned := false
+	for _, entry := range decodeLogEntries(t, &logs) {
+		if msg, _ := entry["msg"].(string); entry["level"] == "WARN" && strings.Contains(msg, "newer version") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Error("No warning about the metadata of a newer version was logged")
+	}
+}
+
+func TestConcurrentMounts(t *testing.T) {
+	const containers = 10
+	m := NewMockSyscallMount()
+	var calls atomic.Int32
+	started := make(chan struct{}, containers)
+	var release chan struct{}
+	var mountErr error
+	// The overlay mount blocks until released, so that the other mounts arrive while it's in progress
+	blockingMount := func(d *DockerOnTop) {
+		d.mountSyscall = func(source, target, fstype string, flags uintptr, data string) error {
+			calls.Add(1)
+			started <- struct{}{}
+			<-release
+			if mountErr != nil {
+				return mountErr
+			}
+			return m.Mount(source, target, fstype, flags, data)
+		}
+		d.unmountSyscall = m.Unmount
+	}
+	d := NewTestDockerOnTop(t, blockingMount)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+
+	mountAll := func(err error) []error {
+		release, mountErr = make(chan struct{}), err
+		errs := make([]error, containers)
+		var wg sync.WaitGroup
+		mount := func(i int) {
+			defer wg.Done()
+			_, errs[i] = d.Mount(&volume.MountRequest{Name: "vol", ID: fmt.Sprintf("container%d", i)})
+		}
+		wg.Add(containers)
+		go mount(0)
+		<-started
+		for i := 1; i < containers; i++ {
+			go mount(i)
+		}
+		// Let the other mounts join the one in progress
+		time.Sleep(200 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return errs
+	}
+
+	// A failing mount is only attempted once
+	for i, err := range mountAll(syscall.EIO) {
+		if err == nil {
+			t.Errorf("Mount %d succeeded", i)
+		}
+	}
+	if n := calls.Swap(0); n != 1 {
+		t.Errorf("The failing overlay mount was attempted %d times, want once", n)
+	}
+	if summaries, err := d.ListActiveMounts(); err != nil || len(summaries) != 0 {
+		t.Errorf("After the failed mounts, the active mounts are %+v, %v", summaries, err)
+	}
+
+	// All the containers are recorded
+	for i, err := range mountAll(nil) {
+		if err != nil {
+			t.Errorf("Mount %d failed: %v", i, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("The overlay was mounted %d times, want once", n)
+	}
+	if summaries, err := d.ListActiveMounts(); err != nil || len(summaries) != containers {
+		t.Errorf("The active mounts are %+v, %v; want %d", summaries, err, containers)
+	}
+	for i := 0; i < containers; i++ {
+		MustUnmountVolume(t, d, "vol", fmt.Sprintf("container%d", i))
+	}
+	if _, ok := m.Mounted(d.VolumeMountpointDir("vol")); ok {
+		t.Error("The overlay is still mounted after all the containers unmounted")
+	}
+}
+
+func TestLastMountOptions(t *testing.T) {
+	var logs bytes.Buffer
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m), WithLogger(newLogger(&logs, "json")))
+	base := t.TempDir()
+	MustCreateVolume(t, d, "vol", base)
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.LastMountOptions != "" {
+		t.Errorf("Before mounting, LastMountOptions = %q, %v; want none", vol.LastMountOptions, err)
+	}
+
+	MustMountVolume(t, d, "vol", "first")
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := m.Mounted(d.VolumeMountpointDir("vol"))
+	if vol.LastMountOptions != data {
+		t.Errorf("LastMountOptions = %q, want the options the overlay was mounted with, %q", vol.LastMountOptions, data)
+	}
+	for _, option := range []string{"lowerdir=" + base, "upperdir=" + d.upperdir("vol", &vol),
+		"workdir=" + d.workdir("vol", &vol)} {
+		if !containsOption(vol.LastMountOptions, option) {
+			t.Errorf("LastMountOptions = %q, want it to contain %s", vol.LastMountOptions, option)
+		}
+	}
+	response, err := d.Get(&volume.GetRequest{Name: "vol"})
+	if err != nil || response.Volume.Status["lastMountOptions"] != vol.LastMountOptions {
+		t.Errorf("Get reports the last mount options %v, %v; want %q", response.Volume.Status["lastMountOptions"],
+			err, vol.LastMountOptions)
+	}
+	// Mounting for another container doesn't mount the overlay again
+	MustMountVolume(t, d, "vol", "second")
+	if again, err := d.getVolumeInfo("vol"); err != nil || again.LastMountOptions != vol.LastMountOptions {
+		t.Errorf("After the second mount, LastMountOptions = %q, %v", again.LastMountOptions, err)
+	}
+
+	driftWarnings := func() int {
+		count := 0
+		for _, entry := range decodeLogEntries(t, &logs) {
+			if msg, _ := entry["msg"].(string); strings.Contains(msg, "mount options have changed") {
+				count++
+			}
+		}
+		logs.Reset()
+		return count
+	}
+	logs.Reset()
+	MustUnmountVolume(t, d, "vol", "second")
+	if count := driftWarnings(); count != 0 {
+		t.Errorf("Without changes, %d drift warnings were logged", count)
+	}
+
+	// The metadata is changed while the volume is mounted
+	vol.OverlayOptions = map[string]string{"index": "off"}
+	if err := d.metadata.Write("vol", vol); err != nil {
+		t.Fatal(err)
+	}
+	MustUnmountVolume(t, d, "vol", "first")
+	if count := driftWarnings(); count != 1 {
+		t.Errorf("After changing the options, %d drift warnings were logged, want 1", count)
+	}
+	if _, ok := m.Mounted(d.VolumeMountpointDir("vol")); ok {
+		t.Error("The overlay is still mounted despite the drift")
+	}
+
+	MustMountVolume(t, d, "vol", "first")
+	if vol, err := d.getVolumeInfo("vol"); err != nil || !containsOption(vol.LastMountOptions, "index=off") {
+		t.Errorf("After mounting again, LastMountOptions = %q, %v; want the new options", vol.LastMountOptions, err)
+	}
+	MustUnmountVolume(t, d, "vol", "first")
+}
+
+// brokenBootVolumes creates the volumes good1, good2, bad-metadata (with unreadable metadata), and bad-mountpoint
+// (whose mountpoint cannot be removed on boot) in a dot root directory, which is returned.
+func brokenBootVolumes(t *testing.T) string {
+	t.Helper()
+	d := NewTestDockerOnTop(t)
+	for _, name := range []string{"good1", "good2", "bad-metadata", "bad-mountpoint"} {
+		MustCreateVolume(t, d, name, t.TempDir())
+	}
+	if err := os.WriteFile(d.volumeDir("bad-metadata")+"/metadata.json", []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, d.mountpointdir("bad-mountpoint"), map[string]string{"file": "left"})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return d.DotRootDirPath()
+}
+
+func TestPermissiveBoot(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		dotRootDir := brokenBootVolumes(t)
+		_, err := NewDockerOnTop(context.Background(), dotRootDir, WithoutOverlayProbe(),
+			WithLogger(newTestLogger(t)), WithBootConcurrency(concurrency))
+		if err == nil || !strings.Contains(err.Error(), "bad-metadata") ||
+			!strings.Contains(err.Error(), "bad-mountpoint") {
+			t.Errorf("Concurrency %d: without permissive boot, NewDockerOnTop = %v; want the errors of both volumes",
+				concurrency, err)
+		}
+
+		var logs bytes.Buffer
+		m := NewMockSyscallMount()
+		d, err := NewDockerOnTop(context.Background(), dotRootDir, WithoutOverlayProbe(),
+			WithLogger(newLogger(&logs, "json")), WithMockSyscallMount(m), WithBootConcurrency(concurrency),
+			WithPermissiveBoot(true))
+		if err != nil {
+			t.Fatalf("Concurrency %d: with permissive boot, NewDockerOnTop failed: %v", concurrency, err)
+		}
+		want := []string{"bad-metadata", "bad-mountpoint"}
+		failed := d.BootErrors()
+		if !slices.Equal(failed, want) {
+			t.Errorf("Concurrency %d: BootErrors = %v, want %v", concurrency, failed, want)
+		}
+		// A copy is returned
+		failed[0] = "changed"
+		if failed := d.BootErrors(); !slices.Equal(failed, want) {
+			t.Errorf("Concurrency %d: after modifying the result, BootErrors = %v", concurrency, failed)
+		}
+
+		logged := map[string]bool{}
+		for _, entry := range decodeLogEntries(t, &logs) {
+			if entry["level"] == "ERROR" {
+				if volumeName, ok := entry["volume"].(string); ok {
+					logged[volumeName] = true
+				}
+			}
+		}
+		if !logged["bad-metadata"] || !logged["bad-mountpoint"] || logged["good1"] || logged["good2"] {
+			t.Errorf("Concurrency %d: the errors were logged for %v, want the bad volumes", concurrency, logged)
+		}
+
+		// The other volumes are usable
+		for _, name := range []string{"good1", "good2"} {
+			MustMountVolume(t, d, name, "container")
+			MustUnmountVolume(t, d, name, "container")
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if failed := NewTestDockerOnTop(t).BootErrors(); len(failed) != 0 {
+		t.Errorf("For a clean boot, BootErrors = %v, want none", failed)
+	}
+}
+
+func TestMountWarnsIfOverlayNotVisible(t *testing.T) {
+	// The mock doesn't mount anything, so the overlay is never visible in the mount table
+	var logs bytes.Buffer
+	d := NewTestDockerOnTop(t, WithLogger(newLogger(&logs, "json")))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustMountVolume(t, d, "vol", "container")
+	defer MustUnmountVolume(t, d, "vol", "container")
+	warned := false
+	for _, entry := range decodeLogEntries(t, &logs) {
+		if msg, _ := entry["msg"].(string); entry["level"] == "WARN" && strings.Contains(msg, "not visible") {
+			warned = entry["volume"] == "vol"
+		}
+	}
+	if !warned {
+		t.Error("No warning about the overlay not visible in the mount table was logged")
+	}
+}
+
+func TestMountVisibleOverlay(t *testing.T) {
+	var logs bytes.Buffer
+	d := NewTestDockerOnTopWithOverlay(t, WithLogger(newLogger(&logs, "json")))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustMountVolume(t, d, "vol", "container")
+	defer MustUnmountVolume(t, d, "vol", "container")
+	for _, entry := range decodeLogEntries(t, &logs) {
+		if msg, _ := entry["msg"].(string); strings.Contains(msg, "not visible") {
+			t.Errorf("A warning about the mounted overlay not visible in the mount table was logged: %v", entry)
+		}
+	}
+}
+
+func TestValidateBaseDir(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"plain", "with,comma", "with:colon", "denied/sub"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"link":         "plain",
+		"double-link":  "link",
+		"comma-link":   "with,comma",
+		"colon-link":   "with:colon",
+		"dangling":     "missing",
+		"loop1":        "loop2",
+		"loop2":        "loop1",
+		"denied-link":  "denied/sub",
+		"escaping-dir": "denied/..",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		want   string // Empty if the path is rejected
+		warned bool   // Whether the symlinks are reported
+	}{
+		{name: "no symlinks", path: root + "/plain", want: root + "/plain"},
+		{name: "unclean", path: root + "/denied/../plain/", want: root + "/plain"},
+		{name: "symlink", path: root + "/link", want: root + "/plain", warned: true},
+		{name: "double symlink", path: root + "/double-link", want: root + "/plain", warned: true},
+		{name: "symlink in the middle", path: root + "/escaping-dir/plain", want: root + "/plain", warned: true},
+		{name: "resolved to a comma", path: root + "/comma-link"},
+		{name: "resolved to a colon", path: root + "/colon-link"},
+		{name: "dangling symlink", path: root + "/dangling"},
+		{name: "symlink loop", path: root + "/loop1"},
+		{name: "resolved into a denied prefix", path: root + "/denied-link"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			d := NewTestDockerOnTop(t, WithLogger(newLogger(&logs, "json")))
+			if err := d.SetBaseDirDeniedPrefixes([]string{root + "/denied"}); err != nil {
+				t.Fatal(err)
+			}
+			resolved, err := d.validateBaseDir(test.path)
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("%s was accepted as %s", test.path, resolved)
+				}
+				if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+					"base": test.path,
+				}}); err == nil {
+					t.Errorf("A volume was created with the base directory %s", test.path)
+				}
+				return
+			} else if err != nil || resolved != test.want {
+				t.Errorf("validateBaseDir = %q, %v; want %q", resolved, err, test.want)
+			}
+			warned := false
+			for _, entry := range decodeLogEntries(t, &logs) {
+				if msg, _ := entry["msg"].(string); strings.Contains(msg, "contains symlinks") {
+					warned = entry["resolved"] == test.want
+				}
+			}
+			if warned != test.warned {
+				t.Errorf("The symlinks were reported: %v, want %v", warned, test.warned)
+			}
+		})
+	}
+}
+
+func TestStuckOverlayWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustMountVolume(t, d, "vol", "container")
+
+	// Nothing is left mounted after a clean unmount
+	MustUnmountVolume(t, d, "vol", "container")
+	d.checkOverlayGone("vol")
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.Stuck {
+		t.Fatalf("After a clean unmount, the volume is %+v, %v; want it not stuck", vol, err)
+	}
+
+	// Like an overlay which survived its unmount: the active mounts are gone, but the overlay is still mounted
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	if err := os.Remove(d.activemountsdir("vol") + "container"); err != nil {
+		t.Fatal(err)
+	}
+	d.checkOverlayGone("vol")
+	if vol, err := d.getVolumeInfo("vol"); err != nil || !vol.Stuck {
+		t.Fatalf("With the overlay left mounted, the volume is %+v, %v; want it stuck", vol, err)
+	}
+
+	// The next mount unmounts the stuck overlay before mounting it afresh
+	MustMountVolume(t, d, "vol", "another")
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.Stuck {
+		t.Errorf("After the next mount, the volume is %+v, %v; want it not stuck", vol, err)
+	}
+	if mountpoints, err := overlayMountpoints("vol"); err != nil || !slices.Equal(mountpoints, []string{
+		filepath.Clean(mountpoint),
+	}) {
+		t.Errorf("After the next mount, the overlay is mounted at %q, %v; want %s only", mountpoints, err, mountpoint)
+	}
+	MustUnmountVolume(t, d, "vol", "another")
+	if mountpoints, err := overlayMountpoints("vol"); err != nil || len(mountpoints) != 0 {
+		t.Errorf("After the unmount, the overlay is mounted at %q, %v; want nowhere", mountpoints, err)
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+// logDryRun logs the `action` that would have been performed on the volume if `DockerOnTop.DryRun` weren't set.
+func (d *DockerOnTop) logDryRun(action string, volumeName string, args ...any) {
+	d.logger.Info("Dry run: would "+action, append([]any{"volume", volumeName}, args...)...)
+}
@@ -0,0 +1,154 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestDryRun(t *testing.T) {
+	var logs bytes.Buffer
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m), WithLogger(newLogger(&logs, "json")))
+	MustCreateVolume(t, d, "existing", t.TempDir())
+	MustCreateVolume(t, d, "mounted", t.TempDir())
+	MustMountVolume(t, d, "mounted", "container")
+	before := describeTree(t, d.DotRootDirPath())
+
+	d.DryRun = true
+	logs.Reset()
+	base := t.TempDir()
+	if err := d.Create(&volume.CreateRequest{Name: "new", Options: map[string]string{"base": base}}); err != nil {
+		t.Errorf("Create failed: %v", err)
+	}
+	response, err := d.Mount(&volume.MountRequest{Name: "existing", ID: "container"})
+	if err != nil {
+		t.Errorf("Mount failed: %v", err)
+	} else if response.Mountpoint != d.VolumeMountpointDir("existing") {
+		t.Errorf("Mount returned the mountpoint %q, want %q", response.Mountpoint, d.VolumeMountpointDir("existing"))
+	}
+	if err := d.Unmount(&volume.UnmountRequest{Name: "mounted", ID: "container"}); err != nil {
+		t.Errorf("Unmount failed: %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "existing"}); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+
+	if after := describeTree(t, d.DotRootDirPath()); !reflect.DeepEqual(after, before) {
+		t.Errorf("The dry run changed the dot root directory from %v to %v", before, after)
+	}
+	if _, ok := m.Mounted(d.VolumeMountpointDir("existing")); ok {
+		t.Error("The dry run mounted the overlay")
+	}
+	if _, ok := m.Mounted(d.VolumeMountpointDir("mounted")); !ok {
+		t.Error("The dry run unmounted the overlay")
+	}
+	var actions []string
+	for _, entry := range decodeLogEntries(t, &logs) {
+		if msg, _ := entry["msg"].(string); strings.HasPrefix(msg, "Dry run: would ") {
+			actions = append(actions, entry["volume"].(string)+": "+strings.TrimPrefix(msg, "Dry run: would "))
+		}
+	}
+	want := []string{
+		"new: create the volume",
+		"existing: mount the overlay (unless already mounted) and record the container",
+		"mounted: remove the record of the container and unmount the overlay (unless still in use)",
+		"existing: remove the volume",
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("The dry run logged %q, want %q", actions, want)
+	}
+
+	// The requests are still validated
+	for name, options := range map[string]map[string]string{
+		"new":      {"base": base + "/missing"},
+		"in/valid": {"base": base},
+		"other":    {"base": base, "volatile": "maybe"},
+	} {
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: options}); err == nil {
+			t.Errorf("Create succeeded for %s with the options %v", name, options)
+		}
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "new", ID: "container"}); err == nil {
+		t.Error("Mount succeeded for a volume the dry run didn't create")
+	}
+}
+
+func TestDryRunBoot(t *testing.T) {
+	root := t.TempDir()
+	m := NewMockSyscallMount()
+	d, err := NewDockerOnTop(context.Background(), root, WithoutOverlayProbe(), WithLogger(newTestLogger(t)),
+		WithMockSyscallMount(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	MustCreateVolume(t, d, "clean", t.TempDir())
+	MustCreateVolume(t, d, "dirty", t.TempDir())
+	// The plugin stops while the volume is in use, and the overlay is gone by the time it starts again
+	MustMountVolume(t, d, "dirty", "container")
+	writeFiles(t, d.VolumeWorkDir("dirty"), map[string]string{"work/leftover": ""})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{scratchDirPrefix + "leftover/file": ""})
+	before := describeTree(t, root)
+
+	var logs bytes.Buffer
+	d, err = NewDockerOnTop(context.Background(), root, WithoutOverlayProbe(), WithLogger(newLogger(&logs, "json")),
+		WithMockSyscallMount(m), WithDryRun(true))
+	if err != nil {
+		t.Fatalf("Failed to create the driver: %v", err)
+	}
+	if !d.DryRun {
+		t.Error("WithDryRun didn't set DryRun")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if after := describeTree(t, root); !reflect.DeepEqual(after, before) {
+		t.Errorf("The dry run boot changed the dot root directory from %v to %v", before, after)
+	}
+	var actions []string
+	for _, entry := range decodeLogEntries(t, &logs) {
+		msg, _ := entry["msg"].(string)
+		if action, ok := strings.CutPrefix(msg, "Dry run: would "); ok {
+			if volumeName, ok := entry["volume"].(string); ok {
+				action = volumeName + ": " + action
+			}
+			actions = append(actions, action)
+		}
+	}
+	slices.Sort(actions)
+	want := []string{
+		"clean: reset the state of the volume on boot (unless it is still mounted)",
+		"dirty: reset the state of the volume on boot (unless it is still mounted)",
+		"remove stale scratch directory",
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("The dry run boot logged %q, want %q", actions, want)
+	}
+
+	// Without the dry run, the same boot resets the volume and removes the scratch directory
+	d, err = NewDockerOnTop(context.Background(), root, WithoutOverlayProbe(), WithLogger(newTestLogger(t)),
+		WithMockSyscallMount(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.activemountsdir("dirty") + "container"); !os.IsNotExist(err) {
+		t.Errorf("After the boot, the active mount exists (%v)", err)
+	}
+	if _, err := os.Stat(root + "/" + scratchDirPrefix + "leftover"); !os.IsNotExist(err) {
+		t.Errorf("After the boot, the scratch directory exists (%v)", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+/*
+The etcd metadata backend.
+
+The metadata of a volume is stored as JSON (the same as in the metadata.json files) under the key
+`/docker-on-top/volumes/<volume name>`. The backend uses the official client (`go.etcd.io/etcd/client/v3`), which
+balances the requests between the endpoints, skipping the unreachable ones, and supports TLS and authentication.
+*/
+
+// etcdMetadataPrefix is the prefix of the etcd keys the volumes' metadata is stored under
+const etcdMetadataPrefix = "/docker-on-top/volumes/"
+
+// etcdRequestTimeout limits the time a request to etcd may take, including waiting for an endpoint to be reachable
+const etcdRequestTimeout = 5 * time.Second
+
+// etcdMetadataBackend is the `MetadataBackend` storing the metadata in etcd (see the comment in the beginning of the
+// file)
+type etcdMetadataBackend struct {
+	client         *clientv3.Client
+	requestTimeout time.Duration
+}
+
+var _ MetadataBackend = (*etcdMetadataBackend)(nil)
+
+// NewEtcdMetadataBackend returns a `MetadataBackend` storing the volumes' metadata in the etcd cluster with the given
+// client endpoints, like `http://10.0.0.1:2379`. The endpoints without a scheme, like `10.0.0.1:2379`, use TLS only
+// if the client is configured with it (see `NewEtcdMetadataBackendWithConfig`). The connections are made in the
+// background, so an unreachable cluster only makes the backend's requests fail.
+func NewEtcdMetadataBackend(endpoints []string) (MetadataBackend, error) {
+	return NewEtcdMetadataBackendWithConfig(clientv3.Config{Endpoints: endpoints})
+}
+
+// NewEtcdMetadataBackendWithConfig is like `NewEtcdMetadataBackend`, but the etcd client is created with `cfg`, e.g.
+// to connect over TLS (`cfg.TLS`) or to authenticate as an etcd user (`cfg.Username` and `cfg.Password`, which
+// requires the cluster to be reachable right away). `cfg.Endpoints` are checked the same way.
+func NewEtcdMetadataBackendWithConfig(cfg clientv3.Config) (MetadataBackend, error) {
+	endpoints, err := cleanEtcdEndpoints(cfg.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Endpoints = endpoints
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = etcdRequestTimeout
+	}
+	if cfg.Logger == nil && cfg.LogConfig == nil {
+		// The failures are reported by the backend's methods
+		cfg.Logger = zap.NewNop()
+	}
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the etcd client: %w", err)
+	}
+	return &etcdMetadataBackend{client: client, requestTimeout: etcdRequestTimeout}, nil
+}
+
+// cleanEtcdEndpoints checks that the endpoints are `http(s)://host:port` URLs or `host:port` addresses and returns
+// them without trailing slashes.
+func cleanEtcdEndpoints(endpoints []string) ([]string, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no etcd endpoints given")
+	}
+	cleaned := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		parsed, err := url.Parse(endpoint)
+		if !strings.Contains(endpoint, "://") {
+			parsed, err = url.Parse("http://" + endpoint)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid etcd endpoint %q: %w", endpoint, err)
+		} else if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid etcd endpoint %q: expected http(s)://host:port", endpoint)
+		}
+		cleaned = append(cleaned, strings.TrimSuffix(endpoint, "/"))
+	}
+	return cleaned, nil
+}
+
+// etcdClientConfig returns the configuration of the etcd client for the plugin's configuration `cfg` (see
+// `Config.MetadataEtcdEndpoints` and the following fields). The TLS files are loaded, but no connection is made.
+func etcdClientConfig(cfg Config) (clientv3.Config, error) {
+	endpoints, err := cleanEtcdEndpoints(cfg.MetadataEtcdEndpoints)
+	if err != nil {
+		return clientv3.Config{}, err
+	}
+	clientCfg := clientv3.Config{
+		Endpoints: endpoints,
+		Username:  cfg.MetadataEtcdUsername,
+		Password:  cfg.MetadataEtcdPassword,
+	}
+	if (cfg.MetadataEtcdCertFile == "") != (cfg.MetadataEtcdKeyFile == "") {
+		return clientv3.Config{}, errors.New("the etcd client certificate and key must be given together")
+	}
+	if cfg.MetadataEtcdPassword != "" && cfg.MetadataEtcdUsername == "" {
+		return clientv3.Config{}, errors.New("the etcd password is given without a username")
+	}
+	if cfg.MetadataEtcdCAFile != "" || cfg.MetadataEtcdCertFile != "" {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      cfg.MetadataEtcdCertFile,
+			KeyFile:       cfg.MetadataEtcdKeyFile,
+			TrustedCAFile: cfg.MetadataEtcdCAFile,
+		}
+		if clientCfg.TLS, err = tlsInfo.ClientConfig(); err != nil {
+			return clientv3.Config{}, fmt.Errorf("invalid etcd TLS configuration: %w", err)
+		}
+	}
+	return clientCfg, nil
+}
+
+// key returns the etcd key of the volume's metadata.
+func (b *etcdMetadataBackend) key(volumeName string) string {
+	return etcdMetadataPrefix + volumeName
+}
+
+// requestContext returns the context the backend's requests are made with.
+func (b *etcdMetadataBackend) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), b.requestTimeout)
+}
+
+// Write implements `MetadataBackend.Write`.
+func (b *etcdMetadataBackend) Write(volumeName string, info VolumeInfo) error {
+	payload, err := encodeVolumeInfo(info)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := b.requestContext()
+	defer cancel()
+	if _, err := b.client.Put(ctx, b.key(volumeName), string(payload)); err != nil {
+		return fmt.Errorf("failed to write the metadata to etcd: %w", err)
+	}
+	return nil
+}
+
+// Read implements `MetadataBackend.Read`.
+func (b *etcdMetadataBackend) Read(volumeName string) (VolumeInfo, error) {
+	ctx, cancel := b.requestContext()
+	defer cancel()
+	response, err := b.client.Get(ctx, b.key(volumeName))
+	if err != nil {
+		return VolumeInfo{}, fmt.Errorf("failed to read the metadata from etcd: %w", err)
+	}
+	if len(response.Kvs) == 0 {
+		return VolumeInfo{}, &fs.PathError{Op: "read", Path: b.key(volumeName), Err: fs.ErrNotExist}
+	}
+	return migrateVolumeInfo(response.Kvs[0].Value, volumeInfoSchemaVersion)
+}
+
+// Delete implements `MetadataBackend.Delete`.
+func (b *etcdMetadataBackend) Delete(volumeName string) error {
+	ctx, cancel := b.requestContext()
+	defer cancel()
+	if _, err := b.client.Delete(ctx, b.key(volumeName)); err != nil {
+		return fmt.Errorf("failed to delete the metadata from etcd: %w", err)
+	}
+	return nil
+}
+
+// List implements `MetadataBackend.List`.
+func (b *etcdMetadataBackend) List() ([]string, error) {
+	ctx, cancel := b.requestContext()
+	defer cancel()
+	response, err := b.client.Get(ctx, etcdMetadataPrefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the metadata in etcd: %w", err)
+	}
+	names := make([]string, 0, len(response.Kvs))
+	for _, kv := range response.Kvs {
+		names = append(names, strings.TrimPrefix(string(kv.Key), etcdMetadataPrefix))
+	}
+	return names, nil
+}
+
+// Close closes the etcd client. The backend cannot be used afterwards.
+func (b *etcdMetadataBackend) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// paxXattrPrefix is the prefix of the PAX records that store extended attributes (the convention used by GNU tar
+	// and others)
+	paxXattrPrefix = "SCHILY.xattr."
+	// paxWhiteoutRecord marks the archive entries that are overlay whiteouts
+	paxWhiteoutRecord = "DOCKERONTOP.whiteout"
+)
+
+// listXattrs returns all extended attributes of the file (symlinks are followed).
+func listXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		valueSize, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = syscall.Getxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = string(value[:valueSize])
+	}
+	return xattrs, nil
+}
+
+// ExportVolumeDiff writes the contents of the volume's upperdir (that is, all the changes made to the volume) to `w`
+// as a tar archive. File modes, ownership, timestamps, and extended attributes are preserved; whiteouts are marked
+// with a PAX record, so that they can be faithfully restored by `ImportVolumeDiff`.
+//
+// If the volume is mounted, the export is still performed (with a warning), but the result may be inconsistent if the
+// volume's contents change in the meantime.
+func (d *DockerOnTop) ExportVolumeDiff(volumeName string, w io.Writer) error {
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if os.IsNotExist(err) {
+		return errors.New("no such volume")
+	} else if err != nil {
+		return err
+	}
+
+	if mounted, err := d.volumeIsMounted(volumeName); err != nil {
+		return err
+	} else if mounted {
+		d.logger.Warn("Exporting the volume while it is mounted. The exported state may be inconsistent", "volume",
+			volumeName)
+	}
+
+	if err := d.writeUpperdirTar(volumeName, d.upperdir(volumeName, &thisVol), w); err != nil {
+		d.logger.Error("Failed to export the volume", "volume", volumeName, "error", err)
+		return err
+	}
+	return nil
+}
+
+// writeUpperdirTar writes the contents of the volume's upperdir `upperdir` to `w` as a tar archive, in the format
+// described in `ExportVolumeDiff`. The upperdir is walked with `upperdirWalk`.
+func (d *DockerOnTop) writeUpperdirTar(volumeName string, upperdir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := d.upperdirWalk(volumeName, upperdir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(upperdir, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Format = tar.FormatPAX
+		header.PAXRecords = map[string]string{}
+
+		if info.Mode()&fs.ModeSymlink == 0 {
+			xattrs, err := listXattrs(path)
+			if err != nil && !errors.Is(err, syscall.ENOTSUP) {
+				return err
+			}
+			for name, value := range xattrs {
+				header.PAXRecords[paxXattrPrefix+name] = value
+			}
+		}
+		if whiteout, _ := isWhiteout(info.Name(), info); whiteout {
+			header.PAXRecords[paxWhiteoutRecord] = "1"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ImportVolumeDiff replaces the contents of the volume's upperdir with the contents of the tar archive read from `r`
+// (normally, produced by `ExportVolumeDiff`). File modes, ownership, timestamps, extended attributes, and whiteouts
+// are restored.
+//
+// The archive is first extracted next to the upperdir, and only after the extraction succeeds the upperdir is replaced
+// with it (the old upperdir is renamed, then removed), so a failed import leaves the volume unchanged. Archive entries
+// that try to escape the upperdir (via `..` or symlinks) are rejected, and so are the devices other than whiteouts.
+// Unless the volume is volatile, every file is fsync'ed.
+//
+// The volume must not be mounted. The volume is locked during the import, so that it cannot be mounted in the
+// meantime.
+func (d *DockerOnTop) ImportVolumeDiff(volumeName string, r io.Reader) error {
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if os.IsNotExist(err) {
+		return errors.New("no such volume")
+	} else if err != nil {
+		return err
+	}
+
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
+		return err
+	}
+	defer unlock()
+	if _, err := d.readActivemounts(volumeName, 1); !errors.Is(err, io.EOF) {
+		if err == nil {
+			return errors.New("the volume is mounted: cannot import while it is in use")
+		}
+		return d.internalError("failed to list activemounts/", err)
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return d.internalError("failed to generate a name for the import directory", err)
+	}
+	upperdir := filepath.Clean(d.upperdir(volumeName, &thisVol))
+	importDir := upperdir + ".import-" + id
+	oldDir := upperdir + ".old-" + id
+
+	if err := os.Mkdir(importDir, os.ModePerm); err != nil {
+		return d.internalError("failed to create the import directory", err)
+	}
+	if err := d.extractTar(r, importDir, !thisVol.Volatile); err != nil {
+		d.logger.Error("Failed to import into the volume", "volume", volumeName, "error", err)
+		if cleanupErr := os.RemoveAll(importDir); cleanupErr != nil {
+			d.logger.Error("Failed to remove the import directory", "error", cleanupErr)
+		}
+		return err
+	}
+
+	if err := os.Rename(upperdir, oldDir); err != nil {
+		_ = os.RemoveAll(importDir)
+		return d.internalError("failed to move the old upperdir away", err, "volume", volumeName)
+	}
+	if err := os.Rename(importDir, upperdir); err != nil {
+		d.logger.Error("Failed to move the imported upperdir into place. Restoring the old one", "volume", volumeName,
+			"error", err)
+		if restoreErr := os.Rename(oldDir, upperdir); restoreErr != nil {
+			d.logCritical("Failed to restore the old upperdir", "volume", volumeName, "error", restoreErr,
+				"leftAt", oldDir)
+		}
+		_ = os.RemoveAll(importDir)
+		return d.internalError("failed to move the imported upperdir into place", err)
+	}
+	if err := os.RemoveAll(oldDir); err != nil {
+		d.logger.Warn("Failed to remove the old upperdir", "volume", volumeName, "path", oldDir, "error", err)
+	}
+	return nil
+}
+
+// extractTar extracts the tar archive into the (existing) directory `dest`, restoring the files' metadata. Entries
+// that would end up outside of `dest` are rejected. If `fsync` is true, every regular file is synced to disk.
+func (d *DockerOnTop) extractTar(r io.Reader, dest string, fsync bool) error {
+	tr := tar.NewReader(r)
+	symlinks := map[string]bool{}
+	type dirTimes struct {
+		path  string
+		mtime time.Time
+		atime time.Time
+	}
+	var dirs []dirTimes
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name, err := sanitizeArchivePath(header.Name, symlinks)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		if symlinks[name] {
+			return fmt.Errorf("archive entry %q replaces a symlink extracted earlier", header.Name)
+		}
+		path := filepath.Join(dest, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(path, 0o700); os.IsExist(err) {
+				// Only a directory extracted earlier can be extracted into again, not e.g. a symlink to a directory
+				if info, err := os.Lstat(path); err != nil {
+					return err
+				} else if !info.IsDir() {
+					return fmt.Errorf("archive entry %q is a directory, but an earlier entry is not", header.Name)
+				}
+			} else if err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTimes{path: path, mtime: header.ModTime, atime: header.AccessTime})
+		case tar.TypeReg:
+			if err := extractRegularFile(tr, path, fsync); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+			symlinks[name] = true
+		case tar.TypeLink:
+			target, err := sanitizeArchivePath(header.Linkname, symlinks)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(filepath.Join(dest, target), path); err != nil {
+				return err
+			}
+			if symlinks[target] {
+				// A hard link to a symlink is a symlink too
+				symlinks[name] = true
+			}
+			continue // The metadata is shared with the target
+		case tar.TypeChar, tar.TypeFifo:
+			mode := uint32(syscall.S_IFIFO)
+			if header.Typeflag == tar.TypeChar {
+				// The only devices an upperdir is expected to have are the overlay whiteouts
+				if header.Devmajor != 0 || header.Devminor != 0 {
+					return fmt.Errorf("archive entry %q is a character device %d:%d (only whiteouts are allowed)",
+						header.Name, header.Devmajor, header.Devminor)
+				}
+				mode = syscall.S_IFCHR
+			}
+			if err := syscall.Mknod(path, mode|0o600, 0); err != nil {
+				return err
+			}
+		case tar.TypeBlock:
+			return fmt.Errorf("archive entry %q is a block device (only whiteouts are allowed)", header.Name)
+		default:
+			d.logger.Warn("Skipping archive entry of unsupported type", "name", header.Name,
+				"type", string(header.Typeflag))
+			continue
+		}
+
+		// None of the following follows symlinks: whatever the archive contains, nothing outside `dest` is modified
+		if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+			return err
+		}
+		// Symlinks have no meaningful permissions, and their xattrs are not exported
+		if header.Typeflag != tar.TypeSymlink {
+			if err := chmodNoFollow(path, uint32(header.Mode&0o7777)); err != nil {
+				return err
+			}
+			for record, value := range header.PAXRecords {
+				if xattr, ok := strings.CutPrefix(record, paxXattrPrefix); ok {
+					if err := unix.Lsetxattr(path, xattr, []byte(value), 0); err != nil {
+						return fmt.Errorf("failed to restore xattr %s of %s: %w", xattr, name, err)
+					}
+				}
+			}
+		}
+		if header.Typeflag != tar.TypeDir {
+			if err := chtimesNoFollow(path, header.AccessTime, header.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Directory timestamps are restored last, as creating their contents modifies them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := chtimesNoFollow(dirs[i].path, dirs[i].atime, dirs[i].mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractRegularFile writes the contents of the current archive entry to a new file at `path`.
+func extractRegularFile(r io.Reader, path string, fsync bool) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil && fsync {
+		err = f.Sync()
+	}
+	return errors.Join(err, f.Close())
+}
+
+// chmodNoFollow changes the mode of `path` (in the format of `chmod(2)`) like `os.Chmod`, but fails instead of
+// following `path` if it is a symlink. Linux's `fchmodat` has no `AT_SYMLINK_NOFOLLOW`, so the file is opened with
+// `O_PATH` and `O_NOFOLLOW` and changed through its `/proc/self/fd/` link, which is what glibc does.
+func chmodNoFollow(path string, mode uint32) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer unix.Close(fd)
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return &os.PathError{Op: "fstat", Path: path, Err: err}
+	} else if stat.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return &os.PathError{Op: "chmod", Path: path, Err: unix.ELOOP}
+	}
+	if err := unix.Chmod(fmt.Sprintf("/proc/self/fd/%d", fd), mode); err != nil {
+		return &os.PathError{Op: "chmod", Path: path, Err: err}
+	}
+	return nil
+}
+
+// chtimesNoFollow is `os.Chtimes`, but it changes the timestamps of `path` itself if it is a symlink.
+func chtimesNoFollow(path string, atime time.Time, mtime time.Time) error {
+	times := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "chtimes", Path: path, Err: err}
+	}
+	return nil
+}
+
+// sanitizeArchivePath cleans the path of an archive entry and makes sure it stays inside the extraction directory:
+// it must be relative, must not go up with `..`, and must not go through any of the symlinks extracted earlier.
+func sanitizeArchivePath(name string, symlinks map[string]bool) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q points outside of the upper directory", name)
+	}
+	for parent := filepath.Dir(cleaned); parent != "."; parent = filepath.Dir(parent) {
+		if symlinks[parent] {
+			return "", fmt.Errorf("archive entry %q points outside of the upper directory (through a symlink)",
+				name)
+		}
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,358 @@
+//go:build dottest
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tarEntry is an entry of the archives built by `buildTar`
+type tarEntry struct {
+	header  tar.Header
+	content string
+}
+
+// buildTar returns a tar archive with the given entries.
+func buildTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := entry.header
+		header.Size = int64(len(entry.content))
+		if header.Mode == 0 {
+			header.Mode = 0o755
+		}
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatalf("Failed to write the header of %s: %v", header.Name, err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("Failed to write %s: %v", header.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	MustCreateVolume(t, d, "src", base)
+	MustCreateVolume(t, d, "dst", base)
+
+	upper := d.VolumeUpperDir("src")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.MkdirAll(upper+"dir/sub", 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(upper+"dir/file", []byte("contents"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(upper+"dir/file", 0o640|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(upper+"dir/file", "user.test", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../outside", upper+"dir/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mknod(upper+"dir/whiteout", syscall.S_IFCHR, 0); errors.Is(err, syscall.EPERM) {
+		t.Skip("Creating whiteouts is not permitted")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"dir/file", "dir/sub", "dir"} {
+		if err := os.Chtimes(upper+path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err := d.ExportVolumeDiff("src", &archive); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if err := d.ImportVolumeDiff("dst", &archive); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+
+	imported := d.VolumeUpperDir("dst")
+	if contents, err := os.ReadFile(imported + "dir/file"); err != nil || string(contents) != "contents" {
+		t.Errorf("dir/file = %q, %v; want %q", contents, err, "contents")
+	}
+	info, err := os.Lstat(imported + "dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 0o640 | os.ModeSetgid; info.Mode() != want {
+		t.Errorf("The mode of dir/file is %v, want %v", info.Mode(), want)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("The mtime of dir/file is %v, want %v", info.ModTime(), mtime)
+	}
+	value := make([]byte, 16)
+	if n, err := unix.Getxattr(imported+"dir/file", "user.test", value); err != nil || string(value[:n]) != "value" {
+		t.Errorf("The xattr of dir/file is %q, %v; want %q", value[:n], err, "value")
+	}
+	if info, err := os.Stat(imported + "dir/sub"); err != nil || info.Mode() != 0o750|os.ModeDir {
+		t.Errorf("dir/sub: %v, %v; want a directory with mode 0750", info, err)
+	}
+	if info, err := os.Stat(imported + "dir"); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("dir: %v, %v; want the mtime %v", info, err, mtime)
+	}
+	if target, err := os.Readlink(imported + "dir/link"); err != nil || target != "../outside" {
+		t.Errorf("dir/link points to %q, %v; want %q", target, err, "../outside")
+	}
+	if info, err := os.Lstat(imported + "dir/whiteout"); err != nil {
+		t.Error(err)
+	} else if whiteout, _ := isWhiteout("whiteout", info); !whiteout {
+		t.Errorf("dir/whiteout is not a whiteout: %v", info.Mode())
+	}
+}
+
+func TestImportRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{
+			name:    "parent directory",
+			entries: []tarEntry{{header: tar.Header{Name: "../escaped", Typeflag: tar.TypeReg}}},
+		},
+		{
+			name:    "absolute path",
+			entries: []tarEntry{{header: tar.Header{Name: "/escaped", Typeflag: tar.TypeReg}}},
+		},
+		{
+			name: "file through a symlink",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"}},
+				{header: tar.Header{Name: "d/escaped", Typeflag: tar.TypeReg}},
+			},
+		},
+		{
+			name: "directory replacing a symlink",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"}},
+				{header: tar.Header{Name: "d/", Typeflag: tar.TypeDir, Mode: 0o777}},
+			},
+		},
+		{
+			name: "file replacing a symlink",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE/escaped"}},
+				{header: tar.Header{Name: "d", Typeflag: tar.TypeReg}},
+			},
+		},
+		{
+			name: "file through a hard link to a symlink",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"}},
+				{header: tar.Header{Name: "h", Typeflag: tar.TypeLink, Linkname: "d"}},
+				{header: tar.Header{Name: "h/escaped", Typeflag: tar.TypeReg}},
+			},
+		},
+		{
+			name:    "hard link to the outside",
+			entries: []tarEntry{{header: tar.Header{Name: "h", Typeflag: tar.TypeLink, Linkname: "../outside"}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t)
+			MustCreateVolume(t, d, "vol", t.TempDir())
+			outside := t.TempDir()
+			if err := os.Chmod(outside, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			for i := range test.entries {
+				test.entries[i].header.Linkname = strings.ReplaceAll(test.entries[i].header.Linkname, "OUTSIDE",
+					outside)
+			}
+
+			if err := d.ImportVolumeDiff("vol", buildTar(t, test.entries...)); err == nil {
+				t.Error("The import succeeded")
+			}
+			if info, err := os.Stat(outside); err != nil || info.Mode().Perm() != 0o700 {
+				t.Errorf("The directory outside was modified: %v, %v", info, err)
+			}
+			if entries, err := os.ReadDir(outside); err != nil || len(entries) != 0 {
+				t.Errorf("Files were created outside: %v, %v", entries, err)
+			}
+			if entries, err := os.ReadDir(d.VolumeUpperDir("vol")); err != nil || len(entries) != 0 {
+				t.Errorf("The failed import modified the upperdir: %v, %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestImportDevices(t *testing.T) {
+	tests := []struct {
+		name   string
+		header tar.Header
+		ok     bool
+	}{
+		{name: "whiteout", header: tar.Header{Name: "wh", Typeflag: tar.TypeChar}, ok: true},
+		{name: "fifo", header: tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0o644}, ok: true},
+		{name: "char device", header: tar.Header{Name: "null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3}},
+		{name: "char device with minor", header: tar.Header{Name: "dev", Typeflag: tar.TypeChar, Devminor: 1}},
+		{name: "block device", header: tar.Header{Name: "disk", Typeflag: tar.TypeBlock, Devmajor: 8}},
+		{name: "block device 0:0", header: tar.Header{Name: "disk", Typeflag: tar.TypeBlock}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t)
+			MustCreateVolume(t, d, "vol", t.TempDir())
+			err := d.ImportVolumeDiff("vol", buildTar(t, tarEntry{header: test.header}))
+			if errors.Is(err, syscall.EPERM) {
+				t.Skip("Creating device files is not permitted")
+			}
+			path := filepath.Join(d.VolumeUpperDir("vol"), test.header.Name)
+			if test.ok {
+				if err != nil {
+					t.Fatalf("Failed to import: %v", err)
+				}
+				if _, err := os.Lstat(path); err != nil {
+					t.Error(err)
+				}
+			} else {
+				if err == nil {
+					t.Error("The import succeeded")
+				}
+				if _, err := os.Lstat(path); !os.IsNotExist(err) {
+					t.Errorf("The device was created (%v)", err)
+				}
+			}
+		})
+	}
+}
+
+// describeTree returns a description of every entry in `dir` (its type, mode, ownership, mtime, extended attributes,
+// and contents or target), keyed by the relative path.
+func describeTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		description := fmt.Sprintf("%v %d:%d rdev=%d mtime=%d", info.Mode(), stat.Uid, stat.Gid, stat.Rdev,
+			info.ModTime().UnixNano())
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			description += " -> " + target
+		case info.Mode().IsRegular():
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			description += fmt.Sprintf(" %q", contents)
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			xattrs, err := listXattrs(path)
+			if err != nil {
+				return err
+			}
+			description += fmt.Sprintf(" %v", xattrs)
+		}
+		tree[relPath] = description
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestExportImportIdenticalUpperdir(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	MustCreateVolume(t, d, "src", base)
+	MustCreateVolume(t, d, "dst", base)
+
+	upper := d.VolumeUpperDir("src")
+	writeFiles(t, upper, map[string]string{
+		"file":                   "contents",
+		"empty":                  "",
+		"dir/nested/file":        "nested",
+		"opaque/file":            "opaque",
+		"marked/" + opaqueMarker: "",
+	})
+	if err := os.Chmod(upper+"dir/nested/file", 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(upper+"empty", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(upper+"opaque", "user.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/nested/file", upper+"link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mknod(upper+"dir/whiteout", syscall.S_IFCHR, 0); errors.Is(err, syscall.EPERM) {
+		t.Skip("Creating whiteouts is not permitted")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	err := filepath.WalkDir(upper, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == upper {
+			return err
+		}
+		return unix.Lutimes(path, []unix.Timeval{unix.NsecToTimeval(mtime.UnixNano()),
+			unix.NsecToTimeval(mtime.UnixNano())})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := describeTree(t, upper)
+
+	var archive bytes.Buffer
+	if err := d.ExportVolumeDiff("src", &archive); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if err := d.ImportVolumeDiff("dst", &archive); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	got := describeTree(t, d.VolumeUpperDir("dst"))
+	for path, description := range want {
+		if got[path] != description {
+			t.Errorf("%s is imported as %q, want %q", path, got[path], description)
+		}
+	}
+	for path := range got {
+		if _, ok := want[path]; !ok {
+			t.Errorf("%s is imported but was not exported", path)
+		}
+	}
+}
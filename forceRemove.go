@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// errForceRemoveDisabled is returned by `ForceRemove` unless `DockerOnTop.AllowForceRemove` is set
+var errForceRemoveDisabled = errors.New("forced removal of volumes is disabled")
+
+// ForceRemove removes the volume even if it is in use: the active mounts are discarded (each is logged), the overlay
+// is forcibly unmounted (and detached, if busy), and then the volume is removed like with `Remove`. The containers
+// using the volume are left with a detached (or broken) mount.
+//
+// As a safety measure, `d.AllowForceRemove` must be set, otherwise `errForceRemoveDisabled` is returned.
+func (d *DockerOnTop) ForceRemove(volumeName string) error {
+	d.logger.Debug("Request ForceRemove", "volume", volumeName)
+
+	if !d.AllowForceRemove {
+		return errForceRemoveDisabled
+	}
+	if _, err := d.getVolumeInfoOrNotFound(volumeName); err != nil {
+		return err
+	}
+	if err := d.checkNoForks(volumeName, "remove"); err != nil {
+		return err
+	}
+	if err := d.discardActiveMounts(volumeName); err != nil {
+		return err
+	}
+	return d.Remove(&volume.RemoveRequest{Name: volumeName})
+}
+
+// discardActiveMounts forcibly unmounts the volume's overlay (if mounted) and removes all its active mount files.
+func (d *DockerOnTop) discardActiveMounts(volumeName string) error {
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
+		return err
+	}
+	defer unlock()
+
+	entries, err := d.readActivemounts(volumeName, -1)
+	if err != nil {
+		return d.internalError("failed to list activemounts/", err, "volume", volumeName)
+	}
+	var info activemountInfo
+	if len(entries) > 0 {
+		info, err = readActivemountInfo(d.activemountsdir(volumeName) + entries[0].Name())
+		if err != nil {
+			d.logger.Warn("Failed to read the active mount file. Assuming the overlay is mounted by the kernel",
+				"volume", volumeName, "error", err)
+		}
+	}
+
+	mountpoint := d.mountpointdir(volumeName)
+	mounted, err := d.isOverlayMounted(volumeName)
+	if err != nil {
+		d.logger.Warn("Failed to check whether the overlay is mounted. Assuming it is", "volume", volumeName,
+			"error", err)
+		mounted = true
+	}
+	if mounted {
+		if info.Fuse {
+			err = unmountFuseOverlay(mountpoint, true)
+		} else {
+			err = d.unmountSyscall(mountpoint, syscall.MNT_FORCE|syscall.MNT_DETACH)
+		}
+		if err != nil && !errors.Is(err, syscall.EINVAL) { // EINVAL: not mounted after all
+			return d.internalError("failed to forcibly unmount the overlay", err, "volume", volumeName)
+		}
+		d.logger.Warn("Forcibly unmounted the overlay", "volume", volumeName)
+	}
+	if err := d.unmountUpperTmpfs(volumeName); err != nil {
+		return d.internalError("failed to unmount the in-memory upper layer", err, "volume", volumeName)
+	}
+
+	for _, entry := range entries {
+		if err := os.Remove(d.activemountsdir(volumeName) + entry.Name()); err != nil && !os.IsNotExist(err) {
+			return d.internalError("failed to remove an active mount file", err, "volume", volumeName, "id",
+				entry.Name())
+		}
+		d.logger.Warn("Forcibly removed the active mount", "volume", volumeName, "id", entry.Name())
+	}
+	return nil
+}
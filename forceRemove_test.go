@@ -0,0 +1,79 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestForceRemove(t *testing.T) {
+	var logs bytes.Buffer
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m), WithLogger(newLogger(&logs, "json")))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	mountpoint := MustMountVolume(t, d, "vol", "first")
+	MustMountVolume(t, d, "vol", "second")
+
+	if err := d.ForceRemove("vol"); !errors.Is(err, errForceRemoveDisabled) {
+		t.Errorf("Without AllowForceRemove, ForceRemove = %v; want %v", err, errForceRemoveDisabled)
+	}
+	if _, ok := m.Mounted(mountpoint); !ok {
+		t.Fatal("The disabled ForceRemove unmounted the overlay")
+	}
+
+	d.AllowForceRemove = true
+	logs.Reset()
+	if err := d.ForceRemove("vol"); err != nil {
+		t.Fatalf("ForceRemove failed: %v", err)
+	}
+	// The mock mounts are not in the mount table, so the unmounting is checked by `TestForceRemoveWithOverlay`
+	if _, err := os.Stat(d.volumeDir("vol")); !os.IsNotExist(err) {
+		t.Errorf("The volume's directory is left behind (%v)", err)
+	}
+	var removedIDs []string
+	for _, entry := range decodeLogEntries(t, &logs) {
+		if entry["msg"] == "Forcibly removed the active mount" {
+			removedIDs = append(removedIDs, entry["id"].(string))
+		}
+	}
+	slices.Sort(removedIDs)
+	if want := []string{"first", "second"}; !slices.Equal(removedIDs, want) {
+		t.Errorf("The forcibly removed active mounts logged are %v, want %v", removedIDs, want)
+	}
+
+	if err := d.ForceRemove("vol"); err == nil {
+		t.Error("ForceRemove succeeded for a nonexistent volume")
+	}
+}
+
+func TestForceRemoveWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	d.AllowForceRemove = true
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	// The container keeps a file open in the volume, so the overlay is busy
+	f, err := os.Create(mountpoint + "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := d.ForceRemove("vol"); err != nil {
+		t.Fatalf("ForceRemove failed: %v", err)
+	}
+	if mounted, err := d.CheckMount("vol"); err != nil || mounted {
+		t.Errorf("After ForceRemove, CheckMount = %v, %v; want false", mounted, err)
+	}
+	if _, err := os.Stat(d.volumeDir("vol")); !os.IsNotExist(err) {
+		t.Errorf("The volume's directory is left behind (%v)", err)
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "vol"}); err == nil {
+		t.Error("The volume still exists")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ForkVolume creates a new volume `forkName` on top of the template volume `templateName`: the fork's base directory is
+// the template's mountpoint, so the fork sees the template's merged view (its base directory together with its
+// changes), while the changes made to the fork are stored in the fork's own (initially empty) upperdir.
+//
+// The fork has the same options as the template, except for the template's custom upperdir and workdir and its lower
+// layers: these are part of the template's merged view already. The template must be mounted, both when it is forked
+// and whenever the fork is mounted. A template cannot be removed or renamed while it has forks (see `ListForks`).
+func (d *DockerOnTop) ForkVolume(templateName string, forkName string) error {
+	d.logger.Debug("Request ForkVolume", "volume", templateName, "forkName", forkName)
+
+	if d.closed.Load() {
+		return errDriverClosed
+	}
+	if !volNameFormat.MatchString(forkName) {
+		d.logger.Debug("Volume name doesn't comply to the regex. Volume not forked")
+		return fmt.Errorf("volume name must match the regex %s", volNameFormat.String())
+	}
+	templateVol, err := d.getVolumeInfoOrNotFound(templateName)
+	if err != nil {
+		return err
+	}
+	if err := d.checkTemplateMounted(templateName); err != nil {
+		return err
+	}
+
+	if err := d.volumeTreeCreate(forkName); err != nil {
+		if os.IsExist(err) {
+			d.logger.Debug("Volume's main directory already exists. Volume not forked")
+			return errors.New("volume already exists")
+		}
+		// The error is already logged and wrapped in `internalError` by `d.volumeTreeCreate`
+		return err
+	}
+
+	// The fork gets all the template's creation options (see `exportedVolume.createOptions`), except for the ones
+	// telling where the template's own data is: the template's merged view (including its lower layers) becomes the
+	// fork's base directory, and the fork's changes go to the fork's own upperdir. The template's statistics and state
+	// are not inherited
+	forkVol := templateVol
+	forkVol.BaseDirPath = filepath.Clean(d.mountpointdir(templateName))
+	forkVol.LowerLayers = nil
+	forkVol.CustomUpperDir = ""
+	forkVol.CustomWorkDir = ""
+	forkVol.ParentVolume = templateName
+	forkVol.Stuck = false
+	forkVol.CreatedAt = time.Now()
+	forkVol.LastUsedAt = time.Time{}
+	forkVol.LastMountedAt = time.Time{}
+	forkVol.LastUnmountedAt = time.Time{}
+	forkVol.TotalMountCount = 0
+	forkVol.LastMountOptions = ""
+	if err := d.writeVolumeInfo(forkName, forkVol); err != nil {
+		_ = d.volumeTreeDestroy(forkName) // The errors are logged, if any
+		return d.internalError("failed to store metadata for the volume", err, "volume", forkName)
+	}
+	d.logger.Info("Forked volume", "volume", templateName, "forkName", forkName)
+	return nil
+}
+
+// ListForks returns the names of the volumes forked from the volume `templateName` (see `ForkVolume`), in ascending
+// order.
+func (d *DockerOnTop) ListForks(templateName string) ([]string, error) {
+	return d.listVolumesWhere(func(vol *VolumeInfo) bool {
+		return vol.ParentVolume == templateName
+	})
+}
+
+// checkTemplateMounted returns an error unless the template volume's overlay is mounted, which is required to fork
+// the template and to mount its forks.
+func (d *DockerOnTop) checkTemplateMounted(templateName string) error {
+	if mounted, err := d.isOverlayMounted(templateName); err != nil {
+		return d.internalError("failed to check whether the template volume is mounted", err, "volume", templateName)
+	} else if !mounted {
+		return fmt.Errorf("the template volume %s is not mounted: mount it (start a container using it) first",
+			templateName)
+	}
+	return nil
+}
+
+// checkNoForks returns an error if the volume has forks, which would lose their base directory if the volume were
+// removed or renamed. `action` is used in the error message.
+func (d *DockerOnTop) checkNoForks(volumeName string, action string) error {
+	forks, err := d.ListForks(volumeName)
+	if err != nil {
+		return err
+	} else if len(forks) > 0 {
+		return fmt.Errorf("cannot %s the volume: it has forks (%s)", action, strings.Join(forks, ", "))
+	}
+	return nil
+}
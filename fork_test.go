@@ -0,0 +1,107 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestForkVolume(t *testing.T) {
+	hooksDir := t.TempDir()
+	d := NewTestDockerOnTopWithOverlay(t, WithHooksDir(hooksDir))
+	base := t.TempDir()
+	if err := os.WriteFile(base+"/file", []byte("base"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	layer := t.TempDir()
+	if err := os.WriteFile(layer+"/layer-file", []byte("layer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := d.Create(&volume.CreateRequest{Name: "template", Options: map[string]string{
+		"base":       base,
+		"layers":     layer,
+		"volatile":   "true",
+		"tmpfs-size": "1M",
+		"nodev":      "true",
+		"index":      "off",
+		"tags":       "team-a,ci",
+		"postmount":  writeHook(t, hooksDir, "postmount", "true"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	templateMountpoint := MustMountVolume(t, d, "template", "template-container")
+	if err := os.WriteFile(templateMountpoint+"/changed", []byte("template"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.ForkVolume("template", "fork"); err != nil {
+		t.Fatalf("Failed to fork: %v", err)
+	}
+	if forks, err := d.ListForks("template"); err != nil || !slices.Equal(forks, []string{"fork"}) {
+		t.Errorf("ListForks = %v, %v; want [fork]", forks, err)
+	}
+
+	templateVol, err := d.getVolumeInfo("template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forkVol, err := d.getVolumeInfo("fork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forkVol.ParentVolume != "template" {
+		t.Errorf("The fork's parent volume is %q, want %q", forkVol.ParentVolume, "template")
+	}
+	templateOptions := (&exportedVolume{VolumeInfo: templateVol}).createOptions()
+	forkOptions := (&exportedVolume{VolumeInfo: forkVol}).createOptions()
+	if forkOptions["base"] != filepath.Clean(d.VolumeMountpointDir("template")) {
+		t.Errorf("The fork's base directory is %s, want the template's mountpoint", forkOptions["base"])
+	}
+	for _, option := range []string{"base", "layers"} {
+		delete(templateOptions, option)
+		delete(forkOptions, option)
+	}
+	if !reflect.DeepEqual(forkOptions, templateOptions) {
+		t.Errorf("The fork's options are %v, want the template's %v", forkOptions, templateOptions)
+	}
+
+	forkMountpoint := MustMountVolume(t, d, "fork", "fork-container")
+	for file, want := range map[string]string{"file": "base", "layer-file": "layer", "changed": "template"} {
+		if contents, err := os.ReadFile(forkMountpoint + "/" + file); err != nil || string(contents) != want {
+			t.Errorf("The fork's %s = %q, %v; want %q", file, contents, err, want)
+		}
+	}
+	if err := os.WriteFile(forkMountpoint+"/changed", []byte("fork"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := os.ReadFile(templateMountpoint + "/changed"); err != nil || string(contents) != "template" {
+		t.Errorf("The fork's change is visible in the template: %q, %v", contents, err)
+	}
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "template"}); err == nil {
+		t.Error("The template was removed while it has a fork")
+	}
+	MustUnmountVolume(t, d, "fork", "fork-container")
+	if err := d.Remove(&volume.RemoveRequest{Name: "fork"}); err != nil {
+		t.Fatal(err)
+	}
+	MustUnmountVolume(t, d, "template", "template-container")
+}
+
+func TestForkVolumeRequiresMountedTemplate(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	MustCreateVolume(t, d, "template", t.TempDir())
+	if err := d.ForkVolume("template", "fork"); err == nil {
+		t.Error("Forked a template that is not mounted")
+	}
+	if _, err := os.Stat(d.volumeDir("fork")); !os.IsNotExist(err) {
+		t.Errorf("The fork was created (%v)", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Binaries used to mount and unmount overlays in userspace (see `DockerOnTop.TryFuseOverlayFallback`)
+const (
+	fuseOverlayBinary = "fuse-overlayfs"
+	fusermountBinary  = "fusermount"
+)
+
+// isOverlayUnsupported reports whether the error returned by `syscall.Mount` means that the kernel doesn't support
+// overlayfs (the module is not loaded or is unavailable, like in WSL1).
+func isOverlayUnsupported(err error) bool {
+	return errors.Is(err, syscall.ENODEV) || errors.Is(err, syscall.ENOTSUP)
+}
+
+// mountFuseOverlay mounts the volume's overlay at `mountpoint` with `fuse-overlayfs`, using the same `options` and
+// `flags` the kernel overlay would be mounted with.
+func mountFuseOverlay(volumeName string, mountpoint string, options string, flags uintptr) error {
+	fuseOptions := []string{options, "fsname=" + overlaySource(volumeName)}
+	for _, flag := range []struct {
+		flag   uintptr
+		option string
+	}{
+		{syscall.MS_RDONLY, "ro"},
+		{syscall.MS_NOEXEC, "noexec"},
+		{syscall.MS_NOSUID, "nosuid"},
+		{syscall.MS_NODEV, "nodev"},
+	} {
+		if flags&flag.flag != 0 {
+			fuseOptions = append(fuseOptions, flag.option)
+		}
+	}
+
+	output, err := exec.Command(fuseOverlayBinary, "-o", strings.Join(fuseOptions, ","), mountpoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w (output: %q)", fuseOverlayBinary, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// unmountFuseOverlay unmounts an overlay mounted with `mountFuseOverlay`. If `lazy` is set, the overlay is detached
+// lazily.
+func unmountFuseOverlay(mountpoint string, lazy bool) error {
+	args := []string{"-u", mountpoint}
+	if lazy {
+		args = append([]string{"-z"}, args...)
+	}
+	output, err := exec.Command(fusermountBinary, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w (output: %q)", fusermountBinary, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// activemountInfo is the content of an active mount file. The files created before it was introduced (and the ones
+// made by `recoverActivemountsFromProcMounts`) are empty, which is equivalent to the zero value.
+type activemountInfo struct {
+	// Fuse is set if the volume's overlay is mounted with `fuse-overlayfs` rather than by the kernel
+	Fuse bool `json:"fuse,omitempty"`
+}
+
+// readActivemountInfo reads the active mount file at `path`.
+func readActivemountInfo(path string) (activemountInfo, error) {
+	var info activemountInfo
+	payload, err := os.ReadFile(path)
+	if err == nil && len(payload) > 0 {
+		err = json.Unmarshal(payload, &info)
+	}
+	return info, err
+}
+
+// createActivemountFile creates the active mount file at `path` with the given content. Like `os.Create`, it
+// truncates the file if it exists.
+func createActivemountFile(path string, info activemountInfo) error {
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, payload, 0o666)
+}
@@ -0,0 +1,104 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// withoutKernelOverlay makes the driver's overlay mounts fail like on a kernel without overlayfs.
+func withoutKernelOverlay(d *DockerOnTop) {
+	d.mountSyscall = func(source, target, fstype string, flags uintptr, data string) error {
+		return syscall.ENODEV
+	}
+}
+
+func TestFuseOverlayFallback(t *testing.T) {
+	// Fake binaries, which only record how they are called
+	binDir := t.TempDir()
+	record := filepath.Join(t.TempDir(), "record")
+	for _, binary := range []string{fuseOverlayBinary, fusermountBinary} {
+		writeHook(t, binDir, binary, `echo "$(basename "$0") $*" >> `+record)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+
+	d := NewTestDockerOnTop(t, withoutKernelOverlay)
+	d.TryFuseOverlayFallback = true
+	base := t.TempDir()
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"base": base, "noexec": "true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	info, err := readActivemountInfo(d.activemountsdir("vol") + "container")
+	if err != nil || !info.Fuse {
+		t.Errorf("The active mount is %+v, %v; want a fuse one", info, err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+
+	contents, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(calls) != 2 {
+		t.Fatalf("The fuse binaries were called as %q, want a mount and an unmount", calls)
+	}
+	mountArgs := strings.Fields(calls[0])
+	if len(mountArgs) != 4 || mountArgs[0] != fuseOverlayBinary || mountArgs[1] != "-o" || mountArgs[3] != mountpoint {
+		t.Fatalf("The overlay was mounted as %q", calls[0])
+	}
+	for _, want := range []string{"lowerdir=" + base, "upperdir=" + d.VolumeUpperDir("vol"),
+		"fsname=" + overlaySource("vol"), "noexec"} {
+		if !containsOption(mountArgs[2], want) {
+			t.Errorf("The overlay was mounted with %q, want %q among the options", mountArgs[2], want)
+		}
+	}
+	if want := fusermountBinary + " -u " + mountpoint; calls[1] != want {
+		t.Errorf("The overlay was unmounted as %q, want %q", calls[1], want)
+	}
+}
+
+func TestFuseOverlayFallbackDisabled(t *testing.T) {
+	d := NewTestDockerOnTop(t, withoutKernelOverlay)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"}); err == nil {
+		t.Error("The mount succeeded without the kernel overlay and the fuse fallback")
+	}
+	if mounted, err := d.volumeIsMounted("vol"); err != nil || mounted {
+		t.Errorf("After the failed mount, volumeIsMounted = %v, %v", mounted, err)
+	}
+}
+
+func TestFuseOverlayFallbackWithFuse(t *testing.T) {
+	for _, binary := range []string{fuseOverlayBinary, fusermountBinary} {
+		if _, err := exec.LookPath(binary); err != nil {
+			t.Skipf("%s is not installed", binary)
+		}
+	}
+	d := NewTestDockerOnTop(t, withoutKernelOverlay)
+	d.TryFuseOverlayFallback = true
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"file": "base"})
+	MustCreateVolume(t, d, "vol", base)
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	if contents, err := os.ReadFile(mountpoint + "/file"); err != nil || string(contents) != "base" {
+		t.Errorf("The volume's file = %q, %v; want %q", contents, err, "base")
+	}
+	writeFiles(t, mountpoint, map[string]string{"file": "changed"})
+	MustUnmountVolume(t, d, "vol", "container")
+	if contents, err := os.ReadFile(base + "/file"); err != nil || string(contents) != "base" {
+		t.Errorf("The base directory was modified: %q, %v", contents, err)
+	}
+	if contents, err := os.ReadFile(d.VolumeUpperDir("vol") + "file"); err != nil || string(contents) != "changed" {
+		t.Errorf("The volume's change = %q, %v; want %q", contents, err, "changed")
+	}
+}
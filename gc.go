@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// gcGracePeriod is how old an incomplete volume tree must be for `GarbageCollect` to remove it. Younger trees may
+// belong to a volume that is being created right now (`Create` makes the tree before writing the metadata)
+const gcGracePeriod = time.Minute
+
+// GarbageCollect removes the leftovers of interrupted volume creations from the dot root directory: volume trees
+// without the metadata file (which can't be used as volumes anyway) and stale scratch directories. Only the entries
+// older than `gcGracePeriod` are considered, and volume trees with active mounts are left alone.
+//
+// The names of the removed entries are returned. Errors on individual entries are logged and joined into the returned
+// error, but don't stop the collection.
+func (d *DockerOnTop) GarbageCollect() ([]string, error) {
+	d.logger.Debug("Request GarbageCollect")
+
+	entries, err := os.ReadDir(d.dotRootDir)
+	if err != nil {
+		return nil, d.internalError("failed to list contents of the dot root directory", err)
+	}
+
+	var removed []string
+	var errs []error
+	// isOld reports whether the entry is older than the grace period
+	isOld := func(info os.FileInfo, err error) bool {
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			return false
+		}
+		return time.Since(info.ModTime()) >= gcGracePeriod
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !isScratchDir(name) || !isOld(entry.Info()) {
+			continue
+		}
+		d.logger.Info("Removing stale scratch directory", "name", name)
+		if err := os.RemoveAll(d.dotRootDir + name); err != nil {
+			d.logger.Error("Failed to remove stale scratch directory", "name", name, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, name)
+	}
+
+	volumeNames, err := d.listVolumeNames()
+	if err != nil {
+		return removed, err
+	}
+	for _, name := range volumeNames {
+		if !isOld(os.Lstat(d.volumeDir(name))) {
+			continue
+		}
+
+		if _, err := d.getVolumeInfo(name); !os.IsNotExist(err) {
+			// Either a valid volume or a volume with broken metadata, which needs a human to look at it
+			continue
+		}
+		if mounted, err := d.volumeIsMounted(name); err != nil && !os.IsNotExist(err) {
+			d.logger.Error("Failed to check whether the orphaned volume tree is in use", "volume", name, "error", err)
+			errs = append(errs, err)
+			continue
+		} else if mounted {
+			d.logger.Warn("Orphaned volume tree has active mounts. Not removing it", "volume", name)
+			continue
+		}
+		if mounted, err := d.isOverlayMounted(name); err != nil || mounted {
+			// Removing the tree recursively would go through the mounted overlay
+			d.logger.Warn("Orphaned volume tree may have its overlay mounted. Not removing it", "volume", name,
+				"error", err)
+			continue
+		}
+		d.logger.Info("Removing orphaned volume tree (it has no metadata)", "volume", name)
+		if err := d.volumeTreeDestroy(name); err != nil {
+			// The error is already logged by `d.volumeTreeDestroy`
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, name)
+	}
+	return removed, errors.Join(errs...)
+}
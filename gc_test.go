@@ -0,0 +1,59 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "valid", t.TempDir())
+	for _, name := range []string{"orphan", "young-orphan", "used-orphan"} {
+		if err := d.volumeTreeCreate(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := createActivemountFile(d.activemountsdir("used-orphan")+"container", activemountInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	scratchDir := scratchDirPrefix + "create"
+	if err := os.Mkdir(d.dotRootDir+scratchDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * gcGracePeriod)
+	for _, name := range []string{"valid", "orphan", "used-orphan", scratchDir} {
+		if err := os.Chtimes(d.dotRootDir+name, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := d.GarbageCollect()
+	if err != nil {
+		t.Fatalf("GarbageCollect failed: %v", err)
+	}
+	slices.Sort(removed)
+	if want := []string{scratchDir, "orphan"}; !slices.Equal(removed, want) {
+		t.Errorf("GarbageCollect removed %v, want %v", removed, want)
+	}
+	for _, name := range []string{"orphan", scratchDir} {
+		if _, err := os.Stat(d.dotRootDir + name); !os.IsNotExist(err) {
+			t.Errorf("%s is left (%v)", name, err)
+		}
+	}
+	for _, name := range []string{"young-orphan", "used-orphan"} {
+		if _, err := os.Stat(d.volumeDir(name)); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "valid"}); err != nil {
+		t.Errorf("The valid volume is broken: %v", err)
+	}
+	MustMountVolume(t, d, "valid", "container")
+	MustUnmountVolume(t, d, "valid", "container")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Values of `healthStatus.Status`
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+)
+
+// healthStatus is the response of the health endpoint (see `StartHealthServer`)
+type healthStatus struct {
+	Status           string `json:"status"`
+	Volumes          int    `json:"volumes"`
+	ActiveMounts     int    `json:"activeMounts"`
+	OverlaySupported bool   `json:"overlaySupported"`
+	// UnmountedVolumes are the volumes in use whose overlays are not mounted (see `CheckMount`)
+	UnmountedVolumes []string `json:"unmountedVolumes,omitempty"`
+	// BootFailedVolumes are the volumes that failed to reset on boot (see `BootErrors`). They don't make the status
+	// degraded, as restarting the plugin wouldn't help
+	BootFailedVolumes []string `json:"bootFailedVolumes,omitempty"`
+}
+
+// StartHealthServer starts an HTTP server responding to `GET /health` with the status of the plugin as JSON, for
+// liveness probes. The status is "degraded" (and the response code is 503) if overlays cannot be mounted (see
+// `probeOverlay`) or the overlay of a volume in use is not mounted (see `CheckMount`). The server runs in the
+// background until `Close` is called; an error is only returned if the address cannot be listened on.
+func (d *DockerOnTop) StartHealthServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", d.serveHealth)
+	server := &http.Server{Handler: mux}
+	d.addCloser(server)
+	d.goBackground(func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			d.logger.Error("Health server stopped", "error", err)
+		}
+	})
+	d.logger.Info("Serving health checks", "address", listener.Addr().String(), "path", "/health")
+	return nil
+}
+
+func (d *DockerOnTop) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := d.health()
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != healthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		d.logger.Debug("Failed to write the health status", "error", err)
+	}
+}
+
+// health checks the state of the plugin.
+func (d *DockerOnTop) health() healthStatus {
+	status := healthStatus{Status: healthOK, OverlaySupported: true, BootFailedVolumes: d.BootErrors()}
+
+	if err := d.probeOverlay("overlay"); err != nil {
+		// The error is already logged by `d.probeOverlay`
+		status.Status = healthDegraded
+		status.OverlaySupported = false
+	}
+
+	volumeNames, err := d.listVolumeNames()
+	if err != nil {
+		// The error is already logged by `d.listVolumeNames`
+		status.Status = healthDegraded
+	}
+	status.Volumes = len(volumeNames)
+
+	activeMounts, err := d.ListActiveMounts()
+	if err != nil {
+		status.Status = healthDegraded
+	}
+	status.ActiveMounts = len(activeMounts)
+	for i, activeMount := range activeMounts {
+		if i > 0 && activeMounts[i-1].VolumeName == activeMount.VolumeName {
+			// Sorted by volume name, so already checked
+			continue
+		}
+		mounted, err := d.CheckMount(activeMount.VolumeName)
+		if err != nil {
+			d.logger.Error("Failed to read the mount table", "error", err)
+			status.Status = healthDegraded
+			break
+		} else if !mounted && d.isInUseButUnmounted(activeMount.VolumeName) {
+			status.Status = healthDegraded
+			status.UnmountedVolumes = append(status.UnmountedVolumes, activeMount.VolumeName)
+		}
+	}
+	return status
+}
@@ -0,0 +1,105 @@
+//go:build dottest
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// getHealth requests the health status from the server at `addr`.
+func getHealth(t *testing.T, addr string) (int, healthStatus) {
+	t.Helper()
+	response, err := http.Get("http://" + addr + "/health")
+	if err != nil {
+		t.Fatalf("Failed to get the health status: %v", err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("The health status has the content type %q", contentType)
+	}
+	var status healthStatus
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		t.Fatalf("The health status is not valid JSON: %v", err)
+	}
+	return response.StatusCode, status
+}
+
+func TestHealthServerWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	addr := freeAddr(t)
+	if err := d.StartHealthServer(addr); err != nil {
+		t.Fatalf("StartHealthServer failed: %v", err)
+	}
+	MustCreateVolume(t, d, "used", t.TempDir())
+	MustCreateVolume(t, d, "unused", t.TempDir())
+	MustMountVolume(t, d, "used", "first")
+	mountpoint := MustMountVolume(t, d, "used", "second")
+
+	code, status := getHealth(t, addr)
+	want := healthStatus{Status: healthOK, Volumes: 2, ActiveMounts: 2, OverlaySupported: true}
+	if code != http.StatusOK || !reflect.DeepEqual(status, want) {
+		t.Errorf("The health status is %d %+v, want %d %+v", code, status, http.StatusOK, want)
+	}
+
+	// The container runtime unmounts the overlay without telling the plugin
+	if err := syscall.Unmount(mountpoint, 0); err != nil {
+		t.Fatal(err)
+	}
+	code, status = getHealth(t, addr)
+	want.Status = healthDegraded
+	want.UnmountedVolumes = []string{"used"}
+	if code != http.StatusServiceUnavailable || !reflect.DeepEqual(status, want) {
+		t.Errorf("The health status is %d %+v, want %d %+v", code, status, http.StatusServiceUnavailable, want)
+	}
+
+	response, err := http.Post("http://"+addr+"/health", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned %d, want %d", response.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHealthServerWithoutOverlay(t *testing.T) {
+	var failing atomic.Bool
+	probeFails := func(d *DockerOnTop) {
+		mount := d.mountSyscall
+		d.mountSyscall = func(source string, target string, fstype string, flags uintptr, data string) error {
+			if failing.Load() {
+				return syscall.ENODEV
+			}
+			return mount(source, target, fstype, flags, data)
+		}
+	}
+	d := NewTestDockerOnTop(t, probeFails)
+	addr := freeAddr(t)
+	if err := d.StartHealthServer(addr); err != nil {
+		t.Fatalf("StartHealthServer failed: %v", err)
+	}
+	if err := d.StartHealthServer(addr); err == nil {
+		t.Error("A second health server was started at the same address")
+	}
+	MustCreateVolume(t, d, "vol", t.TempDir())
+
+	failing.Store(true)
+	code, status := getHealth(t, addr)
+	want := healthStatus{Status: healthDegraded, Volumes: 1}
+	if code != http.StatusServiceUnavailable || !reflect.DeepEqual(status, want) {
+		t.Errorf("The health status is %d %+v, want %d %+v", code, status, http.StatusServiceUnavailable, want)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if response, err := http.Get("http://" + addr + "/health"); err == nil {
+		response.Body.Close()
+		t.Error("The health server is still running after Close")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultHookTimeout is the default limit on the time a volume's hook may run (see `WithHookTimeout`)
+const defaultHookTimeout = 30 * time.Second
+
+// checkHook checks the value of a hook option (like `premount`): it must be an absolute path to an executable file
+// located in the hooks directory (see `WithHooksDir`), once symlinks are resolved. The resolved path is returned.
+func (d *DockerOnTop) checkHook(option string, path string) (string, error) {
+	if d.hooksDir == "" {
+		return "", fmt.Errorf("`%s` is not allowed: hooks are disabled (see --hooks-dir)", option)
+	} else if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("`%s` must be an absolute path", option)
+	}
+	hooksDir, err := filepath.EvalSymlinks(d.hooksDir)
+	if err != nil {
+		return "", fmt.Errorf("the hooks directory is inaccessible: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("the `%s` hook is inaccessible: %w", option, err)
+	} else if !isPathUnder(resolved, hooksDir) {
+		return "", fmt.Errorf("the `%s` hook must be located in the hooks directory %s", option, d.hooksDir)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("the `%s` hook is inaccessible: %w", option, err)
+	} else if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+		return "", fmt.Errorf("the `%s` hook is not an executable file", option)
+	}
+	return resolved, nil
+}
+
+// runHook runs the volume's hook `hookPath` of the given kind (like "pre-mount"), unless it's empty. The hook gets the
+// volume's name, base directory, mountpoint, and upperdir in the `DOT_VOLUME_NAME`, `DOT_BASE_DIR`, `DOT_MOUNTPOINT`,
+// and `DOT_UPPERDIR` environment variables. It is killed (with its children) if it runs for longer than
+// `d.hookTimeout`.
+//
+// The hook is checked with `checkHook` again, as the hooks directory (or the hook itself) may have changed since the
+// volume was created, and the checked (symlink-resolved) path is run. The hook's output is logged. An error is
+// returned if the hook is rejected or fails (exits with a non-zero code or times out).
+func (d *DockerOnTop) runHook(kind string, hookPath string, volumeName string, thisVol VolumeInfo) error {
+	if hookPath == "" {
+		return nil
+	}
+	resolvedPath, err := d.checkHook(kind, hookPath)
+	if err != nil {
+		d.logger.Error("Refusing to run the volume hook", "volume", volumeName, "hook", kind, "path", hookPath,
+			"error", err)
+		return fmt.Errorf("the %s hook %s was not run: %w", kind, hookPath, err)
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, d.hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, resolvedPath)
+	cmd.Env = append(os.Environ(),
+		"DOT_VOLUME_NAME="+volumeName,
+		"DOT_BASE_DIR="+thisVol.BaseDirPath,
+		"DOT_MOUNTPOINT="+d.mountpointdir(volumeName),
+		"DOT_UPPERDIR="+d.upperdir(volumeName, &thisVol),
+	)
+	// On timeout, kill the children of the hook as well: they would keep the output open, so `Run` would wait for them
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	started := time.Now()
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", d.hookTimeout)
+	}
+	d.logger.Debug("Ran volume hook", "volume", volumeName, "hook", kind, "path", hookPath, "duration",
+		time.Since(started), "output", strings.TrimSpace(output.String()))
+	if err != nil {
+		d.logger.Error("Volume hook failed", "volume", volumeName, "hook", kind, "path", hookPath, "error", err,
+			"output", strings.TrimSpace(output.String()))
+		return fmt.Errorf("the %s hook %s failed: %w", kind, hookPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// writeHook creates the executable shell script `name` in `dir` and returns its path.
+func writeHook(t *testing.T, dir string, name string, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHooks(t *testing.T) {
+	hooksDir := t.TempDir()
+	d := NewTestDockerOnTop(t, WithHooksDir(hooksDir))
+	record := filepath.Join(t.TempDir(), "record")
+	options := map[string]string{"base": t.TempDir()}
+	for _, option := range []string{"premount", "postmount", "preunmount", "postunmount"} {
+		options[option] = writeHook(t, hooksDir, option, `echo "`+option+` $DOT_VOLUME_NAME" >> `+record)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err != nil {
+		t.Fatal(err)
+	}
+
+	MustMountVolume(t, d, "vol", "first")
+	// The hooks don't run for the containers sharing the mounted volume
+	MustMountVolume(t, d, "vol", "second")
+	MustUnmountVolume(t, d, "vol", "second")
+	if contents, err := os.ReadFile(record); err != nil || string(contents) != "premount vol\npostmount vol\n" {
+		t.Errorf("After mounting, the hooks recorded %q, %v", contents, err)
+	}
+	MustUnmountVolume(t, d, "vol", "first")
+	want := "premount vol\npostmount vol\npreunmount vol\npostunmount vol\n"
+	if contents, err := os.ReadFile(record); err != nil || string(contents) != want {
+		t.Errorf("After unmounting, the hooks recorded %q, %v; want %q", contents, err, want)
+	}
+}
+
+func TestFailingPreMountHook(t *testing.T) {
+	hooksDir := t.TempDir()
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithHooksDir(hooksDir), WithMockSyscallMount(m))
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":     t.TempDir(),
+		"premount": writeHook(t, hooksDir, "fail", "exit 1"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"}); err == nil {
+		t.Error("The mount succeeded although the pre-mount hook failed")
+	}
+	if _, ok := m.Mounted(d.VolumeMountpointDir("vol")); ok {
+		t.Error("The overlay was mounted although the pre-mount hook failed")
+	}
+}
+
+func TestHooksOutsideHooksDir(t *testing.T) {
+	hooksDir := t.TempDir()
+	outside := writeHook(t, t.TempDir(), "outside", "true")
+	if err := os.Symlink(outside, filepath.Join(hooksDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	dotDot, err := filepath.Rel(filepath.Dir(hooksDir), outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonExecutable := writeHook(t, hooksDir, "non-executable", "true")
+	if err := os.Chmod(nonExecutable, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withHooksDir := []DockerOnTopOption{WithHooksDir(hooksDir)}
+	tests := []struct {
+		name    string
+		options []DockerOnTopOption
+		hook    string
+	}{
+		{name: "hooks disabled", hook: writeHook(t, hooksDir, "hook", "true")},
+		{name: "outside", options: withHooksDir, hook: outside},
+		{name: "symlink to the outside", options: withHooksDir, hook: hooksDir + "/link"},
+		{name: "dot-dot", options: withHooksDir, hook: hooksDir + "/../" + dotDot},
+		{name: "relative", options: withHooksDir, hook: "hook"},
+		{name: "not executable", options: withHooksDir, hook: nonExecutable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t, test.options...)
+			err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+				"base":     t.TempDir(),
+				"premount": test.hook,
+			}})
+			if err == nil {
+				t.Error("The volume was created")
+			}
+		})
+	}
+}
+
+func TestHookCheckedWhenRun(t *testing.T) {
+	hooksDir := t.TempDir()
+	d := NewTestDockerOnTop(t, WithHooksDir(hooksDir))
+	hook := writeHook(t, hooksDir, "hook", "true")
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":     t.TempDir(),
+		"premount": hook,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hook is replaced with a symlink to the outside after the volume is created
+	sentinel := filepath.Join(t.TempDir(), "sentinel")
+	outside := writeHook(t, t.TempDir(), "outside", "touch "+sentinel)
+	if err := os.Remove(hook); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, hook); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"}); err == nil {
+		t.Error("The mount succeeded with a hook outside of the hooks directory")
+	}
+	if _, err := os.Stat(sentinel); !os.IsNotExist(err) {
+		t.Errorf("The hook outside of the hooks directory was run (%v)", err)
+	}
+}
+
+func TestFailingPreUnmountHook(t *testing.T) {
+	hooksDir := t.TempDir()
+	var logs bytes.Buffer
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithHooksDir(hooksDir), WithMockSyscallMount(m), WithLogger(newLogger(&logs, "json")))
+	sentinel := filepath.Join(t.TempDir(), "sentinel")
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":        t.TempDir(),
+		"preunmount":  writeHook(t, hooksDir, "fail", "exit 1"),
+		"postunmount": writeHook(t, hooksDir, "post", "touch "+sentinel),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	MustMountVolume(t, d, "vol", "container")
+
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "container"}); err != nil {
+		t.Errorf("The unmount failed because of the pre-unmount hook: %v", err)
+	}
+	if _, ok := m.Mounted(d.VolumeMountpointDir("vol")); ok {
+		t.Error("The overlay is still mounted")
+	}
+	if _, err := os.Stat(sentinel); err != nil {
+		t.Errorf("The post-unmount hook didn't run: %v", err)
+	}
+	warned := false
+	for _, entry := range decodeLogEntries(t, &logs) {
+		warned = warned || entry["level"] == "WARN" && entry["msg"] == "The pre-unmount hook failed. Unmounting anyway"
+	}
+	if !warned {
+		t.Errorf("The failure of the pre-unmount hook was not logged:\n%s", logs.String())
+	}
+}
+
+func TestUnmountHooksWithOverlay(t *testing.T) {
+	hooksDir := t.TempDir()
+	d := NewTestDockerOnTopWithOverlay(t, WithHooksDir(hooksDir))
+	record := filepath.Join(t.TempDir(), "record")
+	// Records whether the overlay is mounted and what the upperdir contains
+	script := `if grep -q " ${DOT_MOUNTPOINT%/} overlay " /proc/mounts; then state=mounted; else state=unmounted; fi
+echo "$0 $state $(cat "$DOT_UPPERDIR/file")" >> ` + record
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":        t.TempDir(),
+		"volatile":    "true",
+		"preunmount":  writeHook(t, hooksDir, "pre", script),
+		"postunmount": writeHook(t, hooksDir, "post", script),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	writeFiles(t, mountpoint, map[string]string{"file": "changed"})
+
+	MustUnmountVolume(t, d, "vol", "container")
+	// The post-unmount hook can back up the changes of the volatile volume before they are discarded
+	want := hooksDir + "/pre mounted changed\n" + hooksDir + "/post unmounted changed\n"
+	if contents, err := os.ReadFile(record); err != nil || string(contents) != want {
+		t.Errorf("The unmount hooks recorded %q, %v; want %q", contents, err, want)
+	}
+}
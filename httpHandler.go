@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// pluginManifest is the response to `/Plugin.Activate`: the plugin implements the volume driver protocol
+const pluginManifest = `{"Implements": ["VolumeDriver"]}`
+
+// NewHTTPHandler returns an `http.Handler` serving the driver over Docker's volume plugin protocol: `/Plugin.Activate`
+// and the `/VolumeDriver.*` endpoints, which take and return JSON with POST requests. Failures are reported with the
+// status 500 and a `{"Err": "..."}` body, like Docker expects. It allows serving the driver over plain HTTP (e.g. with
+// `http.Server` on a TCP listener) rather than with `ServeUnix`.
+func NewHTTPHandler(d *DockerOnTop) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", pluginEndpoint(d.logger, func(*struct{}) (json.RawMessage, error) {
+		return json.RawMessage(pluginManifest), nil
+	}))
+	mux.HandleFunc("/VolumeDriver.Create", pluginEndpoint(d.logger, withoutResponse(d.Create)))
+	mux.HandleFunc("/VolumeDriver.Remove", pluginEndpoint(d.logger, withoutResponse(d.Remove)))
+	mux.HandleFunc("/VolumeDriver.Mount", pluginEndpoint(d.logger, d.Mount))
+	mux.HandleFunc("/VolumeDriver.Unmount", pluginEndpoint(d.logger, withoutResponse(d.Unmount)))
+	mux.HandleFunc("/VolumeDriver.Path", pluginEndpoint(d.logger, d.Path))
+	mux.HandleFunc("/VolumeDriver.Get", pluginEndpoint(d.logger, d.Get))
+	mux.HandleFunc("/VolumeDriver.List", pluginEndpoint(d.logger, func(*struct{}) (*volume.ListResponse, error) {
+		return d.List()
+	}))
+	mux.HandleFunc("/VolumeDriver.Capabilities", pluginEndpoint(d.logger,
+		func(*struct{}) (*volume.CapabilitiesResponse, error) {
+			return d.Capabilities(), nil
+		}))
+	return mux
+}
+
+// withoutResponse adapts a driver method returning only an error to `pluginEndpoint`, which responds with an empty
+// JSON object on success.
+func withoutResponse[Req any](method func(*Req) error) func(*Req) (*struct{}, error) {
+	return func(request *Req) (*struct{}, error) {
+		return &struct{}{}, method(request)
+	}
+}
+
+// pluginEndpoint makes an HTTP handler function that decodes the JSON request body (an empty body is accepted, as the
+// zero request), calls `method`, and encodes its response or error. The failures to respond are logged with `logger`.
+func pluginEndpoint[Req any, Resp any](logger *slog.Logger, method func(*Req) (Resp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writePluginError(logger, w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		request := new(Req)
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil && !errors.Is(err, io.EOF) {
+			writePluginError(logger, w, http.StatusBadRequest, err)
+			return
+		}
+		response, err := method(request)
+		if err != nil {
+			writePluginError(logger, w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", sdk.DefaultContentTypeV1_1)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Error("Failed to write a plugin response", "path", r.URL.Path, "error", err)
+		}
+	}
+}
+
+// writePluginError responds with the error in the format of the volume plugin protocol.
+func writePluginError(logger *slog.Logger, w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", sdk.DefaultContentTypeV1_1)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(volume.NewErrorResponse(err.Error())); err != nil {
+		logger.Error("Failed to write a plugin error response", "error", err)
+	}
+}
@@ -0,0 +1,155 @@
+//go:build dottest
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postPlugin sends the raw JSON `body` to the plugin endpoint `path` of the server and returns the status code and the
+// decoded response.
+func postPlugin(t *testing.T, server *httptest.Server, path string, body string) (int, map[string]any) {
+	t.Helper()
+	response, err := http.Post(server.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", path, err)
+	}
+	defer response.Body.Close()
+	payload, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/vnd.docker.plugins.v1.1+json" {
+		t.Errorf("POST %s responded with the content type %q", path, contentType)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatalf("POST %s responded with invalid JSON %q: %v", path, payload, err)
+	}
+	return response.StatusCode, decoded
+}
+
+func TestHTTPHandler(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	server := httptest.NewServer(NewHTTPHandler(d))
+	defer server.Close()
+	base := t.TempDir()
+	mountpoint := d.VolumeMountpointDir("vol")
+
+	for _, step := range []struct {
+		path, body string
+		want       string
+	}{
+		{"/Plugin.Activate", ``, `{"Implements": ["VolumeDriver"]}`},
+		{"/VolumeDriver.Capabilities", `{}`, `{"Capabilities": {"Scope": "volume"}}`},
+		{"/VolumeDriver.Create", `{"Name": "vol", "Opts": {"base": "` + base + `"}}`, `{}`},
+		{"/VolumeDriver.List", ``, `{"Volumes": [{"Name": "vol"}]}`},
+		{"/VolumeDriver.Mount", `{"Name": "vol", "ID": "container"}`, `{"Mountpoint": "` + mountpoint + `"}`},
+		{"/VolumeDriver.Path", `{"Name": "vol"}`, `{"Mountpoint": "` + mountpoint + `"}`},
+		{"/VolumeDriver.Get", `{"Name": "vol"}`, `{"Volume": {"Name": "vol", "Status": {"totalMountCount": 1}}}`},
+		{"/VolumeDriver.Unmount", `{"Name": "vol", "ID": "container"}`, `{}`},
+		{"/VolumeDriver.Remove", `{"Name": "vol"}`, `{}`},
+		{"/VolumeDriver.List", `{}`, `{"Volumes": null}`},
+	} {
+		code, response := postPlugin(t, server, step.path, step.body)
+		var want map[string]any
+		if err := json.Unmarshal([]byte(step.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if code != http.StatusOK || !containsJSON(response, want) {
+			t.Errorf("POST %s %s = %d %v, want %d %v", step.path, step.body, code, response, http.StatusOK, want)
+		}
+		if step.path == "/VolumeDriver.Mount" {
+			if _, ok := m.Mounted(mountpoint); !ok {
+				t.Error("The Mount request didn't mount the overlay")
+			}
+		}
+	}
+	if _, ok := m.Mounted(mountpoint); ok {
+		t.Error("The Unmount request didn't unmount the overlay")
+	}
+}
+
+// containsJSON reports whether the decoded JSON value `got` contains `want`: the objects may have more keys than the
+// ones in `want` (like the volumes' statuses), other values must be equal.
+func containsJSON(got any, want any) bool {
+	switch want := want.(type) {
+	case map[string]any:
+		got, ok := got.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if !containsJSON(got[key], value) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		got, ok := got.([]any)
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !containsJSON(got[i], want[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return got == want
+	}
+}
+
+func TestHTTPHandlerErrors(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	server := httptest.NewServer(NewHTTPHandler(d))
+	defer server.Close()
+
+	for _, test := range []struct {
+		path, body string
+		code       int
+		err        string
+	}{
+		{"/VolumeDriver.Create", `{"Name": "vol", "Opts": {"base": "` + t.TempDir() + `", "no-such": "x"}}`,
+			http.StatusInternalServerError, "Invalid option no-such"},
+		{"/VolumeDriver.Mount", `{"Name": "missing", "ID": "container"}`, http.StatusInternalServerError,
+			"no such volume"},
+		{"/VolumeDriver.Create", `{"Name": `, http.StatusBadRequest, "unexpected EOF"},
+		{"/VolumeDriver.Get", `["vol"]`, http.StatusBadRequest, "cannot unmarshal"},
+	} {
+		code, response := postPlugin(t, server, test.path, test.body)
+		if err, _ := response["Err"].(string); code != test.code || !strings.Contains(err, test.err) {
+			t.Errorf("POST %s %s = %d %v, want %d and an error containing %q", test.path, test.body, code, response,
+				test.code, test.err)
+		}
+	}
+
+	response, err := http.Get(server.URL + "/VolumeDriver.List")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	decodeErr := json.NewDecoder(response.Body).Decode(&decoded)
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed || response.Header.Get("Allow") != http.MethodPost ||
+		decodeErr != nil || decoded["Err"] == nil || decoded["Err"] == "" {
+		t.Errorf("GET = %d (Allow: %q) %v, %v; want %d and an error", response.StatusCode,
+			response.Header.Get("Allow"), decoded, decodeErr, http.StatusMethodNotAllowed)
+	}
+
+	response, err = http.Post(server.URL+"/VolumeDriver.NoSuch", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("An unknown endpoint responded with %d, want %d", response.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// definition returns the volume's metadata without the fields describing its state (like the statistics), that is
+// only the fields set from the `Create` options. The empty lists and maps are replaced with nil.
+//
+// The state fields are listed explicitly, so that the fields added later are part of the definition by default.
+func (vol VolumeInfo) definition() VolumeInfo {
+	vol.SchemaVersion = 0
+	vol.Stuck = false
+	vol.CreatedAt = time.Time{}
+	vol.LastUsedAt = time.Time{}
+	vol.LastMountedAt = time.Time{}
+	vol.LastUnmountedAt = time.Time{}
+	vol.TotalMountCount = 0
+	vol.LastMountOptions = ""
+	if len(vol.LowerLayers) == 0 {
+		vol.LowerLayers = nil
+	}
+	if len(vol.OverlayOptions) == 0 {
+		vol.OverlayOptions = nil
+	}
+	if len(vol.Tags) == 0 {
+		vol.Tags = nil
+	}
+	return vol
+}
+
+// isIdenticalRecreate reports whether the volume exists with the same definition as `newVol` (with the custom upper
+// and work directories as given in the options), so that, with `DockerOnTop.IdempotentCreate` set, creating it again
+// succeeds without doing anything.
+func (d *DockerOnTop) isIdenticalRecreate(volumeName string, newVol VolumeInfo, namespaced bool) bool {
+	existing, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		// Doesn't exist, or is broken or being created (then not the same for sure)
+		return false
+	}
+
+	// The stored custom directories are cleaned, with a trailing slash (see `checkCustomDirs`)
+	if newVol.CustomUpperDir != "" {
+		newVol.CustomUpperDir = filepath.Clean(newVol.CustomUpperDir) + "/"
+	}
+	if newVol.CustomWorkDir != "" {
+		newVol.CustomWorkDir = filepath.Clean(newVol.CustomWorkDir) + "/"
+	}
+	return namespaced == d.isNamespaced(volumeName) && reflect.DeepEqual(existing.definition(), newVol.definition())
+}
@@ -0,0 +1,91 @@
+//go:build dottest
+
+package main
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestIdempotentCreate(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	customDir := t.TempDir()
+	options := map[string]string{
+		"base":     t.TempDir(),
+		"volatile": "true",
+		"tags":     "team,ci",
+		"index":    "off",
+		"upper":    customDir + "/upper",
+		"work":     customDir + "/work/",
+	}
+	create := func(name string, options map[string]string) error {
+		return d.Create(&volume.CreateRequest{Name: name, Options: maps.Clone(options)})
+	}
+	if err := create("vol", options); err != nil {
+		t.Fatal(err)
+	}
+	if err := create("vol", options); err == nil {
+		t.Error("Without IdempotentCreate, creating the volume again succeeded")
+	}
+
+	d.IdempotentCreate = true
+	// The state of the volume doesn't matter
+	MustMountVolume(t, d, "vol", "container")
+	MustUnmountVolume(t, d, "vol", "container")
+	before, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := create("vol", options); err != nil {
+		t.Errorf("Creating the volume again with the same options failed: %v", err)
+	}
+	if after, err := d.getVolumeInfo("vol"); err != nil || !after.CreatedAt.Equal(before.CreatedAt) ||
+		after.TotalMountCount != before.TotalMountCount {
+		t.Errorf("Creating the volume again changed its metadata from %+v to %+v (%v)", before, after, err)
+	}
+
+	for option, value := range map[string]string{
+		"base":       t.TempDir(),
+		"volatile":   "false",
+		"tags":       "team",
+		"index":      "on",
+		"namespaced": "true",
+	} {
+		different := maps.Clone(options)
+		different[option] = value
+		if err := create("vol", different); err == nil {
+			t.Errorf("Creating the volume again with a different `%s` succeeded", option)
+		}
+	}
+}
+
+func TestIdempotentCreateWithHooks(t *testing.T) {
+	hooksDir := t.TempDir()
+	d := NewTestDockerOnTop(t, WithHooksDir(hooksDir))
+	d.IdempotentCreate = true
+	base, hook := t.TempDir(), writeHook(t, hooksDir, "hook", "true")
+	create := func(hooks map[string]string) error {
+		options := maps.Clone(hooks)
+		options["base"] = base
+		return d.Create(&volume.CreateRequest{Name: "vol", Options: options})
+	}
+	if err := create(map[string]string{"premount": hook}); err != nil {
+		t.Fatal(err)
+	}
+	if err := create(map[string]string{"premount": hook}); err != nil {
+		t.Errorf("Creating the volume again with the same hooks failed: %v", err)
+	}
+
+	for name, hooks := range map[string]map[string]string{
+		"no hooks":         {},
+		"another premount": {"premount": writeHook(t, hooksDir, "another", "true")},
+		"a postmount":      {"postmount": hook},
+		"an extra hook":    {"premount": hook, "postunmount": hook},
+	} {
+		if err := create(hooks); err == nil {
+			t.Errorf("Creating the volume again with %s succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+Write rate limits of the volumes (the `write-iops` and `write-bps` options).
+
+The writes to a volume are throttled with the `io.max` limits of cgroup v2, which apply to the processes of a cgroup
+rather than to a mount. The plugin cannot move the containers' processes (it doesn't even know them: Docker mounts a
+volume before it starts the container), so every limited volume gets its own cgroup, docker-on-top/<volume name> in
+the cgroup v2 hierarchy, which is created when the volume is mounted, and the containers using the volume must be run
+in it with `docker run --cgroup-parent=/docker-on-top/<volume name>` (which requires the cgroupfs cgroup driver).
+The limits are set on the block device the volume's upperdir is on (the whole disk if the upperdir is on a partition:
+`io.max` only accepts disks) and count the writes to all the files on that disk, not just to the volume. The cgroup
+is removed when the volume is unmounted.
+*/
+
+const (
+	// cgroupRootDir is where the cgroup v2 hierarchy is mounted
+	cgroupRootDir = "/sys/fs/cgroup/"
+	// ioCgroupParent is the cgroup (relative to `cgroupRootDir`) the volumes' cgroups are created in
+	ioCgroupParent = "docker-on-top"
+)
+
+// hasWriteLimits tells whether the writes to the volume are throttled (see the comment in the beginning of the file)
+func (vol *VolumeInfo) hasWriteLimits() bool {
+	return vol.WriteIOPS > 0 || vol.WriteBPS > 0
+}
+
+// ioCgroupDir returns the directory of the volume's cgroup (see the comment in the beginning of the file).
+func ioCgroupDir(volumeName string) string {
+	return cgroupRootDir + ioCgroupParent + "/" + volumeName + "/"
+}
+
+// setUpWriteLimits creates the volume's cgroup, if it doesn't exist, and sets its `io.max` limits for the device of the
+// volume's upperdir to `vol.WriteIOPS` and `vol.WriteBPS` (see the comment in the beginning of the file). The caller is
+// expected to hold the volume's lock.
+func (d *DockerOnTop) setUpWriteLimits(volumeName string, vol VolumeInfo) error {
+	var fsStat unix.Statfs_t
+	if err := unix.Statfs(cgroupRootDir, &fsStat); err != nil {
+		return err
+	} else if fsStat.Type != unix.CGROUP2_SUPER_MAGIC {
+		return fmt.Errorf("%s is not a cgroup v2 hierarchy (cgroup v1 is not supported)", cgroupRootDir)
+	}
+
+	if controllers, err := os.ReadFile(cgroupRootDir + "cgroup.controllers"); err != nil {
+		return err
+	} else if !slices.Contains(strings.Fields(string(controllers)), "io") {
+		return errors.New("the cgroup v2 io controller is not available (is it used by cgroup v1?)")
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(d.upperdir(volumeName, &vol), &stat); err != nil {
+		return err
+	}
+	device, err := wholeDiskOf(stat.Dev)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(cgroupRootDir+ioCgroupParent, 0o755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	// The io controller must be enabled for the children of every ancestor of the volume's cgroup
+	for _, dir := range []string{cgroupRootDir, cgroupRootDir + ioCgroupParent + "/"} {
+		if err := os.WriteFile(dir+"cgroup.subtree_control", []byte("+io"), 0); err != nil {
+			return fmt.Errorf("failed to enable the io controller in %s: %w", dir, err)
+		}
+	}
+	cgroup := ioCgroupDir(volumeName)
+	if err := os.Mkdir(cgroup, 0o755); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	limits := device
+	if vol.WriteIOPS > 0 {
+		limits += fmt.Sprintf(" wiops=%d", vol.WriteIOPS)
+	}
+	if vol.WriteBPS > 0 {
+		limits += fmt.Sprintf(" wbps=%d", vol.WriteBPS)
+	}
+	if err := os.WriteFile(cgroup+"io.max", []byte(limits), 0); err != nil {
+		return fmt.Errorf("failed to set io.max to %q: %w", limits, err)
+	}
+	d.logger.Debug("Set the write limits of the volume", "volume", volumeName, "cgroup", cgroup, "limits", limits)
+	return nil
+}
+
+// removeWriteLimits removes the volume's cgroup, and so its limits, if it exists. It fails if any processes are still
+// in the cgroup.
+func (d *DockerOnTop) removeWriteLimits(volumeName string) error {
+	err := syscall.Rmdir(ioCgroupDir(volumeName))
+	if errors.Is(err, syscall.ENOENT) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	d.logger.Debug("Removed the volume's cgroup", "volume", volumeName)
+	return nil
+}
+
+// wholeDiskOf returns the "major:minor" number of the block device `dev`, or of the disk it is a partition of.
+func wholeDiskOf(dev uint64) (string, error) {
+	device := fmt.Sprintf("%d:%d", unix.Major(dev), unix.Minor(dev))
+	sysfsDir := "/sys/dev/block/" + device
+	if _, err := os.Stat(sysfsDir); os.IsNotExist(err) {
+		return "", fmt.Errorf("the upperdir is not on a block device (device %s)", device)
+	} else if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(sysfsDir + "/partition"); os.IsNotExist(err) {
+		return device, nil
+	} else if err != nil {
+		return "", err
+	}
+	// The sysfs directory of a partition is inside the disk's one
+	partitionDir, err := filepath.EvalSymlinks(sysfsDir)
+	if err != nil {
+		return "", err
+	}
+	disk, err := os.ReadFile(filepath.Dir(partitionDir) + "/dev")
+	if err != nil {
+		return "", fmt.Errorf("failed to find the disk of the partition %s: %w", device, err)
+	}
+	return strings.TrimSpace(string(disk)), nil
+}
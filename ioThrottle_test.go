@@ -0,0 +1,197 @@
+//go:build dottest
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"golang.org/x/sys/unix"
+)
+
+func TestWriteLimitOptions(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":       t.TempDir(),
+		"write-iops": "100",
+		"write-bps":  "10M",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil || vol.WriteIOPS != 100 || vol.WriteBPS != 10<<20 {
+		t.Errorf("WriteIOPS, WriteBPS = %d, %d, %v; want 100, %d", vol.WriteIOPS, vol.WriteBPS, err, 10<<20)
+	}
+	response, err := d.Get(&volume.GetRequest{Name: "vol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := response.Volume.Status
+	if status["writeIops"] != int64(100) || status["writeBps"] != int64(10<<20) ||
+		status["cgroupParent"] != "/docker-on-top/vol" {
+		t.Errorf("The volume's status is %v, want the write limits and the cgroup parent", status)
+	}
+
+	MustCreateVolume(t, d, "unlimited", t.TempDir())
+	response, err = d.Get(&volume.GetRequest{Name: "unlimited"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := response.Volume.Status["cgroupParent"]; ok {
+		t.Errorf("Without the options, the volume's status is %v, want no cgroup parent", response.Volume.Status)
+	}
+
+	for name, options := range map[string]map[string]string{
+		"invalid iops":    {"write-iops": "many"},
+		"negative iops":   {"write-iops": "-1"},
+		"fractional iops": {"write-iops": "1.5"},
+		"invalid bps":     {"write-bps": "fast"},
+		"negative bps":    {"write-bps": "-1M"},
+		"read-only":       {"write-iops": "100", "readonly": "true"},
+		"tmpfs upperdir":  {"write-bps": "1M", "volatile": "true", "tmpfs-size": "1M"},
+	} {
+		options["base"] = t.TempDir()
+		if err := d.Create(&volume.CreateRequest{Name: "invalid", Options: options}); err == nil {
+			t.Errorf("%s: the volume was created", name)
+			if err := d.Remove(&volume.RemoveRequest{Name: "invalid"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// hasCgroupV2IO tells whether the write limits can be set on this host.
+func hasCgroupV2IO() bool {
+	var fsStat unix.Statfs_t
+	if err := unix.Statfs(cgroupRootDir, &fsStat); err != nil || fsStat.Type != unix.CGROUP2_SUPER_MAGIC {
+		return false
+	}
+	controllers, err := os.ReadFile(cgroupRootDir + "cgroup.controllers")
+	return err == nil && slices.Contains(strings.Fields(string(controllers)), "io")
+}
+
+func TestWriteLimitsSetUpOnMount(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":       t.TempDir(),
+		"write-iops": "100",
+		"write-bps":  "1M",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.Mount(&volume.MountRequest{Name: "vol", ID: "container"})
+	if !hasCgroupV2IO() {
+		// The volume is never mounted without its limits
+		if err == nil || !strings.Contains(err.Error(), "write limits") {
+			t.Fatalf("Without cgroup v2, Mount = %v, want a write limits error", err)
+		}
+		if _, ok := m.Mounted(d.mountpointdir("vol")); ok {
+			t.Error("The overlay was mounted although the write limits could not be set up")
+		}
+		if entries, err := os.ReadDir(d.activemountsdir("vol")); err != nil || len(entries) != 0 {
+			t.Errorf("The active mounts are %v, %v; want none", entries, err)
+		}
+		return
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	limits, err := os.ReadFile(ioCgroupDir("vol") + "io.max")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(limits), "wbps=1048576") || !strings.Contains(string(limits), "wiops=100") {
+		t.Errorf("io.max is %q, want wbps=1048576 and wiops=100", limits)
+	}
+	// The second container uses the same cgroup
+	MustMountVolume(t, d, "vol", "another")
+	MustUnmountVolume(t, d, "vol", "another")
+	if _, err := os.Stat(ioCgroupDir("vol")); err != nil {
+		t.Errorf("The cgroup was removed while the volume is still in use: %v", err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+	if _, err := os.Stat(ioCgroupDir("vol")); !os.IsNotExist(err) {
+		t.Errorf("After Unmount, the cgroup still exists (%v)", err)
+	}
+}
+
+func TestWholeDiskOf(t *testing.T) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat("/proc", &stat); err != nil {
+		t.Fatal(err)
+	}
+	if disk, err := wholeDiskOf(stat.Dev); err == nil {
+		t.Errorf("wholeDiskOf of procfs = %s, want an error", disk)
+	}
+
+	entries, err := os.ReadDir("/sys/dev/block")
+	if err != nil {
+		t.Skipf("No block devices: %v", err)
+	}
+	for _, entry := range entries {
+		var major, minor uint32
+		if _, err := fmt.Sscanf(entry.Name(), "%d:%d", &major, &minor); err != nil {
+			t.Fatalf("Unexpected block device %s: %v", entry.Name(), err)
+		}
+		disk, err := wholeDiskOf(unix.Mkdev(major, minor))
+		if err != nil {
+			t.Errorf("wholeDiskOf(%s) failed: %v", entry.Name(), err)
+			continue
+		}
+		_, err = os.Stat("/sys/dev/block/" + entry.Name() + "/partition")
+		if isPartition := err == nil; !isPartition && disk != entry.Name() {
+			t.Errorf("wholeDiskOf(%s) = %s, want the disk itself", entry.Name(), disk)
+		} else if isPartition {
+			// The partition's disk, which is not a partition itself
+			_, err := os.Stat("/sys/dev/block/" + disk + "/partition")
+			if disk == entry.Name() || !os.IsNotExist(err) {
+				t.Errorf("wholeDiskOf(%s) = %s, want the disk of the partition (%v)", entry.Name(), disk, err)
+			}
+		}
+	}
+}
+
+func TestWriteLimitsWithOverlay(t *testing.T) {
+	if !hasCgroupV2IO() {
+		t.Skip("The write limits require the cgroup v2 io controller")
+	}
+	d := NewTestDockerOnTopWithOverlay(t)
+	const limit = 1 << 20
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":      t.TempDir(),
+		"write-bps": "1M",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	defer MustUnmountVolume(t, d, "vol", "container")
+
+	// Like a container run with `--cgroup-parent`, the writer joins the volume's cgroup. The direct writes aren't
+	// absorbed by the page cache, so they are throttled right away
+	cgroup := ioCgroupDir("vol")
+	start := time.Now()
+	output, err := exec.Command("sh", "-c", `echo $$ > "$1"cgroup.procs && exec dd if=/dev/zero of="$2"file `+
+		"bs=64k count=48 oflag=direct conv=fsync", "sh", cgroup, mountpoint).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to write in the cgroup: %v: %s", err, output)
+	}
+	// 3M at 1M/s, with the bursts allowed by the kernel
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("Writing %d bytes took %v, want the writes throttled to %d B/s", 48<<16, elapsed, limit)
+	}
+	if info, err := os.Stat(mountpoint + "file"); err != nil || info.Size() != 48<<16 {
+		t.Errorf("The written file is %v, %v; want %d bytes", info, err, 48<<16)
+	}
+}
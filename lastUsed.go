@@ -0,0 +1,106 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// lastUsedQueueSize is the capacity of the queue of pending `LastUsedAt` updates. If the queue is full, new updates
+	// are dropped.
+	lastUsedQueueSize = 64
+	// lastUsedCoalesceInterval is how long the updates are accumulated before being written, so that many mounts of the
+	// same volume in a short period of time result in a single write.
+	lastUsedCoalesceInterval = time.Second
+)
+
+type lastUsedUpdate struct {
+	volumeName string
+	at         time.Time
+}
+
+// markVolumeUsed schedules an update of the volume's `LastUsedAt` timestamp to the current time. It never blocks: if
+// the queue of pending updates is full, the update is dropped.
+func (d *DockerOnTop) markVolumeUsed(volumeName string) {
+	select {
+	case d.lastUsedUpdates <- lastUsedUpdate{volumeName: volumeName, at: time.Now()}:
+	default:
+		d.logger.Debug("The queue of last-used updates is full. Dropping the update", "volume", volumeName)
+	}
+}
+
+// lastUsedWriter consumes the updates scheduled by `markVolumeUsed` and writes them to the volumes' metadata. It is
+// meant to be run in the background (see `goBackground`) for the whole lifetime of the plugin. Once `d.ctx` is done,
+// the updates queued so far are written and it returns.
+func (d *DockerOnTop) lastUsedWriter() {
+	for {
+		pending := map[string]time.Time{}
+		select {
+		case update := <-d.lastUsedUpdates:
+			pending[update.volumeName] = update.at
+
+			timeout := time.After(lastUsedCoalesceInterval)
+		coalesce:
+			for {
+				select {
+				case update := <-d.lastUsedUpdates:
+					mergeLastUsed(pending, update)
+				case <-timeout:
+					break coalesce
+				case <-d.ctx.Done():
+					break coalesce // The rest of the queue is handled on the next iteration
+				}
+			}
+		case <-d.ctx.Done():
+			for len(d.lastUsedUpdates) > 0 {
+				mergeLastUsed(pending, <-d.lastUsedUpdates)
+			}
+			d.writeLastUsedAll(pending)
+			return
+		}
+		d.writeLastUsedAll(pending)
+	}
+}
+
+// mergeLastUsed adds the update to the pending ones, unless there's a later one for the same volume already.
+func mergeLastUsed(pending map[string]time.Time, update lastUsedUpdate) {
+	if update.at.After(pending[update.volumeName]) {
+		pending[update.volumeName] = update.at
+	}
+}
+
+func (d *DockerOnTop) writeLastUsedAll(pending map[string]time.Time) {
+	for volumeName, at := range pending {
+		d.writeLastUsed(volumeName, at)
+	}
+}
+
+// writeLastUsed sets the volume's `LastUsedAt` to `at` (unless it is already later). The volume is locked while the
+// metadata is being updated. Errors are logged, not returned: the timestamp is best-effort.
+func (d *DockerOnTop) writeLastUsed(volumeName string, at time.Time) {
+	if _, err := d.getVolumeInfo(volumeName); err != nil {
+		// Most likely, the volume has been removed in the meantime
+		d.logger.Debug("Not updating the last-used time of the volume", "volume", volumeName, "error", err)
+		return
+	}
+
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		// The error is already logged by `lockVolume`
+		return
+	}
+	defer unlock()
+
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		d.logger.Warn("Failed to read metadata of the volume to update its last-used time", "volume", volumeName,
+			"error", err)
+		return
+	}
+	if !at.After(thisVol.LastUsedAt) {
+		return
+	}
+	thisVol.LastUsedAt = at
+	if err := d.writeVolumeInfo(volumeName, thisVol); err != nil {
+		d.logger.Warn("Failed to update the last-used time of the volume", "volume", volumeName, "error", err)
+	}
+}
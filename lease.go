@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// volumeLease is the content of a volume's lease file (see `DockerOnTop.AcquireLease`)
+type volumeLease struct {
+	// LeaseID is the ID of the only container allowed to mount the volume
+	LeaseID string `json:"leaseId"`
+	// ExpiresAt is the time the lease stops being in effect at
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// leaseHeldError is returned by `Mount` (and `AcquireLease`) if the volume is leased to someone else
+type leaseHeldError struct {
+	leaseID   string
+	expiresAt time.Time
+}
+
+func (e *leaseHeldError) Error() string {
+	return fmt.Sprintf("the volume is leased to %s until %s", e.leaseID, e.expiresAt.Format(time.RFC3339))
+}
+
+// leasePath returns the path of the volume's lease file.
+func (d *DockerOnTop) leasePath(volumeName string) string {
+	return d.volumeDir(volumeName) + "/lease"
+}
+
+// readLease returns the volume's lease, or nil if it has none or it has expired.
+func (d *DockerOnTop) readLease(volumeName string) (*volumeLease, error) {
+	payload, err := os.ReadFile(d.leasePath(volumeName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var lease volumeLease
+	if err := json.Unmarshal(payload, &lease); err != nil {
+		return nil, fmt.Errorf("malformed lease file: %w", err)
+	}
+	if !time.Now().Before(lease.ExpiresAt) {
+		return nil, nil
+	}
+	return &lease, nil
+}
+
+// AcquireLease gives the container (the mount ID) `leaseID` exclusive use of the volume for `ttl`, e.g. for a batch
+// job: until the lease expires or is released with `ReleaseLease`, `Mount` refuses the other containers. The lease is
+// stored in the `lease` file in the volume's main directory.
+//
+// The lease cannot be acquired while the volume is leased to someone else or used by other containers. Acquiring the
+// lease again with the same `leaseID` extends it.
+func (d *DockerOnTop) AcquireLease(volumeName, leaseID string, ttl time.Duration) error {
+	d.logger.Debug("Request AcquireLease", "volume", volumeName, "leaseId", leaseID, "ttl", ttl)
+
+	if _, err := d.getVolumeInfoOrNotFound(volumeName); err != nil {
+		return err
+	}
+	if leaseID == "" {
+		return errors.New("the lease ID cannot be empty")
+	} else if ttl <= 0 {
+		return fmt.Errorf("the lease duration must be positive, got %v", ttl)
+	}
+
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
+		return err
+	}
+	defer unlock()
+
+	if lease, err := d.readLease(volumeName); err != nil {
+		return d.internalError("failed to read the volume's lease", err, "volume", volumeName)
+	} else if lease != nil && lease.LeaseID != leaseID {
+		return &leaseHeldError{leaseID: lease.LeaseID, expiresAt: lease.ExpiresAt}
+	}
+	activemounts, err := d.readActivemounts(volumeName, -1)
+	if err != nil {
+		return d.internalError("failed to list activemounts/", err, "volume", volumeName)
+	}
+	for _, activemount := range activemounts {
+		if activemount.Name() != leaseID {
+			return fmt.Errorf("the volume is in use by another container (%s)", activemount.Name())
+		}
+	}
+
+	lease := volumeLease{LeaseID: leaseID, ExpiresAt: time.Now().Add(ttl)}
+	payload, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	if err := atomicWriteFile(d.leasePath(volumeName), payload, 0o644); err != nil {
+		return d.internalError("failed to write the volume's lease", err, "volume", volumeName)
+	}
+	d.logger.Info("Leased the volume", "volume", volumeName, "leaseId", leaseID, "expiresAt", lease.ExpiresAt)
+	return nil
+}
+
+// ReleaseLease ends the lease `leaseID` of the volume before it expires (see `AcquireLease`). Releasing a lease that
+// has expired already is not an error, but releasing someone else's is.
+func (d *DockerOnTop) ReleaseLease(volumeName, leaseID string) error {
+	d.logger.Debug("Request ReleaseLease", "volume", volumeName, "leaseId", leaseID)
+
+	if _, err := d.getVolumeInfoOrNotFound(volumeName); err != nil {
+		return err
+	}
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
+		return err
+	}
+	defer unlock()
+
+	lease, err := d.readLease(volumeName)
+	if err != nil {
+		d.logger.Warn("Failed to read the lease of the volume. Removing it anyway", "volume", volumeName, "error", err)
+	} else if lease != nil && lease.LeaseID != leaseID {
+		return &leaseHeldError{leaseID: lease.LeaseID, expiresAt: lease.ExpiresAt}
+	}
+	if err := os.Remove(d.leasePath(volumeName)); err != nil && !os.IsNotExist(err) {
+		return d.internalError("failed to remove the volume's lease", err, "volume", volumeName)
+	}
+	d.logger.Info("Released the lease of the volume", "volume", volumeName, "leaseId", leaseID)
+	return nil
+}
+
+// checkLease returns `*leaseHeldError` if the volume is leased to a container other than `id`. A lease file that
+// cannot be read is logged and ignored, so that it doesn't make the volume unusable. The caller is expected to hold
+// the volume's lock.
+func (d *DockerOnTop) checkLease(volumeName string, id string) error {
+	lease, err := d.readLease(volumeName)
+	if err != nil {
+		d.logger.Warn("Failed to read the lease of the volume. Ignoring it", "volume", volumeName, "error", err)
+		return nil
+	}
+	if lease != nil && lease.LeaseID != id {
+		d.logger.Info("Refusing to mount the volume leased to another container", "volume", volumeName, "id", id,
+			"leaseId", lease.LeaseID)
+		return &leaseHeldError{leaseID: lease.LeaseID, expiresAt: lease.ExpiresAt}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+//go:build dottest
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// readLeaseFile returns the contents of the volume's lease file.
+func readLeaseFile(t *testing.T, d *DockerOnTop, volumeName string) volumeLease {
+	t.Helper()
+	payload, err := os.ReadFile(d.leasePath(volumeName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lease volumeLease
+	if err := json.Unmarshal(payload, &lease); err != nil {
+		t.Fatal(err)
+	}
+	return lease
+}
+
+func TestLease(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+
+	start := time.Now()
+	if err := d.AcquireLease("vol", "batch", time.Hour); err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+	lease := readLeaseFile(t, d, "vol")
+	if lease.LeaseID != "batch" || lease.ExpiresAt.Before(start.Add(time.Hour)) ||
+		lease.ExpiresAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("The lease is %+v, want batch's for an hour", lease)
+	}
+
+	var leaseErr *leaseHeldError
+	_, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "other"})
+	if !errors.As(err, &leaseErr) || !strings.Contains(err.Error(), "leased to batch") {
+		t.Errorf("During the lease, Mount by another container = %v, want a lease error", err)
+	}
+	MustMountVolume(t, d, "vol", "batch")
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "other"}); !errors.As(err, &leaseErr) {
+		t.Errorf("While the lease holder uses the volume, Mount by another container = %v, want a lease error", err)
+	}
+	summaries, err := d.ListActiveMounts()
+	if err != nil || len(summaries) != 1 || summaries[0].ContainerID != "batch" {
+		t.Errorf("The active mounts are %+v, %v; want batch's only", summaries, err)
+	}
+
+	// Only the holder can extend or release the lease
+	if err := d.AcquireLease("vol", "other", time.Hour); !errors.As(err, &leaseErr) {
+		t.Errorf("AcquireLease by another container = %v, want a lease error", err)
+	}
+	if err := d.ReleaseLease("vol", "other"); !errors.As(err, &leaseErr) {
+		t.Errorf("ReleaseLease by another container = %v, want a lease error", err)
+	}
+	if err := d.AcquireLease("vol", "batch", 2*time.Hour); err != nil {
+		t.Errorf("Extending the lease failed: %v", err)
+	} else if extended := readLeaseFile(t, d, "vol"); !extended.ExpiresAt.After(lease.ExpiresAt) {
+		t.Errorf("The extended lease expires at %v, want after %v", extended.ExpiresAt, lease.ExpiresAt)
+	}
+	if err := d.ReleaseLease("vol", "batch"); err != nil {
+		t.Fatalf("ReleaseLease failed: %v", err)
+	}
+	if _, err := os.Stat(d.leasePath("vol")); !os.IsNotExist(err) {
+		t.Errorf("After ReleaseLease, the lease file exists (%v)", err)
+	}
+	MustMountVolume(t, d, "vol", "other")
+
+	// The lease is refused while others use the volume
+	if err := d.AcquireLease("vol", "batch", time.Hour); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("While other containers use the volume, AcquireLease = %v, want an error", err)
+	}
+	MustUnmountVolume(t, d, "vol", "other")
+	MustUnmountVolume(t, d, "vol", "batch")
+	if err := d.ReleaseLease("vol", "batch"); err != nil {
+		t.Errorf("Releasing a released lease failed: %v", err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	const ttl = 100 * time.Millisecond
+	if err := d.AcquireLease("vol", "batch", ttl); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	var leaseErr *leaseHeldError
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "other"}); !errors.As(err, &leaseErr) {
+		if time.Since(start) < ttl {
+			t.Errorf("During the lease, Mount by another container = %v, want a lease error", err)
+		}
+	}
+
+	time.Sleep(ttl)
+	// The expired lease is left behind, but ignored
+	MustMountVolume(t, d, "vol", "other")
+	if err := d.AcquireLease("vol", "another", time.Hour); err == nil {
+		t.Error("AcquireLease succeeded while another container uses the volume")
+	}
+	MustUnmountVolume(t, d, "vol", "other")
+	if err := d.AcquireLease("vol", "another", time.Hour); err != nil {
+		t.Errorf("After the lease expired, AcquireLease by another container failed: %v", err)
+	}
+	if err := d.ReleaseLease("vol", "another"); err != nil {
+		t.Error(err)
+	}
+
+	// Releasing an expired lease is not an error
+	if err := d.AcquireLease("vol", "batch", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := d.ReleaseLease("vol", "another"); err != nil {
+		t.Errorf("Releasing an expired lease failed: %v", err)
+	}
+}
+
+func TestLeaseConcurrentMounts(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	if err := d.AcquireLease("vol", "container0", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Whichever mount leads, only the lease holder's succeeds
+	const containers = 10
+	var wg sync.WaitGroup
+	errs := make([]error, containers)
+	for i := 0; i < containers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = d.Mount(&volume.MountRequest{Name: "vol", ID: fmt.Sprintf("container%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	var leaseErr *leaseHeldError
+	if errs[0] != nil {
+		t.Errorf("Mount by the lease holder failed: %v", errs[0])
+	}
+	for i := 1; i < containers; i++ {
+		if !errors.As(errs[i], &leaseErr) {
+			t.Errorf("Mount by container%d = %v, want a lease error", i, errs[i])
+		}
+	}
+	summaries, err := d.ListActiveMounts()
+	if err != nil || len(summaries) != 1 || summaries[0].ContainerID != "container0" {
+		t.Errorf("The active mounts are %+v, %v; want the lease holder's only", summaries, err)
+	}
+	MustUnmountVolume(t, d, "vol", "container0")
+}
+
+func TestLeaseValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	for name, err := range map[string]error{
+		"empty ID":       d.AcquireLease("vol", "", time.Hour),
+		"zero TTL":       d.AcquireLease("vol", "batch", 0),
+		"negative TTL":   d.AcquireLease("vol", "batch", -time.Hour),
+		"missing volume": d.AcquireLease("missing", "batch", time.Hour),
+		"release":        d.ReleaseLease("missing", "batch"),
+	} {
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := os.Stat(d.leasePath("vol")); !os.IsNotExist(err) {
+		t.Errorf("The invalid leases left a lease file (%v)", err)
+	}
+
+	// A corrupted lease doesn't make the volume unusable
+	writeFiles(t, d.volumeDir("vol"), map[string]string{"lease": "{"})
+	MustMountVolume(t, d, "vol", "container")
+	MustUnmountVolume(t, d, "vol", "container")
+	if err := d.ReleaseLease("vol", "batch"); err != nil {
+		t.Errorf("Releasing a corrupted lease failed: %v", err)
+	}
+}
+
+func TestLeaseSubcommand(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	if _, err := captureStdout(t, runLease, d, "acquire", "vol", "batch", "2h"); err != nil {
+		t.Fatalf("lease acquire failed: %v", err)
+	}
+	if lease := readLeaseFile(t, d, "vol"); lease.LeaseID != "batch" || time.Until(lease.ExpiresAt) < time.Hour {
+		t.Errorf("The lease is %+v, want batch's for 2h", lease)
+	}
+	if _, err := captureStdout(t, runLease, d, "release", "vol", "other"); err == nil {
+		t.Error("lease release by another container succeeded")
+	}
+	if _, err := captureStdout(t, runLease, d, "release", "vol", "batch"); err != nil {
+		t.Errorf("lease release failed: %v", err)
+	}
+	if _, err := os.Stat(d.leasePath("vol")); !os.IsNotExist(err) {
+		t.Errorf("After lease release, the lease file exists (%v)", err)
+	}
+
+	for _, args := range [][]string{nil, {"acquire", "vol", "batch"}, {"release", "vol"}, {"renew", "vol", "batch"}} {
+		if _, err := captureStdout(t, runLease, d, args...); !errors.Is(err, errUsage) {
+			t.Errorf("lease %q = %v, want %v", args, err, errUsage)
+		}
+	}
+	if _, err := captureStdout(t, runLease, d, "acquire", "vol", "batch", "forever"); err == nil {
+		t.Error("lease acquire succeeded with an invalid TTL")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/go-connections/sockets"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// errDriverClosed is returned by the requests that would change the volumes' state after `Close` has been called
+var errDriverClosed = errors.New("docker-on-top is shutting down")
+
+// goBackground runs `fn` in a new goroutine that `Close` waits for. `fn` is expected to return once `d.ctx` is done.
+func (d *DockerOnTop) goBackground(fn func()) {
+	d.background.Add(1)
+	go func() {
+		defer d.background.Done()
+		fn()
+	}()
+}
+
+// addCloser registers a server or a listener to be closed by `Close`. If `Close` has already been called, it is closed
+// right away.
+func (d *DockerOnTop) addCloser(closer io.Closer) {
+	d.closersMutex.Lock()
+	defer d.closersMutex.Unlock()
+	if d.closed.Load() {
+		_ = closer.Close()
+		return
+	}
+	d.closers = append(d.closers, closer)
+}
+
+// Close shuts the driver down: the socket served with `ServeUnix` and the metrics server are closed, the background
+// activities (the last-used time updates, the volume watchers) are stopped, and Close waits for them to finish. The
+// pending last-used time updates are written before that. Logs are written synchronously, so there is nothing to
+// flush.
+//
+// After `Close`, no volumes can be created or mounted. The subsequent calls of `Close` only wait for the shutdown to
+// finish.
+func (d *DockerOnTop) Close() error {
+	d.closersMutex.Lock()
+	if d.closed.Swap(true) {
+		d.closersMutex.Unlock()
+		d.background.Wait() // Don't return before the first call does
+		return nil
+	}
+	closers := d.closers
+	d.closers = nil
+	d.closersMutex.Unlock()
+
+	d.logger.Info("Shutting down")
+	d.cancel()
+	var errs []error
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	d.background.Wait()
+	return errors.Join(errs...)
+}
+
+// ServeUnix serves the docker daemon at the given UNIX socket until `Close` is called (then nil is returned) or an
+// error occurs. The socket is created inaccessible and then given the permissions `perms`. The socket file is removed
+// afterwards.
+func (d *DockerOnTop) ServeUnix(socketPath string, perms SocketPermissions) error {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0o755); err != nil {
+		return err
+	}
+	listener, err := sockets.NewUnixSocketWithOpts(socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+	d.addCloser(listener)
+	if err := applySocketPermissions(socketPath, perms); err != nil {
+		return fmt.Errorf("failed to set the socket's permissions: %w", err)
+	}
+
+	d.logger.Info("Serving", "socket", socketPath, "mode", fmt.Sprintf("%04o", perms.Mode.Perm()), "uid", perms.UID,
+		"gid", perms.GID)
+	err = volume.NewHandler(d).Serve(listener)
+	if d.closed.Load() {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestClose(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	d, err := NewDockerOnTop(context.Background(), t.TempDir(), WithoutOverlayProbe(), WithLogger(newTestLogger(t)),
+		WithMockSyscallMount(NewMockSyscallMount()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustMountVolume(t, d, "vol", "container")
+	MustUnmountVolume(t, d, "vol", "container")
+
+	// Every kind of background activity
+	if err := d.StartMetricsServer(freeAddr(t)); err != nil {
+		t.Fatal(err)
+	}
+	events, err := d.WatchAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketPath := t.TempDir() + "/plugin.sock"
+	served := make(chan error)
+	go func() {
+		served <- d.ServeUnix(socketPath, SocketPermissions{Mode: 0o600, UID: os.Getuid(), GID: os.Getgid()})
+	}()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(socketPath); err == nil {
+			break
+		} else if time.Since(start) > 5*time.Second {
+			t.Fatal("The socket was not created")
+		}
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ServeUnix returned %v after Close", err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("The volume watcher was not stopped")
+	}
+	// The last-used time recorded on the unmount is written before Close returns
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.LastUsedAt.IsZero() {
+		t.Errorf("After Close, LastUsedAt = %v, %v", vol.LastUsedAt, err)
+	}
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"}); !errors.Is(err, errDriverClosed) {
+		t.Errorf("Mount after Close returned %v, want %v", err, errDriverClosed)
+	}
+	err = d.Create(&volume.CreateRequest{Name: "new", Options: map[string]string{"base": t.TempDir()}})
+	if !errors.Is(err, errDriverClosed) {
+		t.Errorf("Create after Close returned %v, want %v", err, errDriverClosed)
+	}
+	if err := d.Close(); err != nil {
+		t.Errorf("The second Close failed: %v", err)
+	}
+
+	// No goroutines are leaked (the ones of the closed connections may take a moment to exit)
+	for start := time.Now(); runtime.NumGoroutine() > goroutines; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines are leaked:\n%s", runtime.NumGoroutine()-goroutines, buf[:runtime.Stack(buf, true)])
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LockManager provides the per-volume locks that serialize the operations on a volume (mounts, unmounts, resets, and
+// so on). The key is the volume name. The default one locks the volume's activemounts/ directory (see
+// `dirLockManager`); set another one with `WithLockManager`, e.g. `FileLockManager` where locking directories is
+// unreliable, like on NFS.
+//
+// All the processes working with the same dot root directory (the plugin and the command-line subcommands) must use
+// the same kind of locks.
+type LockManager interface {
+	// Lock takes the exclusive lock of `key`, blocking until it is available. The returned function releases it
+	Lock(key string) (unlock func(), err error)
+	// TryLock is like `Lock` but waits for at most `timeout`, returning `ErrLockTimeout` if the lock is still taken
+	// by then
+	TryLock(key string, timeout time.Duration) (unlock func(), err error)
+}
+
+// WithLockManager makes the driver lock the volumes with `locks` instead of locking their activemounts/ directories.
+func WithLockManager(locks LockManager) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.locks = locks
+	}
+}
+
+// dirLockManager is the default `LockManager`: the lock of a volume is the `flock` of its activemounts/ directory
+type dirLockManager struct {
+	d *DockerOnTop
+}
+
+var _ LockManager = (*dirLockManager)(nil)
+
+// Lock implements `LockManager.Lock`.
+func (m *dirLockManager) Lock(volumeName string) (func(), error) {
+	return m.TryLock(volumeName, -1)
+}
+
+// TryLock implements `LockManager.TryLock`. A negative `timeout` makes it block like `Lock`.
+func (m *dirLockManager) TryLock(volumeName string, timeout time.Duration) (func(), error) {
+	activemountsdir := lockedFile{logger: m.d.logger}
+	if err := activemountsdir.OpenTimeout(m.d.activemountsdir(volumeName), timeout); err != nil {
+		return nil, err
+	}
+	// The errors are logged by `Close`
+	return func() { _ = activemountsdir.Close() }, nil
+}
+
+// FileLockManager is a `LockManager` that locks (with `flock`) a dedicated `<key>.lock` file in its directory for
+// every key. Unlike the directories the default one locks, regular files can be locked reliably on NFS (where `flock`
+// is emulated with POSIX locks). The lock files are never removed, as removing a lock file someone waits for would let
+// two processes hold the "same" lock.
+type FileLockManager struct {
+	// dir is the directory of the lock files
+	dir string
+}
+
+var _ LockManager = (*FileLockManager)(nil)
+
+// NewFileLockManager returns a `FileLockManager` keeping the lock files in `dir`, which is created if it doesn't
+// exist. The directory must not be inside the dot root directory (it would be taken for a volume).
+func NewFileLockManager(dir string) (*FileLockManager, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileLockManager{dir: strings.TrimSuffix(dir, "/") + "/"}, nil
+}
+
+// Lock implements `LockManager.Lock`.
+func (m *FileLockManager) Lock(key string) (func(), error) {
+	return m.TryLock(key, -1)
+}
+
+// TryLock implements `LockManager.TryLock`. A negative `timeout` makes it block like `Lock`.
+func (m *FileLockManager) TryLock(key string, timeout time.Duration) (func(), error) {
+	if key == "" || strings.ContainsRune(key, '/') {
+		return nil, fmt.Errorf("invalid lock key %q", key)
+	}
+	f, err := os.OpenFile(m.dir+key+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := flockTimeout(f, timeout); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return func() {
+		// Closing the file would release the lock anyway
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
+
+// lockVolume takes the exclusive lock of the volume (see `LockManager`), blocking. It must be held while the volume's
+// activemounts/ directory or metadata are modified, or when they must not change under the caller. The returned
+// function releases the lock.
+//
+// Errors are logged and the returned error is wrapped with `internalError`.
+func (d *DockerOnTop) lockVolume(volumeName string) (func(), error) {
+	unlock, err := d.locks.Lock(volumeName)
+	if err != nil {
+		return nil, d.internalError("failed to lock the volume", err, "volume", volumeName)
+	}
+	return unlock, nil
+}
+
+// readActivemounts lists the volume's activemounts/ directory like `os.File.ReadDir` does: at most `n` entries (all of
+// them if `n` is not positive), and `io.EOF` if there are none and `n` is positive. The caller is expected to hold the
+// volume's lock (see `lockVolume`).
+func (d *DockerOnTop) readActivemounts(volumeName string, n int) ([]os.DirEntry, error) {
+	dir, err := os.Open(d.activemountsdir(volumeName))
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.ReadDir(n)
+}
@@ -0,0 +1,212 @@
+//go:build dottest
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// lockManagers returns the default and the file lock managers, both able to lock the volumes "vol" and "other".
+func lockManagers(t *testing.T) map[string]LockManager {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustCreateVolume(t, d, "other", t.TempDir())
+	files, err := NewFileLockManager(t.TempDir() + "/locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]LockManager{"dir": d.locks, "file": files}
+}
+
+func TestLockManagerExclusion(t *testing.T) {
+	for name, locks := range lockManagers(t) {
+		const workers, iterations = 8, 20
+		// The race detector doesn't know about flock, so the exclusion is observed with atomics (the race detector
+		// still checks the lock managers themselves)
+		var holders, acquired atomic.Int32
+		var overlapped atomic.Bool
+		var wg sync.WaitGroup
+		errs := make([]error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < iterations; j++ {
+					unlock, err := locks.Lock("vol")
+					if err != nil {
+						errs[i] = err
+						return
+					}
+					if holders.Add(1) != 1 {
+						overlapped.Store(true)
+					}
+					acquired.Add(1)
+					runtime.Gosched()
+					holders.Add(-1)
+					unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			t.Errorf("%s: Lock failed: %v", name, err)
+		}
+		if overlapped.Load() {
+			t.Errorf("%s: the lock was held by several goroutines at once", name)
+		}
+		if n := acquired.Load(); n != workers*iterations {
+			t.Errorf("%s: the lock was taken %d times, want %d", name, n, workers*iterations)
+		}
+	}
+}
+
+func TestLockManagerTryLock(t *testing.T) {
+	for name, locks := range lockManagers(t) {
+		unlock, err := locks.Lock("vol")
+		if err != nil {
+			t.Fatalf("%s: Lock failed: %v", name, err)
+		}
+		start := time.Now()
+		if _, err := locks.TryLock("vol", 100*time.Millisecond); !errors.Is(err, ErrLockTimeout) {
+			t.Errorf("%s: while locked, TryLock = %v, want %v", name, err, ErrLockTimeout)
+		} else if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+			t.Errorf("%s: TryLock timed out after %v, want 100ms", name, elapsed)
+		}
+		if _, err := locks.TryLock("vol", 0); !errors.Is(err, ErrLockTimeout) {
+			t.Errorf("%s: while locked, TryLock without waiting = %v, want %v", name, err, ErrLockTimeout)
+		}
+
+		// The other keys are independent
+		if unlockOther, err := locks.TryLock("other", 0); err != nil {
+			t.Errorf("%s: TryLock of another key failed: %v", name, err)
+		} else {
+			unlockOther()
+		}
+
+		// A waiting TryLock gets the lock once it's released
+		start = time.Now()
+		go func(unlock func()) {
+			time.Sleep(50 * time.Millisecond)
+			unlock()
+		}(unlock)
+		unlock, err = locks.TryLock("vol", 5*time.Second)
+		if err != nil {
+			t.Fatalf("%s: after the lock was released, TryLock failed: %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 2*time.Second {
+			t.Errorf("%s: TryLock took the lock after %v, want about 50ms", name, elapsed)
+		}
+		unlock()
+	}
+}
+
+func TestFileLockManager(t *testing.T) {
+	dir := t.TempDir() + "/nested/locks"
+	locks, err := NewFileLockManager(dir)
+	if err != nil {
+		t.Fatalf("NewFileLockManager failed: %v", err)
+	}
+	for _, key := range []string{"", "../escape", "a/b"} {
+		if _, err := locks.Lock(key); err == nil {
+			t.Errorf("Lock(%q) succeeded", key)
+		}
+	}
+
+	unlock, err := locks.Lock("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	// The lock file is kept, so that another process waiting for it isn't left with a removed file
+	if info, err := os.Stat(dir + "/vol.lock"); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("The lock file is %v, %v; want a regular file", info, err)
+	}
+
+	// Another instance (like the one of a subcommand) uses the same locks
+	another, err := NewFileLockManager(dir + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = locks.Lock("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := another.TryLock("vol", 0); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("TryLock by another manager = %v, want %v", err, ErrLockTimeout)
+	}
+	unlock()
+	if unlock, err := another.TryLock("vol", 0); err != nil {
+		t.Errorf("After unlocking, TryLock by another manager failed: %v", err)
+	} else {
+		unlock()
+	}
+}
+
+func TestWithLockManager(t *testing.T) {
+	dir := t.TempDir()
+	locks, err := NewFileLockManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m), WithLockManager(locks))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+
+	const containers = 10
+	var wg sync.WaitGroup
+	errs := make([]error, containers)
+	for i := 0; i < containers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = d.Mount(&volume.MountRequest{Name: "vol", ID: fmt.Sprintf("container%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Mount %d failed: %v", i, err)
+		}
+	}
+	if summaries, err := d.ListActiveMounts(); err != nil || len(summaries) != containers {
+		t.Errorf("The active mounts are %+v, %v; want %d", summaries, err, containers)
+	}
+	if _, err := os.Stat(dir + "/vol.lock"); err != nil {
+		t.Errorf("The volume was not locked with the lock manager: %v", err)
+	}
+
+	// The driver waits for the lock held by someone else
+	unlock, err := locks.Lock("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmounted := make(chan error)
+	go func() {
+		unmounted <- d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "container0"})
+	}()
+	select {
+	case err := <-unmounted:
+		t.Fatalf("Unmount returned while the volume was locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err := <-unmounted; err != nil {
+		t.Errorf("Unmount failed: %v", err)
+	}
+
+	for i := 1; i < containers; i++ {
+		MustUnmountVolume(t, d, "vol", fmt.Sprintf("container%d", i))
+	}
+	if _, ok := m.Mounted(d.VolumeMountpointDir("vol")); ok {
+		t.Error("The overlay is still mounted after all the containers unmounted")
+	}
+}
@@ -1,33 +1,47 @@
 package main
 
 import (
+	"errors"
+	"log/slog"
 	"os"
 	"syscall"
+	"time"
 )
 
+// ErrLockTimeout is returned by `LockManager.TryLock` if the lock could not be taken in time
+var ErrLockTimeout = errors.New("timed out waiting for the lock")
+
+// lockPollInterval is how often a lock that is busy is retried by the locks with a timeout
+const lockPollInterval = 10 * time.Millisecond
+
 // lockedFile is a wrapper around `os.File` that adds `.Open()` and overrides `.Close()` methods so that the
-// underlying file is exclusively locked (via `flock(..., LOCK_EX)`) when accessed.
+// underlying file is locked (via `flock`) when accessed.
 type lockedFile struct {
 	*os.File
+	// logger receives the errors of unlocking the file
+	logger *slog.Logger
 }
 
 // Open opens the file as in `os.Open` and locks the file in exclusive mode via `flock(..., LOCK_EX)`,
 // possibly blocking.
 //
-// If an error occurs in either step, it is reported and the internals are cleaned up (i.e. no need for the caller to
+// If an error occurs in either step, it is returned and the internals are cleaned up (i.e. no need for the caller to
 // call `.Close()`), otherwise the object must be `.Close()`d to release the lock and the file descriptor.
 func (lf *lockedFile) Open(path string) error {
+	return lf.OpenTimeout(path, -1)
+}
+
+// OpenTimeout is like `.Open()` but waits for the lock for at most `timeout` (unless it's negative), returning
+// `ErrLockTimeout` if the file is still locked by then.
+func (lf *lockedFile) OpenTimeout(path string, timeout time.Duration) error {
 	var err error
 	lf.File, err = os.Open(path)
 	if err != nil {
-		log.Errorf("Failed to Open: %v", err)
-		return internalError("failed to Open inside lockedFile", err)
+		return err
 	}
-	err = syscall.Flock(int(lf.File.Fd()), syscall.LOCK_EX)
-	if err != nil {
-		log.Errorf("Failed to get exclusive lock on %s: %v", lf.File.Name(), err)
+	if err := flockTimeout(lf.File, timeout); err != nil {
 		lf.File.Close() // An error is going to be returned, so the caller won't call `.Close()`
-		return internalError("failed to get exclusive Flock", err)
+		return err
 	}
 	return nil
 }
@@ -39,8 +53,28 @@ func (lf *lockedFile) Close() error {
 	defer lf.File.Close()
 	err := syscall.Flock(int(lf.File.Fd()), syscall.LOCK_UN)
 	if err != nil {
-		log.Criticalf("Failed to release lock on %s: %v", lf.File.Name(), err)
+		logCritical(lf.logger, "Failed to release lock", "path", lf.File.Name(), "error", err)
 		return err
 	}
 	return nil
 }
+
+// flockTimeout locks the file in exclusive mode via `flock`. If `timeout` is negative, it blocks until the lock is
+// taken; otherwise, the lock is retried every `lockPollInterval` until `timeout` passes, and then `ErrLockTimeout` is
+// returned.
+func flockTimeout(f *os.File, timeout time.Duration) error {
+	if timeout < 0 {
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
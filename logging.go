@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// levelCritical is the level of the messages about the situations that require a human interaction
+const levelCritical = slog.LevelError + 4
+
+// logLevel is the minimum level of the messages that are logged (debug by default). It can be changed at any time
+var logLevel = func() *slog.LevelVar {
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelDebug)
+	return level
+}()
+
+// defaultLogger is the logger of the drivers created without `WithLogger`, also used by the code that runs without a
+// driver (like the startup of the plugin). It writes JSON to the standard error unless changed by `setupLogger`
+var defaultLogger = newLogger(os.Stderr, "json")
+
+// newLogger creates a logger writing to `w` in the given format ("json" or "text"), honoring `logLevel`.
+func newLogger(w io.Writer, format string) *slog.Logger {
+	options := &slog.HandlerOptions{
+		Level: logLevel,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.LevelKey && len(groups) == 0 {
+				if level, ok := attr.Value.Any().(slog.Level); ok && level == levelCritical {
+					attr.Value = slog.StringValue("CRITICAL")
+				}
+			}
+			return attr
+		},
+	}
+	if format == "text" {
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+// setupLogger replaces `defaultLogger` with the one in the given format ("json" or "text") and sets the log level
+// ("debug", "info", "warn", or "error").
+func setupLogger(format string, level string) error {
+	if format != "json" && format != "text" {
+		return fmt.Errorf("unknown log format %q (must be either \"json\" or \"text\")", format)
+	}
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q: %w", level, err)
+	}
+	defaultLogger = newLogger(os.Stderr, format)
+	return nil
+}
+
+// logCritical logs a message at the critical level (see `levelCritical`) with the given logger.
+func logCritical(logger *slog.Logger, msg string, args ...any) {
+	logger.Log(context.Background(), levelCritical, msg, args...)
+}
+
+// logCritical logs a message at the critical level with the driver's logger.
+func (d *DockerOnTop) logCritical(msg string, args ...any) {
+	logCritical(d.logger, msg, args...)
+}
@@ -0,0 +1,131 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// decodeLogEntries decodes the JSON log entries, one per line.
+func decodeLogEntries(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	if strings.TrimSpace(logs.String()) == "" {
+		return nil
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("The log line %q is not JSON: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestJSONLogging(t *testing.T) {
+	var logs bytes.Buffer
+	d := NewTestDockerOnTop(t, WithLogger(newLogger(&logs, "json")))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustMountVolume(t, d, "vol", "container")
+	MustUnmountVolume(t, d, "vol", "container")
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := decodeLogEntries(t, &logs)
+	for _, entry := range entries {
+		for _, key := range []string{"time", "level", "msg"} {
+			if _, ok := entry[key]; !ok {
+				t.Errorf("The log entry %v has no %q key", entry, key)
+			}
+		}
+	}
+	for _, request := range []string{"Create", "Mount", "Unmount", "Remove"} {
+		found := false
+		for _, entry := range entries {
+			if msg, _ := entry["msg"].(string); strings.HasPrefix(msg, "Request "+request) {
+				found = true
+				if entry["volume"] != "vol" || entry["level"] != "DEBUG" {
+					t.Errorf("The log entry of the %s request is %v", request, entry)
+				}
+				if id, ok := entry["id"]; (request == "Mount" || request == "Unmount") && (!ok || id != "container") {
+					t.Errorf("The log entry of the %s request has no container id: %v", request, entry)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("The %s request is not logged", request)
+		}
+	}
+}
+
+func TestCriticalLogLevel(t *testing.T) {
+	var logs bytes.Buffer
+	logCritical(newLogger(&logs, "json"), "Something is broken", "volume", "vol")
+	entries := decodeLogEntries(t, &logs)
+	if len(entries) != 1 || entries[0]["level"] != "CRITICAL" || entries[0]["volume"] != "vol" {
+		t.Errorf("The critical message is logged as %v", entries)
+	}
+
+	logs.Reset()
+	logCritical(newLogger(&logs, "text"), "Something is broken")
+	if !strings.Contains(logs.String(), "level=CRITICAL") {
+		t.Errorf("The critical message is logged as %q", logs.String())
+	}
+}
+
+func TestSetupLoggerValidation(t *testing.T) {
+	for _, args := range [][2]string{{"xml", "debug"}, {"json", "verbose"}} {
+		if err := setupLogger(args[0], args[1]); err == nil {
+			t.Errorf("setupLogger(%q, %q) succeeded", args[0], args[1])
+		}
+	}
+}
+
+func TestPerInstanceLogger(t *testing.T) {
+	var firstLogs, secondLogs bytes.Buffer
+	first := NewTestDockerOnTop(t, WithLogger(slog.New(slog.NewJSONHandler(&firstLogs,
+		&slog.HandlerOptions{Level: slog.LevelDebug}))))
+	second := NewTestDockerOnTop(t, WithLogger(slog.New(slog.NewJSONHandler(&secondLogs, nil))))
+	MustCreateVolume(t, first, "first", t.TempDir())
+	MustCreateVolume(t, second, "second", t.TempDir())
+	MustMountVolume(t, first, "first", "container")
+	MustUnmountVolume(t, first, "first", "container")
+	// The mock mounts make the drivers warn that the overlay is not in the mount table
+	MustMountVolume(t, second, "second", "container")
+	MustUnmountVolume(t, second, "second", "container")
+	err := second.Create(&volume.CreateRequest{Name: "invalid", Options: map[string]string{"no-such": "x"}})
+	if err == nil {
+		t.Fatal("Creating a volume with an invalid option succeeded")
+	}
+
+	// Each driver logs only its own operations, at the level of its logger
+	messages := map[string]bool{}
+	for _, entry := range decodeLogEntries(t, &firstLogs) {
+		if entry["volume"] != "first" {
+			t.Errorf("The first driver logged %v", entry)
+		}
+		msg, _ := entry["msg"].(string)
+		messages[msg] = true
+	}
+	for _, msg := range []string{"Request Create", "Request Mount", "Request Unmount"} {
+		if !messages[msg] {
+			t.Errorf("The first driver didn't log %q", msg)
+		}
+	}
+	for _, entry := range decodeLogEntries(t, &secondLogs) {
+		if entry["level"] == "DEBUG" || entry["volume"] == "first" {
+			t.Errorf("The second driver logged %v", entry)
+		}
+	}
+	if !strings.Contains(secondLogs.String(), `"level":"WARN","msg":"mount syscall returned success`) {
+		t.Errorf("The second driver didn't log the warning about its mount:\n%s", secondLogs.String())
+	}
+}
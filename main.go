@@ -1,43 +1,313 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"os"
-
-	"github.com/docker/go-plugins-helpers/volume"
-	"github.com/op/go-logging"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
-func initLogger() *logging.Logger {
-	// Define the log format
-	logFormat := logging.MustStringFormatter(
-		"%{color:reset}%{color}%{time:2006-01-02 15:04:05.000} ▶ %{level:.4s} %{message} [in %{shortfunc}]",
-	)
+// version is the version of the plugin. It is meant to be set at build time with
+// `go build -ldflags "-X main.version=..."`
+var version = "dev"
 
-	// Create a log backend that writes to standard error
-	backend := logging.NewLogBackend(os.Stderr, "", 0)
+func main() {
+	defaults := DefaultConfig()
+	configPath := flag.String("config", defaultConfigPath, "configuration file (TOML, or JSON if the name ends "+
+		"with .json). The flags given explicitly override the values from it")
+	dotRootDir := flag.String("dot-root-dir", defaults.DotRootDir, "the plugin's internal directory")
+	socketPath := flag.String("socket-path", defaults.SocketPath, "the UNIX socket to serve the docker daemon at")
+	allowNestedOverlay := flag.Bool("allow-nested-overlay", false, "allow base directories located on an overlay "+
+		"filesystem (requires kernel 5.11+)")
+	defaultVolatile := flag.Bool("default-volatile", false, "make the volumes created without the `volatile` "+
+		"option volatile")
+	defaultLazyUnmount := flag.Bool("default-lazy-unmount", false, "detach busy overlays lazily on unmount for "+
+		"the volumes created without the `lazy` option")
+	userXattr := flag.Bool("userxattr", false, "make the volumes created without the `userxattr` option use it "+
+		"(always the default when not running as root)")
+	fuseOverlayFallback := flag.Bool("fuse-overlay-fallback", false, "mount the overlays with fuse-overlayfs if "+
+		"the kernel doesn't support overlayfs")
+	dryRun := flag.Bool("dry-run", false, "validate the volume requests and log what would be done instead of "+
+		"doing it (for testing volume configurations)")
+	idempotentCreate := flag.Bool("idempotent-create", false, "make creating a volume that already exists succeed "+
+		"if it has the same options")
+	disableUsageReporting := flag.Bool("disable-usage-reporting", false, "don't report the disk usage of the "+
+		"volumes (saves walking their directories on every volume listing)")
+	allowedBasePrefixes := flag.String("allowed-base-prefixes", "", "colon-separated list of directories the "+
+		"base directories of new volumes must be located under (by default, any directory is allowed)")
+	deniedBasePrefixes := flag.String("denied-base-prefixes", "", "colon-separated list of directories the "+
+		"base directories of new volumes must not be located under (takes precedence over the allowed ones)")
+	basePrefixesConfig := flag.String("base-prefixes-config", "", "JSON file with the allowed and denied base "+
+		"directory prefixes (as `AllowedBasePrefixes` and `DeniedBasePrefixes` lists). Overrides the flags")
+	metricsAddr := flag.String("metrics-addr", "", "address (like `:9323`) to serve Prometheus metrics at, on the "+
+		"/metrics path (by default, metrics are not served)")
+	healthAddr := flag.String("health-addr", "", "address (like `:9324`) to serve the health endpoint at, on the "+
+		"/health path (by default, it is not served)")
+	auditLog := flag.String("audit-log", "", "file to append a JSON line to for every volume creation, removal, "+
+		"mount, and unmount (by default, no audit log is written)")
+	accessLogMaxSize := flag.Int64("access-log-max-size", 0, "size (in bytes) the volumes' access logs are "+
+		"rotated at (by default, 1 MiB)")
+	mountTimeout := flag.String("mount-timeout", "", "limit on the time mounting an overlay may take, like `30s` "+
+		"(the default)")
+	hookTimeout := flag.String("hook-timeout", "", "limit on the time a volume's hook (like `premount`) may run, "+
+		"like `30s` (the default)")
+	hooksDir := flag.String("hooks-dir", "", "directory the volumes' hooks (like `premount`) must be located in "+
+		"(by default, the volumes cannot have hooks)")
+	mountWatchdogInterval := flag.String("mount-watchdog-interval", "", "how often to check that the overlays of "+
+		"the volumes in use are still mounted, like `1m` (by default, no checks are made)")
+	bootConcurrency := flag.Int("boot-concurrency", 0, "number of volumes reset in parallel on startup (by "+
+		"default, the number of CPUs)")
+	permissiveBoot := flag.Bool("permissive-boot", false, "start even if some volumes fail to reset on startup "+
+		"(the failures are logged)")
+	metadataEtcdEndpoints := flag.String("metadata-etcd-endpoints", "", "comma-separated list of the etcd "+
+		"endpoints (like `http://10.0.0.1:2379`) to store the volumes' metadata in (by default, it is stored in "+
+		"the volumes' directories)")
+	metadataEtcdCAFile := flag.String("metadata-etcd-ca-file", "", "CA certificate to verify the etcd servers "+
+		"with (by default, the system's CAs)")
+	metadataEtcdCertFile := flag.String("metadata-etcd-cert-file", "", "client certificate to authenticate to "+
+		"etcd with (requires -metadata-etcd-key-file)")
+	metadataEtcdKeyFile := flag.String("metadata-etcd-key-file", "", "key of the etcd client certificate")
+	metadataEtcdUsername := flag.String("metadata-etcd-username", "", "etcd user to authenticate as (the "+
+		"password is taken from the configuration file or DOT_METADATA_ETCD_PASSWORD)")
+	lockDir := flag.String("lock-dir", "", "directory of the volumes' lock files, for the filesystems where "+
+		"locking directories is unreliable, like NFS (by default, the volumes' activemounts/ directories are locked)")
+	gcOnStart := flag.Bool("gc-on-start", false, "remove the leftovers of interrupted volume creations on startup")
+	logFormat := flag.String("log-format", defaults.LogFormat, "format of the log messages: `json` or text")
+	logLevelName := flag.String("log-level", defaults.LogLevel, "minimum level of the logged messages: debug, "+
+		"info, warn, or error")
+	flag.Usage = printUsage
+	flag.Parse()
 
-	// Apply the log format to the backend
-	backendFormatter := logging.NewBackendFormatter(backend, logFormat)
+	overrides := map[string]func(cfg *Config){
+		"dot-root-dir":            func(cfg *Config) { cfg.DotRootDir = *dotRootDir },
+		"socket-path":             func(cfg *Config) { cfg.SocketPath = *socketPath },
+		"allow-nested-overlay":    func(cfg *Config) { cfg.AllowNestedOverlay = *allowNestedOverlay },
+		"default-volatile":        func(cfg *Config) { cfg.DefaultVolatile = *defaultVolatile },
+		"default-lazy-unmount":    func(cfg *Config) { cfg.DefaultLazyUnmount = *defaultLazyUnmount },
+		"userxattr":               func(cfg *Config) { cfg.DefaultUserXattr = *userXattr },
+		"fuse-overlay-fallback":   func(cfg *Config) { cfg.FuseOverlayFallback = *fuseOverlayFallback },
+		"dry-run":                 func(cfg *Config) { cfg.DryRun = *dryRun },
+		"idempotent-create":       func(cfg *Config) { cfg.IdempotentCreate = *idempotentCreate },
+		"disable-usage-reporting": func(cfg *Config) { cfg.DisableUsageReporting = *disableUsageReporting },
+		"allowed-base-prefixes":   func(cfg *Config) { cfg.AllowedBasePrefixes = splitList(*allowedBasePrefixes) },
+		"denied-base-prefixes":    func(cfg *Config) { cfg.DeniedBasePrefixes = splitList(*deniedBasePrefixes) },
+		"metrics-addr":            func(cfg *Config) { cfg.MetricsAddr = *metricsAddr },
+		"health-addr":             func(cfg *Config) { cfg.HealthAddr = *healthAddr },
+		"audit-log":               func(cfg *Config) { cfg.AuditLog = *auditLog },
+		"access-log-max-size":     func(cfg *Config) { cfg.AccessLogMaxSize = *accessLogMaxSize },
+		"mount-timeout":           func(cfg *Config) { cfg.MountTimeout = *mountTimeout },
+		"hook-timeout":            func(cfg *Config) { cfg.HookTimeout = *hookTimeout },
+		"hooks-dir":               func(cfg *Config) { cfg.HooksDir = *hooksDir },
+		"mount-watchdog-interval": func(cfg *Config) { cfg.MountWatchdogInterval = *mountWatchdogInterval },
+		"boot-concurrency":        func(cfg *Config) { cfg.BootConcurrency = *bootConcurrency },
+		"permissive-boot":         func(cfg *Config) { cfg.PermissiveBoot = *permissiveBoot },
+		"gc-on-start":             func(cfg *Config) { cfg.GCOnStart = *gcOnStart },
+		"lock-dir":                func(cfg *Config) { cfg.LockDir = *lockDir },
+		"log-format":              func(cfg *Config) { cfg.LogFormat = *logFormat },
+		"log-level":               func(cfg *Config) { cfg.LogLevel = *logLevelName },
+		"metadata-etcd-endpoints": func(cfg *Config) {
+			cfg.MetadataEtcdEndpoints = nil
+			if *metadataEtcdEndpoints != "" {
+				cfg.MetadataEtcdEndpoints = strings.Split(*metadataEtcdEndpoints, ",")
+			}
+		},
+		"metadata-etcd-ca-file":   func(cfg *Config) { cfg.MetadataEtcdCAFile = *metadataEtcdCAFile },
+		"metadata-etcd-cert-file": func(cfg *Config) { cfg.MetadataEtcdCertFile = *metadataEtcdCertFile },
+		"metadata-etcd-key-file":  func(cfg *Config) { cfg.MetadataEtcdKeyFile = *metadataEtcdKeyFile },
+		"metadata-etcd-username":  func(cfg *Config) { cfg.MetadataEtcdUsername = *metadataEtcdUsername },
+	}
+	// loadConfig loads the configuration from the configuration file (or the environment, if there's no file), the
+	// flags given explicitly, and the base directory prefixes config, in the increasing order of precedence
+	loadConfig := func() (Config, error) {
+		cfg, err := mergeConfig(*configPath, flag.CommandLine, overrides)
+		if err != nil {
+			return cfg, err
+		}
+		if *basePrefixesConfig != "" {
+			prefixes, err := loadBasePrefixesConfig(*basePrefixesConfig)
+			if err != nil {
+				return cfg, fmt.Errorf("failed to load the base directory prefixes config: %w", err)
+			}
+			cfg.AllowedBasePrefixes, cfg.DeniedBasePrefixes = prefixes.AllowedBasePrefixes, prefixes.DeniedBasePrefixes
+		}
+		if err := ValidateConfig(cfg); err != nil {
+			return cfg, fmt.Errorf("invalid configuration: %w", err)
+		}
+		return cfg, nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
 
-	// Set the backend as the logging backend
-	logging.SetBackend(backendFormatter)
+	if err := setupLogger(cfg.LogFormat, cfg.LogLevel); err != nil {
+		// Can't happen after `ValidateConfig`
+		fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+		os.Exit(2)
+	}
 
-	// Enable Debug level logs
-	logging.SetLevel(logging.DEBUG, "")
+	// The options shared by the subcommands and the plugin: they must see the same metadata and take the same locks
+	var sharedOptions []DockerOnTopOption
+	if len(cfg.MetadataEtcdEndpoints) > 0 {
+		clientCfg, _ := etcdClientConfig(cfg) // Validated by `ValidateConfig`
+		backend, err := NewEtcdMetadataBackendWithConfig(clientCfg)
+		if err != nil {
+			defaultLogger.Error("Failed to connect to etcd", "endpoints", cfg.MetadataEtcdEndpoints, "error", err)
+			os.Exit(1)
+		}
+		sharedOptions = append(sharedOptions, WithMetadataBackend(backend))
+	}
+	if cfg.LockDir != "" {
+		locks, err := NewFileLockManager(cfg.LockDir)
+		if err != nil {
+			defaultLogger.Error("Failed to create the lock directory", "path", cfg.LockDir, "error", err)
+			os.Exit(1)
+		}
+		sharedOptions = append(sharedOptions, WithLockManager(locks))
+	}
 
-	// Create and return the logger
-	return logging.MustGetLogger("docker-on-top")
-}
+	if flag.NArg() > 0 {
+		os.Exit(runSubcommand(cfg.DotRootDir, flag.Args(), sharedOptions...))
+	}
 
-var log *logging.Logger = initLogger()
+	bootOptions := append([]DockerOnTopOption{WithPermissiveBoot(cfg.PermissiveBoot)}, sharedOptions...)
+	if cfg.FuseOverlayFallback {
+		// The kernel may not support overlayfs, which is fine then
+		bootOptions = append(bootOptions, WithoutOverlayProbe())
+	}
+	if cfg.DryRun {
+		// Overlays won't be mounted, so whether they can be is irrelevant
+		bootOptions = append(bootOptions, WithDryRun(true), WithoutOverlayProbe())
+	}
+	if cfg.MountTimeout != "" {
+		timeout, _ := time.ParseDuration(cfg.MountTimeout) // Validated by `ValidateConfig`
+		bootOptions = append(bootOptions, WithMountTimeout(timeout))
+	}
+	if cfg.AccessLogMaxSize > 0 {
+		bootOptions = append(bootOptions, WithAccessLogMaxSize(cfg.AccessLogMaxSize))
+	}
+	if cfg.HookTimeout != "" {
+		timeout, _ := time.ParseDuration(cfg.HookTimeout) // Validated by `ValidateConfig`
+		bootOptions = append(bootOptions, WithHookTimeout(timeout))
+	}
+	if cfg.HooksDir != "" {
+		bootOptions = append(bootOptions, WithHooksDir(cfg.HooksDir))
+	}
+	if cfg.BootConcurrency > 0 {
+		bootOptions = append(bootOptions, WithBootConcurrency(cfg.BootConcurrency))
+	}
+	bootOptions = append(bootOptions, WithBasePrefixesReloader(func() (BasePrefixesConfig, error) {
+		reloaded, err := loadConfig()
+		return BasePrefixesConfig{
+			AllowedBasePrefixes: reloaded.AllowedBasePrefixes,
+			DeniedBasePrefixes:  reloaded.DeniedBasePrefixes,
+		}, err
+	}))
+	tracerProvider, err := newTracerProviderFromEnv()
+	if err != nil {
+		defaultLogger.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if tracerProvider != nil {
+		defer tracerProvider.Shutdown(context.Background())
+		bootOptions = append(bootOptions, WithTracer(tracerProvider))
+		defaultLogger.Info("Tracing enabled", "endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	driver := MustNewDockerOnTop(ctx, cfg.DotRootDir, bootOptions...)
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go func() {
+		for range reloadSignals {
+			defaultLogger.Info("Received SIGHUP. Reloading the configuration")
+			if err := driver.Reload(); err != nil {
+				defaultLogger.Error("Failed to reload the configuration. Keeping the current one", "error", err)
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		// Stops `ServeUnix`
+		if err := driver.Close(); err != nil {
+			defaultLogger.Warn("Failed to shut down cleanly", "error", err)
+		}
+	}()
+	driver.AllowNestedOverlay = cfg.AllowNestedOverlay
+	driver.DefaultVolatile = cfg.DefaultVolatile
+	driver.DefaultLazyUnmount = cfg.DefaultLazyUnmount
+	driver.DefaultUserXattr = driver.DefaultUserXattr || cfg.DefaultUserXattr
+	driver.TryFuseOverlayFallback = cfg.FuseOverlayFallback
+	driver.DisableUsageReporting = cfg.DisableUsageReporting
+	driver.IdempotentCreate = cfg.IdempotentCreate
+	if err := driver.SetDefaultOptions(cfg.DefaultOptions); err != nil {
+		// Can't happen after `ValidateConfig`
+		defaultLogger.Error("Invalid default volume options", "error", err)
+		os.Exit(1)
+	}
+	if err := driver.applyBasePrefixesConfig(BasePrefixesConfig{
+		AllowedBasePrefixes: cfg.AllowedBasePrefixes,
+		DeniedBasePrefixes:  cfg.DeniedBasePrefixes,
+	}); err != nil {
+		// Can't happen after `ValidateConfig`
+		defaultLogger.Error("Invalid base directory prefixes", "error", err)
+		os.Exit(1)
+	}
 
-func main() {
-	dotRootDir := "/var/lib/docker-on-top/"
-	socketPath := "/run/docker/plugins/docker-on-top.sock"
+	if cfg.AuditLog != "" {
+		auditLog, err := NewFileAuditLog(cfg.AuditLog)
+		if err != nil {
+			defaultLogger.Error("Failed to open the audit log", "error", err)
+			os.Exit(1)
+		}
+		driver.AuditLog = auditLog
+		driver.addCloser(auditLog)
+	}
+
+	if cfg.GCOnStart && !cfg.DryRun {
+		if _, err := driver.GarbageCollect(); err != nil {
+			defaultLogger.Warn("Garbage collection on startup failed", "error", err)
+		}
+	}
 
-	handler := volume.NewHandler(MustNewDockerOnTop(dotRootDir))
-	log.Infof("Serving at %s", socketPath)
-	log.Critical(handler.ServeUnix(socketPath, 0))
+	if cfg.MountWatchdogInterval != "" {
+		interval, _ := time.ParseDuration(cfg.MountWatchdogInterval) // Validated by `ValidateConfig`
+		driver.StartMountWatchdog(interval)
+	}
+
+	if cfg.MetricsAddr != "" {
+		if err := driver.StartMetricsServer(cfg.MetricsAddr); err != nil {
+			defaultLogger.Error("Failed to start the metrics server", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.HealthAddr != "" {
+		if err := driver.StartHealthServer(cfg.HealthAddr); err != nil {
+			defaultLogger.Error("Failed to start the health server", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	err = driver.ServeUnix(cfg.SocketPath, cfg.SocketPermissions)
+	if err != nil {
+		logCritical(defaultLogger, "Stopped serving", "error", err)
+	}
+	// Wait for the shutdown to finish (whether it was initiated by a signal or not)
+	if err := driver.Close(); err != nil {
+		defaultLogger.Warn("Failed to shut down cleanly", "error", err)
+	}
+}
 
-	// TODO: in case of abrupt termination, delete the socket file
+// splitList splits a colon-separated list. An empty string results in an empty list.
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ":")
 }
@@ -0,0 +1,233 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// pluginArgsEnv makes `TestReloadOnSIGHUP` run the plugin with the arguments from it (separated by newlines) instead
+// of testing, so that the test can run the plugin as a subprocess
+const pluginArgsEnv = "DOT_TEST_PLUGIN_ARGS"
+
+// lockedBuffer is a buffer that can be read while a subprocess writes to it.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+// startPlugin runs the plugin with `args` in a subprocess that is stopped at the end of the test, and waits for it to
+// serve at `socketPath`. The plugin's log is returned.
+func startPlugin(t *testing.T, socketPath string, args ...string) (*exec.Cmd, *lockedBuffer) {
+	t.Helper()
+	var log lockedBuffer
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), pluginArgsEnv+"="+strings.Join(append([]string{"-socket-path", socketPath},
+		args...), "\n"))
+	cmd.Stdout, cmd.Stderr = &log, &log
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		_ = cmd.Wait()
+		if t.Failed() {
+			t.Logf("The plugin's log:\n%s", log.String())
+		}
+	})
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(socketPath); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("The plugin doesn't serve at %s: %v", socketPath, err)
+		}
+	}
+	return cmd, &log
+}
+
+// createVolumeRequest sends the Create request for the volume to the plugin serving at `socketPath`, returning the
+// error it responded with.
+func createVolumeRequest(t *testing.T, socketPath string, name string, options map[string]string) error {
+	t.Helper()
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	payload, err := json.Marshal(volume.CreateRequest{Name: name, Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Post("http://plugin/VolumeDriver.Create", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to send the Create request: %v", err)
+	}
+	defer response.Body.Close()
+	var result volume.ErrorResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatalf("Invalid response to the Create request: %v", err)
+	}
+	if result.Err != "" {
+		return errors.New(result.Err)
+	}
+	return nil
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	if args := os.Getenv(pluginArgsEnv); args != "" {
+		os.Args = append([]string{"docker-on-top"}, strings.Split(args, "\n")...)
+		main()
+		return
+	}
+
+	dir := t.TempDir()
+	allowed, other := t.TempDir(), t.TempDir()
+	prefixesConfig := dir + "/prefixes.json"
+	writePrefixes := func(allowed ...string) {
+		t.Helper()
+		payload, err := json.Marshal(BasePrefixesConfig{AllowedBasePrefixes: allowed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(prefixesConfig, payload, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePrefixes(allowed)
+	socketPath := dir + "/plugin.sock"
+	cmd, log := startPlugin(t, socketPath, "-dot-root-dir", dir+"/dot", "-base-prefixes-config", prefixesConfig)
+
+	if err := createVolumeRequest(t, socketPath, "allowed", map[string]string{"base": allowed}); err != nil {
+		t.Errorf("Creating a volume under the allowed prefix failed: %v", err)
+	}
+	if err := createVolumeRequest(t, socketPath, "other", map[string]string{"base": other}); err == nil {
+		t.Fatal("Creating a volume outside of the allowed prefixes succeeded")
+	}
+
+	writePrefixes(other)
+	if err := cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	// The configuration is reloaded asynchronously
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if err := createVolumeRequest(t, socketPath, "other", map[string]string{"base": other}); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("After SIGHUP, creating a volume under the new allowed prefix still fails: %v", err)
+		}
+	}
+	if err := createVolumeRequest(t, socketPath, "allowed2", map[string]string{"base": allowed}); err == nil {
+		t.Error("After SIGHUP, creating a volume under the old allowed prefix succeeded")
+	}
+
+	// An invalid configuration is not applied
+	if err := os.WriteFile(prefixesConfig, []byte(`{"AllowedBasePrefixes": ["relative"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); !strings.Contains(log.String(), "Failed to reload"); {
+		if time.Now().After(deadline) {
+			t.Fatal("The plugin didn't report the invalid configuration")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := createVolumeRequest(t, socketPath, "other2", map[string]string{"base": other}); err != nil {
+		t.Errorf("After reloading an invalid configuration, the previous one was dropped: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	if err := d.Reload(); err == nil {
+		t.Error("Reload succeeded without a configuration source")
+	}
+
+	allowed, other := t.TempDir(), t.TempDir()
+	prefixes := BasePrefixesConfig{AllowedBasePrefixes: []string{allowed}}
+	var reloadErr error
+	WithBasePrefixesReloader(func() (BasePrefixesConfig, error) { return prefixes, reloadErr })(d)
+	if err := d.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	MustCreateVolume(t, d, "allowed", allowed)
+	if err := d.Create(&volume.CreateRequest{Name: "other", Options: map[string]string{"base": other}}); err == nil {
+		t.Error("After Reload, a base directory outside of the allowed prefixes was accepted")
+	}
+
+	// On error, the current configuration is kept
+	for _, reloaded := range []struct {
+		prefixes BasePrefixesConfig
+		err      error
+	}{
+		{BasePrefixesConfig{AllowedBasePrefixes: []string{other}}, errors.New("unreadable")},
+		{BasePrefixesConfig{AllowedBasePrefixes: []string{other}, DeniedBasePrefixes: []string{"relative"}}, nil},
+	} {
+		prefixes, reloadErr = reloaded.prefixes, reloaded.err
+		if err := d.Reload(); err == nil {
+			t.Errorf("Reload succeeded with %+v, %v", reloaded.prefixes, reloaded.err)
+		}
+		err := d.Create(&volume.CreateRequest{Name: "other", Options: map[string]string{"base": other}})
+		if err == nil {
+			t.Errorf("After a failed Reload, the prefixes %+v were applied", reloaded.prefixes)
+		}
+	}
+}
+
+func TestPermissiveBootFlag(t *testing.T) {
+	if args := os.Getenv(pluginArgsEnv); args != "" {
+		os.Args = append([]string{"docker-on-top"}, strings.Split(args, "\n")...)
+		main()
+		return
+	}
+
+	dotRootDir := brokenBootVolumes(t)
+	socketPath := t.TempDir() + "/plugin.sock"
+
+	// Without the flag, the plugin refuses to start
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), pluginArgsEnv+"=-socket-path\n"+socketPath+"\n-dot-root-dir\n"+dotRootDir)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil || err == nil || !strings.Contains(string(output), "bad-mountpoint") {
+		t.Errorf("Without -permissive-boot, the plugin exited with %v, %v; want a failure because of the broken "+
+			"volumes:\n%s", err, ctx.Err(), output)
+	}
+
+	_, log := startPlugin(t, socketPath, "-dot-root-dir", dotRootDir, "-permissive-boot")
+	if err := createVolumeRequest(t, socketPath, "new", map[string]string{"base": t.TempDir()}); err != nil {
+		t.Errorf("With a broken volume, the plugin started with -permissive-boot doesn't work: %v", err)
+	}
+	if !strings.Contains(log.String(), "bad-metadata") || !strings.Contains(log.String(), "permissive boot") {
+		t.Errorf("The plugin didn't report the broken volumes:\n%s", log.String())
+	}
+}
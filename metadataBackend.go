@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// MetadataBackend stores the volumes' metadata (`VolumeInfo`). By default, it is stored in the metadata.json files in
+// the volumes' main directories (see `FileMetadataBackend`); set another backend with `WithMetadataBackend`, e.g. to
+// keep the metadata in etcd (see `NewEtcdMetadataBackend`).
+//
+// The volumes' main directories are still kept in the dot root directory whatever the backend is.
+type MetadataBackend interface {
+	// Write stores the volume's metadata, replacing the previous one, if any. The metadata is written in the current
+	// format (see `volumeInfoSchemaVersion`)
+	Write(volumeName string, info VolumeInfo) error
+	// Read returns the volume's metadata, upgraded to the current format (see `migrateVolumeInfo`). If the volume has
+	// no metadata, an error such that `os.IsNotExist(err)` is returned
+	Read(volumeName string) (VolumeInfo, error)
+	// Delete removes the volume's metadata. Deleting the metadata that doesn't exist is not an error
+	Delete(volumeName string) error
+	// List returns the names of the volumes that have metadata, in no particular order
+	List() ([]string, error)
+}
+
+// WithMetadataBackend makes the driver store the volumes' metadata in `backend` instead of the metadata.json files.
+// The metadata already stored elsewhere is not moved (see `CopyMetadata`).
+func WithMetadataBackend(backend MetadataBackend) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.metadata = backend
+	}
+}
+
+// encodeVolumeInfo serializes the volume's metadata in the current format (see `volumeInfoSchemaVersion`).
+func encodeVolumeInfo(vol VolumeInfo) ([]byte, error) {
+	vol.SchemaVersion = volumeInfoSchemaVersion
+	return json.Marshal(vol)
+}
+
+// FileMetadataBackend is the default `MetadataBackend`: the metadata of a volume is stored in the metadata.json file
+// in its main directory (see volumeTreeManagement.go), which must exist for the metadata to be written.
+type FileMetadataBackend struct {
+	// dotRootDir is the dot root directory, with a trailing slash
+	dotRootDir string
+}
+
+var _ MetadataBackend = (*FileMetadataBackend)(nil)
+
+// NewFileMetadataBackend returns the `FileMetadataBackend` for the volumes in the given dot root directory.
+func NewFileMetadataBackend(dotRootDir string) *FileMetadataBackend {
+	if dotRootDir != "" && dotRootDir[len(dotRootDir)-1] != '/' {
+		dotRootDir += "/"
+	}
+	return &FileMetadataBackend{dotRootDir: dotRootDir}
+}
+
+// path returns the volume's metadata file.
+func (b *FileMetadataBackend) path(volumeName string) string {
+	return volumeDirIn(b.dotRootDir, volumeName) + "/metadata.json"
+}
+
+// Write implements `MetadataBackend.Write`.
+func (b *FileMetadataBackend) Write(volumeName string, info VolumeInfo) error {
+	payload, err := encodeVolumeInfo(info)
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(b.path(volumeName), payload, 0o666)
+}
+
+// Read implements `MetadataBackend.Read`. For the volumes created before the creation time was recorded, `CreatedAt`
+// is set to the modification time of the metadata file.
+func (b *FileMetadataBackend) Read(volumeName string) (VolumeInfo, error) {
+	path := b.path(volumeName)
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return VolumeInfo{}, err
+	}
+	vol, err := migrateVolumeInfo(payload, volumeInfoSchemaVersion)
+	if err != nil {
+		return vol, err
+	}
+	if vol.CreatedAt.IsZero() {
+		// The metadata file is rewritten on every update, so its modification time is only an approximation
+		if info, err := os.Stat(path); err == nil {
+			vol.CreatedAt = info.ModTime()
+		}
+	}
+	return vol, nil
+}
+
+// Delete implements `MetadataBackend.Delete`.
+func (b *FileMetadataBackend) Delete(volumeName string) error {
+	if err := os.Remove(b.path(volumeName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List implements `MetadataBackend.List`.
+func (b *FileMetadataBackend) List() ([]string, error) {
+	dirs, err := listVolumeDirs(b.dotRootDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range dirs {
+		if _, err := os.Lstat(b.path(name)); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// CopyMetadata copies the metadata of all the volumes from `src` to `dst` (replacing the metadata `dst` already has
+// for them), e.g. before switching the driver to another backend. The names of the copied volumes are returned. The
+// volumes whose metadata fails to be copied are skipped, and the errors are returned joined.
+func CopyMetadata(dst MetadataBackend, src MetadataBackend) ([]string, error) {
+	names, err := src.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the volumes: %w", err)
+	}
+	var copied []string
+	var errs []error
+	for _, name := range names {
+		vol, err := src.Read(name)
+		if err == nil {
+			err = dst.Write(name, vol)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("volume %s: %w", name, err))
+			continue
+		}
+		copied = append(copied, name)
+	}
+	return copied, errors.Join(errs...)
+}
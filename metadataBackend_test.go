@@ -0,0 +1,420 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// fakeEtcd is an in-memory etcd serving the parts of the KV API that `etcdMetadataBackend` uses.
+type fakeEtcd struct {
+	etcdserverpb.UnimplementedKVServer
+	mutex sync.Mutex
+	kvs   map[string][]byte
+	// requests counts the requests served
+	requests int
+	// err, if set, is returned for all the requests
+	err error
+}
+
+var _ etcdserverpb.KVServer = (*fakeEtcd)(nil)
+
+// newFakeEtcd starts a fake etcd, which is stopped when the test finishes, and returns it with its endpoint.
+func newFakeEtcd(t *testing.T) (*fakeEtcd, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etcd := &fakeEtcd{kvs: map[string][]byte{}}
+	server := grpc.NewServer()
+	etcdserverpb.RegisterKVServer(server, etcd)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return etcd, "http://" + listener.Addr().String()
+}
+
+// newTestEtcdMetadataBackend returns a `MetadataBackend` for the etcd endpoints, which is closed when the test
+// finishes. Its requests time out after a second.
+func newTestEtcdMetadataBackend(t *testing.T, endpoints ...string) MetadataBackend {
+	t.Helper()
+	backend, err := NewEtcdMetadataBackend(endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.(*etcdMetadataBackend).requestTimeout = time.Second
+	t.Cleanup(func() { backend.(io.Closer).Close() })
+	return backend
+}
+
+// rangeKeys returns the sorted keys in the range: `key` alone, or [key, rangeEnd).
+func (e *fakeEtcd) rangeKeys(key, rangeEnd []byte) []string {
+	var keys []string
+	for k := range e.kvs {
+		if k == string(key) || len(rangeEnd) > 0 && k >= string(key) && k < string(rangeEnd) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (e *fakeEtcd) Range(_ context.Context, request *etcdserverpb.RangeRequest) (*etcdserverpb.RangeResponse, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.requests++
+	if e.err != nil {
+		return nil, e.err
+	}
+	response := &etcdserverpb.RangeResponse{Header: &etcdserverpb.ResponseHeader{}}
+	for _, k := range e.rangeKeys(request.Key, request.RangeEnd) {
+		kv := &mvccpb.KeyValue{Key: []byte(k)}
+		if !request.KeysOnly {
+			kv.Value = e.kvs[k]
+		}
+		response.Kvs = append(response.Kvs, kv)
+	}
+	response.Count = int64(len(response.Kvs))
+	return response, nil
+}
+
+func (e *fakeEtcd) Put(_ context.Context, request *etcdserverpb.PutRequest) (*etcdserverpb.PutResponse, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.requests++
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.kvs[string(request.Key)] = request.Value
+	return &etcdserverpb.PutResponse{Header: &etcdserverpb.ResponseHeader{}}, nil
+}
+
+func (e *fakeEtcd) DeleteRange(_ context.Context, request *etcdserverpb.DeleteRangeRequest) (
+	*etcdserverpb.DeleteRangeResponse, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.requests++
+	if e.err != nil {
+		return nil, e.err
+	}
+	keys := e.rangeKeys(request.Key, request.RangeEnd)
+	for _, k := range keys {
+		delete(e.kvs, k)
+	}
+	return &etcdserverpb.DeleteRangeResponse{Header: &etcdserverpb.ResponseHeader{}, Deleted: int64(len(keys))}, nil
+}
+
+func TestMetadataBackends(t *testing.T) {
+	_, endpoint := newFakeEtcd(t)
+	etcd := newTestEtcdMetadataBackend(t, endpoint)
+	backends := map[string]MetadataBackend{"file": NewFileMetadataBackend(t.TempDir()), "etcd": etcd}
+
+	// The metadata to store: that of real volumes
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "first", t.TempDir())
+	err := d.Create(&volume.CreateRequest{Name: "second", Options: map[string]string{"base": t.TempDir(),
+		"volatile": "true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := map[string]VolumeInfo{}
+	for _, name := range []string{"first", "second"} {
+		if infos[name], err = d.getVolumeInfo(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, backend := range backends {
+		if names, err := backend.List(); err != nil || len(names) != 0 {
+			t.Errorf("%s: initially, List = %v, %v; want no volumes", name, names, err)
+		}
+		if _, err := backend.Read("first"); !os.IsNotExist(err) {
+			t.Errorf("%s: Read of missing metadata = %v, want a not-exist error", name, err)
+		}
+		for volumeName, info := range infos {
+			if name == "file" {
+				// The file backend stores the metadata in the volumes' directories, which the driver creates
+				dir := volumeDirIn(backend.(*FileMetadataBackend).dotRootDir, volumeName)
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := backend.Write(volumeName, info); err != nil {
+				t.Fatalf("%s: Write(%s) failed: %v", name, volumeName, err)
+			}
+		}
+		for volumeName, want := range infos {
+			if info, err := backend.Read(volumeName); err != nil || !reflect.DeepEqual(info, want) {
+				t.Errorf("%s: Read(%s) = %+v, %v; want %+v", name, volumeName, info, err, want)
+			}
+		}
+		names, err := backend.List()
+		slices.Sort(names)
+		if err != nil || !slices.Equal(names, []string{"first", "second"}) {
+			t.Errorf("%s: List = %v, %v; want [first second]", name, names, err)
+		}
+
+		// Write replaces the metadata
+		replaced := infos["first"]
+		replaced.Volatile = !replaced.Volatile
+		if err := backend.Write("first", replaced); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := backend.Read("first"); err != nil || !reflect.DeepEqual(info, replaced) {
+			t.Errorf("%s: after replacing, Read = %+v, %v; want %+v", name, info, err, replaced)
+		}
+
+		if err := backend.Delete("first"); err != nil {
+			t.Errorf("%s: Delete failed: %v", name, err)
+		}
+		if err := backend.Delete("first"); err != nil {
+			t.Errorf("%s: Delete of missing metadata failed: %v", name, err)
+		}
+		if _, err := backend.Read("first"); !os.IsNotExist(err) {
+			t.Errorf("%s: Read of deleted metadata = %v, want a not-exist error", name, err)
+		}
+		if names, err := backend.List(); err != nil || !slices.Equal(names, []string{"second"}) {
+			t.Errorf("%s: after Delete, List = %v, %v; want [second]", name, names, err)
+		}
+	}
+}
+
+func TestEtcdMetadataBackendEndpoints(t *testing.T) {
+	etcd, endpoint := newFakeEtcd(t)
+	// A closed port: nothing listens there
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := "http://" + listener.Addr().String()
+	listener.Close()
+
+	backend := newTestEtcdMetadataBackend(t, unreachable, strings.TrimPrefix(endpoint, "http://")+"/")
+	if err := backend.Write("vol", VolumeInfo{BaseDirPath: "/base"}); err != nil {
+		t.Fatalf("With the first endpoint unreachable, Write failed: %v", err)
+	}
+	if info, err := backend.Read("vol"); err != nil || info.BaseDirPath != "/base" {
+		t.Errorf("With the first endpoint unreachable, Read = %+v, %v", info, err)
+	}
+	etcd.mutex.Lock()
+	_, ok := etcd.kvs[etcdMetadataPrefix+"vol"]
+	etcd.mutex.Unlock()
+	if !ok {
+		t.Errorf("The metadata is not stored under the prefix %s", etcdMetadataPrefix)
+	}
+
+	unreachableOnly := newTestEtcdMetadataBackend(t, unreachable)
+	if _, err := unreachableOnly.List(); err == nil || os.IsNotExist(err) {
+		t.Errorf("With no reachable endpoint, List = %v, want a connection error", err)
+	}
+
+	// An error reported by etcd is returned as is, without retrying
+	failing, failingEndpoint := newFakeEtcd(t)
+	failing.err = rpctypes.ErrGRPCPermissionDenied
+	backend = newTestEtcdMetadataBackend(t, failingEndpoint)
+	if _, err := backend.Read("vol"); !errors.Is(err, rpctypes.ErrPermissionDenied) {
+		t.Errorf("With etcd failing, Read = %v, want %v", err, rpctypes.ErrPermissionDenied)
+	}
+	failing.mutex.Lock()
+	defer failing.mutex.Unlock()
+	if failing.requests != 1 {
+		t.Errorf("After etcd failed, %d requests were sent, want 1", failing.requests)
+	}
+}
+
+func TestNewEtcdMetadataBackendValidation(t *testing.T) {
+	for _, endpoints := range [][]string{
+		nil,
+		{"ftp://10.0.0.1:2379"},
+		{"http://"},
+		{"10.0.0.1:2379", "http://[::1"},
+	} {
+		if backend, err := NewEtcdMetadataBackend(endpoints); err == nil {
+			t.Errorf("NewEtcdMetadataBackend(%q) succeeded", endpoints)
+			backend.(io.Closer).Close()
+		}
+	}
+	// The connections are made in the background
+	backend, err := NewEtcdMetadataBackend([]string{"10.0.0.1:2379", "https://etcd.example.com:2379/"})
+	if err != nil {
+		t.Fatalf("NewEtcdMetadataBackend failed for valid endpoints: %v", err)
+	}
+	backend.(io.Closer).Close()
+}
+
+// writeTestCertificate writes a self-signed certificate and its key to PEM files in `dir` and returns their paths.
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "docker-on-top"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = dir+"/cert.pem", dir+"/key.pem"
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: cert},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestEtcdClientConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	base := DefaultConfig()
+	base.MetadataEtcdEndpoints = []string{"10.0.0.1:2379/"}
+
+	cfg := base
+	cfg.MetadataEtcdUsername, cfg.MetadataEtcdPassword = "dot", "secret"
+	clientCfg, err := etcdClientConfig(cfg)
+	want := clientv3.Config{Endpoints: []string{"10.0.0.1:2379"}, Username: "dot", Password: "secret"}
+	if err != nil || !reflect.DeepEqual(clientCfg, want) {
+		t.Errorf("etcdClientConfig = %+v, %v; want %+v", clientCfg, err, want)
+	}
+
+	cfg = base
+	cfg.MetadataEtcdCAFile, cfg.MetadataEtcdCertFile, cfg.MetadataEtcdKeyFile = certFile, certFile, keyFile
+	clientCfg, err = etcdClientConfig(cfg)
+	if err != nil {
+		t.Fatalf("etcdClientConfig failed with TLS: %v", err)
+	}
+	if clientCfg.TLS == nil || clientCfg.TLS.RootCAs == nil || clientCfg.TLS.GetClientCertificate == nil {
+		t.Errorf("With TLS, the etcd client's TLS configuration is %+v, want the CA and the certificate", clientCfg.TLS)
+	}
+
+	for name, modify := range map[string]func(cfg *Config){
+		"no endpoints":      func(cfg *Config) { cfg.MetadataEtcdEndpoints = nil },
+		"invalid endpoint":  func(cfg *Config) { cfg.MetadataEtcdEndpoints = []string{"ftp://10.0.0.1:2379"} },
+		"certificate alone": func(cfg *Config) { cfg.MetadataEtcdCertFile = certFile },
+		"key alone":         func(cfg *Config) { cfg.MetadataEtcdKeyFile = keyFile },
+		"password alone":    func(cfg *Config) { cfg.MetadataEtcdPassword = "secret" },
+		"missing CA file":   func(cfg *Config) { cfg.MetadataEtcdCAFile = certFile + ".missing" },
+		"invalid CA file":   func(cfg *Config) { cfg.MetadataEtcdCAFile = keyFile },
+		"missing key file": func(cfg *Config) {
+			cfg.MetadataEtcdCertFile, cfg.MetadataEtcdKeyFile = certFile, "/missing"
+		},
+		"key as the certificate": func(cfg *Config) {
+			cfg.MetadataEtcdCertFile, cfg.MetadataEtcdKeyFile = keyFile, keyFile
+		},
+	} {
+		cfg := base
+		modify(&cfg)
+		if clientCfg, err := etcdClientConfig(cfg); err == nil {
+			t.Errorf("%s: etcdClientConfig = %+v, want an error", name, clientCfg)
+		}
+		if err := ValidateConfig(cfg); err == nil && len(cfg.MetadataEtcdEndpoints) > 0 {
+			t.Errorf("%s: ValidateConfig succeeded", name)
+		}
+	}
+}
+
+func TestSwitchingMetadataBackends(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "first", t.TempDir())
+	MustCreateVolume(t, d, "second", t.TempDir())
+	want, err := d.getVolumeInfo("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := captureStdout(t, runCopyMetadata, d); err == nil {
+		t.Error("copy-metadata succeeded without another backend configured")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, endpoint := newFakeEtcd(t)
+	etcd := newTestEtcdMetadataBackend(t, endpoint)
+	// Like the copy-metadata subcommand run with --metadata-etcd-endpoints
+	cli, err := openDockerOnTop(d.dotRootDir, WithMetadataBackend(etcd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.getVolumeInfo("first"); !os.IsNotExist(err) {
+		t.Errorf("Before copying the metadata, reading it = %v, want a not-exist error", err)
+	}
+	out, err := captureStdout(t, runCopyMetadata, cli)
+	copied := strings.Fields(out)
+	slices.Sort(copied)
+	if err != nil || !slices.Equal(copied, []string{"first", "second"}) {
+		t.Errorf("copy-metadata printed %q, %v; want first and second", out, err)
+	}
+	// From now on, the metadata files are not used
+	for _, name := range []string{"first", "second"} {
+		if err := os.Remove(volumeDirIn(d.dotRootDir, name) + "/metadata.json"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err = NewDockerOnTop(context.Background(), d.dotRootDir, WithoutOverlayProbe(), WithLogger(newTestLogger(t)),
+		WithMockSyscallMount(NewMockSyscallMount()), WithMetadataBackend(etcd))
+	if err != nil {
+		t.Fatalf("With the metadata in etcd, the driver failed to boot: %v", err)
+	}
+	defer d.Close()
+	if vol, err := d.getVolumeInfo("first"); err != nil || !reflect.DeepEqual(vol, want) {
+		t.Errorf("After switching, the metadata is %+v, %v; want %+v", vol, err, want)
+	}
+	if response, err := d.List(); err != nil || len(response.Volumes) != 2 {
+		t.Errorf("After switching, List = %v, %v; want 2 volumes", response, err)
+	}
+	MustMountVolume(t, d, "first", "container")
+	MustUnmountVolume(t, d, "first", "container")
+
+	MustCreateVolume(t, d, "third", t.TempDir())
+	if _, err := os.Stat(volumeDirIn(d.dotRootDir, "third") + "/metadata.json"); !os.IsNotExist(err) {
+		t.Errorf("A metadata file was written for a new volume (%v)", err)
+	}
+	if info, err := etcd.Read("third"); err != nil || info.BaseDirPath == "" {
+		t.Errorf("The new volume's metadata in etcd is %+v, %v", info, err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "third"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := etcd.Read("third"); !os.IsNotExist(err) {
+		t.Errorf("After Remove, reading the metadata from etcd = %v, want a not-exist error", err)
+	}
+}
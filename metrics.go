@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// driverMetrics holds the Prometheus collectors of the driver. Each `DockerOnTop` has its own registry, so that several
+// instances (if any) don't clash.
+type driverMetrics struct {
+	registry *prometheus.Registry
+
+	creates  *prometheus.CounterVec
+	removes  *prometheus.CounterVec
+	mounts   *prometheus.CounterVec
+	unmounts *prometheus.CounterVec
+	// mountsShortCircuited counts the mounts refused by the circuit breaker (see `mountCircuitBreaker`)
+	mountsShortCircuited prometheus.Counter
+
+	mountDuration prometheus.Histogram
+}
+
+// newDriverMetrics creates the driver's collectors and registers them in a new registry.
+func newDriverMetrics() *driverMetrics {
+	requestCounter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, []string{"result"})
+	}
+	m := &driverMetrics{
+		registry: prometheus.NewRegistry(),
+		creates:  requestCounter("docker_on_top_creates_total", "Number of volume creation requests."),
+		removes:  requestCounter("docker_on_top_removes_total", "Number of volume removal requests."),
+		mounts:   requestCounter("docker_on_top_mounts_total", "Number of volume mount requests."),
+		unmounts: requestCounter("docker_on_top_unmounts_total", "Number of volume unmount requests."),
+		mountsShortCircuited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "docker_on_top_mounts_short_circuited_total",
+			Help: "Number of volume mount requests refused because of repeated overlay mount failures.",
+		}),
+		mountDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "docker_on_top_mount_duration_seconds",
+			Help:    "Time it takes to handle a volume mount request.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	m.registry.MustRegister(m.creates, m.removes, m.mounts, m.unmounts, m.mountsShortCircuited, m.mountDuration)
+	return m
+}
+
+// resultLabel returns the value of the "result" label for a request that finished with the given error.
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// observeMount records a mount request that took `duration` and finished with `err`.
+func (m *driverMetrics) observeMount(duration time.Duration, err error) {
+	m.mounts.WithLabelValues(resultLabel(err)).Inc()
+	m.mountDuration.Observe(duration.Seconds())
+}
+
+// StartMetricsServer starts an HTTP server exposing the driver's metrics in the Prometheus format at `/metrics` on
+// the given address. The server runs in the background until `Close` is called; an error is only returned if the
+// address cannot be listened on.
+func (d *DockerOnTop) StartMetricsServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(d.metrics.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+	d.addCloser(server)
+	d.goBackground(func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			d.logger.Error("Metrics server stopped", "error", err)
+		}
+	})
+	d.logger.Info("Serving metrics", "address", listener.Addr().String(), "path", "/metrics")
+	return nil
+}
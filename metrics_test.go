@@ -0,0 +1,75 @@
+//go:build dottest
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// freeAddr returns a local address that is (likely) free to listen on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// scrapeMetrics returns the lines of the metrics served at `addr`, without the comments.
+func scrapeMetrics(t *testing.T, addr string) []string {
+	t.Helper()
+	response, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			samples = append(samples, line)
+		}
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	addr := freeAddr(t)
+	if err := d.StartMetricsServer(addr); err != nil {
+		t.Fatalf("StartMetricsServer failed: %v", err)
+	}
+
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustMountVolume(t, d, "vol", "first")
+	MustMountVolume(t, d, "vol", "second")
+	if _, err := d.Mount(&volume.MountRequest{Name: "missing", ID: "container"}); err == nil {
+		t.Fatal("Mounting a nonexistent volume succeeded")
+	}
+	MustUnmountVolume(t, d, "vol", "first")
+
+	samples := scrapeMetrics(t, addr)
+	for _, want := range []string{
+		`docker_on_top_creates_total{result="success"} 1`,
+		`docker_on_top_mounts_total{result="success"} 2`,
+		`docker_on_top_mounts_total{result="failure"} 1`,
+		`docker_on_top_unmounts_total{result="success"} 1`,
+		`docker_on_top_mount_duration_seconds_count 3`,
+	} {
+		if !slices.Contains(samples, want) {
+			t.Errorf("The metrics don't contain %q: %q", want, samples)
+		}
+	}
+}
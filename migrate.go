@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// MigrateVolume changes the base directory of the volume to `newBasePath`, which is checked just like the `base` option
+// of a new volume. The changes made to the volume are kept.
+//
+// The returned list describes the changes that don't match the new base directory: the whiteouts hiding entries that
+// don't exist in it and the modified directories that are files in it (or vice versa). Such changes do no harm, but
+// they may indicate that the new base directory is not what the volume expects. If `dryRun` is set, the volume is not
+// changed: only the checks are performed.
+//
+// The volume must not be in use.
+func (d *DockerOnTop) MigrateVolume(volumeName string, newBasePath string, dryRun bool) ([]string, error) {
+	d.logger.Debug("Request MigrateVolume", "volume", volumeName, "newBase", newBasePath, "dryRun", dryRun)
+
+	thisVol, err := d.getVolumeInfoOrNotFound(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	newBasePath, err = d.checkBaseDir(newBasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.lockIdleVolume(volumeName, "migrate")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	mismatches, err := d.findBaseMismatches(volumeName, &thisVol, newBasePath)
+	if err != nil {
+		return nil, d.internalError("failed to check the upperdir against the new base directory", err, "volume",
+			volumeName)
+	}
+	if dryRun {
+		return mismatches, nil
+	}
+
+	oldBasePath := thisVol.BaseDirPath
+	thisVol.BaseDirPath = newBasePath
+	if err := d.writeVolumeInfo(volumeName, thisVol); err != nil {
+		return nil, d.internalError("failed to store metadata for the volume", err, "volume", volumeName)
+	}
+	d.logger.Info("Migrated volume", "volume", volumeName, "oldBase", oldBasePath, "newBase", newBasePath,
+		"mismatches", len(mismatches))
+	return mismatches, nil
+}
+
+// findBaseMismatches lists the entries of the upperdir of the volume with the metadata `vol` that don't match
+// `basePath` (see `MigrateVolume`).
+// The volume's other lower layers are not taken into account.
+func (d *DockerOnTop) findBaseMismatches(volumeName string, vol *VolumeInfo, basePath string) ([]string, error) {
+	var mismatches []string
+	upperdir := d.upperdir(volumeName, vol)
+	err := filepath.WalkDir(upperdir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(upperdir, path)
+		if err != nil || relPath == "." || entry.Name() == opaqueMarker {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if whiteout, target := isWhiteout(entry.Name(), info); whiteout {
+			deleted := filepath.Join(filepath.Dir(relPath), target)
+			if _, err := os.Lstat(filepath.Join(basePath, deleted)); os.IsNotExist(err) {
+				mismatches = append(mismatches, fmt.Sprintf("%s: deleted, but doesn't exist in the new base", deleted))
+			}
+			return nil
+		}
+		if baseInfo, err := os.Lstat(filepath.Join(basePath, relPath)); err == nil && entry.IsDir() != baseInfo.IsDir() {
+			mismatches = append(mismatches, fmt.Sprintf("%s: the type differs from the new base", relPath))
+		}
+		return nil
+	})
+	return mismatches, err
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// MigrateRootDir moves the dot root directory from `oldRoot` to `newRoot`, which must not exist or be empty. Nothing
+// may be mounted under either of them (according to `/proc/mounts`), so the plugin must be stopped (or all volumes
+// unmounted) first.
+//
+// The tree is copied rather than renamed, as the roots are usually located on different filesystems: regular files are
+// hard-linked when possible and copied (with their checksums verified) otherwise. Modes, ownership, timestamps,
+// extended attributes, and whiteouts are preserved. The activemounts/ directories of the volumes are reset, and the
+// paths pointing into the old root (like the base directory of a fork) are rewritten in the volumes' metadata.
+//
+// If `oldRoot` is a symlink, it is atomically switched to point to `newRoot`, so that the plugin's configuration stays
+// valid. Otherwise, the old tree is left in place and the dot root directory must be changed in the configuration.
+func MigrateRootDir(oldRoot, newRoot string) error {
+	defaultLogger.Debug("Request MigrateRootDir", "oldRoot", oldRoot, "newRoot", newRoot)
+
+	if !filepath.IsAbs(oldRoot) || !filepath.IsAbs(newRoot) {
+		return errors.New("both dot root directories must be absolute paths")
+	}
+	oldLink := filepath.Clean(oldRoot)
+	oldRoot, err := filepath.EvalSymlinks(oldLink)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the old dot root directory: %w", err)
+	}
+	newRoot = filepath.Clean(newRoot)
+	if resolved, err := filepath.EvalSymlinks(newRoot); err == nil {
+		newRoot = resolved
+	}
+	if isPathUnder(newRoot, oldRoot) || isPathUnder(oldRoot, newRoot) {
+		return errors.New("the old and new dot root directories must not be nested in one another")
+	}
+
+	if err := checkNoMountsUnder(oldRoot, newRoot); err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(newRoot); err == nil && len(entries) > 0 {
+		return fmt.Errorf("the new dot root directory %s is not empty", newRoot)
+	} else if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read the new dot root directory: %w", err)
+	}
+
+	linked, copied, err := copyRootTree(oldRoot, newRoot)
+	if err != nil {
+		return internalError(defaultLogger, "failed to copy the dot root directory", err, "oldRoot", oldRoot, "newRoot",
+			newRoot)
+	}
+	defaultLogger.Info("Copied the dot root directory", "oldRoot", oldRoot, "newRoot", newRoot, "linked", linked,
+		"copied", copied)
+
+	if err := rewriteRootPaths(oldLink, oldRoot, newRoot); err != nil {
+		return internalError(defaultLogger, "failed to update the volumes' metadata", err, "newRoot", newRoot)
+	}
+
+	if info, err := os.Lstat(oldLink); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		// A symlink is replaced atomically by renaming a new one over it (within the same directory)
+		tmpLink := oldLink + ".migrate-tmp"
+		_ = os.Remove(tmpLink)
+		if err := os.Symlink(newRoot, tmpLink); err != nil {
+			return internalError(defaultLogger, "failed to create a symlink to the new dot root directory", err, "path",
+				tmpLink)
+		}
+		if err := os.Rename(tmpLink, oldLink); err != nil {
+			_ = os.Remove(tmpLink)
+			return internalError(defaultLogger, "failed to switch the dot root symlink", err, "path", oldLink)
+		}
+		defaultLogger.Info("Switched the dot root symlink to the new directory", "symlink", oldLink, "newRoot", newRoot)
+	} else {
+		defaultLogger.Warn("The old dot root directory is not a symlink: update the dot root directory in the "+
+			"configuration and remove the old tree once the plugin works with the new one", "oldRoot", oldRoot,
+			"newRoot", newRoot)
+	}
+	return nil
+}
+
+// checkNoMountsUnder returns an error if anything is mounted under any of `roots` (clean absolute paths), according
+// to `/proc/mounts`.
+func checkNoMountsUnder(roots ...string) error {
+	f, err := os.Open(procMounts)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", procMounts, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields: source, mountpoint, fstype, options, dump, pass
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		mountpoint := unescapeMountInfo(fields[1])
+		for _, root := range roots {
+			if isPathUnder(mountpoint, root) {
+				return fmt.Errorf("%s is mounted under %s: unmount it first", mountpoint, root)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", procMounts, err)
+	}
+	return nil
+}
+
+// copyRootTree copies the contents of the dot root directory `src` to `dst` (see `MigrateRootDir`), returning the
+// numbers of hard-linked and copied regular files. The scratch directories and the contents of the volumes'
+// activemounts/ directories are skipped.
+func copyRootTree(src, dst string) (linked int, copied int, err error) {
+	copyFile := func(src, dst string) (bool, error) {
+		if err := os.Link(src, dst); err == nil {
+			linked++
+			return true, nil
+		} else if !errors.Is(err, syscall.EXDEV) && !errors.Is(err, syscall.EPERM) {
+			return false, err
+		}
+		if err := copyVerifiedFile(src, dst); err != nil {
+			return false, err
+		}
+		copied++
+		return false, nil
+	}
+	skip := func(path string, rel string) bool {
+		if filepath.Dir(rel) == "." && isScratchDir(rel) {
+			return true
+		}
+		// The volumes are not in use: their active mounts are stale
+		parent := filepath.Dir(path)
+		return filepath.Base(parent) == "activemounts" && isVolumeDir(filepath.Dir(parent))
+	}
+	err = copyTree(src, dst, copyFile, skip, defaultLogger)
+	return linked, copied, err
+}
+
+// copyVerifiedFile copies the contents of the regular file `src` to the new file `dst`, then reads the copy back and
+// checks that its SHA-256 checksum matches the source's.
+func copyVerifiedFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	srcHash := sha256.New()
+	_, err = io.Copy(out, io.TeeReader(in, srcHash))
+	if err == nil {
+		err = out.Sync()
+	}
+	if err := errors.Join(err, out.Close()); err != nil {
+		return err
+	}
+
+	dstHash, err := fileChecksum(dst)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(srcHash.Sum(nil), dstHash) {
+		return fmt.Errorf("checksum mismatch after copying %s to %s", src, dst)
+	}
+	return nil
+}
+
+// fileChecksum returns the SHA-256 checksum of the file's contents.
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// rewriteRootPaths replaces the paths pointing into the old dot root directory (given both as configured, `oldLink`,
+// and resolved, `oldRoot`) with the ones pointing into `newRoot` in the metadata of all volumes stored in `newRoot`.
+func rewriteRootPaths(oldLink, oldRoot, newRoot string) error {
+	d := newDockerOnTop(context.Background(), newRoot+"/")
+	names, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+
+	rewrite := func(path string) string {
+		for _, old := range []string{oldLink, oldRoot} {
+			if isPathUnder(filepath.Clean(path), old) {
+				return newRoot + strings.TrimPrefix(filepath.Clean(path), old) + suffixSlash(path)
+			}
+		}
+		return path
+	}
+
+	var errs []error
+	for _, name := range names {
+		vol, err := d.getVolumeInfo(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("volume %s: %w", name, err))
+			continue
+		}
+		updated := vol.clone()
+		updated.BaseDirPath = rewrite(vol.BaseDirPath)
+		updated.CustomUpperDir = rewrite(vol.CustomUpperDir)
+		updated.CustomWorkDir = rewrite(vol.CustomWorkDir)
+		for i, layer := range vol.LowerLayers {
+			updated.LowerLayers[i] = rewrite(layer)
+		}
+		updated.LastMountOptions = "" // It refers to the old paths
+		if err := d.writeVolumeInfo(name, updated); err != nil {
+			errs = append(errs, fmt.Errorf("volume %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// suffixSlash returns "/" if `path` ends with a slash and an empty string otherwise.
+func suffixSlash(path string) string {
+	if strings.HasSuffix(path, "/") && path != "/" {
+		return "/"
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// prepareMigration creates a driver with the dot root directory `root` holding a volume with changes, a whiteout, and
+// a stale active mount, and a volume whose base directory is inside the dot root directory (like a fork's). The driver
+// is closed before returning, as the roots must not be in use when migrating.
+func prepareMigration(t *testing.T, root string) (base string, upperdir map[string]string) {
+	t.Helper()
+	d, err := NewDockerOnTop(context.Background(), root, WithoutOverlayProbe(), WithLogger(newTestLogger(t)),
+		WithMockSyscallMount(NewMockSyscallMount()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base = t.TempDir()
+	MustCreateVolume(t, d, "vol", base)
+	MustCreateVolume(t, d, "fork", t.TempDir())
+	MustMountVolume(t, d, "vol", "stale")
+
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, d.upperdir("vol", &vol), map[string]string{"file": "changed", "dir/nested": "added"})
+	if err := os.Chmod(d.upperdir("vol", &vol)+"file", 0o640); err != nil {
+		t.Fatal(err)
+	}
+	makeWhiteouts(t, d.upperdir("vol", &vol), "deleted")
+	upperdir = describeTree(t, d.upperdir("vol", &vol))
+
+	fork, err := d.getVolumeInfo("fork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork.BaseDirPath = filepath.Clean(d.VolumeMountpointDir("vol"))
+	if err := d.metadata.Write("fork", fork); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return base, upperdir
+}
+
+// checkMigrated checks that the volumes made by `prepareMigration` are accessible in the dot root directory `root`.
+func checkMigrated(t *testing.T, root string, base string, upperdir map[string]string) {
+	t.Helper()
+	m := NewMockSyscallMount()
+	d, err := NewDockerOnTop(context.Background(), root, WithoutOverlayProbe(), WithLogger(newTestLogger(t)),
+		WithMockSyscallMount(m))
+	if err != nil {
+		t.Fatalf("Failed to open the new dot root directory: %v", err)
+	}
+	defer d.Close()
+
+	list, err := d.List()
+	if err != nil || len(list.Volumes) != 2 {
+		t.Errorf("In the new root, List = %v, %v; want 2 volumes", list, err)
+	}
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol.BaseDirPath != base {
+		t.Errorf("The base directory outside of the root changed from %s to %s", base, vol.BaseDirPath)
+	}
+	if got := describeTree(t, d.upperdir("vol", &vol)); !reflect.DeepEqual(got, upperdir) {
+		t.Errorf("The upperdir changed from %v to %v", upperdir, got)
+	}
+	if mounts, err := d.listActiveMounts("vol"); err != nil || len(mounts) != 0 {
+		t.Errorf("The active mounts weren't reset: %v, %v", mounts, err)
+	}
+	// The paths are rewritten to the resolved root
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(filepath.Clean(d.VolumeMountpointDir("vol")), filepath.Clean(root), resolvedRoot, 1)
+	if fork, err := d.getVolumeInfo("fork"); err != nil || fork.BaseDirPath != want {
+		t.Errorf("The base directory inside the root is %q, %v; want %s", fork.BaseDirPath, err, want)
+	}
+
+	MustMountVolume(t, d, "vol", "container")
+	data, _ := m.Mounted(d.VolumeMountpointDir("vol"))
+	if !containsOption(data, "upperdir="+d.upperdir("vol", &vol)) {
+		t.Errorf("The migrated volume was mounted with %q", data)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+	if err := d.Remove(&volume.RemoveRequest{Name: "fork"}); err != nil {
+		t.Errorf("Failed to remove a migrated volume: %v", err)
+	}
+}
+
+func TestMigrateRootDir(t *testing.T) {
+	oldRoot := t.TempDir()
+	base, upperdir := prepareMigration(t, oldRoot)
+	newRoot := t.TempDir() + "/new"
+	if err := MigrateRootDir(oldRoot, newRoot); err != nil {
+		t.Fatalf("MigrateRootDir failed: %v", err)
+	}
+	checkMigrated(t, newRoot, base, upperdir)
+
+	// On the same filesystem, the files are hard-linked rather than copied, and the old tree is left in place
+	var oldStat, newStat syscall.Stat_t
+	if err := syscall.Stat(oldRoot+"/vol/upper/file", &oldStat); err != nil {
+		t.Fatalf("The old tree was removed: %v", err)
+	}
+	if err := syscall.Stat(newRoot+"/vol/upper/file", &newStat); err != nil {
+		t.Fatal(err)
+	}
+	if oldStat.Ino != newStat.Ino {
+		t.Error("The file was copied rather than hard-linked")
+	}
+}
+
+func TestMigrateRootDirSymlink(t *testing.T) {
+	dir := t.TempDir()
+	oldRoot, link := dir+"/old", dir+"/link"
+	if err := os.Mkdir(oldRoot, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(oldRoot, link); err != nil {
+		t.Fatal(err)
+	}
+	base, upperdir := prepareMigration(t, link)
+	newRoot := dir + "/new"
+	if err := MigrateRootDir(link, newRoot); err != nil {
+		t.Fatalf("MigrateRootDir failed: %v", err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != newRoot {
+		t.Errorf("The dot root symlink points to %q, %v; want %s", target, err, newRoot)
+	}
+	// The configured dot root directory stays valid
+	checkMigrated(t, link, base, upperdir)
+}
+
+func TestMigrateRootDirAcrossFilesystems(t *testing.T) {
+	var tmpStat, shmStat syscall.Stat_t
+	if syscall.Stat(os.TempDir(), &tmpStat) != nil || syscall.Stat("/dev/shm", &shmStat) != nil ||
+		tmpStat.Dev == shmStat.Dev {
+		t.Skip("No other filesystem to migrate to")
+	}
+	newRootParent, err := os.MkdirTemp("/dev/shm", "migrate")
+	if err != nil {
+		t.Skip("Cannot write to /dev/shm")
+	}
+	defer os.RemoveAll(newRootParent)
+
+	oldRoot := t.TempDir()
+	base, upperdir := prepareMigration(t, oldRoot)
+	newRoot := newRootParent + "/new"
+	if err := MigrateRootDir(oldRoot, newRoot); err != nil {
+		t.Fatalf("MigrateRootDir failed: %v", err)
+	}
+	checkMigrated(t, newRoot, base, upperdir)
+}
+
+func TestMigrateRootDirValidation(t *testing.T) {
+	oldRoot := t.TempDir()
+	prepareMigration(t, oldRoot)
+	nonEmpty := t.TempDir()
+	writeFiles(t, nonEmpty, map[string]string{"file": ""})
+	for _, newRoot := range []string{"relative", oldRoot + "/nested", filepath.Dir(oldRoot), nonEmpty} {
+		if err := MigrateRootDir(oldRoot, newRoot); err == nil {
+			t.Errorf("Migrating to %s succeeded", newRoot)
+		}
+	}
+	if err := MigrateRootDir(t.TempDir()+"/missing", t.TempDir()+"/new"); err == nil {
+		t.Error("Migrating a missing root succeeded")
+	}
+}
+
+func TestMigrateRootDirWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustMountVolume(t, d, "vol", "container")
+	newRoot := t.TempDir() + "/new"
+	if err := MigrateRootDir(filepath.Clean(d.DotRootDirPath()), newRoot); err == nil {
+		t.Error("Migrating a root with a mounted volume succeeded")
+	}
+	if _, err := os.Stat(newRoot); !os.IsNotExist(err) {
+		t.Errorf("The refused migration created the new root (%v)", err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
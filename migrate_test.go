@@ -0,0 +1,85 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestMigrateVolumeWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	oldBase, newBase := t.TempDir(), t.TempDir()
+	writeFiles(t, oldBase, map[string]string{"file": "old", "deleted": "old", "dir/file": "old"})
+	writeFiles(t, newBase, map[string]string{"file": "new", "only-new": "new", "dir": "now a file"})
+	MustCreateVolume(t, d, "vol", oldBase)
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	writeFiles(t, mountpoint, map[string]string{"added": "upper", "dir/added": "upper"})
+	if err := os.Remove(mountpoint + "/deleted"); err != nil {
+		t.Fatal(err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+
+	wantMismatches := []string{
+		"deleted: deleted, but doesn't exist in the new base",
+		"dir: the type differs from the new base",
+	}
+	mismatches, err := d.MigrateVolume("vol", newBase, true)
+	if err != nil {
+		t.Fatalf("Dry-run MigrateVolume failed: %v", err)
+	}
+	slices.Sort(mismatches)
+	if !slices.Equal(mismatches, wantMismatches) {
+		t.Errorf("Dry-run MigrateVolume reported %q, want %q", mismatches, wantMismatches)
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.BaseDirPath != oldBase {
+		t.Errorf("After the dry run, the base directory is %q, %v; want %q", vol.BaseDirPath, err, oldBase)
+	}
+
+	mismatches, err = d.MigrateVolume("vol", newBase, false)
+	if err != nil {
+		t.Fatalf("MigrateVolume failed: %v", err)
+	}
+	slices.Sort(mismatches)
+	if !slices.Equal(mismatches, wantMismatches) {
+		t.Errorf("MigrateVolume reported %q, want %q", mismatches, wantMismatches)
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.BaseDirPath != newBase {
+		t.Errorf("After the migration, the base directory is %q, %v; want %q", vol.BaseDirPath, err, newBase)
+	}
+
+	mountpoint = MustMountVolume(t, d, "vol", "container")
+	for path, want := range map[string]string{"file": "new", "only-new": "new", "added": "upper"} {
+		if contents, err := os.ReadFile(mountpoint + "/" + path); err != nil || string(contents) != want {
+			t.Errorf("After the migration, the volume's %s = %q, %v; want %q", path, contents, err, want)
+		}
+	}
+	writeFiles(t, mountpoint, map[string]string{"written": "after the migration"})
+	MustUnmountVolume(t, d, "vol", "container")
+	if _, err := os.Stat(newBase + "/written"); !os.IsNotExist(err) {
+		t.Errorf("The new base directory was modified (%v)", err)
+	}
+}
+
+func TestMigrateVolumeFailures(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	oldBase := t.TempDir()
+	MustCreateVolume(t, d, "vol", oldBase)
+
+	for _, newBase := range []string{"relative", t.TempDir() + "/missing"} {
+		if _, err := d.MigrateVolume("vol", newBase, false); err == nil {
+			t.Errorf("The volume was migrated to %s", newBase)
+		}
+	}
+	if _, err := d.MigrateVolume("missing", t.TempDir(), false); err == nil {
+		t.Error("A nonexistent volume was migrated")
+	}
+	MustMountVolume(t, d, "vol", "container")
+	if _, err := d.MigrateVolume("vol", t.TempDir(), false); err == nil {
+		t.Error("The mounted volume was migrated")
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.BaseDirPath != oldBase {
+		t.Errorf("After the failed migrations, the base directory is %q, %v; want %q", vol.BaseDirPath, err, oldBase)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// procSelfMountInfo is the file describing the mounts in the plugin's mount namespace
+const procSelfMountInfo = "/proc/self/mountinfo"
+
+// mountInfoEntry is a (partial) representation of a line of a `/proc/<pid>/mountinfo` file. See proc(5) for details.
+type mountInfoEntry struct {
+	MountPoint   string
+	Options      string
+	FsType       string
+	Source       string
+	SuperOptions string
+}
+
+// readMountInfo parses the given mountinfo file (usually, `procSelfMountInfo`).
+func readMountInfo(path string) ([]mountInfoEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []mountInfoEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Optional fields are terminated by a single hyphen, followed by three more fields
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep == -1 || sep+2 >= len(fields) {
+			return nil, fmt.Errorf("malformed line in %s: %q", path, scanner.Text())
+		}
+
+		entry := mountInfoEntry{
+			MountPoint: unescapeMountInfo(fields[4]),
+			Options:    fields[5],
+			FsType:     fields[sep+1],
+			Source:     unescapeMountInfo(fields[sep+2]),
+		}
+		if sep+3 < len(fields) {
+			entry.SuperOptions = fields[sep+3]
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// unescapeMountInfo decodes the octal escapes (like `\040` for a space) the kernel uses in the mount tables.
+func unescapeMountInfo(s string) string {
+	if !strings.ContainsRune(s, '\\') {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if code, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isPathUnder reports whether `path` is `dir` itself or is located inside it. Both must be clean absolute paths.
+func isPathUnder(path, dir string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// errNestedOverlay is returned by `checkOverlayNesting` when the base directory is inside an overlay mount
+var errNestedOverlay = errors.New("base directory is inside an existing overlay mount; nested overlays require " +
+	"kernel 5.11+ and correct options")
+
+// checkOverlayNesting checks whether `baseDir` (a clean absolute path) is located on an overlay filesystem, that is
+// whether using it as a lower directory would make an overlay on top of another overlay.
+//
+// If it is, a warning is logged and, unless `d.AllowNestedOverlay` is set, `errNestedOverlay` is returned. If the mount
+// table cannot be read, the check is skipped (with a warning).
+func (d *DockerOnTop) checkOverlayNesting(baseDir string) error {
+	entries, err := readMountInfo(procSelfMountInfo)
+	if err != nil {
+		d.logger.Warn("Failed to read the mount table, skipping the overlay nesting check", "error", err)
+		return nil
+	}
+
+	containing := containingMount(entries, baseDir)
+	if containing == nil || containing.FsType != "overlay" {
+		return nil
+	}
+	d.logger.Warn("Base directory is inside an existing overlay mount; nested overlays require kernel 5.11+ and "+
+		"correct options", "baseDir", baseDir, "overlayMountpoint", containing.MountPoint)
+	if d.AllowNestedOverlay {
+		return nil
+	}
+	return errNestedOverlay
+}
+
+// containingMount returns the entry of the filesystem `path` (a clean absolute path) is located on: the one mounted at
+// the deepest mountpoint containing it. If several filesystems are mounted at the same place, the last one shadows
+// the others. Nil is returned if no mountpoint contains the path.
+func containingMount(entries []mountInfoEntry, path string) *mountInfoEntry {
+	var containing *mountInfoEntry
+	for i := range entries {
+		if !isPathUnder(path, entries[i].MountPoint) {
+			continue
+		}
+		if containing == nil || len(entries[i].MountPoint) >= len(containing.MountPoint) {
+			containing = &entries[i]
+		}
+	}
+	return containing
+}
+
+// overlaySource returns the "device" name the volume's overlay is mounted with.
+func overlaySource(volumeName string) string {
+	return "docker-on-top_" + volumeName
+}
+
+// procMounts is the file listing the mounts in the plugin's mount namespace, in the fstab-like format
+const procMounts = "/proc/mounts"
+
+// parseProcMounts returns the set of names of the volumes whose overlays are mounted in the plugin's mount namespace,
+// according to `/proc/mounts`.
+func parseProcMounts() (map[string]bool, error) {
+	return parseProcMountsFile(procMounts)
+}
+
+// parseProcMountsFile is `parseProcMounts` for an arbitrary file in the `/proc/mounts` format.
+func parseProcMountsFile(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounted := map[string]bool{}
+	sourcePrefix := overlaySource("")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields: source, mountpoint, fstype, options, dump, pass
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != "overlay" {
+			continue
+		}
+		source := unescapeMountInfo(fields[0])
+		if strings.HasPrefix(source, sourcePrefix) {
+			mounted[strings.TrimPrefix(source, sourcePrefix)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounted, nil
+}
+
+// isOverlayMounted reports whether the volume's overlay is currently mounted at its mountpoint in the plugin's mount
+// namespace, according to `/proc/self/mountinfo`.
+func (d *DockerOnTop) isOverlayMounted(volumeName string) (bool, error) {
+	return d.isOverlayMountedIn(procSelfMountInfo, volumeName)
+}
+
+// isOverlayMountedIn is `isOverlayMounted` for an arbitrary file in the mountinfo format.
+func (d *DockerOnTop) isOverlayMountedIn(path string, volumeName string) (bool, error) {
+	entries, err := readMountInfo(path)
+	if err != nil {
+		return false, err
+	}
+	mountpoint := filepath.Clean(d.mountpointdir(volumeName))
+	for _, entry := range entries {
+		if entry.Source == overlaySource(volumeName) && entry.MountPoint == mountpoint {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// overlayMountpoints returns all the places the volume's overlay is mounted at in the plugin's mount namespace,
+// according to `/proc/self/mountinfo`, regardless of whether it is the volume's mountpoint or not.
+func overlayMountpoints(volumeName string) ([]string, error) {
+	return overlayMountpointsIn(procSelfMountInfo, volumeName)
+}
+
+// overlayMountpointsIn is `overlayMountpoints` for an arbitrary file in the mountinfo format.
+func overlayMountpointsIn(path string, volumeName string) ([]string, error) {
+	entries, err := readMountInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	var mountpoints []string
+	for _, entry := range entries {
+		if entry.Source == overlaySource(volumeName) && entry.FsType == "overlay" {
+			mountpoints = append(mountpoints, entry.MountPoint)
+		}
+	}
+	return mountpoints, nil
+}
+
+// checkOverlayGone verifies that the volume's overlay is no longer listed in `/proc/self/mountinfo` after it has been
+// unmounted. If it still is (which may happen because of rare kernel races), the error is logged and the volume is
+// marked as stuck in its metadata, so that the next `Mount` attempts to unmount the overlay forcibly first (see
+// `forceUnmountStuckOverlay`).
+//
+// The caller is expected to hold the volume's lock (see `lockVolume`).
+func (d *DockerOnTop) checkOverlayGone(volumeName string) {
+	mountpoints, err := overlayMountpoints(volumeName)
+	if err != nil {
+		d.logger.Warn("Failed to check that the overlay is unmounted", "volume", volumeName, "error", err)
+		return
+	}
+	if len(mountpoints) == 0 {
+		return
+	}
+
+	d.logger.Error("The overlay is still mounted after unmount. Marking the volume as stuck", "volume", volumeName,
+		"mountpoints", mountpoints)
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err == nil {
+		thisVol.Stuck = true
+		err = d.writeVolumeInfo(volumeName, thisVol)
+	}
+	if err != nil {
+		d.logger.Error("Failed to mark the volume as stuck", "volume", volumeName, "error", err)
+	}
+}
+
+// forceUnmountStuckOverlay forcibly unmounts all the remaining mounts of the overlay of a volume marked as stuck (see
+// `checkOverlayGone`) and clears the mark. Errors are logged but not returned: mounting is attempted anyway.
+//
+// The caller is expected to hold the volume's lock (see `lockVolume`).
+func (d *DockerOnTop) forceUnmountStuckOverlay(volumeName string, thisVol *VolumeInfo) {
+	d.logger.Warn("Volume is marked as stuck: its overlay was not cleaned up on the last unmount. Attempting a "+
+		"forced unmount", "volume", volumeName)
+
+	mountpoints, err := overlayMountpoints(volumeName)
+	if err != nil {
+		d.logger.Error("Failed to find the mounts of the stuck overlay", "volume", volumeName, "error", err)
+		return
+	}
+	for _, mountpoint := range mountpoints {
+		if err := d.unmountSyscall(mountpoint, syscall.MNT_FORCE|syscall.MNT_DETACH); err != nil {
+			d.logger.Error("Failed to forcibly unmount the stuck overlay", "volume", volumeName,
+				"mountpoint", mountpoint, "error", err)
+			return
+		}
+	}
+
+	thisVol.Stuck = false
+	if err := d.writeVolumeInfo(volumeName, *thisVol); err != nil {
+		d.logger.Error("Failed to clear the stuck mark of the volume", "volume", volumeName, "error", err)
+	}
+}
+
+// recoverActivemountsFromProcMounts attempts to restore the active mounts of a volume whose overlay is mounted but
+// whose activemounts/ directory is empty (for example, if it got lost because of a crash). The mount tables of all
+// processes (`/proc/<pid>/mounts`) are checked for the volume's overlay: each mount namespace (other than the
+// plugin's) where it is found is considered a container using the volume, and a synthetic active mount file named
+// `recovered-<namespace inode>` is created for it.
+//
+// The number of recovered active mounts is returned. The caller is expected to hold the volume's lock.
+func (d *DockerOnTop) recoverActivemountsFromProcMounts(volumeName string) (int, error) {
+	return d.recoverActivemountsFromProcDir("/proc", volumeName)
+}
+
+// recoverActivemountsFromProcDir is `recoverActivemountsFromProcMounts` for an arbitrary directory in the `/proc`
+// layout (only the `<pid>/ns/mnt` links and the `<pid>/mounts` files are used).
+func (d *DockerOnTop) recoverActivemountsFromProcDir(procDir string, volumeName string) (int, error) {
+	ownNamespace, err := os.Readlink(procDir + "/self/ns/mnt")
+	if err != nil {
+		return 0, err
+	}
+	procEntries, err := os.ReadDir(procDir)
+	if err != nil {
+		return 0, err
+	}
+
+	namespaces := map[string]bool{}
+	for _, procEntry := range procEntries {
+		pid := procEntry.Name()
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		namespace, err := os.Readlink(procDir + "/" + pid + "/ns/mnt")
+		if err != nil || namespace == ownNamespace || namespaces[namespace] {
+			// Processes may exit at any moment, so errors are expected here
+			continue
+		}
+		if usesOverlay, err := mountsContainSource(procDir+"/"+pid+"/mounts", overlaySource(volumeName)); err == nil &&
+			usesOverlay {
+			namespaces[namespace] = true
+		}
+	}
+
+	recovered := 0
+	for namespace := range namespaces {
+		// Namespace links look like "mnt:[4026531841]"
+		inode := strings.TrimSuffix(strings.TrimPrefix(namespace, "mnt:["), "]")
+		f, err := os.Create(d.activemountsdir(volumeName) + "recovered-" + inode)
+		if err != nil {
+			return recovered, err
+		}
+		_ = f.Close()
+		recovered++
+	}
+
+	d.logger.Info("recovered active mounts from /proc", "volume", volumeName, "count", recovered)
+	return recovered, nil
+}
+
+// mountsContainSource reports whether any of the mounts listed in the given file (in the `/proc/<pid>/mounts`
+// format) has the given source.
+func mountsContainSource(path string, source string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && unescapeMountInfo(fields[0]) == source {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// CheckMount reports whether the volume's overlay is mounted at its mountpoint, according to `/proc/mounts`. The
+// container runtime may unmount it behind the plugin's back, so this is useful to verify that a volume recorded as in
+// use is actually usable. An error is only returned if `/proc/mounts` cannot be read.
+func (d *DockerOnTop) CheckMount(volumeName string) (bool, error) {
+	return d.checkMountInFile(procMounts, volumeName)
+}
+
+// checkMountInFile is `CheckMount` for an arbitrary file in the `/proc/mounts` format.
+func (d *DockerOnTop) checkMountInFile(path string, volumeName string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	mountpoint := filepath.Clean(d.mountpointdir(volumeName))
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields: source, mountpoint, fstype, options, dump, pass. The fstype is not checked, so that the overlays
+		// mounted with `fuse-overlayfs` are recognized as well
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		if unescapeMountInfo(fields[0]) == overlaySource(volumeName) && unescapeMountInfo(fields[1]) == mountpoint {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// writeMountTable writes a fake mount table (like `/proc/mounts`) and returns its path.
+func writeMountTable(t *testing.T, contents string) string {
+	t.Helper()
+	path := t.TempDir() + "/mounts"
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseProcMountsFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		want  map[string]bool
+	}{
+		{
+			name: "volumes",
+			table: `proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+docker-on-top_vol /var/lib/docker-on-top/vol/mountpoint overlay rw,lowerdir=/data,upperdir=/u,workdir=/w 0 0
+docker-on-top_other /var/lib/docker-on-top/other/mountpoint overlay rw 0 0
+`,
+			want: map[string]bool{"vol": true, "other": true},
+		},
+		{
+			name: "escaped paths",
+			table: `docker-on-top_team.vol\040x /var/lib/docker-on-top/team/vol\040x/mountpoint overlay rw 0 0
+docker-on-top_tab\011and\012newline /mnt/tab\011and\012newline overlay rw 0 0
+docker-on-top_back\134slash /mnt/back\134slash overlay rw 0 0
+`,
+			want: map[string]bool{"team.vol x": true, "tab\tand\nnewline": true, "back\\slash": true},
+		},
+		{
+			name: "overlay nested in an overlay",
+			table: `overlay /var/lib/docker/overlay2/abc/merged overlay rw,lowerdir=/l,upperdir=/u,workdir=/w 0 0
+docker-on-top_vol /var/lib/docker/overlay2/abc/merged/mnt/vol overlay rw,lowerdir=/data 0 0
+`,
+			want: map[string]bool{"vol": true},
+		},
+		{
+			name: "not overlays",
+			table: `docker-on-top_tmpfs /var/lib/docker-on-top/tmpfs/upper tmpfs rw 0 0
+docker-on-top_fuse /var/lib/docker-on-top/fuse/mountpoint fuse.fuse-overlayfs rw 0 0
+overlay /var/lib/docker/overlay2/abc/merged overlay rw,lowerdir=/l,upperdir=/u,workdir=/w 0 0
+short
+`,
+			want: map[string]bool{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mounted, err := parseProcMountsFile(writeMountTable(t, test.table))
+			if err != nil || !reflect.DeepEqual(mounted, test.want) {
+				t.Errorf("parseProcMountsFile = %v, %v; want %v", mounted, err, test.want)
+			}
+		})
+	}
+
+	if _, err := parseProcMountsFile(t.TempDir() + "/missing"); err == nil {
+		t.Error("parseProcMountsFile succeeded for a missing file")
+	}
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	for escaped, want := range map[string]string{
+		"/mnt/data":                    "/mnt/data",
+		`/mnt/vol\040x`:                "/mnt/vol x",
+		`/mnt/a\011b\012c\134d`:        "/mnt/a\tb\nc\\d",
+		`\040leading and trailing\040`: " leading and trailing ",
+		`/mnt/\134040`:                 `/mnt/\040`, // Unescaped only once
+		`/mnt/short\04`:                `/mnt/short\04`,
+		`/mnt/not-octal\089`:           `/mnt/not-octal\089`,
+		`/mnt/too-large\777`:           `/mnt/too-large\777`,
+		`/mnt/trailing\`:               `/mnt/trailing\`,
+	} {
+		if unescaped := unescapeMountInfo(escaped); unescaped != want {
+			t.Errorf("unescapeMountInfo(%q) = %q, want %q", escaped, unescaped, want)
+		}
+	}
+}
+
+func TestReadMountInfo(t *testing.T) {
+	tests := []struct {
+		name      string
+		table     string
+		want      []mountInfoEntry
+		malformed bool
+	}{
+		{
+			name:  "optional fields",
+			table: "22 1 8:1 / / rw,relatime shared:1 master:2 propagate_from:2 unbindable - ext4 /dev/sda1 rw\n",
+			want: []mountInfoEntry{
+				{MountPoint: "/", Options: "rw,relatime", FsType: "ext4", Source: "/dev/sda1", SuperOptions: "rw"},
+			},
+		},
+		{
+			name:  "no optional fields and super options",
+			table: "97 22 0:50 / /mnt rw - tmpfs tmpfs\n",
+			want:  []mountInfoEntry{{MountPoint: "/mnt", Options: "rw", FsType: "tmpfs", Source: "tmpfs"}},
+		},
+		{
+			name: "escaped paths",
+			table: `97 22 0:50 / /var/lib/dot/vol\040x/mountpoint rw,relatime - overlay docker-on-top_vol\040x rw
+98 22 0:51 / /mnt/a\011b\012c\134d rw - ext4 /dev/disk\040a rw
+`,
+			want: []mountInfoEntry{
+				{MountPoint: "/var/lib/dot/vol x/mountpoint", Options: "rw,relatime", FsType: "overlay",
+					Source: "docker-on-top_vol x", SuperOptions: "rw"},
+				{MountPoint: "/mnt/a\tb\nc\\d", Options: "rw", FsType: "ext4", Source: "/dev/disk a",
+					SuperOptions: "rw"},
+			},
+		},
+		{
+			name: "overlay nested in an overlay",
+			table: `300 29 0:60 / /var/lib/docker/overlay2/abc/merged rw - overlay overlay rw,lowerdir=/l,upperdir=/u
+301 300 0:61 / /var/lib/docker/overlay2/abc/merged/mnt/vol rw - overlay docker-on-top_vol rw,lowerdir=/data
+`,
+			want: []mountInfoEntry{
+				{MountPoint: "/var/lib/docker/overlay2/abc/merged", Options: "rw", FsType: "overlay",
+					Source: "overlay", SuperOptions: "rw,lowerdir=/l,upperdir=/u"},
+				{MountPoint: "/var/lib/docker/overlay2/abc/merged/mnt/vol", Options: "rw", FsType: "overlay",
+					Source: "docker-on-top_vol", SuperOptions: "rw,lowerdir=/data"},
+			},
+		},
+		{name: "empty"},
+		{name: "no separator", table: "22 1 8:1 / / rw,relatime\n", malformed: true},
+		{name: "no mount options", table: "22 1 8:1 / / - ext4 /dev/sda1 rw\n", malformed: true},
+		{name: "no fields after the separator", table: "22 1 8:1 / / rw - ext4\n", malformed: true},
+		{
+			name:      "malformed line after valid ones",
+			table:     "22 1 8:1 / / rw - ext4 /dev/sda1 rw\n23 22 0:5 /dev\n",
+			malformed: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := readMountInfo(writeMountTable(t, test.table))
+			if test.malformed {
+				if err == nil {
+					t.Errorf("readMountInfo accepted the malformed table as %+v", entries)
+				}
+			} else if err != nil || !reflect.DeepEqual(entries, test.want) {
+				t.Errorf("readMountInfo = %+v, %v; want %+v", entries, err, test.want)
+			}
+		})
+	}
+
+	if _, err := readMountInfo(t.TempDir() + "/missing"); err == nil {
+		t.Error("readMountInfo succeeded for a missing file")
+	}
+}
+
+func TestContainingMount(t *testing.T) {
+	const container = "/var/lib/docker/overlay2/abc/merged"
+	entries := []mountInfoEntry{
+		{MountPoint: "/", FsType: "ext4"},
+		{MountPoint: "/data", FsType: "xfs"},
+		{MountPoint: container, FsType: "overlay", Source: "overlay"},
+		// An overlay nested in the container's overlay, and a bind mount on top of the container's overlay
+		{MountPoint: container + "/mnt/vol", FsType: "overlay", Source: "docker-on-top_vol"},
+		{MountPoint: container + "/data", FsType: "xfs"},
+		// The later mount shadows the earlier one
+		{MountPoint: "/mnt/shadowed", FsType: "overlay"},
+		{MountPoint: "/mnt/shadowed", FsType: "tmpfs"},
+	}
+	tests := []struct {
+		path string
+		want int // The index of the containing entry
+	}{
+		{path: "/", want: 0},
+		{path: "/home/user", want: 0},
+		{path: "/data", want: 1},
+		{path: "/data/sub", want: 1},
+		{path: "/database", want: 0},
+		{path: container + "/etc", want: 2},
+		{path: container + "/mnt", want: 2},
+		{path: container + "/mnt/vol", want: 3},
+		{path: container + "/mnt/vol/sub", want: 3},
+		{path: container + "/mnt/volume", want: 2},
+		{path: container + "/data/sub", want: 4},
+		{path: "/mnt/shadowed/sub", want: 6},
+	}
+	for _, test := range tests {
+		if containing := containingMount(entries, test.path); containing != &entries[test.want] {
+			t.Errorf("containingMount(%s) = %+v, want %+v", test.path, containing, entries[test.want])
+		}
+	}
+
+	if containing := containingMount(entries[1:], "/home/user"); containing != nil {
+		t.Errorf("Without the root mount, containingMount = %+v, want nil", containing)
+	}
+}
+
+func TestCheckMountInFile(t *testing.T) {
+	d := newDockerOnTop(context.Background(), "/var/lib/docker-on-top/")
+	path := writeMountTable(t, `docker-on-top_mounted /var/lib/docker-on-top/mounted/mountpoint overlay rw 0 0
+docker-on-top_fuse /var/lib/docker-on-top/fuse/mountpoint fuse.fuse-overlayfs rw 0 0
+docker-on-top_elsewhere /mnt/elsewhere overlay rw 0 0
+docker-on-top_other /var/lib/docker-on-top/moved/mountpoint overlay rw 0 0
+`)
+	for volumeName, want := range map[string]bool{
+		"mounted":   true,
+		"fuse":      true,
+		"elsewhere": false,
+		"moved":     false,
+		"missing":   false,
+	} {
+		if mounted, err := d.checkMountInFile(path, volumeName); err != nil || mounted != want {
+			t.Errorf("checkMountInFile(%s) = %v, %v; want %v", volumeName, mounted, err, want)
+		}
+	}
+
+	if _, err := d.checkMountInFile(path+".missing", "mounted"); err == nil {
+		t.Error("checkMountInFile succeeded for a missing file")
+	}
+}
+
+func TestIsOverlayMountedIn(t *testing.T) {
+	d := newDockerOnTop(context.Background(), "/var/lib/docker on top/")
+	// The plugin runs in a container, whose root is an overlay itself
+	path := writeMountTable(t, `1 0 0:1 / / rw - overlay overlay rw,lowerdir=/l,upperdir=/u,workdir=/w
+2 1 0:2 / /var/lib/docker\040on\040top/mounted/mountpoint rw - overlay docker-on-top_mounted rw,lowerdir=/data
+3 1 0:3 / /var/lib/docker\040on\040top/team.vol/mountpoint rw - overlay docker-on-top_team.vol rw
+4 1 0:4 / /mnt/elsewhere rw - overlay docker-on-top_elsewhere rw
+5 1 0:5 / /var/lib/docker\040on\040top/swapped/mountpoint rw - overlay docker-on-top_other rw
+6 1 0:6 / /var/lib/docker\040on\040top/prefix/mountpoint rw - overlay docker-on-top_prefixed rw
+7 1 0:7 / /var/lib/docker-on-top/unescaped/mountpoint rw - overlay docker-on-top_unescaped rw
+`)
+	for volumeName, want := range map[string]bool{
+		"mounted":   true,
+		"team.vol":  true,
+		"elsewhere": false,
+		"swapped":   false,
+		"other":     false,
+		"prefix":    false,
+		"prefixed":  false,
+		"unescaped": false,
+		"missing":   false,
+	} {
+		if mounted, err := d.isOverlayMountedIn(path, volumeName); err != nil || mounted != want {
+			t.Errorf("isOverlayMountedIn(%s) = %v, %v; want %v", volumeName, mounted, err, want)
+		}
+	}
+
+	if _, err := d.isOverlayMountedIn(writeMountTable(t, "1 0 0:1 / / rw\n"), "mounted"); err == nil {
+		t.Error("isOverlayMountedIn succeeded for a malformed table")
+	}
+	if _, err := d.isOverlayMountedIn(path+".missing", "mounted"); err == nil {
+		t.Error("isOverlayMountedIn succeeded for a missing file")
+	}
+}
+
+func TestOverlayMountpointsIn(t *testing.T) {
+	path := writeMountTable(t, `1 0 0:1 / / rw - overlay overlay rw,lowerdir=/l,upperdir=/u,workdir=/w
+2 1 0:2 / /var/lib/docker\040on\040top/vol/mountpoint rw - overlay docker-on-top_vol rw,lowerdir=/data
+3 1 0:3 / /var/lib/docker/overlay2/abc/merged rw - overlay overlay rw,lowerdir=/l2,upperdir=/u2,workdir=/w2
+4 3 0:2 / /var/lib/docker/overlay2/abc/merged/mnt/vol rw - overlay docker-on-top_vol rw,lowerdir=/data
+5 1 0:4 / /mnt/tab\011and\012newline rw - overlay docker-on-top_vol rw,lowerdir=/data
+6 1 0:5 / /var/lib/docker\040on\040top/fuse/mountpoint rw - fuse.fuse-overlayfs docker-on-top_fuse rw
+7 1 0:6 / /var/lib/docker\040on\040top/volume/mountpoint rw - overlay docker-on-top_volume rw
+`)
+	for volumeName, want := range map[string][]string{
+		"vol": {
+			"/var/lib/docker on top/vol/mountpoint",
+			"/var/lib/docker/overlay2/abc/merged/mnt/vol",
+			"/mnt/tab\tand\nnewline",
+		},
+		"volume":  {"/var/lib/docker on top/volume/mountpoint"},
+		"fuse":    nil,
+		"missing": nil,
+	} {
+		if mountpoints, err := overlayMountpointsIn(path, volumeName); err != nil ||
+			!reflect.DeepEqual(mountpoints, want) {
+			t.Errorf("overlayMountpointsIn(%s) = %q, %v; want %q", volumeName, mountpoints, err, want)
+		}
+	}
+
+	if _, err := overlayMountpointsIn(writeMountTable(t, "1 0 0:1 / / rw\n"), "vol"); err == nil {
+		t.Error("overlayMountpointsIn succeeded for a malformed table")
+	}
+	if _, err := overlayMountpointsIn(path+".missing", "vol"); err == nil {
+		t.Error("overlayMountpointsIn succeeded for a missing file")
+	}
+}
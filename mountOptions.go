@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MountOptions describes the options an overlay is mounted with (see `BuildOptionsString`).
+type MountOptions struct {
+	// LowerDirs are the lower directories, from the topmost to the bottommost one. At least one is required
+	LowerDirs []string
+	// UpperDir and WorkDir are the upperdir and the workdir. Either both or neither (for a read-only overlay) must be
+	// set
+	UpperDir string
+	WorkDir  string
+	// ExtraOptions are the other overlay options, like `index=off`. The options without a value (like `userxattr`)
+	// have an empty value
+	ExtraOptions map[string]string
+}
+
+// mountOptionNameFormat is the format of the names of the extra mount options
+var mountOptionNameFormat = regexp.MustCompile("^[a-z0-9_]+$")
+
+// mountOptionsPathEscaper escapes the characters that have a special meaning in the overlay mount options: the comma
+// separates the options and the colon separates the lower directories. overlayfs unescapes them with the backslash
+var mountOptionsPathEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `:`, `\:`)
+
+// BuildOptionsString assembles the overlay mount options string (the `data` of the mount syscall) from `mo`: the
+// `lowerdir`, `upperdir`, and `workdir` options followed by the extra options sorted by name, comma-separated. The
+// special characters of the paths are escaped.
+//
+// An error is returned if the options are incomplete, if an extra option tries to set the directories, or if its name
+// or value contains characters that could make it be parsed as several options.
+func BuildOptionsString(mo MountOptions) (string, error) {
+	if len(mo.LowerDirs) == 0 {
+		return "", errors.New("no lower directories given")
+	}
+	if (mo.UpperDir == "") != (mo.WorkDir == "") {
+		return "", errors.New("the upperdir and the workdir must be given together")
+	}
+	for _, dir := range append(append([]string{}, mo.LowerDirs...), mo.UpperDir, mo.WorkDir) {
+		if strings.ContainsRune(dir, 0) {
+			return "", fmt.Errorf("the directory %q contains a null byte", dir)
+		}
+	}
+
+	lowerdirs := make([]string, len(mo.LowerDirs))
+	for i, dir := range mo.LowerDirs {
+		if dir == "" {
+			return "", errors.New("empty lower directory given")
+		}
+		lowerdirs[i] = mountOptionsPathEscaper.Replace(dir)
+	}
+	options := []string{"lowerdir=" + strings.Join(lowerdirs, ":")}
+	if mo.UpperDir != "" {
+		options = append(options, "upperdir="+mountOptionsPathEscaper.Replace(mo.UpperDir),
+			"workdir="+mountOptionsPathEscaper.Replace(mo.WorkDir))
+	}
+
+	names := make([]string, 0, len(mo.ExtraOptions))
+	for name := range mo.ExtraOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := mo.ExtraOptions[name]
+		switch {
+		case name == "lowerdir" || name == "upperdir" || name == "workdir":
+			return "", fmt.Errorf("option `%s` cannot be given as an extra option", name)
+		case !mountOptionNameFormat.MatchString(name):
+			return "", fmt.Errorf("invalid mount option name %q", name)
+		case strings.ContainsAny(value, ",\\\x00"):
+			// A comma would start another option, like `index=on,upperdir=/etc`
+			return "", fmt.Errorf("mount option `%s` has an invalid value %q: commas and backslashes are not "+
+				"allowed", name, value)
+		case value == "":
+			options = append(options, name)
+		default:
+			// Everything after the first `=` is the value, so it may contain `=` as well
+			options = append(options, name+"="+value)
+		}
+	}
+	return strings.Join(options, ","), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildOptionsString(t *testing.T) {
+	for name, test := range map[string]struct {
+		mo   MountOptions
+		want string
+	}{
+		"no extra options": {
+			MountOptions{LowerDirs: []string{"/base"}, UpperDir: "/upper", WorkDir: "/work"},
+			"lowerdir=/base,upperdir=/upper,workdir=/work",
+		},
+		"empty extra options": {
+			MountOptions{LowerDirs: []string{"/base"}, UpperDir: "/upper", WorkDir: "/work",
+				ExtraOptions: map[string]string{}},
+			"lowerdir=/base,upperdir=/upper,workdir=/work",
+		},
+		"multiple lower dirs": {
+			MountOptions{LowerDirs: []string{"/top", "/middle", "/bottom"}, UpperDir: "/upper", WorkDir: "/work"},
+			"lowerdir=/top:/middle:/bottom,upperdir=/upper,workdir=/work",
+		},
+		"read-only": {
+			MountOptions{LowerDirs: []string{"/top", "/bottom"}},
+			"lowerdir=/top:/bottom",
+		},
+		"extra options sorted": {
+			MountOptions{LowerDirs: []string{"/base"}, UpperDir: "/upper", WorkDir: "/work",
+				ExtraOptions: map[string]string{"userxattr": "", "index": "off", "metacopy": "on"}},
+			"lowerdir=/base,upperdir=/upper,workdir=/work,index=off,metacopy=on,userxattr",
+		},
+		"value containing =": {
+			MountOptions{LowerDirs: []string{"/base"}, UpperDir: "/upper", WorkDir: "/work",
+				ExtraOptions: map[string]string{"context": "system_u:object_r:container_file_t:s0=x"}},
+			"lowerdir=/base,upperdir=/upper,workdir=/work,context=system_u:object_r:container_file_t:s0=x",
+		},
+		"escaped paths": {
+			MountOptions{LowerDirs: []string{"/a:b", `/c\d`}, UpperDir: "/up,per", WorkDir: "/work"},
+			`lowerdir=/a\:b:/c\\d,upperdir=/up\,per,workdir=/work`,
+		},
+	} {
+		if options, err := BuildOptionsString(test.mo); err != nil || options != test.want {
+			t.Errorf("%s: BuildOptionsString = %q, %v; want %q", name, options, err, test.want)
+		}
+	}
+}
+
+func TestBuildOptionsStringValidation(t *testing.T) {
+	valid := func(extra map[string]string) MountOptions {
+		return MountOptions{LowerDirs: []string{"/base"}, UpperDir: "/upper", WorkDir: "/work", ExtraOptions: extra}
+	}
+	for name, mo := range map[string]MountOptions{
+		"no lower dirs":       {UpperDir: "/upper", WorkDir: "/work"},
+		"empty lower dir":     {LowerDirs: []string{"/base", ""}, UpperDir: "/upper", WorkDir: "/work"},
+		"upperdir alone":      {LowerDirs: []string{"/base"}, UpperDir: "/upper"},
+		"workdir alone":       {LowerDirs: []string{"/base"}, WorkDir: "/work"},
+		"null byte in a path": {LowerDirs: []string{"/base\x00,upperdir=/etc"}, UpperDir: "/upper", WorkDir: "/work"},
+		"injected option":     valid(map[string]string{"index": "on,upperdir=/etc"}),
+		"injected escape":     valid(map[string]string{"index": `on\`}),
+		"injected null byte":  valid(map[string]string{"index": "on\x00"}),
+		"injected name":       valid(map[string]string{"index=on,upperdir": "/etc"}),
+		"uppercase name":      valid(map[string]string{"Index": "on"}),
+		"empty name":          valid(map[string]string{"": "on"}),
+		"upperdir as extra":   valid(map[string]string{"upperdir": "/etc"}),
+		"lowerdir as extra":   valid(map[string]string{"lowerdir": "/etc"}),
+		"workdir as extra":    valid(map[string]string{"workdir": "/etc"}),
+	} {
+		if options, err := BuildOptionsString(mo); err == nil {
+			t.Errorf("%s: BuildOptionsString = %q, want an error", name, options)
+		}
+	}
+
+	// The escaped paths can't end the option early
+	options, err := BuildOptionsString(MountOptions{LowerDirs: []string{"/base,upperdir=/etc"}, UpperDir: "/upper",
+		WorkDir: "/work"})
+	if err != nil || strings.Count(strings.ReplaceAll(options, `\,`, ""), ",") != 2 {
+		t.Errorf("With a comma in the lower dir, BuildOptionsString = %q, %v; want 3 options", options, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// defaultMountTimeout is the default limit on the time the overlay mount syscall may take (see `WithMountTimeout`)
+const defaultMountTimeout = 30 * time.Second
+
+// mountFunc is the signature of `syscall.Mount`, which can be replaced for testing (see `DockerOnTop.mountSyscall`)
+type mountFunc func(source string, target string, fstype string, flags uintptr, data string) error
+
+// unmountFunc is the signature of `syscall.Unmount`, which can be replaced for testing (see
+// `DockerOnTop.unmountSyscall`)
+type unmountFunc func(target string, flags int) error
+
+// mountTimeoutError is returned by `mountWithTimeout` if the mount syscall doesn't return in time
+type mountTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *mountTimeoutError) Error() string {
+	return fmt.Sprintf("mounting the overlay timed out after %v (is the base directory on an unresponsive "+
+		"filesystem?)", e.timeout)
+}
+
+// mountWithTimeout mounts the volume's overlay at `mountpoint` like `syscall.Mount` does, but gives up after
+// `d.mountTimeout`: in rare cases (e.g. with an unresponsive NFS base directory) the syscall may block indefinitely,
+// which would keep the volume locked forever. Then `*mountTimeoutError` is returned and the mountpoint is forcibly
+// unmounted, in case the mount has completed in the meantime. If the blocked syscall succeeds later, the overlay is
+// unmounted right away.
+func (d *DockerOnTop) mountWithTimeout(volumeName string, mountpoint string, flags uintptr, options string) error {
+	// Unbuffered, so that a mount completing after the timeout can't be handed over to nobody
+	result := make(chan error)
+	timedOut := make(chan struct{})
+	go func() {
+		err := d.mountSyscall(overlaySource(volumeName), mountpoint, "overlay", flags, options)
+		select {
+		case result <- err:
+		case <-timedOut:
+			if err == nil {
+				d.logger.Warn("The overlay mount completed after timing out. Unmounting it", "volume", volumeName)
+				if err := d.unmountSyscall(mountpoint, syscall.MNT_FORCE|syscall.MNT_DETACH); err != nil {
+					d.logger.Error("Failed to unmount the overlay mounted after timing out", "volume", volumeName,
+						"error", err)
+				}
+			}
+		}
+	}()
+
+	timer := time.NewTimer(d.mountTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+	}
+
+	close(timedOut)
+	select {
+	case err := <-result:
+		// Completed just now
+		return err
+	default:
+	}
+	d.logger.Error("The overlay mount timed out", "volume", volumeName, "timeout", d.mountTimeout)
+	if err := d.unmountSyscall(mountpoint, syscall.MNT_FORCE|syscall.MNT_DETACH); err != nil {
+		d.logger.Debug("Nothing to clean up after the mount timeout", "volume", volumeName, "error", err)
+	}
+	return &mountTimeoutError{timeout: d.mountTimeout}
+}
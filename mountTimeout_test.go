@@ -0,0 +1,88 @@
+//go:build dottest
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestMountTimeout(t *testing.T) {
+	m := NewMockSyscallMount()
+	release := make(chan struct{})
+	lateResult := make(chan error, 1)
+	var calls, unmounts atomic.Int32
+	// The first mount blocks until released, like with an unresponsive filesystem
+	blockingMount := func(d *DockerOnTop) {
+		d.mountSyscall = func(source, target, fstype string, flags uintptr, data string) error {
+			if calls.Add(1) != 1 {
+				return m.Mount(source, target, fstype, flags, data)
+			}
+			<-release
+			err := m.Mount(source, target, fstype, flags, data)
+			lateResult <- err
+			return err
+		}
+		d.unmountSyscall = func(target string, flags int) error {
+			unmounts.Add(1)
+			return m.Unmount(target, flags)
+		}
+	}
+	timeout := 100 * time.Millisecond
+	d := NewTestDockerOnTop(t, blockingMount, WithMountTimeout(timeout))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+
+	start := time.Now()
+	_, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"})
+	var timeoutErr *mountTimeoutError
+	if !errors.As(err, &timeoutErr) || !strings.Contains(err.Error(), "timed out after "+timeout.String()) {
+		t.Errorf("The blocked mount returned %v, want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > timeout+time.Second {
+		t.Errorf("The blocked mount took %v", elapsed)
+	}
+
+	// The volume's lock is released, and the failed mount is not recorded
+	locked := make(chan error)
+	go func() {
+		unlock, err := d.lockVolume("vol")
+		if err == nil {
+			unlock()
+		}
+		locked <- err
+	}()
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The volume is still locked after the mount timed out")
+	}
+	if mounted, err := d.volumeIsMounted("vol"); err != nil || mounted {
+		t.Errorf("After the timeout, volumeIsMounted = %v, %v", mounted, err)
+	}
+
+	// The mount completing late is undone (if the mountpoint is still there to mount at). The mountpoint was also
+	// unmounted on the timeout, in case the mount had completed
+	close(release)
+	wantUnmounts := int32(1)
+	if err := <-lateResult; err == nil {
+		wantUnmounts = 2
+	}
+	for start := time.Now(); unmounts.Load() < wantUnmounts; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("The overlay mounted after the timeout is not unmounted")
+		}
+	}
+	if _, ok := m.Mounted(d.VolumeMountpointDir("vol")); ok {
+		t.Error("The overlay mounted after the timeout is left mounted")
+	}
+	MustMountVolume(t, d, "vol", "container")
+	MustUnmountVolume(t, d, "vol", "container")
+}
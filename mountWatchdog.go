@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// mountWatchdogLockTimeout is how long the mount watchdog waits for the lock of a volume it rechecks
+const mountWatchdogLockTimeout = 5 * time.Second
+
+// StartMountWatchdog starts checking every `interval` that the overlays of the volumes in use (the ones with active
+// mounts) are still mounted (see `CheckMount`). The ones that are not, e.g. because the container runtime unmounted
+// them without telling the plugin, are logged as errors. The watchdog is stopped by `Close`.
+func (d *DockerOnTop) StartMountWatchdog(interval time.Duration) {
+	d.logger.Info("Starting the mount watchdog", "interval", interval)
+	d.goBackground(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-ticker.C:
+				d.checkActiveMounts()
+			}
+		}
+	})
+}
+
+// checkActiveMounts checks that the overlays of all the volumes in use are mounted and logs the ones that are not.
+func (d *DockerOnTop) checkActiveMounts() {
+	volumeNames, err := d.listVolumeNames()
+	if err != nil {
+		// The error is already logged by `d.listVolumeNames`
+		return
+	}
+	for _, volumeName := range volumeNames {
+		inUse, err := d.volumeIsMounted(volumeName)
+		if err != nil || !inUse {
+			// Errors are expected if the volume is being removed
+			continue
+		}
+		mounted, err := d.CheckMount(volumeName)
+		if err != nil {
+			d.logger.Error("Mount watchdog: failed to read the mount table", "error", err)
+			return
+		} else if !mounted && d.isInUseButUnmounted(volumeName) {
+			d.logger.Error("Mount watchdog: the volume is in use but its overlay is not mounted", "volume", volumeName)
+		}
+	}
+}
+
+// isInUseButUnmounted rechecks a volume that looks unmounted while in use, with the volume's lock taken: without the
+// lock, the volume may have been caught in the middle of a mount or an unmount. If the lock stays taken for
+// `mountWatchdogLockTimeout`, the volume is assumed to be fine, so that a slow mount doesn't hold up the watchdog.
+func (d *DockerOnTop) isInUseButUnmounted(volumeName string) bool {
+	unlock, err := d.locks.TryLock(volumeName, mountWatchdogLockTimeout)
+	if errors.Is(err, ErrLockTimeout) {
+		return false
+	} else if err != nil {
+		d.logger.Error("Mount watchdog: failed to lock the volume", "volume", volumeName, "error", err)
+		return false
+	}
+	defer unlock()
+	if inUse, err := d.volumeIsMounted(volumeName); err != nil || !inUse {
+		return false
+	}
+	mounted, err := d.CheckMount(volumeName)
+	return err == nil && !mounted
+}
@@ -0,0 +1,43 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestMountWatchdogWithOverlay(t *testing.T) {
+	var logs bytes.Buffer
+	d := NewTestDockerOnTopWithOverlay(t, WithLogger(newLogger(&logs, "json")))
+	for _, name := range []string{"healthy", "lost", "unused"} {
+		MustCreateVolume(t, d, name, t.TempDir())
+	}
+	MustMountVolume(t, d, "healthy", "container")
+	defer MustUnmountVolume(t, d, "healthy", "container")
+	lostMountpoint := MustMountVolume(t, d, "lost", "container")
+	// The container runtime unmounts the overlay without telling the plugin
+	if err := syscall.Unmount(lostMountpoint, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{"healthy": true, "lost": false, "unused": false} {
+		if mounted, err := d.CheckMount(name); err != nil || mounted != want {
+			t.Errorf("CheckMount(%s) = %v, %v; want %v", name, mounted, err, want)
+		}
+	}
+
+	logs.Reset()
+	d.checkActiveMounts()
+	var reported []string
+	for _, entry := range decodeLogEntries(t, &logs) {
+		if msg, _ := entry["msg"].(string); entry["level"] == "ERROR" && strings.HasPrefix(msg, "Mount watchdog") {
+			reported = append(reported, entry["volume"].(string))
+		}
+	}
+	if len(reported) != 1 || reported[0] != "lost" {
+		t.Errorf("The mount watchdog reported %v, want only lost", reported)
+	}
+}
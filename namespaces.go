@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/*
+Namespaced volumes.
+
+A volume created with the `namespaced` option has a hierarchical (dot-separated) name, like `projectA.serviceB.data`,
+and its main directory is nested accordingly: `projectA/serviceB/data/` in the dot root directory. The intermediate
+directories (`projectA/` and `projectA/serviceB/`) are namespace directories: they contain only namespaced volumes and
+other namespace directories. A volume's main directory is recognized by the activemounts/ directory or the metadata
+file inside it (see `isVolumeDir`).
+
+The volumes created without the option have their main directories right in the dot root directory, regardless of
+dots in their names. Thus, `volumeDir` checks the flat location first.
+*/
+
+// errNamespaceNotVolume is returned when a namespace directory is addressed as a volume
+var errNamespaceNotVolume = errors.New("the name refers to a namespace of volumes, not a volume")
+
+// namespacedDir returns the path of a namespaced volume's main directory relative to the dot root directory, or an
+// empty string if `volumeName` cannot be namespaced (it has no dots or has empty components).
+func namespacedDir(volumeName string) string {
+	components := strings.Split(volumeName, ".")
+	if len(components) < 2 || slices.Contains(components, "") {
+		return ""
+	}
+	return strings.Join(components, "/")
+}
+
+// isVolumeDir reports whether `path` is a volume's main directory (as opposed to a namespace directory).
+func isVolumeDir(path string) bool {
+	if _, err := os.Lstat(path + "/activemounts"); err == nil {
+		return true
+	}
+	_, err := os.Lstat(path + "/metadata.json")
+	return err == nil
+}
+
+// isNamespaceDir reports whether `path` is a namespace directory: a non-empty directory that is not a volume's main
+// directory. (An empty one is considered a broken volume, so that it can be removed.)
+func isNamespaceDir(path string) bool {
+	if isVolumeDir(path) {
+		return false
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	names, _ := dir.Readdirnames(1)
+	return len(names) > 0
+}
+
+// volumeDir returns the main directory of the volume (without a trailing slash): the nested one if the volume is
+// namespaced, the one right in the dot root directory otherwise (or if the volume doesn't exist).
+func (d *DockerOnTop) volumeDir(volumeName string) string {
+	return volumeDirIn(d.dotRootDir, volumeName)
+}
+
+// volumeDirIn is `DockerOnTop.volumeDir` for the given dot root directory (with a trailing slash).
+func volumeDirIn(dotRootDir string, volumeName string) string {
+	flat := dotRootDir + volumeName
+	if !strings.ContainsRune(volumeName, '.') {
+		return flat
+	}
+	if _, err := os.Lstat(flat); !os.IsNotExist(err) {
+		return flat
+	}
+	if nested := namespacedDir(volumeName); nested != "" && isVolumeDir(dotRootDir+nested) {
+		return dotRootDir + nested
+	}
+	return flat
+}
+
+// isNamespaced reports whether the volume's main directory is nested (see `volumeDir`).
+func (d *DockerOnTop) isNamespaced(volumeName string) bool {
+	return d.volumeDir(volumeName) != d.dotRootDir+volumeName
+}
+
+// prepareNamespacedDir checks that a namespaced volume named `volumeName` can be created and returns its main
+// directory: the name must consist of several dot-separated components, and the main directory must neither clash
+// with an existing flat volume nor be nested in another volume's main directory. The intermediate directories are
+// created.
+func (d *DockerOnTop) prepareNamespacedDir(volumeName string) (string, error) {
+	nested := namespacedDir(volumeName)
+	if nested == "" {
+		return "", errors.New("the name of a namespaced volume must consist of dot-separated components, like " +
+			"`project.service.data`")
+	}
+	if _, err := os.Lstat(d.dotRootDir + volumeName); err == nil {
+		return "", &os.PathError{Op: "mkdir", Path: d.dotRootDir + volumeName, Err: os.ErrExist}
+	}
+	parent := filepath.Dir(nested)
+	for dir := parent; dir != "."; dir = filepath.Dir(dir) {
+		if isVolumeDir(d.dotRootDir + dir) {
+			return "", errors.New("a namespace of the volume is an existing volume: " + strings.ReplaceAll(dir, "/",
+				"."))
+		}
+	}
+	if err := os.MkdirAll(d.dotRootDir+parent, os.ModePerm); err != nil {
+		return "", err
+	}
+	return d.dotRootDir + nested, nil
+}
+
+// removeEmptyNamespaceDirs removes the namespace directories the main directory of a removed namespaced volume was
+// nested in, as long as they are empty.
+func (d *DockerOnTop) removeEmptyNamespaceDirs(mainDir string) {
+	for dir := filepath.Dir(mainDir); len(dir) >= len(d.dotRootDir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			// Not empty (or already gone)
+			return
+		}
+	}
+}
+
+// listVolumeNames returns the names of all the volumes, including the namespaced ones, in ascending order of their
+// main directories. The entries of the dot root directory that are neither volumes' main directories nor namespace
+// directories are considered (broken) volumes as well; the scratch directories are skipped.
+func (d *DockerOnTop) listVolumeNames() ([]string, error) {
+	names, err := listVolumeDirs(d.dotRootDir)
+	if err != nil {
+		return nil, d.internalError("failed to list contents of the dot root directory", err)
+	}
+	return names, nil
+}
+
+// listVolumeDirs is `DockerOnTop.listVolumeNames` for the given dot root directory (with a trailing slash), without
+// logging.
+func listVolumeDirs(dotRootDir string) ([]string, error) {
+	var names []string
+	var walk func(dir string, prefix string) error
+	walk = func(dir string, prefix string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if prefix == "" && isScratchDir(name) {
+				continue
+			}
+			if isNamespaceDir(dir + name) {
+				if err := walk(dir+name+"/", prefix+name+"."); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			} else if prefix == "" || isVolumeDir(dir+name) {
+				names = append(names, prefix+name)
+			}
+		}
+		return nil
+	}
+	if err := walk(dotRootDir, ""); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
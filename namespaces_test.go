@@ -0,0 +1,106 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// mustCreateNamespacedVolume creates the volume `name` with the `namespaced` option, failing the test on error.
+func mustCreateNamespacedVolume(t *testing.T, d *DockerOnTop, name string) {
+	t.Helper()
+	err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{
+		"base":       t.TempDir(),
+		"namespaced": "true",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create the namespaced volume %s: %v", name, err)
+	}
+}
+
+func TestNamespacedVolumes(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	mustCreateNamespacedVolume(t, d, "a.b.c")
+	mustCreateNamespacedVolume(t, d, "a.b.d")
+	// Without the option, the dots are kept in the directory name
+	MustCreateVolume(t, d, "a.flat", t.TempDir())
+
+	root := d.DotRootDirPath()
+	for _, dir := range []string{"a/b/c", "a/b/d", "a.flat"} {
+		if !isVolumeDir(root + dir) {
+			t.Errorf("%s is not a volume's main directory", dir)
+		}
+	}
+	for _, name := range []string{"a.b.c", "a.b.d"} {
+		if _, err := os.Lstat(root + name); !os.IsNotExist(err) {
+			t.Errorf("The namespaced volume %s has a flat directory (%v)", name, err)
+		}
+	}
+
+	response, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, vol := range response.Volumes {
+		names = append(names, vol.Name)
+	}
+	slices.Sort(names)
+	if want := []string{"a.b.c", "a.b.d", "a.flat"}; !slices.Equal(names, want) {
+		t.Errorf("List = %v, want %v", names, want)
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "a.b.c"}); err != nil {
+		t.Errorf("Get failed for a namespaced volume: %v", err)
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "a.b"}); err == nil {
+		t.Error("Get succeeded for a namespace")
+	}
+
+	mountpoint := MustMountVolume(t, d, "a.b.c", "container")
+	if mountpoint != root+"a/b/c/mountpoint/" {
+		t.Errorf("The namespaced volume is mounted at %s", mountpoint)
+	}
+	if _, ok := m.Mounted(mountpoint); !ok {
+		t.Error("The namespaced volume's overlay is not mounted")
+	}
+	MustUnmountVolume(t, d, "a.b.c", "container")
+
+	// The namespace directories are removed with the last volume in them
+	if err := d.Remove(&volume.RemoveRequest{Name: "a.b.c"}); err != nil {
+		t.Fatal(err)
+	}
+	if !isVolumeDir(root + "a/b/d") {
+		t.Error("Removing a.b.c removed a.b.d")
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "a.b.d"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(root + "a"); !os.IsNotExist(err) {
+		t.Errorf("The empty namespace directory is left behind (%v)", err)
+	}
+}
+
+func TestNamespacedVolumesValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "p.q", t.TempDir())
+	mustCreateNamespacedVolume(t, d, "m.n")
+	for _, name := range []string{
+		"plain", // No namespace
+		"p.q",   // A flat volume of the same name exists
+		"m.n",   // Exists
+		"m.n.o", // Nested in a volume
+	} {
+		err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{
+			"base":       t.TempDir(),
+			"namespaced": "true",
+		}})
+		if err == nil {
+			t.Errorf("The namespaced volume %s was created", name)
+		}
+	}
+}
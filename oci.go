@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/*
+Support for the volumes whose base directory is a layer of an unpacked OCI image (the `base-type=oci` option).
+
+The base directory is the `rootfs` directory of an image bundle, and the image's other layers are unpacked into
+directories of the bundle directory (the parent of `rootfs`). The layers are described by one of the following files in
+the bundle directory:
+	- layers.json  - a JSON list of the layer directories (absolute or relative to the bundle directory), ordered from
+		the bottommost layer to the topmost one. Takes precedence over manifest.json.
+	- manifest.json  - the manifest in the `docker save` format: a JSON list of images, the first of which is used.
+		Its `Layers` (ordered from the bottommost one) name the layer archives, like `<id>/layer.tar`; each layer is
+		expected to be unpacked into the directory named like its archive without the `layer.tar` or `.tar` suffix.
+The layers other than `rootfs` itself become the volume's additional lower layers, so that the volumes based on the
+same image share its read-only layers.
+*/
+
+// Files describing the layers of an OCI image bundle (see the comment in the beginning of the file)
+const (
+	ociLayersFile   = "layers.json"
+	ociManifestFile = "manifest.json"
+)
+
+// Values of the `base-type` volume option
+const (
+	baseTypeDir = "dir"
+	baseTypeOCI = "oci"
+)
+
+// ociManifestEntry is an image in a `docker save` manifest.json (only the fields that are used)
+type ociManifestEntry struct {
+	Layers []string
+}
+
+// ociParentLayers returns the layers of the image whose `rootfs` directory (an absolute path) is given, except
+// `rootfs` itself, in the order of the overlay's lowerdirs (the topmost first). If the bundle directory has neither
+// layers.json nor manifest.json, the image is considered to have a single layer and nil is returned.
+func ociParentLayers(rootfs string) ([]string, error) {
+	rootfs = filepath.Clean(rootfs)
+	bundle := filepath.Dir(rootfs)
+
+	layers, err := readOCILayersFile(filepath.Join(bundle, ociLayersFile))
+	if os.IsNotExist(err) {
+		layers, err = readOCIManifest(filepath.Join(bundle, ociManifestFile))
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var parents []string
+	for i := len(layers) - 1; i >= 0; i-- {
+		layer := layers[i]
+		if !filepath.IsAbs(layer) {
+			layer = filepath.Join(bundle, layer)
+		}
+		layer = filepath.Clean(layer)
+		if layer == rootfs || slices.Contains(parents, layer) {
+			continue
+		}
+		parents = append(parents, layer)
+	}
+	return parents, nil
+}
+
+// readOCILayersFile parses a layers.json file (see the comment in the beginning of the file).
+func readOCILayersFile(path string) ([]string, error) {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var layers []string
+	if err := json.Unmarshal(payload, &layers); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return layers, nil
+}
+
+// readOCIManifest parses a manifest.json file and returns the directories of the first image's layers (see the comment
+// in the beginning of the file).
+func readOCIManifest(path string) ([]string, error) {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest []ociManifestEntry
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("invalid %s: no images are listed", path)
+	}
+
+	layers := make([]string, 0, len(manifest[0].Layers))
+	for _, archive := range manifest[0].Layers {
+		dir := strings.TrimSuffix(archive, "layer.tar")
+		if dir == archive {
+			dir = strings.TrimSuffix(archive, ".tar")
+		}
+		if dir = strings.TrimSuffix(dir, "/"); dir == "" {
+			return nil, fmt.Errorf("invalid %s: cannot tell the directory of the layer %s", path, archive)
+		}
+		layers = append(layers, dir)
+	}
+	return layers, nil
+}
@@ -0,0 +1,136 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// makeOCIBundle creates an OCI image bundle with the `rootfs` directory and the other layer directories `layers`
+// (relative to the bundle), writing the descriptor files `files` (like layers.json) in it. The rootfs is returned.
+func makeOCIBundle(t *testing.T, layers []string, files map[string]string) string {
+	t.Helper()
+	bundle := t.TempDir()
+	for _, layer := range append(layers, "rootfs") {
+		if err := os.MkdirAll(filepath.Join(bundle, layer), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, bundle, files)
+	return bundle + "/rootfs"
+}
+
+// ociLowerdir creates the volume on top of `rootfs` with `base-type=oci`, mounts it, and returns the lowerdir option
+// its overlay was mounted with.
+func ociLowerdir(t *testing.T, rootfs string) string {
+	t.Helper()
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"base": rootfs, "base-type": "oci"}})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	MustMountVolume(t, d, "vol", "container")
+	defer MustUnmountVolume(t, d, "vol", "container")
+	data, _ := m.Mounted(d.VolumeMountpointDir("vol"))
+	for _, option := range strings.Split(data, ",") {
+		if lowerdir, ok := strings.CutPrefix(option, "lowerdir="); ok {
+			return lowerdir
+		}
+	}
+	t.Fatalf("The overlay was mounted without lowerdir: %q", data)
+	return ""
+}
+
+func TestOCIBaseType(t *testing.T) {
+	t.Run("layers.json", func(t *testing.T) {
+		rootfs := makeOCIBundle(t, []string{"bottom", "middle"}, nil)
+		bundle := filepath.Dir(rootfs)
+		// Absolute and relative paths; rootfs itself is skipped
+		writeFiles(t, bundle, map[string]string{"layers.json": `["` + bundle + `/bottom", "middle", "rootfs"]`})
+		want := rootfs + ":" + bundle + "/middle:" + bundle + "/bottom"
+		if lowerdir := ociLowerdir(t, rootfs); lowerdir != want {
+			t.Errorf("lowerdir = %q, want %q", lowerdir, want)
+		}
+	})
+	t.Run("manifest.json", func(t *testing.T) {
+		rootfs := makeOCIBundle(t, []string{"abc", "def"}, map[string]string{
+			"manifest.json": `[{"Config": "config.json", "Layers": ["abc/layer.tar", "def.tar"]}, {"Layers": []}]`,
+		})
+		bundle := filepath.Dir(rootfs)
+		want := rootfs + ":" + bundle + "/def:" + bundle + "/abc"
+		if lowerdir := ociLowerdir(t, rootfs); lowerdir != want {
+			t.Errorf("lowerdir = %q, want %q", lowerdir, want)
+		}
+	})
+	t.Run("layers.json takes precedence", func(t *testing.T) {
+		rootfs := makeOCIBundle(t, []string{"listed", "manifested"}, map[string]string{
+			"layers.json":   `["listed"]`,
+			"manifest.json": `[{"Layers": ["manifested/layer.tar"]}]`,
+		})
+		want := rootfs + ":" + filepath.Dir(rootfs) + "/listed"
+		if lowerdir := ociLowerdir(t, rootfs); lowerdir != want {
+			t.Errorf("lowerdir = %q, want %q", lowerdir, want)
+		}
+	})
+	t.Run("single layer", func(t *testing.T) {
+		rootfs := makeOCIBundle(t, nil, nil)
+		if lowerdir := ociLowerdir(t, rootfs); lowerdir != rootfs {
+			t.Errorf("lowerdir = %q, want %q", lowerdir, rootfs)
+		}
+	})
+}
+
+func TestOCIBaseTypeValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	other := t.TempDir()
+	for name, test := range map[string]struct {
+		files   map[string]string
+		options map[string]string
+	}{
+		"invalid layers.json": {files: map[string]string{"layers.json": `{"layers": []}`}},
+		"missing layer":       {files: map[string]string{"layers.json": `["missing"]`}},
+		"empty manifest.json": {files: map[string]string{"manifest.json": `[]`}},
+		"unnamed layer":       {files: map[string]string{"manifest.json": `[{"Layers": ["layer.tar"]}]`}},
+		"with the layers":     {options: map[string]string{"layers": other}},
+		"invalid base type":   {options: map[string]string{"base-type": "image"}},
+	} {
+		rootfs := makeOCIBundle(t, nil, test.files)
+		options := map[string]string{"base": rootfs, "base-type": "oci"}
+		for key, value := range test.options {
+			options[key] = value
+		}
+		if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err == nil {
+			t.Errorf("%s: the volume was created", name)
+			if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestOCIBaseTypeWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	rootfs := makeOCIBundle(t, []string{"bottom"}, map[string]string{
+		"layers.json":      `["bottom"]`,
+		"bottom/base-file": "bottom",
+		"bottom/shadowed":  "bottom",
+		"rootfs/shadowed":  "rootfs",
+	})
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"base": rootfs, "base-type": "oci"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	for file, want := range map[string]string{"base-file": "bottom", "shadowed": "rootfs"} {
+		if contents, err := os.ReadFile(mountpoint + file); err != nil || string(contents) != want {
+			t.Errorf("The volume's %s contains %q, %v; want %q", file, contents, err, want)
+		}
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DockerOnTopOption configures a `DockerOnTop` object when it is created with `NewDockerOnTop`.
+type DockerOnTopOption func(d *DockerOnTop)
+
+// WithBootConcurrency sets the number of volumes whose state is reset on boot in parallel (`runtime.NumCPU()` by
+// default). Values less than 1 are treated as 1.
+func WithBootConcurrency(n int) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.bootConcurrency = max(n, 1)
+	}
+}
+
+// WithPermissiveBoot makes the failures to reset the state of the volumes on boot only be logged instead of making
+// `NewDockerOnTop` fail. The names of the volumes that failed are then reported by `DockerOnTop.BootErrors`.
+func WithPermissiveBoot(permissive bool) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.permissiveBoot = permissive
+	}
+}
+
+// WithDryRun sets `DockerOnTop.DryRun`. Unlike setting the field after `NewDockerOnTop` returns, it also makes the
+// boot reset of the volumes and the removal of stale scratch directories only log what they would do.
+func WithDryRun(dryRun bool) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.DryRun = dryRun
+	}
+}
+
+// WithoutOverlayProbe makes `NewDockerOnTop` not check that overlays can be mounted, e.g. when they are to be mounted
+// with `fuse-overlayfs` (see `DockerOnTop.TryFuseOverlayFallback`).
+func WithoutOverlayProbe() DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.skipOverlayProbe = true
+	}
+}
+
+// WithMountCircuitBreaker sets the parameters of the circuit breaker for overlay mounts: after `threshold`
+// consecutive mount failures within `window`, mounts are refused for `cooldown` (5 failures within 60 seconds and 30
+// seconds by default).
+func WithMountCircuitBreaker(threshold int, window time.Duration, cooldown time.Duration) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.mountBreaker.threshold = max(threshold, 1)
+		d.mountBreaker.window = window
+		d.mountBreaker.cooldown = cooldown
+	}
+}
+
+// WithUsageTimeout limits the time spent computing the disk usage of a volume for `Get` and `List` (5 seconds by
+// default). If it takes longer, the usage is not reported.
+func WithUsageTimeout(timeout time.Duration) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.usageTimeout = timeout
+	}
+}
+
+// WithAccessLogMaxSize sets the size (in bytes) a volume's access log is rotated at (1 MiB by default). The rotated
+// entries are kept in `access.log.1`, replacing the previously rotated ones.
+func WithAccessLogMaxSize(size int64) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.accessLogMaxSize = size
+	}
+}
+
+// WithHookTimeout limits the time a volume's hook (like the `premount` one) may run (30 seconds by default). The hook
+// is killed if it runs for longer.
+func WithHookTimeout(timeout time.Duration) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.hookTimeout = timeout
+	}
+}
+
+// WithHooksDir allows the volumes to have hooks (like the `premount` one), but only the executables located in `dir`
+// (directly or in its subdirectories, after resolving symlinks). Without this option, the volumes cannot have hooks.
+func WithHooksDir(dir string) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.hooksDir = filepath.Clean(dir)
+	}
+}
+
+// WithVolumeInfoCacheTTL sets the time the metadata cached by `GetVolumeInfo` is trusted for (60 seconds by default).
+// Zero disables the cache.
+func WithVolumeInfoCacheTTL(ttl time.Duration) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.volumeInfoCacheTTL = ttl
+	}
+}
+
+// WithBasePrefixesReloader sets the function `Reload` gets the new allowed and denied base directory prefixes from.
+func WithBasePrefixesReloader(reload func() (BasePrefixesConfig, error)) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.reloadBasePrefixes = reload
+	}
+}
+
+// WithMountTimeout limits the time the overlay mount syscall may take (30 seconds by default). If it takes longer,
+// mounting the volume fails.
+func WithMountTimeout(timeout time.Duration) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.mountTimeout = timeout
+	}
+}
+
+// WithLogger makes the driver log with the given logger rather than the plugin's default one (which writes to the
+// standard error).
+func WithLogger(l *slog.Logger) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.logger = l
+	}
+}
+
+// WithTracer makes the driver trace its operations with a tracer from the given provider. By default, a no-op
+// provider is used.
+func WithTracer(tp trace.TracerProvider) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.tracer = tp.Tracer(tracerName)
+	}
+}
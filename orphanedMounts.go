@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// recoveredActivemountID is the name of the synthetic active mount file created by `RecoverOrphanedMounts`
+const recoveredActivemountID = "recovered"
+
+// fuseOverlayFsType is the filesystem type of the overlays mounted with `fuse-overlayfs` in `/proc/mounts`
+const fuseOverlayFsType = "fuse." + fuseOverlayBinary
+
+// overlayMountEntry is a mount of a volume's overlay found in the mount table
+type overlayMountEntry struct {
+	mountpoint string
+	fuse       bool
+}
+
+// RecoverOrphanedMounts finds the volumes whose overlays are mounted at their mountpoints (according to
+// `/proc/mounts`) but whose activemounts/ directories are empty, which happens if the plugin crashed in the middle of
+// a mount or unmount. A synthetic active mount file named "recovered" is created for each of them, so that the volume
+// is considered in use: the next `Mount` reuses the overlay instead of mounting another one on top of it, and the next
+// `Unmount` unmounts it (removing the synthetic file as a leftover).
+//
+// The names of the recovered volumes are returned, sorted. The volumes that fail to be recovered are logged and
+// skipped.
+func (d *DockerOnTop) RecoverOrphanedMounts() ([]string, error) {
+	return d.recoverOrphanedMountsFrom(procMounts)
+}
+
+// recoverOrphanedMountsFrom is `RecoverOrphanedMounts` for an arbitrary file in the `/proc/mounts` format.
+func (d *DockerOnTop) recoverOrphanedMountsFrom(path string) ([]string, error) {
+	d.logger.Debug("Request RecoverOrphanedMounts", "mounts", path)
+
+	mounts, err := parseOverlayMounts(path)
+	if err != nil {
+		return nil, d.internalError("failed to read the mount table", err, "path", path)
+	}
+
+	recovered := []string{}
+	for _, volumeName := range sortedKeys(mounts) {
+		if !volNameFormat.MatchString(volumeName) || !isVolumeDir(d.volumeDir(volumeName)) {
+			// Not a volume of this dot root directory (another instance of the plugin may be running)
+			continue
+		}
+		mountpoint := filepath.Clean(d.mountpointdir(volumeName))
+		i := slices.IndexFunc(mounts[volumeName], func(mount overlayMountEntry) bool {
+			return mount.mountpoint == mountpoint
+		})
+		if i < 0 {
+			continue
+		}
+		if ok, err := d.recoverOrphanedMount(volumeName, mounts[volumeName][i].fuse); err != nil {
+			d.logger.Error("Failed to recover the orphaned mount of the volume", "volume", volumeName, "error", err)
+		} else if ok {
+			recovered = append(recovered, volumeName)
+		}
+	}
+	return recovered, nil
+}
+
+// recoverOrphanedMount creates the synthetic active mount file of the volume (see `RecoverOrphanedMounts`) unless its
+// activemounts/ directory is not empty. Whether the file was created is returned.
+func (d *DockerOnTop) recoverOrphanedMount(volumeName string, fuse bool) (bool, error) {
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	// Checked under the lock, as the volume may have been mounted meanwhile
+	if _, err := d.readActivemounts(volumeName, 1); !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	if err := createActivemountFile(d.activemountsdir(volumeName)+recoveredActivemountID,
+		activemountInfo{Fuse: fuse}); err != nil {
+		return false, err
+	}
+	d.logger.Warn("The volume's overlay is mounted, but no containers were recorded as using it. Recovered a "+
+		"synthetic active mount", "volume", volumeName, "id", recoveredActivemountID, "fuse", fuse)
+	return true, nil
+}
+
+// parseOverlayMounts returns the mounts of the volumes' overlays (both the kernel and the `fuse-overlayfs` ones)
+// listed in the given file in the `/proc/mounts` format, by volume name.
+func parseOverlayMounts(path string) (map[string][]overlayMountEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounts := map[string][]overlayMountEntry{}
+	sourcePrefix := overlaySource("")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields: source, mountpoint, fstype, options, dump, pass
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || (fields[2] != "overlay" && fields[2] != fuseOverlayFsType) {
+			continue
+		}
+		source := unescapeMountInfo(fields[0])
+		if !strings.HasPrefix(source, sourcePrefix) {
+			continue
+		}
+		volumeName := strings.TrimPrefix(source, sourcePrefix)
+		mounts[volumeName] = append(mounts[volumeName], overlayMountEntry{
+			mountpoint: filepath.Clean(unescapeMountInfo(fields[1])),
+			fuse:       fields[2] == fuseOverlayFsType,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
@@ -0,0 +1,117 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRecoverOrphanedMounts(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	for _, name := range []string{"orphaned", "fuse", "in-use", "unmounted", "elsewhere", "not-overlay"} {
+		MustCreateVolume(t, d, name, t.TempDir())
+	}
+	MustMountVolume(t, d, "in-use", "container")
+	// The plugin crashed after mounting the overlay of orphaned, before recording the active mount
+	if err := os.MkdirAll(d.mountpointdir("orphaned"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mount(overlaySource("orphaned"), d.mountpointdir("orphaned"), "overlay", 0, "lowerdir=/x"); err != nil {
+		t.Fatal(err)
+	}
+
+	mountpoint := func(name string) string { return strings.TrimSuffix(d.mountpointdir(name), "/") }
+	mounts := t.TempDir() + "/mounts"
+	err := os.WriteFile(mounts, []byte(strings.Join([]string{
+		"proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0",
+		"docker-on-top_orphaned " + mountpoint("orphaned") + " overlay rw,lowerdir=/x 0 0",
+		"docker-on-top_fuse " + mountpoint("fuse") + " fuse.fuse-overlayfs rw,user_id=0 0 0",
+		"docker-on-top_in-use " + mountpoint("in-use") + " overlay rw 0 0",
+		// Mounted by another instance of the plugin, with another dot root directory
+		"docker-on-top_elsewhere /var/lib/other-dot/elsewhere/mountpoint overlay rw 0 0",
+		"docker-on-top_unknown /var/lib/other-dot/unknown/mountpoint overlay rw 0 0",
+		"docker-on-top_not-overlay " + mountpoint("not-overlay") + " tmpfs rw 0 0",
+		"",
+	}, "\n")), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := d.recoverOrphanedMountsFrom(mounts)
+	if want := []string{"fuse", "orphaned"}; err != nil || !slices.Equal(recovered, want) {
+		t.Fatalf("recoverOrphanedMountsFrom = %v, %v; want %v", recovered, err, want)
+	}
+	for name, wantFuse := range map[string]bool{"orphaned": false, "fuse": true} {
+		entries, err := os.ReadDir(d.activemountsdir(name))
+		if err != nil || len(entries) != 1 || entries[0].Name() != recoveredActivemountID {
+			t.Errorf("%s: the active mounts are %v, %v; want only %s", name, entries, err, recoveredActivemountID)
+			continue
+		}
+		info, err := readActivemountInfo(d.activemountsdir(name) + recoveredActivemountID)
+		if err != nil || info.Fuse != wantFuse {
+			t.Errorf("%s: the synthetic active mount is %+v, %v; want Fuse %v", name, info, err, wantFuse)
+		}
+	}
+	for _, name := range []string{"unmounted", "elsewhere", "not-overlay"} {
+		if entries, err := os.ReadDir(d.activemountsdir(name)); err != nil || len(entries) != 0 {
+			t.Errorf("%s: the active mounts are %v, %v; want none", name, entries, err)
+		}
+	}
+	if entries, err := os.ReadDir(d.activemountsdir("in-use")); err != nil || len(entries) != 1 ||
+		entries[0].Name() != "container" {
+		t.Errorf("in-use: the active mounts are %v, %v; want only container", entries, err)
+	}
+
+	// Already recovered
+	if recovered, err := d.recoverOrphanedMountsFrom(mounts); err != nil || len(recovered) != 0 {
+		t.Errorf("The second time, recoverOrphanedMountsFrom = %v, %v; want nothing", recovered, err)
+	}
+
+	// The recovered overlay is reused by the new containers, and unmounted when the container it was mounted for
+	// (which the plugin no longer knows about) is the last one
+	MustMountVolume(t, d, "orphaned", "another")
+	MustUnmountVolume(t, d, "orphaned", "another")
+	if _, ok := m.Mounted(d.mountpointdir("orphaned")); !ok {
+		t.Error("The recovered overlay was unmounted while in use")
+	}
+	MustUnmountVolume(t, d, "orphaned", "crashed")
+	if _, ok := m.Mounted(d.mountpointdir("orphaned")); ok {
+		t.Error("The recovered overlay was not unmounted")
+	}
+	if entries, err := os.ReadDir(d.activemountsdir("orphaned")); err != nil || len(entries) != 0 {
+		t.Errorf("After Unmount, the active mounts are %v, %v; want none", entries, err)
+	}
+	MustUnmountVolume(t, d, "in-use", "container")
+
+	if _, err := d.recoverOrphanedMountsFrom(mounts + ".missing"); err == nil {
+		t.Error("recoverOrphanedMountsFrom succeeded for a missing file")
+	}
+}
+
+func TestRecoverOrphanedMountsWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	writeFiles(t, mountpoint, map[string]string{"file": "written"})
+	// The plugin crashed and lost the active mounts
+	if err := os.Remove(d.activemountsdir("vol") + "container"); err != nil {
+		t.Fatal(err)
+	}
+
+	if recovered, err := d.RecoverOrphanedMounts(); err != nil || !slices.Equal(recovered, []string{"vol"}) {
+		t.Fatalf("RecoverOrphanedMounts = %v, %v; want [vol]", recovered, err)
+	}
+	mountpoint = MustMountVolume(t, d, "vol", "another")
+	if contents, err := os.ReadFile(mountpoint + "file"); err != nil || string(contents) != "written" {
+		t.Errorf("After recovering, file = %q, %v; want %q", contents, err, "written")
+	}
+	MustUnmountVolume(t, d, "vol", "another")
+	MustUnmountVolume(t, d, "vol", "container")
+	if mounted, err := d.checkMountInFile(procMounts, "vol"); err != nil || mounted {
+		t.Errorf("After Unmount, the overlay is mounted: %v, %v", mounted, err)
+	}
+}
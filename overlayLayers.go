@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+/*
+Helpers for working with the overlay layers of a volume directly, i.e. without relying on the kernel to merge them.
+
+The upper layer may contain two kinds of special entries that affect the merged view:
+	- whiteouts  - hide the entry with the same name in the lower layers. The kernel creates them as character devices
+		with the 0/0 device number, but files named `.wh.<name>` (the format used in image layers) are also recognized.
+	- opaque directories  - hide all the contents of the directories with the same path in the lower layers. Marked
+		with the `trusted.overlay.opaque` (or `user.overlay.opaque`) xattr set to "y" or by containing a `.wh..wh..opq`
+		file.
+*/
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueMarker   = ".wh..wh..opq"
+)
+
+// isWhiteout reports whether the given file is an overlay whiteout. If it is, the name of the entry it hides is
+// returned as well.
+func isWhiteout(name string, info fs.FileInfo) (bool, string) {
+	if name == opaqueMarker {
+		return false, ""
+	}
+	if strings.HasPrefix(name, whiteoutPrefix) {
+		return true, strings.TrimPrefix(name, whiteoutPrefix)
+	}
+	if info.Mode()&fs.ModeCharDevice != 0 {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Rdev == 0 {
+			return true, name
+		}
+	}
+	return false, ""
+}
+
+// isOpaqueDir reports whether the given directory is marked as opaque (see the comment in the beginning of the file).
+func isOpaqueDir(path string) bool {
+	buf := make([]byte, 1)
+	for _, attr := range []string{"trusted.overlay.opaque", "user.overlay.opaque"} {
+		n, err := syscall.Getxattr(path, attr, buf)
+		if err == nil && n == 1 && buf[0] == 'y' {
+			return true
+		}
+	}
+	_, err := os.Lstat(filepath.Join(path, opaqueMarker))
+	return err == nil
+}
+
+// mergedDirWalk walks the merged view of the volume's overlay, calling `fn` for each file or directory in it, just
+// like `filepath.Walk` does.
+//
+// If the volume's overlay is mounted, this is simply `filepath.Walk` over the mountpoint. Otherwise (including when the
+// overlay was unmounted behind the plugin's back while containers still use the volume), the merge is simulated by
+// walking the upper layer and the lower layers at the same time and applying the whiteout logic. In the latter case,
+// the paths passed to `fn` are still reported as if they were under the mountpoint, but they don't exist on the
+// filesystem: `info` describes the file in the layer where it comes from.
+func (d *DockerOnTop) mergedDirWalk(name string, fn filepath.WalkFunc) error {
+	mounted, err := d.isOverlayMounted(name)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return filepath.Walk(d.mountpointdir(name), fn)
+	}
+
+	thisVol, err := d.getVolumeInfo(name)
+	if err != nil {
+		return err
+	}
+
+	root := d.mountpointdir(name)
+	layers := append([]string{d.upperdir(name, &thisVol)}, thisVol.lowerDirs()...)
+	rootInfo, err := os.Stat(layers[0])
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = fn(root, rootInfo, nil)
+	if err == nil {
+		err = walkMergedDir(layers, root, rootInfo, fn)
+	}
+	if errors.Is(err, filepath.SkipDir) || errors.Is(err, filepath.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walkMergedDir calls `fn` for every entry of the merged directory `virtual`, whose contents are located in `dirs`
+// (ordered from the topmost layer to the bottommost one), and recurses into subdirectories.
+func walkMergedDir(dirs []string, virtual string, info fs.FileInfo, fn filepath.WalkFunc) error {
+	type mergedEntry struct {
+		info fs.FileInfo
+		// dirs lists the locations of the directory in the layers it is merged from (for directories only)
+		dirs []string
+		// open is true while the lower layers may still contribute to the directory
+		open bool
+	}
+	entries := map[string]*mergedEntry{}
+	hidden := map[string]bool{}
+
+	for _, dir := range dirs {
+		list, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fn(virtual, info, err)
+		}
+
+		for _, dirEntry := range list {
+			entryInfo, err := dirEntry.Info()
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return fn(filepath.Join(virtual, dirEntry.Name()), nil, err)
+			}
+
+			name := dirEntry.Name()
+			if name == opaqueMarker {
+				continue
+			}
+			if whiteout, target := isWhiteout(name, entryInfo); whiteout {
+				if entry, ok := entries[target]; ok {
+					entry.open = false
+				} else {
+					hidden[target] = true
+				}
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			if entry, ok := entries[name]; ok {
+				// Already provided by an upper layer. A directory is merged with the lower directories until a
+				// non-directory or an opaque directory is encountered
+				if entry.open && entryInfo.IsDir() {
+					entry.dirs = append(entry.dirs, path)
+					entry.open = !isOpaqueDir(path)
+				} else {
+					entry.open = false
+				}
+				continue
+			}
+			if hidden[name] {
+				continue
+			}
+
+			entry := &mergedEntry{info: entryInfo}
+			if entryInfo.IsDir() {
+				entry.dirs = []string{path}
+				entry.open = !isOpaqueDir(path)
+			}
+			entries[name] = entry
+		}
+
+		if isOpaqueDir(dir) {
+			break
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry := entries[name]
+		path := filepath.Join(virtual, name)
+		err := fn(path, entry.info, nil)
+		if err == nil && entry.info.IsDir() {
+			err = walkMergedDir(entry.dirs, path, entry.info, fn)
+		}
+		if errors.Is(err, filepath.SkipDir) {
+			if !entry.info.IsDir() {
+				// As in `filepath.Walk`, SkipDir on a file skips the rest of the directory
+				return nil
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upperdirWalk calls `fn` for every entry of the volume's upperdir `upperdir`, just like `filepath.Walk` does, but
+// reaching them through the merged view (see `mergedDirWalk`), so that it works whether the volume is mounted or not.
+// The whiteouts and the opaque markers, which don't show in the merged view, are reported right after the directory
+// containing them. The paths passed to `fn` are the ones in the upperdir.
+func (d *DockerOnTop) upperdirWalk(name string, upperdir string, fn filepath.WalkFunc) error {
+	root := d.mountpointdir(name)
+	return d.mergedDirWalk(name, func(path string, mergedInfo fs.FileInfo, err error) error {
+		relPath, relErr := filepath.Rel(root, path)
+		if relErr != nil {
+			return relErr
+		}
+		upperPath := filepath.Join(upperdir, relPath)
+		if err != nil {
+			return fn(upperPath, nil, err)
+		}
+		info, err := os.Lstat(upperPath)
+		if os.IsNotExist(err) {
+			// Comes from a lower layer. A directory missing from the upperdir has nothing of the upperdir below it
+			if relPath == "." {
+				return fn(upperPath, nil, err)
+			} else if mergedInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if err != nil {
+			return fn(upperPath, nil, err)
+		}
+		if whiteout, _ := isWhiteout(info.Name(), info); whiteout || info.Name() == opaqueMarker {
+			// Only shows in the merged view of a mounted volume (as the kernel doesn't recognize `.wh.` files), and
+			// is reported along with its directory below
+			return nil
+		}
+
+		if err := fn(upperPath, info, nil); err != nil || !info.IsDir() {
+			return err
+		}
+		entries, err := os.ReadDir(upperPath)
+		if err != nil {
+			return fn(upperPath, info, err)
+		}
+		for _, entry := range entries {
+			entryInfo, err := entry.Info()
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return fn(filepath.Join(upperPath, entry.Name()), nil, err)
+			}
+			if whiteout, _ := isWhiteout(entry.Name(), entryInfo); whiteout || entry.Name() == opaqueMarker {
+				if err := fn(filepath.Join(upperPath, entry.Name()), entryInfo, nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
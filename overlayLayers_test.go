@@ -0,0 +1,155 @@
+//go:build dottest
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// walkedPaths returns the paths `walk` reports, relative to `root` and sorted, with a trailing slash for directories
+// and the size of the regular files.
+func walkedPaths(t *testing.T, root string, walk func(fn filepath.WalkFunc) error) []string {
+	t.Helper()
+	var paths []string
+	err := walk(func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			relPath += "/"
+		} else if info.Mode().IsRegular() {
+			relPath += fmt.Sprintf(" (%d)", info.Size())
+		}
+		paths = append(paths, relPath)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestMergedDirWalk(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"kept":           "base",
+		"modified":       "base",
+		"deleted":        "base",
+		"gone":           "base",
+		"dir/lower":      "base",
+		"dir/deleted":    "base",
+		"opaque/hidden":  "base",
+		"replaced-dir/x": "base",
+	})
+	MustCreateVolume(t, d, "vol", base)
+	upper := d.VolumeUpperDir("vol")
+	writeFiles(t, upper, map[string]string{
+		"added":                  "upper",
+		"modified":               "changed",
+		"dir/upper":              "upper",
+		"opaque/visible":         "upper",
+		"opaque/" + opaqueMarker: "",
+		"replaced-dir":           "upper",
+		whiteoutPrefix + "gone":  "",
+	})
+	makeWhiteouts(t, upper, "deleted", "dir/deleted")
+
+	merged := walkedPaths(t, d.VolumeMountpointDir("vol"), func(fn filepath.WalkFunc) error {
+		return d.mergedDirWalk("vol", fn)
+	})
+	// The whiteouts and the opaque directory hide the lower files, and the files of the upper layer come first
+	want := []string{
+		"./", "added (5)", "dir/", "dir/lower (4)", "dir/upper (5)", "kept (4)", "modified (7)", "opaque/",
+		"opaque/visible (5)", "replaced-dir (5)",
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("mergedDirWalk of the unmounted volume walked %q, want %q", merged, want)
+	}
+
+	// Only the upper layer's entries, including the whiteouts and the opaque markers
+	walked := walkedPaths(t, upper, func(fn filepath.WalkFunc) error {
+		return d.upperdirWalk("vol", upper, fn)
+	})
+	want = walkedPaths(t, upper, func(fn filepath.WalkFunc) error { return filepath.Walk(upper, fn) })
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("upperdirWalk of the unmounted volume walked %q, want %q", walked, want)
+	}
+
+	if err := d.mergedDirWalk("missing", func(string, fs.FileInfo, error) error { return nil }); err == nil {
+		t.Error("mergedDirWalk succeeded for a nonexistent volume")
+	}
+}
+
+func TestMergedDirWalkSkipDir(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"a": "", "b/c": "", "d": "", "e": ""})
+	MustCreateVolume(t, d, "vol", base)
+	root := d.VolumeMountpointDir("vol")
+
+	var walked []string
+	err := d.mergedDirWalk("vol", func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		switch path {
+		case root + "b":
+			return filepath.SkipDir
+		case root + "d":
+			// As in `filepath.Walk`, skips the rest of the directory
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	want := []string{root, root + "a", root + "b", root + "d"}
+	if err != nil || !reflect.DeepEqual(walked, want) {
+		t.Errorf("mergedDirWalk walked %q, %v; want %q", walked, err, want)
+	}
+}
+
+func TestMergedDirWalkWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"kept":          "base",
+		"modified":      "base",
+		"deleted":       "base",
+		"dir/lower":     "base",
+		"dir/deleted/x": "base",
+	})
+	MustCreateVolume(t, d, "vol", base)
+	upper := d.VolumeUpperDir("vol")
+	writeFiles(t, upper, map[string]string{"added": "upper", "modified": "changed", "dir/upper": "upper"})
+	makeWhiteouts(t, upper, "deleted", "dir/deleted")
+
+	root := d.VolumeMountpointDir("vol")
+	mergedWalk := func(fn filepath.WalkFunc) error { return d.mergedDirWalk("vol", fn) }
+	upperWalk := func(fn filepath.WalkFunc) error { return d.upperdirWalk("vol", upper, fn) }
+	simulated, simulatedUpper := walkedPaths(t, root, mergedWalk), walkedPaths(t, upper, upperWalk)
+	MustMountVolume(t, d, "vol", "container")
+	defer MustUnmountVolume(t, d, "vol", "container")
+
+	// The kernel's merge is the same as the simulated one
+	if merged := walkedPaths(t, root, mergedWalk); !reflect.DeepEqual(merged, simulated) {
+		t.Errorf("mergedDirWalk of the mounted volume walked %q, of the unmounted one %q", merged, simulated)
+	}
+	if walked := walkedPaths(t, upper, upperWalk); !reflect.DeepEqual(walked, simulatedUpper) {
+		t.Errorf("upperdirWalk of the mounted volume walked %q, of the unmounted one %q", walked, simulatedUpper)
+	}
+	if _, err := os.Stat(root + "deleted"); !os.IsNotExist(err) {
+		t.Errorf("The whited out file is visible in the overlay (%v)", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// overlayTuningOptions are the overlayfs mount options that can be given as volume options (with the same names) to
+// tune the volume's overlay. Only these are accepted, so that a volume option cannot inject arbitrary mount options
+// (like another `lowerdir`)
+var overlayTuningOptions = []string{"index", "redirect_dir", "metacopy", "nfs_export"}
+
+// overlayOptionValueFormat is the format of the values of the overlay tuning options (like "on", "off", "follow")
+var overlayOptionValueFormat = regexp.MustCompile("^[a-zA-Z0-9_]+$")
+
+// parseOverlayOptions extracts the overlay tuning options (see `overlayTuningOptions`) from the volume options given
+// to `Create`. Nil is returned if there are none.
+//
+// Besides "on" and "off", `nfs_export` accepts the values of the boolean volume options ("true", "yes", ...). As
+// overlayfs requires the index for NFS export, enabling `nfs_export` enables `index` as well.
+func parseOverlayOptions(options map[string]string) (map[string]string, error) {
+	var overlayOptions map[string]string
+	for _, name := range overlayTuningOptions {
+		value, ok := options[name]
+		if !ok {
+			continue
+		}
+		if err := validateOverlayOption(name, value); err != nil {
+			return nil, err
+		}
+		if overlayOptions == nil {
+			overlayOptions = map[string]string{}
+		}
+		overlayOptions[name] = value
+	}
+
+	if value, ok := overlayOptions["nfs_export"]; ok {
+		switch strings.ToLower(value) {
+		case "on", "yes", "true":
+			if index, ok := overlayOptions["index"]; ok && index != "on" {
+				return nil, errors.New("option `nfs_export` requires `index=on`")
+			}
+			overlayOptions["nfs_export"] = "on"
+			overlayOptions["index"] = "on"
+		case "off", "no", "false":
+			overlayOptions["nfs_export"] = "off"
+		default:
+			return nil, errors.New("option `nfs_export` must be either 'on', 'off', 'true', 'false', 'yes', or 'no'")
+		}
+	}
+	return overlayOptions, nil
+}
+
+// validateOverlayOption checks that the overlay tuning option is allowed and its value is well-formed.
+func validateOverlayOption(name string, value string) error {
+	if !slices.Contains(overlayTuningOptions, name) {
+		return fmt.Errorf("overlay option `%s` is not supported", name)
+	} else if !overlayOptionValueFormat.MatchString(value) {
+		return fmt.Errorf("option `%s` has an invalid value %q: only letters, digits, and underscores are allowed",
+			name, value)
+	}
+	return nil
+}
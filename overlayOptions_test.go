@@ -0,0 +1,165 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// procMountsOptions returns the options of the mount at `mountpoint` in `/proc/mounts`, failing the test if it's not
+// there.
+func procMountsOptions(t *testing.T, mountpoint string) string {
+	t.Helper()
+	contents, err := os.ReadFile(procMounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 4 && fields[1] == filepath.Clean(mountpoint) {
+			return fields[3]
+		}
+	}
+	t.Fatalf("%s is not in %s", mountpoint, procMounts)
+	return ""
+}
+
+func TestOverlayOptions(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":         t.TempDir(),
+		"index":        "off",
+		"redirect_dir": "nofollow",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"index": "off", "redirect_dir": "nofollow"}; !reflect.DeepEqual(vol.OverlayOptions,
+		want) {
+		t.Errorf("OverlayOptions = %v, want %v", vol.OverlayOptions, want)
+	}
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	data, _ := m.Mounted(mountpoint)
+	for _, option := range []string{"index=off", "redirect_dir=nofollow"} {
+		if !containsOption(data, option) {
+			t.Errorf("The overlay is mounted with %q, without %s", data, option)
+		}
+	}
+}
+
+func TestOverlayOptionsWithOverlay(t *testing.T) {
+	for _, index := range []string{"on", "off"} {
+		t.Run("index="+index, func(t *testing.T) {
+			d := NewTestDockerOnTopWithOverlay(t)
+			err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+				"base":  t.TempDir(),
+				"index": index,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mountpoint := MustMountVolume(t, d, "vol", "container")
+			defer MustUnmountVolume(t, d, "vol", "container")
+
+			// The kernel lists `index` only if it differs from the default, which is usually off
+			options := procMountsOptions(t, mountpoint)
+			if index == "on" && !containsOption(options, "index=on") {
+				t.Errorf("The overlay is mounted with %q, without index=on", options)
+			} else if index == "off" && containsOption(options, "index=on") {
+				t.Errorf("The overlay is mounted with %q, with index=on", options)
+			}
+		})
+	}
+}
+
+func TestOverlayOptionsValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	for _, options := range []map[string]string{
+		{"index": "off,lowerdir=/etc"},
+		{"index": ""},
+		{"metacopy": "on off"},
+		{"redirect_dir": "../x"},
+	} {
+		options["base"] = t.TempDir()
+		if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err == nil {
+			t.Errorf("A volume was created with the options %v", options)
+		}
+	}
+}
+
+func TestNFSExport(t *testing.T) {
+	tests := []struct {
+		options map[string]string
+		want    map[string]string
+	}{
+		{map[string]string{"nfs_export": "true"}, map[string]string{"nfs_export": "on", "index": "on"}},
+		{map[string]string{"nfs_export": "on", "index": "on"}, map[string]string{"nfs_export": "on", "index": "on"}},
+		{map[string]string{"nfs_export": "no", "index": "off"}, map[string]string{"nfs_export": "off", "index": "off"}},
+		{map[string]string{"nfs_export": "yes", "index": "off"}, nil},
+		{map[string]string{"nfs_export": "maybe"}, nil},
+	}
+	for _, test := range tests {
+		m := NewMockSyscallMount()
+		d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+		test.options["base"] = t.TempDir()
+		err := d.Create(&volume.CreateRequest{Name: "vol", Options: test.options})
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("A volume was created with the options %v", test.options)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("Creating a volume with the options %v failed: %v", test.options, err)
+			continue
+		}
+
+		if vol, err := d.getVolumeInfo("vol"); err != nil || !reflect.DeepEqual(vol.OverlayOptions, test.want) {
+			t.Errorf("With the options %v, OverlayOptions = %v, %v; want %v", test.options, vol.OverlayOptions, err,
+				test.want)
+		}
+		data, _ := m.Mounted(MustMountVolume(t, d, "vol", "container"))
+		for name, value := range test.want {
+			if !containsOption(data, name+"="+value) {
+				t.Errorf("With the options %v, the overlay is mounted with %q, without %s=%s", test.options, data, name,
+					value)
+			}
+		}
+	}
+}
+
+func TestNFSExportVolatileWarning(t *testing.T) {
+	for _, volatile := range []string{"false", "true"} {
+		var logs bytes.Buffer
+		d := NewTestDockerOnTop(t, WithLogger(newLogger(&logs, "json")))
+		err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+			"base":       t.TempDir(),
+			"nfs_export": "on",
+			"volatile":   volatile,
+		}})
+		// Only a warning, the volume is created
+		if err != nil {
+			t.Fatalf("Creating a volume with nfs_export=on and volatile=%s failed: %v", volatile, err)
+		}
+		warned := false
+		for _, entry := range decodeLogEntries(t, &logs) {
+			if msg, _ := entry["msg"].(string); entry["level"] == "WARN" && strings.Contains(msg, "nfs_export") {
+				warned = true
+			}
+		}
+		if warned != (volatile == "true") {
+			t.Errorf("With volatile=%s, a warning was logged: %v", volatile, warned)
+		}
+	}
+}
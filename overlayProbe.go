@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// OverlayNotSupportedError is returned by `NewDockerOnTop` if overlays cannot be mounted on the host (see
+// `probeOverlay`). Besides the error of the probe mount, it holds the diagnostics of the host's overlayfs support.
+type OverlayNotSupportedError struct {
+	// Err is the error of the probe mount
+	Err error
+	// KernelVersion is the content of `/proc/version` (empty if it cannot be read)
+	KernelVersion string
+	// InProcFilesystems is set if `/proc/filesystems` lists overlay, that is if the module is loaded (or built in)
+	InProcFilesystems bool
+	// ModuleFound is set if the overlay kernel module exists in `/lib/modules/<release>/`
+	ModuleFound bool
+}
+
+func (e *OverlayNotSupportedError) Error() string {
+	var hint string
+	switch {
+	case e.InProcFilesystems:
+		hint = "the kernel supports overlayfs, but the probe mount failed (is the plugin's directory on a filesystem " +
+			"that can't be an overlay's upperdir, like another overlay?)"
+	case e.ModuleFound:
+		hint = "the overlay kernel module is not loaded (try `modprobe overlay`)"
+	default:
+		hint = "the kernel doesn't support overlayfs and no overlay module was found (consider " +
+			"--fuse-overlay-fallback)"
+	}
+	return fmt.Sprintf("overlayfs is not usable: %v: %s (kernel: %s)", e.Err, hint, e.KernelVersion)
+}
+
+func (e *OverlayNotSupportedError) Unwrap() error {
+	return e.Err
+}
+
+// probeOverlay checks that overlays can be mounted by mounting one made of empty directories (inside a scratch
+// directory in the dot root directory) with the filesystem type `fsType` ("overlay" normally) and unmounting it right
+// away. If the mount fails, `*OverlayNotSupportedError` is returned, and the diagnostics are logged.
+func (d *DockerOnTop) probeOverlay(fsType string) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	probeDir := d.dotRootDir + scratchDirPrefix + "probe-" + id
+	defer func() {
+		if err := os.RemoveAll(probeDir); err != nil {
+			d.logger.Warn("Failed to remove the overlay probe directory", "path", probeDir, "error", err)
+		}
+	}()
+	for _, dir := range []string{"lower", "upper", "work", "merged"} {
+		if err := os.MkdirAll(probeDir+"/"+dir, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to prepare the overlay probe: %w", err)
+		}
+	}
+
+	options := "lowerdir=" + probeDir + "/lower,upperdir=" + probeDir + "/upper,workdir=" + probeDir + "/work"
+	if d.DefaultUserXattr {
+		options += ",userxattr"
+	}
+	err = d.mountSyscall("docker-on-top-probe", probeDir+"/merged", fsType, 0, options)
+	if err == nil {
+		if err := d.unmountSyscall(probeDir+"/merged", syscall.MNT_DETACH); err != nil {
+			d.logger.Warn("Failed to unmount the overlay probe", "error", err)
+		}
+		return nil
+	}
+
+	probeErr := &OverlayNotSupportedError{Err: err, KernelVersion: kernelVersion()}
+	if filesystems, err := os.ReadFile("/proc/filesystems"); err == nil {
+		for _, line := range strings.Split(string(filesystems), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[len(fields)-1] == "overlay" {
+				probeErr.InProcFilesystems = true
+			}
+		}
+	}
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err == nil {
+		release := unix.ByteSliceToString(uname.Release[:])
+		modules, _ := filepath.Glob("/lib/modules/" + release + "/kernel/fs/overlayfs/overlay.ko*")
+		probeErr.ModuleFound = len(modules) > 0
+	}
+	d.logger.Error("The overlay probe mount failed", "error", err, "kernelVersion", probeErr.KernelVersion,
+		"overlayInProcFilesystems", probeErr.InProcFilesystems, "overlayModuleFound", probeErr.ModuleFound)
+	return probeErr
+}
+
+// kernelVersion returns the content of `/proc/version`, or an empty string if it cannot be read.
+func kernelVersion() string {
+	version, err := os.ReadFile("/proc/version")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(version))
+}
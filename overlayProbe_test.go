@@ -0,0 +1,88 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestOverlayProbe(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	if err := d.probeOverlay("overlay"); err != nil {
+		t.Fatalf("The overlay probe failed: %v", err)
+	}
+
+	// A filesystem type the kernel doesn't know makes the probe fail like without overlayfs
+	err := d.probeOverlay("docker-on-top-no-such-fs")
+	var notSupported *OverlayNotSupportedError
+	if !errors.As(err, &notSupported) {
+		t.Fatalf("The probe with a bad filesystem type returned %v, want an *OverlayNotSupportedError", err)
+	}
+	if !errors.Is(err, syscall.ENODEV) {
+		t.Errorf("The probe error %v doesn't wrap ENODEV", err)
+	}
+	if notSupported.KernelVersion != kernelVersion() || notSupported.KernelVersion == "" {
+		t.Errorf("KernelVersion = %q, want the content of /proc/version", notSupported.KernelVersion)
+	}
+	// The sandbox can mount overlays, so the module is loaded
+	if !notSupported.InProcFilesystems {
+		t.Error("InProcFilesystems is not set")
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "overlayfs is not usable: ") ||
+		!strings.Contains(msg, notSupported.KernelVersion) {
+		t.Errorf("Unexpected error message %q", msg)
+	}
+
+	// The probe directories are removed either way
+	entries, err := os.ReadDir(d.DotRootDirPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), scratchDirPrefix) {
+			t.Errorf("The probe left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestNewDockerOnTopWithoutOverlay(t *testing.T) {
+	withoutOverlay := func(d *DockerOnTop) {
+		d.mountSyscall = func(string, string, string, uintptr, string) error { return syscall.ENODEV }
+	}
+	_, err := NewDockerOnTop(context.Background(), t.TempDir(), WithLogger(newTestLogger(t)), withoutOverlay)
+	var notSupported *OverlayNotSupportedError
+	if !errors.As(err, &notSupported) || !errors.Is(err, syscall.ENODEV) {
+		t.Errorf("NewDockerOnTop = %v, want an *OverlayNotSupportedError wrapping ENODEV", err)
+	}
+
+	d, err := NewDockerOnTop(context.Background(), t.TempDir(), WithLogger(newTestLogger(t)), withoutOverlay,
+		WithoutOverlayProbe())
+	if err != nil {
+		t.Fatalf("Without the probe, NewDockerOnTop failed: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOverlayNotSupportedErrorHints(t *testing.T) {
+	tests := []struct {
+		err  OverlayNotSupportedError
+		hint string
+	}{
+		{OverlayNotSupportedError{InProcFilesystems: true, ModuleFound: true}, "the kernel supports overlayfs"},
+		{OverlayNotSupportedError{ModuleFound: true}, "modprobe overlay"},
+		{OverlayNotSupportedError{}, "--fuse-overlay-fallback"},
+	}
+	for _, test := range tests {
+		test.err.Err = syscall.ENODEV
+		if msg := test.err.Error(); !strings.Contains(msg, test.hint) {
+			t.Errorf("The error message %q doesn't contain %q", msg, test.hint)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// lastActivity returns the latest of the times the volume was created, mounted, unmounted, or used.
+func (vol *VolumeInfo) lastActivity() time.Time {
+	latest := vol.CreatedAt
+	for _, t := range []time.Time{vol.LastMountedAt, vol.LastUnmountedAt, vol.LastUsedAt} {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// Prune removes the volumes that are not in use and have been idle for longer than `olderThan`: both created and last
+// mounted (or unmounted) before then. The volumes with unreadable metadata are left alone. If `dryRun` is set, nothing
+// is removed.
+//
+// The names of the pruned (or, with `dryRun`, the would-be-pruned) volumes are returned, in ascending order. The
+// volumes that fail to be removed (e.g. the ones with forks) are logged and skipped, and the errors are joined into the
+// returned error.
+func (d *DockerOnTop) Prune(olderThan time.Duration, dryRun bool) ([]string, error) {
+	d.logger.Debug("Request Prune", "olderThan", olderThan, "dryRun", dryRun)
+
+	cutoff := time.Now().Add(-olderThan)
+	candidates, err := d.listVolumesWhere(func(vol *VolumeInfo) bool {
+		return vol.lastActivity().Before(cutoff)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []string{}
+	var errs []error
+	for _, volumeName := range candidates {
+		if dryRun {
+			if mounted, err := d.volumeIsMounted(volumeName); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", volumeName, err))
+			} else if !mounted {
+				pruned = append(pruned, volumeName)
+			}
+			continue
+		}
+		if ok, err := d.pruneVolume(volumeName, cutoff); err != nil {
+			d.logger.Error("Failed to prune the volume", "volume", volumeName, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", volumeName, err))
+		} else if ok {
+			pruned = append(pruned, volumeName)
+		}
+	}
+	return pruned, errors.Join(errs...)
+}
+
+// pruneVolume removes the volume unless it is in use or has been active since `cutoff`, which is rechecked under the
+// volume's lock, as the volume may have been mounted in the meantime. Whether the volume was removed is returned.
+func (d *DockerOnTop) pruneVolume(volumeName string, cutoff time.Time) (bool, error) {
+	unlock, err := d.lockIdleVolume(volumeName, "prune")
+	if err != nil {
+		d.logger.Debug("Not pruning the volume", "volume", volumeName, "reason", err)
+		return false, nil
+	}
+	defer unlock()
+
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		return false, err
+	} else if !thisVol.lastActivity().Before(cutoff) {
+		return false, nil
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: volumeName}); err != nil {
+		return false, err
+	}
+	d.logger.Info("Pruned the volume", "volume", volumeName, "lastActivity", thisVol.lastActivity())
+	return true, nil
+}
+
+// parseAge parses a duration like `time.ParseDuration` does, additionally accepting a whole number of days, like "7d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,149 @@
+//go:build dottest
+
+package main
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"7d":    7 * 24 * time.Hour,
+		"0d":    0,
+		"12h":   12 * time.Hour,
+		"1h30m": 90 * time.Minute,
+	} {
+		if age, err := parseAge(s); err != nil || age != want {
+			t.Errorf("parseAge(%q) = %v, %v; want %v", s, age, err, want)
+		}
+	}
+	for _, s := range []string{"", "d", "-1d", "1.5d", "7 days", "week"} {
+		if age, err := parseAge(s); err == nil {
+			t.Errorf("parseAge(%q) = %v, want an error", s, age)
+		}
+	}
+}
+
+// agedVolumes creates volumes whose activity times are set relative to now: the times that are not given stay zero
+// (CreatedAt stays now).
+func agedVolumes(t *testing.T, d *DockerOnTop, ages map[string]map[string]time.Duration) {
+	t.Helper()
+	now := time.Now()
+	for name, times := range ages {
+		MustCreateVolume(t, d, name, t.TempDir())
+		err := d.updateVolumeInfo(name, func(vol *VolumeInfo) {
+			for field, age := range times {
+				switch field {
+				case "created":
+					vol.CreatedAt = now.Add(-age)
+				case "mounted":
+					vol.LastMountedAt = now.Add(-age)
+				case "unmounted":
+					vol.LastUnmountedAt = now.Add(-age)
+				case "used":
+					vol.LastUsedAt = now.Add(-age)
+				default:
+					t.Fatalf("Unknown time %s", field)
+				}
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	const day = 24 * time.Hour
+	d := NewTestDockerOnTop(t)
+	agedVolumes(t, d, map[string]map[string]time.Duration{
+		"old":                {"created": 10 * day},
+		"old-and-unmounted":  {"created": 30 * day, "mounted": 20 * day, "unmounted": 20 * day},
+		"new":                {},
+		"almost-old":         {"created": 6 * day},
+		"recently-mounted":   {"created": 10 * day, "mounted": day},
+		"recently-unmounted": {"created": 10 * day, "mounted": 10 * day, "unmounted": day},
+		"recently-used":      {"created": 10 * day, "used": day},
+	})
+	// Mounted long ago and still in use
+	MustCreateVolume(t, d, "in-use", t.TempDir())
+	MustMountVolume(t, d, "in-use", "container")
+	err := d.updateVolumeInfo("in-use", func(vol *VolumeInfo) {
+		vol.CreatedAt = time.Now().Add(-10 * day)
+		vol.LastMountedAt = time.Now().Add(-10 * day)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := []string{"old", "old-and-unmounted"}
+	pruned, err := d.Prune(7*day, true)
+	if err != nil || !slices.Equal(pruned, candidates) {
+		t.Errorf("Dry-run Prune = %v, %v; want %v", pruned, err, candidates)
+	}
+	for _, name := range candidates {
+		if _, err := d.getVolumeInfo(name); err != nil {
+			t.Errorf("Dry-run Prune removed %s: %v", name, err)
+		}
+	}
+
+	pruned, err = d.Prune(7*day, false)
+	if err != nil || !slices.Equal(pruned, candidates) {
+		t.Errorf("Prune = %v, %v; want %v", pruned, err, candidates)
+	}
+	for _, name := range candidates {
+		if _, err := os.Stat(d.volumeDir(name)); !os.IsNotExist(err) {
+			t.Errorf("The pruned volume %s still exists (%v)", name, err)
+		}
+	}
+	kept := []string{"new", "almost-old", "recently-mounted", "recently-unmounted", "recently-used", "in-use"}
+	for _, name := range kept {
+		if _, err := d.getVolumeInfo(name); err != nil {
+			t.Errorf("The volume %s was pruned: %v", name, err)
+		}
+	}
+	MustUnmountVolume(t, d, "in-use", "container")
+
+	if pruned, err := d.Prune(100*day, false); err != nil || len(pruned) != 0 {
+		t.Errorf("With a longer age, Prune = %v, %v; want nothing", pruned, err)
+	}
+}
+
+func TestPruneSubcommand(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	agedVolumes(t, d, map[string]map[string]time.Duration{
+		"old": {"created": 8 * 24 * time.Hour},
+		"new": {},
+	})
+
+	out, err := captureStdout(t, runPrune, d, "-older-than", "7d", "-dry-run")
+	if err != nil || out != "old\n" {
+		t.Errorf("prune -older-than 7d -dry-run printed %q, %v; want %q", out, err, "old\n")
+	}
+	if _, err := d.getVolumeInfo("old"); err != nil {
+		t.Errorf("The dry run removed the volume: %v", err)
+	}
+	out, err = captureStdout(t, runPrune, d, "-older-than", "7d")
+	if err != nil || out != "old\n" {
+		t.Errorf("prune -older-than 7d printed %q, %v; want %q", out, err, "old\n")
+	}
+	if _, err := d.getVolumeInfo("old"); !os.IsNotExist(err) {
+		t.Errorf("The volume was not pruned (%v)", err)
+	}
+
+	for _, args := range [][]string{nil, {"-older-than"}, {"-older-than", "7d", "extra"}} {
+		if _, err := captureStdout(t, runPrune, d, args...); !errors.Is(err, errUsage) {
+			t.Errorf("prune %q = %v, want %v", args, err, errUsage)
+		}
+	}
+	if _, err := captureStdout(t, runPrune, d, "-older-than", "a week"); err == nil {
+		t.Error("prune succeeded with an invalid age")
+	}
+	if _, err := d.getVolumeInfo("new"); err != nil {
+		t.Errorf("The new volume was pruned: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+Disk quotas of the volumes (the `quota` option).
+
+The space the changes made to a volume may take is limited with a project quota, which both ext4 and XFS support: the
+volume's upperdir and workdir are assigned a project ID (with the "project inherit" flag, so that everything created
+inside gets the same ID), and a hard limit on the blocks of the project is set with `quotactl`. A write that would
+exceed the limit fails with `ENOSPC`. The project ID is the inode number of the upperdir, which is unique on the
+filesystem, so no IDs need to be allocated and stored. Both directories must be in the same project: overlayfs creates
+the copies of the lower files in the workdir and renames them into the upperdir, and the filesystems refuse renames
+between projects.
+
+The filesystem of the volumes' upperdirs (the dot root directory, unless the `upper` and `work` options are used) must
+have project quotas enabled:
+- ext4: the `quota` and `project` features (`mkfs.ext4 -O quota,project -I 256`, or `tune2fs -O quota,project` on an
+  unmounted filesystem) and the `prjquota` mount option;
+- XFS: the `prjquota` (aka `pquota`) mount option.
+The kernel must be built with `CONFIG_QUOTA` (and `CONFIG_QUOTACTL`). The quotas are set with `quotactl_fd` (Linux
+5.14+), falling back to `quotactl` on the filesystem's block device on older kernels. If the quota cannot be set up,
+the volume fails to mount rather than being mounted without the limit.
+*/
+
+const (
+	// fsIocFsGetXattr and fsIocFsSetXattr are the `FS_IOC_FSGETXATTR` and `FS_IOC_FSSETXATTR` ioctls (with the
+	// generic `_IOR` / `_IOW` encoding used by x86 and arm)
+	fsIocFsGetXattr = 0x801c581f
+	fsIocFsSetXattr = 0x401c5820
+	// fsXflagProjInherit is `FS_XFLAG_PROJINHERIT`: the files created in the directory inherit its project ID
+	fsXflagProjInherit = 0x00000200
+
+	// prjQuota is `PRJQUOTA`, the project quota type
+	prjQuota = 2
+	// qSetQuota is the `Q_SETQUOTA` quotactl command
+	qSetQuota = 0x800008
+	// qifBLimits is `QIF_BLIMITS`: the block limits of `ifDqblk` are set
+	qifBLimits = 1
+	// quotaBlockSize is the size of the blocks the limits of `ifDqblk` are expressed in (`QIF_DQBLKSIZE`)
+	quotaBlockSize = 1024
+)
+
+// fsxattr is `struct fsxattr` of the `FS_IOC_FSGETXATTR` / `FS_IOC_FSSETXATTR` ioctls
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// ifDqblk is `struct if_dqblk` of the `Q_SETQUOTA` quotactl command
+type ifDqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+}
+
+// quotaOverrunTolerance is how much (as a fraction of the quota) the size of the changes made to a volume may exceed
+// its quota before a warning is logged on unmount. The quota counts the allocated blocks while the size of the changes
+// is the total size of the files, so the two never match exactly
+const quotaOverrunTolerance = 0.1
+
+// parseSize parses a human-readable size, like "512M": a non-negative integer with an optional binary suffix (K, M, G,
+// T, case-insensitive, optionally followed by "iB" or "B"). A number without a suffix is in bytes.
+func parseSize(s string) (int64, error) {
+	number := strings.TrimSuffix(strings.ToUpper(s), "B")
+	var shift uint
+	if i := strings.LastIndexAny(number, "KMGT"); i >= 0 && (i == len(number)-1 || number[i+1:] == "I") {
+		shift = 10 * uint(strings.IndexByte("KMGT", number[i])+1)
+		number = number[:i]
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a number with an optional K, M, G, or T suffix", s)
+	} else if n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("the size %q is too large", s)
+	}
+	return n << shift, nil
+}
+
+// setUpQuota limits the size of the changes made to the volume to `vol.QuotaBytes` with a project quota (see the
+// comment in the beginning of the file). The upperdir and workdir trees are (re)assigned to the project if the
+// upperdir is not in it yet, which is the case for the first mount and after the upperdir is replaced (e.g. by
+// `Reset`). The caller is expected to hold the volume's lock.
+func (d *DockerOnTop) setUpQuota(volumeName string, vol VolumeInfo) error {
+	upperdir := d.upperdir(volumeName, &vol)
+	var stat syscall.Stat_t
+	if err := syscall.Stat(upperdir, &stat); err != nil {
+		return err
+	}
+	projectID := uint32(stat.Ino)
+	if projectID == 0 {
+		// Project 0 is the default one, which is not limited
+		projectID = 1
+	}
+
+	current, err := getProjectID(upperdir)
+	if err != nil {
+		return fmt.Errorf("failed to get the project ID of the upperdir (does the filesystem support project "+
+			"quotas?): %w", err)
+	}
+	if current != projectID {
+		d.logger.Debug("Assigning the volume's directories to its quota project", "volume", volumeName, "project",
+			projectID)
+		for _, dir := range []string{upperdir, d.workdir(volumeName, &vol)} {
+			if err := setProjectIDTree(dir, projectID); err != nil {
+				return fmt.Errorf("failed to set the project ID of %s: %w", dir, err)
+			}
+		}
+	}
+
+	limit := ifDqblk{
+		bhardlimit: uint64((vol.QuotaBytes + quotaBlockSize - 1) / quotaBlockSize),
+		valid:      qifBLimits,
+	}
+	if err := setProjectQuota(upperdir, projectID, &limit); err != nil {
+		return fmt.Errorf("failed to set the project quota (is the filesystem mounted with `prjquota`?): %w", err)
+	}
+	return nil
+}
+
+// getProjectID returns the project ID of the file at `path`.
+func getProjectID(path string) (uint32, error) {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+	var attr fsxattr
+	if err := fsxattrIoctl(fd, fsIocFsGetXattr, &attr); err != nil {
+		return 0, err
+	}
+	return attr.projid, nil
+}
+
+// setProjectIDTree assigns the directory `root` and everything inside it to the project `projectID`, setting the
+// project inherit flag on the directories. Symbolic links and special files, which cannot be opened, are skipped (they
+// take no data blocks anyway).
+func setProjectIDTree(root string, projectID uint32) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+		fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd)
+		var attr fsxattr
+		if err := fsxattrIoctl(fd, fsIocFsGetXattr, &attr); err != nil {
+			return err
+		}
+		attr.projid = projectID
+		if entry.IsDir() {
+			attr.xflags |= fsXflagProjInherit
+		}
+		return fsxattrIoctl(fd, fsIocFsSetXattr, &attr)
+	})
+}
+
+// fsxattrIoctl performs the `FS_IOC_FSGETXATTR` or `FS_IOC_FSSETXATTR` ioctl on `fd`.
+func fsxattrIoctl(fd int, request uintptr, attr *fsxattr) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(attr)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setProjectQuota sets the limits of the project `projectID` on the filesystem of `path`: with `quotactl_fd` if the
+// kernel supports it, otherwise with `quotactl` on the filesystem's block device.
+func setProjectQuota(path string, projectID uint32, limit *ifDqblk) error {
+	cmd := uintptr(qSetQuota<<8 | prjQuota)
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL_FD, uintptr(fd), cmd, uintptr(projectID),
+		uintptr(unsafe.Pointer(limit)), 0, 0)
+	unix.Close(fd)
+	if errno != syscall.ENOSYS {
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+
+	device, err := blockDeviceOf(path)
+	if err != nil {
+		return fmt.Errorf("quotactl_fd is not supported and the filesystem's device cannot be found: %w", err)
+	}
+	devicePtr, err := unix.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno = unix.Syscall6(unix.SYS_QUOTACTL, cmd, uintptr(unsafe.Pointer(devicePtr)), uintptr(projectID),
+		uintptr(unsafe.Pointer(limit)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// blockDeviceOf returns the source (normally, the block device) of the mount `path` is located on, that is, of the
+// longest mountpoint containing `path` in `procSelfMountInfo`.
+func blockDeviceOf(path string) (string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	entries, err := readMountInfo(procSelfMountInfo)
+	if err != nil {
+		return "", err
+	}
+	var best mountInfoEntry
+	for _, entry := range entries {
+		if isPathUnder(path, entry.MountPoint) && len(entry.MountPoint) >= len(best.MountPoint) {
+			best = entry
+		}
+	}
+	if !strings.HasPrefix(best.Source, "/dev/") {
+		return "", fmt.Errorf("%s is not on a block device", path)
+	}
+	return best.Source, nil
+}
+
+// checkQuotaOverrun logs a warning if the size of the changes made to the volume exceeds its quota by more than
+// `quotaOverrunTolerance`, which means that the quota was not enforced (e.g. the upperdir was written to directly).
+func (d *DockerOnTop) checkQuotaOverrun(volumeName string, vol VolumeInfo) {
+	if vol.QuotaBytes <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.usageTimeout)
+	defer cancel()
+	size, err := d.upperdirUsage(ctx, volumeName, &vol)
+	if errors.Is(err, context.DeadlineExceeded) {
+		d.logger.Debug("Timed out computing the size of the volume's changes. Not checking the quota", "volume",
+			volumeName)
+		return
+	} else if err != nil {
+		d.logger.Warn("Failed to compute the size of the volume's changes to check the quota", "volume", volumeName,
+			"error", err)
+		return
+	}
+	if float64(size) > float64(vol.QuotaBytes)*(1+quotaOverrunTolerance) {
+		d.logger.Warn("The changes made to the volume exceed its quota. Is the quota enforced by the filesystem?",
+			"volume", volumeName, "size", size, "quota", vol.QuotaBytes)
+	}
+}
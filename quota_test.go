@@ -0,0 +1,224 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"0":     0,
+		"1234":  1234,
+		"512M":  512 << 20,
+		"512m":  512 << 20,
+		"512MB": 512 << 20,
+		"1GiB":  1 << 30,
+		"2k":    2 << 10,
+		"3T":    3 << 40,
+		"100B":  100,
+	} {
+		if n, err := parseSize(s); err != nil || n != want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", s, n, err, want)
+		}
+	}
+	for _, s := range []string{"", "M", "-1M", "1.5G", "1P", "1MM", "1 M", "9223372036854775807K"} {
+		if n, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) = %d, want an error", s, n)
+		}
+	}
+}
+
+func TestQuotaOption(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	options := map[string]string{"base": t.TempDir(), "quota": "512M"}
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.QuotaBytes != 512<<20 {
+		t.Errorf("QuotaBytes = %d, %v; want %d", vol.QuotaBytes, err, 512<<20)
+	}
+	response, err := d.Get(&volume.GetRequest{Name: "vol"})
+	if err != nil || response.Volume.Status["quotaBytes"] != int64(512<<20) {
+		t.Errorf("The volume's status is %v, %v; want quotaBytes %d", response.Volume.Status, err, 512<<20)
+	}
+
+	MustCreateVolume(t, d, "unlimited", t.TempDir())
+	if vol, err := d.getVolumeInfo("unlimited"); err != nil || vol.QuotaBytes != 0 {
+		t.Errorf("Without the option, QuotaBytes = %d, %v; want 0", vol.QuotaBytes, err)
+	}
+
+	for name, options := range map[string]map[string]string{
+		"invalid size":    {"quota": "lots"},
+		"negative size":   {"quota": "-1G"},
+		"read-only":       {"quota": "1G", "readonly": "true"},
+		"tmpfs upperdir":  {"quota": "1G", "tmpfs-size": "1G"},
+		"fractional size": {"quota": "0.5G"},
+	} {
+		options["base"] = t.TempDir()
+		if err := d.Create(&volume.CreateRequest{Name: "invalid", Options: options}); err == nil {
+			t.Errorf("%s: the volume was created", name)
+			if err := d.Remove(&volume.RemoveRequest{Name: "invalid"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestQuotaSetUpOnMount(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	options := map[string]string{"base": t.TempDir(), "quota": "1M"}
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.Mount(&volume.MountRequest{Name: "vol", ID: "container"})
+	if err != nil {
+		// The volume is never mounted without its quota
+		if !strings.Contains(err.Error(), "quota") {
+			t.Errorf("Mount failed with %v, want a quota error", err)
+		}
+		if _, ok := m.Mounted(d.mountpointdir("vol")); ok {
+			t.Error("The overlay was mounted although the quota could not be set up")
+		}
+		if entries, err := os.ReadDir(d.activemountsdir("vol")); err != nil || len(entries) != 0 {
+			t.Errorf("The active mounts are %v, %v; want none", entries, err)
+		}
+		return
+	}
+
+	// The filesystem supports project quotas: the upperdir is in the project named after its inode number
+	var stat syscall.Stat_t
+	if err := syscall.Stat(d.upperdir("vol", &vol), &stat); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{d.upperdir("vol", &vol), d.workdir("vol", &vol)} {
+		if id, err := getProjectID(dir); err != nil || id != uint32(stat.Ino) {
+			t.Errorf("The project ID of %s is %d, %v; want %d", dir, id, err, stat.Ino)
+		}
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
+
+func TestQuotaOverrunWarning(t *testing.T) {
+	var logs bytes.Buffer
+	d := NewTestDockerOnTop(t, WithLogger(newLogger(&logs, "json")))
+	options := map[string]string{"base": t.TempDir(), "quota": "10K"}
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Within the tolerance
+	writeFiles(t, d.upperdir("vol", &vol), map[string]string{"file": strings.Repeat("x", 10<<10+512)})
+	d.checkQuotaOverrun("vol", vol)
+	if strings.Contains(logs.String(), "exceed its quota") {
+		t.Errorf("A warning was logged for changes within the tolerance: %s", logs.String())
+	}
+
+	writeFiles(t, d.upperdir("vol", &vol), map[string]string{"another": strings.Repeat("x", 2<<10)})
+	d.checkQuotaOverrun("vol", vol)
+	entries := decodeLogEntries(t, &logs)
+	found := false
+	for _, entry := range entries {
+		if entry["level"] == "WARN" && strings.Contains(entry["msg"].(string), "exceed its quota") {
+			found = true
+			if entry["volume"] != "vol" || entry["quota"] != float64(10<<10) {
+				t.Errorf("The warning is %v", entry)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("No warning was logged for the changes exceeding the quota: %v", entries)
+	}
+}
+
+// projectQuotaDir mounts a small ext4 filesystem with project quotas enabled and returns a directory on it. The test
+// is skipped if this is not possible (no root privileges, mkfs.ext4, loop devices, or kernel support for quotas).
+func projectQuotaDir(t *testing.T) string {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("Mounting a filesystem requires root privileges")
+	}
+	dir := t.TempDir()
+	image := dir + "/image"
+	if err := os.WriteFile(image, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(image, 64<<20); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("mkfs.ext4", "-q", "-O", "quota,project", "-I", "256", image).CombinedOutput()
+	if err != nil {
+		t.Skipf("mkfs.ext4 failed: %v: %s", err, output)
+	}
+	mountpoint := dir + "/mnt"
+	if err := os.Mkdir(mountpoint, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	output, err = exec.Command("mount", "-o", "loop,prjquota", image, mountpoint).CombinedOutput()
+	if err != nil {
+		t.Skipf("Failed to mount a filesystem with project quotas: %v: %s", err, output)
+	}
+	t.Cleanup(func() {
+		if err := syscall.Unmount(mountpoint, syscall.MNT_DETACH); err != nil {
+			t.Errorf("Failed to unmount %s: %v", mountpoint, err)
+		}
+	})
+	return mountpoint
+}
+
+func TestQuotaWithOverlay(t *testing.T) {
+	storage := projectQuotaDir(t)
+	d := NewTestDockerOnTopWithOverlay(t)
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":  t.TempDir(),
+		"upper": storage + "/upper",
+		"work":  storage + "/work",
+		"quota": "1M",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	writeFiles(t, mountpoint, map[string]string{"small": strings.Repeat("x", 512<<10)})
+	err = os.WriteFile(mountpoint+"large", bytes.Repeat([]byte("x"), 1<<20), 0o644)
+	if !errors.Is(err, syscall.ENOSPC) && !errors.Is(err, syscall.EDQUOT) {
+		t.Errorf("Writing over the quota = %v, want %v", err, syscall.ENOSPC)
+	}
+	if contents, err := os.ReadFile(mountpoint + "small"); err != nil || len(contents) != 512<<10 {
+		t.Errorf("The file within the quota has %d bytes, %v", len(contents), err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+
+	// The quota survives the remount
+	mountpoint = MustMountVolume(t, d, "vol", "container")
+	if err := os.Remove(mountpoint + "large"); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(mountpoint+"large", bytes.Repeat([]byte("x"), 1<<20), 0o644)
+	if !errors.Is(err, syscall.ENOSPC) && !errors.Is(err, syscall.EDQUOT) {
+		t.Errorf("After the remount, writing over the quota = %v, want %v", err, syscall.ENOSPC)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
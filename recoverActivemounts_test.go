@@ -0,0 +1,140 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+// fakeProcess is a process in a fake `/proc` directory (see `writeFakeProc`).
+type fakeProcess struct {
+	// namespace is the target of the `ns/mnt` link, no link is made if it's empty
+	namespace string
+	// mounts are the contents of the `mounts` file, no file is made if it's empty
+	mounts string
+}
+
+// writeFakeProc makes a fake `/proc` directory with the given processes, where the plugin's own mount namespace is
+// "mnt:[1]", and returns its path.
+func writeFakeProc(t *testing.T, processes map[string]fakeProcess) string {
+	t.Helper()
+	proc := t.TempDir()
+	processes["self"] = fakeProcess{namespace: "mnt:[1]"}
+	for pid, process := range processes {
+		if err := os.MkdirAll(proc+"/"+pid+"/ns", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if process.namespace != "" {
+			if err := os.Symlink(process.namespace, proc+"/"+pid+"/ns/mnt"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if process.mounts != "" {
+			if err := os.WriteFile(proc+"/"+pid+"/mounts", []byte(process.mounts), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return proc
+}
+
+func TestRecoverActivemountsFromProcDir(t *testing.T) {
+	const (
+		rootMounts = "/dev/sda1 / ext4 rw 0 0\n"
+		// The volume mounted into a container, and the plugin's own mount of it
+		containerMounts = rootMounts + "docker-on-top_vol /data overlay rw,lowerdir=/base 0 0\n"
+		pluginMounts    = rootMounts + "docker-on-top_vol /var/lib/docker-on-top/vol/mountpoint overlay rw 0 0\n"
+	)
+	tests := []struct {
+		name      string
+		processes map[string]fakeProcess
+		want      []string // The recovered active mounts
+	}{
+		{
+			name:      "plugin's namespace only",
+			processes: map[string]fakeProcess{"1": {namespace: "mnt:[1]", mounts: pluginMounts}},
+		},
+		{
+			name: "one container",
+			processes: map[string]fakeProcess{
+				"1":   {namespace: "mnt:[1]", mounts: pluginMounts},
+				"100": {namespace: "mnt:[2]", mounts: containerMounts},
+			},
+			want: []string{"recovered-2"},
+		},
+		{
+			name: "processes sharing a namespace",
+			processes: map[string]fakeProcess{
+				"100": {namespace: "mnt:[2]", mounts: containerMounts},
+				"101": {namespace: "mnt:[2]", mounts: containerMounts},
+				"102": {namespace: "mnt:[2]", mounts: containerMounts},
+			},
+			want: []string{"recovered-2"},
+		},
+		{
+			name: "two containers",
+			processes: map[string]fakeProcess{
+				"100": {namespace: "mnt:[2]", mounts: containerMounts},
+				"200": {namespace: "mnt:[3]", mounts: containerMounts},
+				"201": {namespace: "mnt:[3]", mounts: containerMounts},
+			},
+			want: []string{"recovered-2", "recovered-3"},
+		},
+		{
+			name: "nested in the container's overlay",
+			processes: map[string]fakeProcess{
+				"100": {namespace: "mnt:[2]", mounts: "overlay / overlay rw,lowerdir=/l,upperdir=/u,workdir=/w 0 0\n" +
+					"docker-on-top_vol /mnt/vol overlay rw 0 0\n"},
+			},
+			want: []string{"recovered-2"},
+		},
+		{
+			name: "other volumes",
+			processes: map[string]fakeProcess{
+				"100": {namespace: "mnt:[2]", mounts: rootMounts + "docker-on-top_other /data overlay rw 0 0\n"},
+				"200": {namespace: "mnt:[3]", mounts: rootMounts + "docker-on-top_volume /data overlay rw 0 0\n"},
+				"300": {namespace: "mnt:[4]", mounts: rootMounts},
+			},
+		},
+		{
+			name: "exited processes and other entries",
+			processes: map[string]fakeProcess{
+				"100":     {mounts: containerMounts},
+				"200":     {namespace: "mnt:[3]"},
+				"sys":     {namespace: "mnt:[4]", mounts: containerMounts},
+				"300":     {namespace: "mnt:[5]", mounts: containerMounts},
+				"thread0": {namespace: "mnt:[6]", mounts: containerMounts},
+			},
+			want: []string{"recovered-5"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t)
+			MustCreateVolume(t, d, "vol", t.TempDir())
+			count, err := d.recoverActivemountsFromProcDir(writeFakeProc(t, test.processes), "vol")
+			if err != nil || count != len(test.want) {
+				t.Errorf("recoverActivemountsFromProcDir = %d, %v; want %d", count, err, len(test.want))
+			}
+			entries, err := os.ReadDir(d.activemountsdir("vol"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var recovered []string
+			for _, entry := range entries {
+				recovered = append(recovered, entry.Name())
+			}
+			if !slices.Equal(recovered, test.want) {
+				t.Errorf("The active mounts are %q, want %q", recovered, test.want)
+			}
+		})
+	}
+
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	if count, err := d.recoverActivemountsFromProcDir(t.TempDir(), "vol"); err == nil {
+		t.Errorf("Without the plugin's namespace, recoverActivemountsFromProcDir = %d, want an error", count)
+	}
+}
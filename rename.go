@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Rename renames the volume `oldName` to `newName`. The volume's main directory is renamed, which is atomic, so the
+// volume is never split between the two names. A namespaced volume stays namespaced, so the new name must be a
+// namespaced one as well.
+//
+// The volume must not be in use. Note that the docker daemon is not notified: it will only learn about the renamed
+// volume on the next `List`.
+func (d *DockerOnTop) Rename(oldName, newName string) error {
+	d.logger.Debug("Request Rename", "volume", oldName, "newName", newName)
+
+	if !volNameFormat.MatchString(newName) {
+		d.logger.Debug("Volume name doesn't comply to the regex. Volume not renamed")
+		return fmt.Errorf("volume name must match the regex %s", volNameFormat.String())
+	}
+	if _, err := d.getVolumeInfoOrNotFound(oldName); err != nil {
+		return err
+	}
+	if err := d.checkNoForks(oldName, "rename"); err != nil {
+		return err
+	}
+	newMainDir := d.dotRootDir + newName
+	if d.isNamespaced(oldName) {
+		// Keep the volume namespaced
+		var err error
+		newMainDir, err = d.prepareNamespacedDir(newName)
+		if os.IsExist(err) {
+			return errors.New("volume already exists")
+		} else if err != nil {
+			return err
+		}
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			// Created by `prepareNamespacedDir`
+			d.removeEmptyNamespaceDirs(newMainDir)
+		}
+	}()
+	if _, err := os.Lstat(d.volumeDir(newName)); err == nil {
+		return errors.New("volume already exists")
+	} else if _, err := os.Lstat(newMainDir); err == nil {
+		return errors.New("volume already exists")
+	} else if !os.IsNotExist(err) {
+		return d.internalError("failed to check whether the new name is taken", err, "volume", newName)
+	}
+
+	unlock, err := d.lockIdleVolume(oldName, "rename")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Read under the lock, so that it's up to date
+	vol, err := d.getVolumeInfo(oldName)
+	if err != nil {
+		return d.internalError("failed to retrieve the volume's metadata", err, "volume", oldName)
+	}
+	oldMainDir := d.volumeDir(oldName)
+	if err := os.Rename(oldMainDir, newMainDir); err != nil {
+		return d.internalError("failed to rename volume main directory", err, "volume", oldName, "newName", newName)
+	}
+	renamed = true
+	// With the default backend, the metadata has moved together with the main directory, but other backends store it
+	// by the volume name
+	if err := d.metadata.Write(newName, vol); err != nil {
+		d.logCritical("Failed to write the metadata of the renamed volume. Human interaction is required",
+			"volume", oldName, "newName", newName, "error", err)
+		return d.internalError("failed to write the metadata of the renamed volume", err)
+	}
+	if err := d.metadata.Delete(oldName); err != nil {
+		d.logger.Warn("Failed to delete the metadata of the volume's old name", "volume", oldName, "error", err)
+	}
+	d.invalidateVolumeInfo(oldName)
+	d.invalidateVolumeInfo(newName)
+	d.removeEmptyNamespaceDirs(oldMainDir)
+	d.logger.Info("Renamed volume", "volume", oldName, "newName", newName)
+	return nil
+}
@@ -0,0 +1,79 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestRename(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	base := t.TempDir()
+	MustCreateVolume(t, d, "old", base)
+	writeFiles(t, d.VolumeUpperDir("old"), map[string]string{"file": "changed"})
+
+	if err := d.Rename("old", "new"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	response, err := d.Get(&volume.GetRequest{Name: "new"})
+	if err != nil {
+		t.Fatalf("Get failed for the new name: %v", err)
+	}
+	if response.Volume.Name != "new" {
+		t.Errorf("Get returned the volume %s, want new", response.Volume.Name)
+	}
+	if vol, err := d.getVolumeInfo("new"); err != nil || vol.BaseDirPath != base {
+		t.Errorf("The renamed volume's base directory is %q, %v; want %q", vol.BaseDirPath, err, base)
+	}
+	if contents, err := os.ReadFile(d.VolumeUpperDir("new") + "file"); err != nil || string(contents) != "changed" {
+		t.Errorf("The renamed volume's changes = %q, %v; want %q", contents, err, "changed")
+	}
+	_, err = d.Get(&volume.GetRequest{Name: "old"})
+	if err == nil || !strings.Contains(err.Error(), "no such volume") {
+		t.Errorf("Get for the old name returned %v, want \"no such volume\"", err)
+	}
+	MustMountVolume(t, d, "new", "container")
+	MustUnmountVolume(t, d, "new", "container")
+}
+
+func TestRenameFailures(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustCreateVolume(t, d, "taken", t.TempDir())
+
+	tests := []struct {
+		name    string
+		oldName string
+		newName string
+		wantErr string
+	}{
+		{name: "invalid name", oldName: "vol", newName: "-invalid", wantErr: "must match the regex"},
+		{name: "taken name", oldName: "vol", newName: "taken", wantErr: "already exists"},
+		{name: "nonexistent volume", oldName: "missing", newName: "new", wantErr: "no such volume"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := d.Rename(test.oldName, test.newName); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Rename returned %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+
+	MustMountVolume(t, d, "vol", "container")
+	if err := d.Rename("vol", "new"); err == nil || !strings.Contains(err.Error(), "mounted") {
+		t.Errorf("Renaming the mounted volume returned %v, want an error about the mount", err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+	for _, name := range []string{"vol", "taken"} {
+		if _, err := d.Get(&volume.GetRequest{Name: name}); err != nil {
+			t.Errorf("After the failed renames, Get(%s) failed: %v", name, err)
+		}
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "new"}); err == nil {
+		t.Error("After the failed renames, the volume exists under the new name")
+	}
+}
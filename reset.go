@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// upperdirBackup returns the path a volume's upperdir `upperdir` is moved to by `Reset` at the given time.
+func upperdirBackup(upperdir string, at time.Time) string {
+	return strings.TrimSuffix(upperdir, "/") + ".bak." + at.UTC().Format("20060102T150405Z")
+}
+
+// Reset discards all the changes made to the volume, so that it shows the base directory (and the lower layers) as
+// they are. The upperdir is moved to `upper.bak.<timestamp>` inside the volume's main directory and replaced with an
+// empty one. If `purge` is set, the backup is removed afterwards. The volume's metadata is left untouched.
+//
+// The volume must not be in use.
+func (d *DockerOnTop) Reset(volumeName string, purge bool) error {
+	d.logger.Debug("Request Reset", "volume", volumeName, "purge", purge)
+
+	thisVol, err := d.getVolumeInfoOrNotFound(volumeName)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lockIdleVolume(volumeName, "reset")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	upperdir := d.upperdir(volumeName, &thisVol)
+	backup := upperdirBackup(upperdir, time.Now())
+	if err := os.Rename(upperdir, backup); err != nil {
+		return d.internalError("failed to back up the upperdir", err, "volume", volumeName, "path", backup)
+	}
+	if err := os.Mkdir(upperdir, os.ModePerm); err != nil {
+		if restoreErr := os.Rename(backup, upperdir); restoreErr != nil {
+			d.logCritical("Failed to restore the upperdir. Human interaction is required", "volume", volumeName,
+				"path", backup, "error", restoreErr)
+		}
+		return d.internalError("failed to create a new upperdir", err, "volume", volumeName)
+	}
+	// The workdir may contain leftovers referring to the old upperdir
+	if err := os.RemoveAll(d.workdir(volumeName, &thisVol)); err != nil {
+		d.logger.Warn("Failed to remove the workdir", "volume", volumeName, "error", err)
+	}
+
+	if purge {
+		if err := os.RemoveAll(backup); err != nil {
+			return d.internalError("failed to remove the backup of the upperdir", err, "volume", volumeName, "path",
+				backup)
+		}
+		backup = ""
+	}
+	d.logger.Info("Reset volume", "volume", volumeName, "backup", backup)
+	return nil
+}
@@ -0,0 +1,70 @@
+//go:build dottest
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResetWithOverlay(t *testing.T) {
+	for _, purge := range []bool{false, true} {
+		t.Run(fmt.Sprintf("purge=%v", purge), func(t *testing.T) {
+			d := NewTestDockerOnTopWithOverlay(t)
+			base := t.TempDir()
+			writeFiles(t, base, map[string]string{"file": "base"})
+			MustCreateVolume(t, d, "vol", base)
+			mountpoint := MustMountVolume(t, d, "vol", "container")
+			writeFiles(t, mountpoint, map[string]string{"file": "changed", "added": "added"})
+			MustUnmountVolume(t, d, "vol", "container")
+
+			if err := d.Reset("vol", purge); err != nil {
+				t.Fatalf("Reset failed: %v", err)
+			}
+			mountpoint = MustMountVolume(t, d, "vol", "container")
+			if contents, err := os.ReadFile(mountpoint + "/file"); err != nil || string(contents) != "base" {
+				t.Errorf("After the reset, the volume's file = %q, %v; want %q", contents, err, "base")
+			}
+			if _, err := os.Stat(mountpoint + "/added"); !os.IsNotExist(err) {
+				t.Errorf("After the reset, the added file is visible (%v)", err)
+			}
+			MustUnmountVolume(t, d, "vol", "container")
+
+			backups, err := filepath.Glob(d.volumeDir("vol") + "/upper.bak.*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purge && len(backups) != 0 {
+				t.Errorf("The purged backups are left: %v", backups)
+			} else if !purge {
+				if len(backups) != 1 {
+					t.Fatalf("The backups are %v, want one", backups)
+				}
+				contents, err := os.ReadFile(backups[0] + "/added")
+				if err != nil || string(contents) != "added" {
+					t.Errorf("The backup's file = %q, %v; want %q", contents, err, "added")
+				}
+			}
+		})
+	}
+}
+
+func TestResetFailures(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	if err := d.Reset("missing", false); err == nil || !strings.Contains(err.Error(), "no such volume") {
+		t.Errorf("Resetting a nonexistent volume returned %v, want \"no such volume\"", err)
+	}
+
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	writeFiles(t, d.VolumeUpperDir("vol"), map[string]string{"file": "changed"})
+	MustMountVolume(t, d, "vol", "container")
+	if err := d.Reset("vol", true); err == nil || !strings.Contains(err.Error(), "mounted") {
+		t.Errorf("Resetting the mounted volume returned %v, want an error about the mount", err)
+	}
+	if _, err := os.Stat(d.VolumeUpperDir("vol") + "file"); err != nil {
+		t.Errorf("The refused reset discarded the changes: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// mutableOptions are the volume options that can be changed after the volume is created (see `SetVolumeOption`). Each
+// function parses the value the same way `create` does and applies it to the volume's metadata.
+var mutableOptions = map[string]func(vol *VolumeInfo, value string) error{
+	"volatile": func(vol *VolumeInfo, value string) error {
+		volatile, err := parseBoolOption(map[string]string{"volatile": value}, "volatile")
+		if err != nil {
+			return err
+		}
+		if volatile && vol.ReadOnly {
+			return errors.New("options `volatile` and `readonly` are mutually exclusive")
+		} else if !volatile && vol.TmpfsSizeBytes > 0 {
+			return errors.New("the volume keeps its changes in memory (`tmpfs-size`), so it must be volatile")
+		}
+		vol.Volatile = volatile
+		return nil
+	},
+	"tags": func(vol *VolumeInfo, value string) error {
+		tags, err := parseTags(value)
+		if err != nil {
+			return err
+		}
+		vol.Tags = tags
+		return nil
+	},
+}
+
+// SetVolumeOption changes the value of the volume's option `key` (one of `mutableOptions`) as if the volume was
+// created with `key=value`. As the options are applied when the overlay is mounted, the volume must not be in use.
+func (d *DockerOnTop) SetVolumeOption(volumeName string, key string, value string) error {
+	apply, ok := mutableOptions[key]
+	if !ok {
+		if isVolumeOption(key) {
+			return fmt.Errorf("option `%s` cannot be changed after the volume is created", key)
+		}
+		return errors.New("Invalid option " + key)
+	}
+	if _, err := d.getVolumeInfoOrNotFound(volumeName); err != nil {
+		return err
+	}
+	unlock, err := d.lockIdleVolume(volumeName, "change its options")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Read again under the lock, so that concurrent updates are not lost
+	thisVol, err := d.getVolumeInfoOrNotFound(volumeName)
+	if err != nil {
+		return err
+	}
+	if err := apply(&thisVol, value); err != nil {
+		return err
+	}
+	if err := d.writeVolumeInfo(volumeName, thisVol); err != nil {
+		return d.internalError("failed to store metadata for the volume", err, "volume", volumeName)
+	}
+	d.logger.Info("Changed volume option", "volume", volumeName, "option", key, "value", value)
+	return nil
+}
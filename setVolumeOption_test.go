@@ -0,0 +1,85 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestSetVolumeOption(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base": t.TempDir(), "volatile": "false", "tags": "team",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SetVolumeOption("vol", "volatile", "true"); err != nil {
+		t.Fatalf("SetVolumeOption failed: %v", err)
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || !vol.Volatile || !slices.Equal(vol.Tags, []string{"team"}) {
+		t.Errorf("After setting `volatile`, the metadata is %+v, %v", vol, err)
+	}
+	// The new value applies to the next mount: the changes made to the now volatile volume are discarded
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, d.upperdir("vol", &vol), map[string]string{"change": "x"})
+	MustMountVolume(t, d, "vol", "container")
+	if _, err := os.Stat(d.upperdir("vol", &vol) + "change"); !os.IsNotExist(err) {
+		t.Errorf("After mounting, the change made to the volume is still there: %v", err)
+	}
+
+	// The volume must not be in use
+	if err := d.SetVolumeOption("vol", "volatile", "false"); err == nil {
+		t.Error("SetVolumeOption succeeded for a mounted volume")
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || !vol.Volatile {
+		t.Errorf("SetVolumeOption changed the metadata of a mounted volume to %+v, %v", vol, err)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+
+	if err := d.SetVolumeOption("vol", "tags", "ci, nightly"); err != nil {
+		t.Fatalf("SetVolumeOption failed: %v", err)
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || !slices.Equal(vol.Tags, []string{"ci", "nightly"}) ||
+		!vol.Volatile {
+		t.Errorf("After setting `tags`, the metadata is %+v, %v", vol, err)
+	}
+}
+
+func TestSetVolumeOptionValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	if err := d.Create(&volume.CreateRequest{Name: "readonly", Options: map[string]string{
+		"base": t.TempDir(), "readonly": "true",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		volume, key, value string
+	}{
+		{"vol", "volatile", "maybe"},
+		{"vol", "tags", "in\nvalid"},
+		{"vol", "base", "/"},
+		{"vol", "no-such-option", "true"},
+		{"readonly", "volatile", "true"},
+		{"missing", "volatile", "true"},
+	} {
+		if err := d.SetVolumeOption(test.volume, test.key, test.value); err == nil {
+			t.Errorf("SetVolumeOption(%s, %s, %q) succeeded", test.volume, test.key, test.value)
+		}
+	}
+	if vol, err := d.getVolumeInfo("vol"); err != nil || vol.Volatile || len(vol.Tags) != 0 {
+		t.Errorf("The failed calls changed the metadata to %+v, %v", vol, err)
+	}
+	if vol, err := d.getVolumeInfo("readonly"); err != nil || vol.Volatile {
+		t.Errorf("The failed call changed the metadata to %+v, %v", vol, err)
+	}
+}
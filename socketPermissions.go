@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// socketGroup is the group that is given access to the plugin's socket by default
+const socketGroup = "docker"
+
+// SocketPermissions are the mode and ownership the plugin's UNIX socket is given after it is created. A UID or GID of
+// -1 leaves the corresponding owner unchanged.
+type SocketPermissions struct {
+	Mode os.FileMode `toml:"mode" json:"mode"`
+	UID  int         `toml:"uid" json:"uid"`
+	GID  int         `toml:"gid" json:"gid"`
+}
+
+// DefaultSocketPermissions returns the permissions the socket is given unless configured otherwise: mode 0660, owned
+// by the current user and the `docker` group (or the current user's group, if there's no `docker` group).
+func DefaultSocketPermissions() SocketPermissions {
+	perms := SocketPermissions{Mode: 0o660, UID: os.Getuid(), GID: os.Getgid()}
+	if group, err := user.LookupGroup(socketGroup); err == nil {
+		if gid, err := strconv.Atoi(group.Gid); err == nil {
+			perms.GID = gid
+		}
+	}
+	return perms
+}
+
+// applySocketPermissions sets the ownership and then the mode of the socket at `path`.
+func applySocketPermissions(path string, perms SocketPermissions) error {
+	if err := os.Lchown(path, perms.UID, perms.GID); err != nil {
+		return err
+	}
+	return os.Chmod(path, perms.Mode.Perm())
+}
@@ -0,0 +1,106 @@
+//go:build dottest
+
+package main
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// socketStat returns the mode and the ownership of the socket at `path`, checking that it's a socket.
+func socketStat(t *testing.T, path string) (os.FileMode, int, int) {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		t.Errorf("%s has the type %v, want a socket", path, info.Mode().Type())
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	return info.Mode().Perm(), int(stat.Uid), int(stat.Gid)
+}
+
+func TestDefaultSocketPermissions(t *testing.T) {
+	want := SocketPermissions{Mode: 0o660, UID: os.Getuid(), GID: os.Getgid()}
+	if group, err := user.LookupGroup("docker"); err == nil {
+		if want.GID, err = strconv.Atoi(group.Gid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if perms := DefaultSocketPermissions(); perms != want {
+		t.Errorf("DefaultSocketPermissions() = %+v, want %+v", perms, want)
+	}
+}
+
+func TestApplySocketPermissions(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the socket's owner requires root privileges")
+	}
+	path := t.TempDir() + "/plugin.sock"
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if err := applySocketPermissions(path, SocketPermissions{Mode: 0o640, UID: 1234, GID: 5678}); err != nil {
+		t.Fatalf("applySocketPermissions failed: %v", err)
+	}
+	if mode, uid, gid := socketStat(t, path); mode != 0o640 || uid != 1234 || gid != 5678 {
+		t.Errorf("The socket has the mode %o and the owner %d:%d, want 640 and 1234:5678", mode, uid, gid)
+	}
+
+	// -1 leaves the owner unchanged
+	if err := applySocketPermissions(path, SocketPermissions{Mode: 0o600, UID: -1, GID: -1}); err != nil {
+		t.Fatalf("applySocketPermissions failed: %v", err)
+	}
+	if mode, uid, gid := socketStat(t, path); mode != 0o600 || uid != 1234 || gid != 5678 {
+		t.Errorf("The socket has the mode %o and the owner %d:%d, want 600 and 1234:5678", mode, uid, gid)
+	}
+
+	if err := applySocketPermissions(path+".missing", DefaultSocketPermissions()); err == nil {
+		t.Error("applySocketPermissions succeeded for a missing socket")
+	}
+}
+
+func TestServeUnixSocketPermissions(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the socket's owner requires root privileges")
+	}
+	d := NewTestDockerOnTop(t)
+	socketPath := t.TempDir() + "/plugins/plugin.sock"
+	served := make(chan error)
+	go func() {
+		served <- d.ServeUnix(socketPath, SocketPermissions{Mode: 0o660, UID: 0, GID: 4321})
+	}()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(socketPath); err == nil {
+			break
+		} else if time.Since(start) > 5*time.Second {
+			t.Fatal("The socket was not created")
+		}
+	}
+	// The permissions are applied before the requests are served
+	if err := createVolumeRequest(t, socketPath, "vol", map[string]string{"base": t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	if mode, uid, gid := socketStat(t, socketPath); mode != 0o660 || uid != 0 || gid != 4321 {
+		t.Errorf("The socket has the mode %o and the owner %d:%d, want 660 and 0:4321", mode, uid, gid)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ServeUnix returned %v", err)
+	}
+	if _, err := os.Lstat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("The socket was not removed (%v)", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// StatusReport is a quick overview of the plugin's state, returned by `DockerOnTop.Status`
+type StatusReport struct {
+	// TotalVolumes is the number of volumes
+	TotalVolumes int `json:"totalVolumes"`
+	// MountedVolumes is the number of volumes used by at least one container
+	MountedVolumes int `json:"mountedVolumes"`
+	// TotalActiveMounts is the number of containers using the volumes (a container using two volumes counts twice)
+	TotalActiveMounts int `json:"totalActiveMounts"`
+	// OldestMountAge is the time since the oldest of the active mounts was made (0 if there are none)
+	OldestMountAge time.Duration `json:"oldestMountAge"`
+	// DotRootDirFreeBytes is the space available to the plugin on the filesystem of the dot root directory
+	DotRootDirFreeBytes uint64 `json:"dotRootDirFreeBytes"`
+}
+
+// Status returns the numbers of the volumes and of their active mounts, the age of the oldest active mount (the time
+// since its active mount file was last modified), and the free space in the dot root directory.
+//
+// Like with `ListActiveMounts`, no locks are taken, so the report is only a snapshot. The failures are logged and
+// leave the affected fields incomplete (e.g. the volumes that cannot be listed are not counted).
+func (d *DockerOnTop) Status() StatusReport {
+	d.logger.Debug("Request Status")
+	var report StatusReport
+
+	// On failure, the error is already logged by `d.listVolumeNames`
+	volumeNames, _ := d.listVolumeNames()
+	report.TotalVolumes = len(volumeNames)
+	now := time.Now()
+	for _, volumeName := range volumeNames {
+		activemounts, err := d.readActivemounts(volumeName, -1)
+		if err != nil {
+			// Likely, being created or removed
+			continue
+		}
+		if len(activemounts) > 0 {
+			report.MountedVolumes++
+		}
+		report.TotalActiveMounts += len(activemounts)
+		for _, activemount := range activemounts {
+			info, err := activemount.Info()
+			if err != nil {
+				// Unmounted since it was listed
+				continue
+			}
+			if age := now.Sub(info.ModTime()); age > report.OldestMountAge {
+				report.OldestMountAge = age
+			}
+		}
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(d.dotRootDir, &stat); err != nil {
+		d.logger.Warn("Failed to get the free space in the dot root directory", "error", err)
+	} else {
+		report.DotRootDirFreeBytes = stat.Bavail * uint64(stat.Bsize)
+	}
+	return report
+}
@@ -0,0 +1,130 @@
+//go:build dottest
+
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeBytes returns the space available on the filesystem of `dir`.
+func freeBytes(t *testing.T, dir string) uint64 {
+	t.Helper()
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat.Bavail * uint64(stat.Bsize)
+}
+
+// ageActivemount makes the active mount of the container look like it was made `age` ago.
+func ageActivemount(t *testing.T, d *DockerOnTop, volumeName, id string, age time.Duration) {
+	t.Helper()
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(d.activemountsdir(volumeName)+id, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	before := freeBytes(t, d.dotRootDir)
+	report := d.Status()
+	after := freeBytes(t, d.dotRootDir)
+	// Other processes may be writing to the same filesystem
+	const slack = 64 << 20
+	if report.DotRootDirFreeBytes+slack < min(before, after) || report.DotRootDirFreeBytes > max(before, after)+slack {
+		t.Errorf("DotRootDirFreeBytes = %d, want about %d", report.DotRootDirFreeBytes, before)
+	}
+	report.DotRootDirFreeBytes = 0
+	if report != (StatusReport{}) {
+		t.Errorf("Without volumes, Status = %+v, want nothing", report)
+	}
+
+	for _, name := range []string{"shared", "single", "unused"} {
+		MustCreateVolume(t, d, name, t.TempDir())
+	}
+	MustMountVolume(t, d, "shared", "container1")
+	MustMountVolume(t, d, "shared", "container2")
+	MustMountVolume(t, d, "single", "container1")
+	ageActivemount(t, d, "shared", "container1", time.Hour)
+	ageActivemount(t, d, "single", "container1", 2*time.Hour)
+
+	report = d.Status()
+	if report.TotalVolumes != 3 || report.MountedVolumes != 2 || report.TotalActiveMounts != 3 {
+		t.Errorf("Status = %+v, want 3 volumes, 2 of them mounted, and 3 active mounts", report)
+	}
+	if report.OldestMountAge < 2*time.Hour || report.OldestMountAge > 2*time.Hour+time.Minute {
+		t.Errorf("OldestMountAge = %v, want 2h", report.OldestMountAge)
+	}
+	if report.DotRootDirFreeBytes == 0 {
+		t.Error("DotRootDirFreeBytes = 0")
+	}
+
+	MustUnmountVolume(t, d, "single", "container1")
+	report = d.Status()
+	if report.MountedVolumes != 1 || report.TotalActiveMounts != 2 {
+		t.Errorf("After an unmount, Status = %+v, want 1 mounted volume and 2 active mounts", report)
+	}
+	if report.OldestMountAge < time.Hour || report.OldestMountAge > time.Hour+time.Minute {
+		t.Errorf("After an unmount, OldestMountAge = %v, want 1h", report.OldestMountAge)
+	}
+
+	// The volumes being removed are still counted, but not their active mounts
+	if err := os.RemoveAll(d.activemountsdir("unused")); err != nil {
+		t.Fatal(err)
+	}
+	if report := d.Status(); report.TotalVolumes != 3 || report.TotalActiveMounts != 2 {
+		t.Errorf("Without the active mounts directory of a volume, Status = %+v", report)
+	}
+}
+
+func TestStatusSubcommand(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	MustCreateVolume(t, d, "unused", t.TempDir())
+	out, err := captureStdout(t, runStatus, d)
+	if err != nil {
+		t.Errorf("status failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	want := []string{"Volumes:          2", "Mounted volumes:  0", "Active mounts:    0", "Free space:       "}
+	if len(lines) != len(want) {
+		t.Fatalf("status printed %q, want %d lines", out, len(want))
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Errorf("Line %d of the status is %q, want %q", i, line, want[i])
+		}
+	}
+
+	MustMountVolume(t, d, "vol", "container")
+	ageActivemount(t, d, "vol", "container", 25*time.Hour)
+	out, err = captureStdout(t, runStatus, d)
+	if !strings.Contains(out, "Active mounts:    1\n") || !strings.Contains(out, "Oldest mount:     25h0m0s ago\n") {
+		t.Errorf("With a stale mount, status printed %q", out)
+	}
+	if err == nil || !strings.Contains(err.Error(), "older than 24h0m0s") {
+		t.Errorf("With a mount older than 24h, status = %v, want an error", err)
+	}
+	if _, err := captureStdout(t, runStatus, d, "-stale-after", "2d"); err != nil {
+		t.Errorf("status -stale-after 2d = %v, want no error", err)
+	}
+	if _, err := captureStdout(t, runStatus, d, "-stale-after", "12h"); err == nil {
+		t.Error("status -stale-after 12h succeeded with a mount older than that")
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+
+	for _, args := range [][]string{{"extra"}, {"-stale-after"}} {
+		if _, err := captureStdout(t, runStatus, d, args...); !errors.Is(err, errUsage) {
+			t.Errorf("status %q = %v, want %v", args, err, errUsage)
+		}
+	}
+	if _, err := captureStdout(t, runStatus, d, "-stale-after", "a day"); err == nil || errors.Is(err, errUsage) {
+		t.Errorf("status -stale-after \"a day\" = %v, want an error", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// maxTagLength is the maximum length of a tag, in bytes
+const maxTagLength = 128
+
+// validateTag checks that the tag is non-empty, is at most `maxTagLength` bytes long, and contains neither control
+// characters nor commas (which separate the tags in the `tags` option).
+func validateTag(tag string) error {
+	if tag == "" {
+		return errors.New("tag cannot be empty")
+	} else if len(tag) > maxTagLength {
+		return fmt.Errorf("tag cannot be longer than %d bytes", maxTagLength)
+	} else if strings.ContainsFunc(tag, unicode.IsControl) {
+		return errors.New("tag cannot contain control characters")
+	} else if strings.ContainsRune(tag, ',') {
+		return errors.New("tag cannot contain commas")
+	}
+	return nil
+}
+
+// parseTags parses the value of the `tags` option: a comma-separated list of tags. Empty items are ignored, duplicates
+// are dropped.
+func parseTags(value string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		if err := validateTag(tag); err != nil {
+			return nil, fmt.Errorf("invalid tag %q: %w", tag, err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// AddTag adds the tag to the volume, unless it already has it.
+func (d *DockerOnTop) AddTag(volumeName string, tag string) error {
+	if err := validateTag(tag); err != nil {
+		return err
+	}
+	return d.updateTags(volumeName, func(tags []string) []string {
+		if slices.Contains(tags, tag) {
+			return tags
+		}
+		return append(tags, tag)
+	})
+}
+
+// RemoveTag removes the tag from the volume. Removing a tag the volume doesn't have is not an error.
+func (d *DockerOnTop) RemoveTag(volumeName string, tag string) error {
+	return d.updateTags(volumeName, func(tags []string) []string {
+		return slices.DeleteFunc(tags, func(t string) bool { return t == tag })
+	})
+}
+
+// updateTags replaces the volume's tags with `update(tags)`. The volume is locked meanwhile, so that concurrent updates
+// of the metadata are not lost.
+func (d *DockerOnTop) updateTags(volumeName string, update func(tags []string) []string) error {
+	if _, err := d.getVolumeInfoOrNotFound(volumeName); err != nil {
+		return err
+	}
+
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
+		return err
+	}
+	defer unlock()
+
+	err = d.updateVolumeInfo(volumeName, func(vol *VolumeInfo) { vol.Tags = update(vol.Tags) })
+	if err != nil {
+		return d.internalError("failed to update the volume's metadata", err, "volume", volumeName)
+	}
+	return nil
+}
+
+// ListByTag returns the names of the volumes that have the given tag, in ascending order. Volumes with unreadable
+// metadata are skipped (with a warning).
+func (d *DockerOnTop) ListByTag(tag string) ([]string, error) {
+	return d.listVolumesWhere(func(vol *VolumeInfo) bool {
+		return slices.Contains(vol.Tags, tag)
+	})
+}
+
+// RemoveByTag removes all the volumes that have the given tag. Unless `force` is set, the volumes that are in use are
+// not removed. An attempt is made to remove every volume; the errors are joined.
+func (d *DockerOnTop) RemoveByTag(tag string, force bool) error {
+	names, err := d.ListByTag(tag)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range names {
+		if !force {
+			if mounted, err := d.volumeIsMounted(name); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			} else if mounted {
+				errs = append(errs, fmt.Errorf("%s: the volume is in use", name))
+				continue
+			}
+		}
+		if err := d.Remove(&volume.RemoveRequest{Name: name}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// mustCreateTaggedVolume creates the volume `name` with the `tags` option, failing the test on error.
+func mustCreateTaggedVolume(t *testing.T, d *DockerOnTop, name string, tags string) {
+	t.Helper()
+	options := map[string]string{"base": t.TempDir(), "tags": tags}
+	if err := d.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+		t.Fatalf("Failed to create volume %s: %v", name, err)
+	}
+}
+
+func TestListByTag(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	for name, tags := range map[string]string{
+		"api":    "service:api,env:prod",
+		"worker": "service:worker, env:prod",
+		"dev":    "service:api,env:dev,service:api",
+		"plain":  "",
+	} {
+		mustCreateTaggedVolume(t, d, name, tags)
+	}
+	if vol, err := d.getVolumeInfo("dev"); err != nil || !slices.Equal(vol.Tags, []string{"service:api", "env:dev"}) {
+		t.Errorf("The tags of dev are %q, %v", vol.Tags, err)
+	}
+
+	// The tags are read back from the metadata by another driver
+	reopened, err := NewDockerOnTop(context.Background(), d.DotRootDirPath(), WithoutOverlayProbe(),
+		WithLogger(newTestLogger(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for tag, want := range map[string][]string{
+		"env:prod":    {"api", "worker"},
+		"service:api": {"api", "dev"},
+		"env:dev":     {"dev"},
+		"env":         nil,
+		"missing":     nil,
+	} {
+		if names, err := reopened.ListByTag(tag); err != nil || !slices.Equal(names, want) {
+			t.Errorf("ListByTag(%s) = %v, %v; want %v", tag, names, err, want)
+		}
+	}
+}
+
+func TestRemoveByTag(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	for _, name := range []string{"first", "second", "mounted", "other"} {
+		tags := "group"
+		if name == "other" {
+			tags = "other-group"
+		}
+		mustCreateTaggedVolume(t, d, name, tags)
+	}
+	MustMountVolume(t, d, "mounted", "container")
+
+	if err := d.RemoveByTag("group", false); err == nil {
+		t.Error("RemoveByTag didn't report the volume in use")
+	}
+	list, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, vol := range list.Volumes {
+		names = append(names, vol.Name)
+	}
+	slices.Sort(names)
+	if want := []string{"mounted", "other"}; !slices.Equal(names, want) {
+		t.Errorf("After RemoveByTag, the volumes are %v, want %v", names, want)
+	}
+}
+
+func TestInvalidTags(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	for _, tags := range []string{"a\tb", "new\nline", strings.Repeat("x", maxTagLength+1)} {
+		options := map[string]string{"base": t.TempDir(), "tags": tags}
+		if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err == nil {
+			t.Errorf("A volume with the tags %q was created", tags)
+		}
+	}
+}
+
+func TestAddAndRemoveTags(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	mustCreateTaggedVolume(t, d, "vol", "initial")
+	for _, tag := range []string{"first", "second", "first"} {
+		if err := d.AddTag("vol", tag); err != nil {
+			t.Fatalf("AddTag(%s) failed: %v", tag, err)
+		}
+	}
+	for _, tag := range []string{"first", "never-added"} {
+		if err := d.RemoveTag("vol", tag); err != nil {
+			t.Fatalf("RemoveTag(%s) failed: %v", tag, err)
+		}
+	}
+	d.invalidateVolumeInfo("vol")
+	if vol, err := d.getVolumeInfo("vol"); err != nil || !slices.Equal(vol.Tags, []string{"initial", "second"}) {
+		t.Errorf("The volume's tags are %q, %v; want [initial second]", vol.Tags, err)
+	}
+
+	for _, tag := range []string{"", "control\x7f", "comma,separated", strings.Repeat("x", maxTagLength+1)} {
+		if err := d.AddTag("vol", tag); err == nil {
+			t.Errorf("The invalid tag %q was added", tag)
+		}
+	}
+	if err := d.AddTag("missing", "tag"); err == nil || !strings.Contains(err.Error(), "no such volume") {
+		t.Errorf("Tagging a nonexistent volume returned %v, want \"no such volume\"", err)
+	}
+}
+
+func TestConcurrentTagUpdates(t *testing.T) {
+	d := NewTestDockerOnTop(t, WithVolumeInfoCacheTTL(0))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	var wg sync.WaitGroup
+	var want []string
+	for i := 0; i < 20; i++ {
+		tag := fmt.Sprintf("tag%02d", i)
+		want = append(want, tag)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.AddTag("vol", tag); err != nil {
+				t.Errorf("AddTag(%s) failed: %v", tag, err)
+			}
+		}()
+	}
+	wg.Wait()
+	// No update is lost
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := slices.Clone(vol.Tags)
+	slices.Sort(tags)
+	if !slices.Equal(tags, want) {
+		t.Errorf("After the concurrent updates, the tags are %q, want %q", tags, want)
+	}
+}
@@ -0,0 +1,205 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+/*
+In-process test harness (built with `-tags dottest`).
+
+It runs the driver against a temporary dot root directory without the docker daemon, root privileges, or overlayfs:
+the mount syscalls are replaced with `MockSyscallMount`, which only records what is mounted where, so the volumes'
+mountpoints stay empty directories. Everything else (the volumes' directory trees, metadata, locks, active mounts) is
+real, e.g.:
+
+	func TestLifecycle(t *testing.T) {
+		d := NewTestDockerOnTop(t)
+		MustCreateVolume(t, d, "vol", t.TempDir())
+		MustMountVolume(t, d, "vol", "container")
+		...
+	}
+
+The tests of what the containers see in the volumes need real overlays: `NewTestDockerOnTopWithOverlay` creates a
+driver that mounts them, and skips the test where this is not possible.
+*/
+
+// MockSyscallMount replaces `syscall.Mount` and `syscall.Unmount` for a driver created by `NewTestDockerOnTop` (see
+// `DockerOnTop.mountSyscall` and `DockerOnTop.unmountSyscall`). Like the kernel, it refuses to mount twice at the same
+// target (`EBUSY`), to mount at a missing directory (`ENOENT`), and to unmount what is not mounted (`EINVAL`). It is
+// safe for concurrent use.
+type MockSyscallMount struct {
+	mutex sync.Mutex
+	// mounts maps the targets of the mounts to their options
+	mounts map[string]string
+}
+
+// NewMockSyscallMount returns a `MockSyscallMount` with nothing mounted.
+func NewMockSyscallMount() *MockSyscallMount {
+	return &MockSyscallMount{mounts: map[string]string{}}
+}
+
+// Mount has the signature of `syscall.Mount`.
+func (m *MockSyscallMount) Mount(source string, target string, fstype string, flags uintptr, data string) error {
+	if info, err := os.Stat(target); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	target = strings.TrimSuffix(target, "/")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.mounts[target]; ok {
+		return syscall.EBUSY
+	}
+	m.mounts[target] = data
+	return nil
+}
+
+// Unmount has the signature of `syscall.Unmount`.
+func (m *MockSyscallMount) Unmount(target string, flags int) error {
+	target = strings.TrimSuffix(target, "/")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.mounts[target]; !ok {
+		return syscall.EINVAL
+	}
+	delete(m.mounts, target)
+	return nil
+}
+
+// Mounted returns the options `target` is mounted with, if it is mounted.
+func (m *MockSyscallMount) Mounted(target string) (data string, ok bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	data, ok = m.mounts[strings.TrimSuffix(target, "/")]
+	return data, ok
+}
+
+// WithMockSyscallMount makes the driver "mount" with `m` instead of the mount syscalls.
+func WithMockSyscallMount(m *MockSyscallMount) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		d.mountSyscall = m.Mount
+		d.unmountSyscall = m.Unmount
+	}
+}
+
+// NewTestDockerOnTop returns a driver with a fresh `MockSyscallMount` and a dot root directory in `t.TempDir()`, which
+// logs to the test's log. The overlay probe is skipped. `opts` are applied afterwards, e.g. `WithMockSyscallMount` to
+// inspect the mounts. The driver is closed when the test finishes.
+func NewTestDockerOnTop(t *testing.T, opts ...DockerOnTopOption) *DockerOnTop {
+	t.Helper()
+	opts = append([]DockerOnTopOption{
+		WithoutOverlayProbe(),
+		WithLogger(newTestLogger(t)),
+		WithMockSyscallMount(NewMockSyscallMount()),
+	}, opts...)
+	d, err := NewDockerOnTop(context.Background(), t.TempDir(), opts...)
+	if err != nil {
+		t.Fatalf("Failed to create the driver: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := d.Close(); err != nil {
+			t.Errorf("Failed to close the driver: %v", err)
+		}
+	})
+	return d
+}
+
+// NewTestDockerOnTopWithOverlay is like `NewTestDockerOnTop`, but the driver uses the real mount syscalls, so the
+// volumes' overlays are really mounted. The test is skipped unless it runs as root on a host that supports overlayfs
+// (judging by the driver's overlay probe). The mounts left in the dot root directory are lazily unmounted when the test
+// finishes.
+func NewTestDockerOnTopWithOverlay(t *testing.T, opts ...DockerOnTopOption) *DockerOnTop {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("Mounting overlays requires root privileges")
+	}
+	dotRootDir := t.TempDir()
+	opts = append([]DockerOnTopOption{WithLogger(newTestLogger(t))}, opts...)
+	d, err := NewDockerOnTop(context.Background(), dotRootDir, opts...)
+	var notSupported *OverlayNotSupportedError
+	if errors.As(err, &notSupported) {
+		t.Skipf("Overlays cannot be mounted: %v", err)
+	} else if err != nil {
+		t.Fatalf("Failed to create the driver: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := d.Close(); err != nil {
+			t.Errorf("Failed to close the driver: %v", err)
+		}
+		unmountAllUnder(t, dotRootDir)
+	})
+	return d
+}
+
+// unmountAllUnder lazily unmounts everything mounted inside `dir` (deepest first), so that it can be removed.
+func unmountAllUnder(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := readMountInfo(procSelfMountInfo)
+	if err != nil {
+		t.Errorf("Failed to read the mount table: %v", err)
+		return
+	}
+	dir = filepath.Clean(dir)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if mountPoint := entries[i].MountPoint; isPathUnder(mountPoint, dir) {
+			if err := syscall.Unmount(mountPoint, syscall.MNT_DETACH); err != nil {
+				t.Errorf("Failed to unmount %s: %v", mountPoint, err)
+			}
+		}
+	}
+}
+
+// MustCreateVolume creates the volume `name` with the base directory `base`, failing the test on error.
+func MustCreateVolume(t *testing.T, d *DockerOnTop, name string, base string) {
+	t.Helper()
+	if err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"base": base}}); err != nil {
+		t.Fatalf("Failed to create volume %s: %v", name, err)
+	}
+}
+
+// MustMountVolume mounts the volume `name` for the container `id`, failing the test on error. The mountpoint is
+// returned.
+func MustMountVolume(t *testing.T, d *DockerOnTop, name string, id string) string {
+	t.Helper()
+	response, err := d.Mount(&volume.MountRequest{Name: name, ID: id})
+	if err != nil {
+		t.Fatalf("Failed to mount volume %s for %s: %v", name, id, err)
+	}
+	return response.Mountpoint
+}
+
+// MustUnmountVolume unmounts the volume `name` for the container `id`, failing the test on error.
+func MustUnmountVolume(t *testing.T, d *DockerOnTop, name string, id string) {
+	t.Helper()
+	if err := d.Unmount(&volume.UnmountRequest{Name: name, ID: id}); err != nil {
+		t.Fatalf("Failed to unmount volume %s for %s: %v", name, id, err)
+	}
+}
+
+// newTestLogger returns a logger that writes everything to the test's log.
+func newTestLogger(t *testing.T) *slog.Logger {
+	return slog.New(slog.NewTextHandler(testLogWriter{t}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// testLogWriter passes the driver's log messages to the test's log
+type testLogWriter struct {
+	t *testing.T
+}
+
+func (w testLogWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+/*
+In-memory upper layers (the `tmpfs-size` option).
+
+The changes made to a volatile volume created with `tmpfs-size` are kept in memory: before the overlay is mounted, a
+tmpfs of the given size is mounted at tmpfs/ in the volume's main directory, and the volume's upperdir and workdir are
+tmpfs/upper/ and tmpfs/work/ (overlayfs requires both to be on the same mount, so the tmpfs cannot be mounted at the
+upperdir itself). After the overlay is unmounted, so is the tmpfs (not before: the overlay keeps it busy), discarding
+the changes, as volatile volumes do anyway.
+
+While the volume is not mounted, tmpfs/upper/ is an empty directory on the dot root directory's filesystem (created
+with the volume), so that the operations on the upperdir (like `Reset` or `Get`'s usage reporting) keep working.
+*/
+
+// tmpfsdir returns the directory the volume's in-memory upper layer is mounted at (see the comment in the beginning of
+// the file).
+func (d *DockerOnTop) tmpfsdir(volumeName string) string {
+	return d.volumeDir(volumeName) + "/tmpfs/"
+}
+
+// tmpfsSource returns the source the volume's in-memory upper layer is mounted with. It differs from `overlaySource`,
+// so that the tmpfs is never taken for the overlay.
+func tmpfsSource(volumeName string) string {
+	return "docker-on-top-tmpfs_" + volumeName
+}
+
+// mountUpperTmpfs mounts a tmpfs of `vol.TmpfsSizeBytes` bytes at the volume's `tmpfsdir` and creates the upperdir in
+// it (`vol` is the volume's metadata). A stale
+// tmpfs left mounted there (e.g. if the overlay was stuck) is unmounted first. The caller is expected to hold the
+// volume's lock.
+func (d *DockerOnTop) mountUpperTmpfs(volumeName string, vol *VolumeInfo) error {
+	if err := d.unmountUpperTmpfs(volumeName); err != nil {
+		return fmt.Errorf("failed to unmount the stale tmpfs: %w", err)
+	}
+	if err := os.MkdirAll(d.tmpfsdir(volumeName), os.ModePerm); err != nil {
+		return err
+	}
+	data := fmt.Sprintf("size=%d,mode=0755", vol.TmpfsSizeBytes)
+	if err := d.mountSyscall(tmpfsSource(volumeName), d.tmpfsdir(volumeName), "tmpfs", 0, data); err != nil {
+		return err
+	}
+	// Normally, the upperdir doesn't exist on the fresh tmpfs, but `MkdirAll` lets the mount syscall be mocked
+	if err := os.MkdirAll(d.upperdir(volumeName, vol), os.ModePerm); err != nil {
+		_ = d.unmountUpperTmpfs(volumeName)
+		return err
+	}
+	d.logger.Debug("Mounted the in-memory upper layer", "volume", volumeName, "path", d.tmpfsdir(volumeName),
+		"size", vol.TmpfsSizeBytes)
+	return nil
+}
+
+// unmountUpperTmpfs unmounts the volume's in-memory upper layer, if it is mounted, discarding its contents. The
+// overlay must be unmounted first.
+func (d *DockerOnTop) unmountUpperTmpfs(volumeName string) error {
+	err := d.unmountSyscall(d.tmpfsdir(volumeName), 0)
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOENT) {
+		// Not mounted (or not an in-memory volume at all)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	d.logger.Debug("Unmounted the in-memory upper layer", "volume", volumeName)
+	return nil
+}
+
+// createTmpfsUpperPlaceholder creates the empty upperdir the volume with an in-memory upper layer has while it is not
+// mounted (see the comment in the beginning of the file).
+func (d *DockerOnTop) createTmpfsUpperPlaceholder(volumeName string) error {
+	return os.MkdirAll(d.tmpfsdir(volumeName)+"upper", os.ModePerm)
+}
@@ -0,0 +1,253 @@
+//go:build dottest
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// recordingSyscallMount is a `MockSyscallMount` that records the mount and unmount calls as "mount FSTYPE TARGET" and
+// "unmount TARGET", and fails the mounts of the type `failFstype`.
+type recordingSyscallMount struct {
+	*MockSyscallMount
+	mutex      sync.Mutex
+	calls      []string
+	failFstype string
+}
+
+func (m *recordingSyscallMount) option(d *DockerOnTop) {
+	d.mountSyscall = func(source, target, fstype string, flags uintptr, data string) error {
+		m.mutex.Lock()
+		m.calls = append(m.calls, "mount "+fstype+" "+strings.TrimSuffix(target, "/"))
+		m.mutex.Unlock()
+		if fstype == m.failFstype {
+			return syscall.EINVAL
+		}
+		return m.Mount(source, target, fstype, flags, data)
+	}
+	d.unmountSyscall = func(target string, flags int) error {
+		err := m.Unmount(target, flags)
+		if err == nil {
+			m.mutex.Lock()
+			m.calls = append(m.calls, "unmount "+strings.TrimSuffix(target, "/"))
+			m.mutex.Unlock()
+		}
+		return err
+	}
+}
+
+// takeCalls returns the calls recorded since the last time.
+func (m *recordingSyscallMount) takeCalls() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	calls := m.calls
+	m.calls = nil
+	return calls
+}
+
+func TestTmpfsUpper(t *testing.T) {
+	m := &recordingSyscallMount{MockSyscallMount: NewMockSyscallMount()}
+	d := NewTestDockerOnTop(t, m.option)
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":       t.TempDir(),
+		"volatile":   "true",
+		"tmpfs-size": "256m",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil || vol.TmpfsSizeBytes != 256<<20 {
+		t.Errorf("TmpfsSizeBytes = %d, %v; want %d", vol.TmpfsSizeBytes, err, 256<<20)
+	}
+	response, err := d.Get(&volume.GetRequest{Name: "vol"})
+	if err != nil || response.Volume.Status["tmpfsSizeBytes"] != int64(256<<20) {
+		t.Errorf("The volume's status is %v, %v; want tmpfsSizeBytes %d", response.Volume.Status, err, 256<<20)
+	}
+	tmpfsdir := strings.TrimSuffix(d.tmpfsdir("vol"), "/")
+	if upperdir := d.upperdir("vol", &vol); upperdir != tmpfsdir+"/upper/" {
+		t.Errorf("The upperdir is %s, want %s", upperdir, tmpfsdir+"/upper/")
+	}
+	// The placeholder upperdir of the unmounted volume
+	if entries, err := os.ReadDir(d.upperdir("vol", &vol)); err != nil || len(entries) != 0 {
+		t.Errorf("Before the mount, the upperdir has %v, %v; want an empty directory", entries, err)
+	}
+
+	mountpoint := strings.TrimSuffix(MustMountVolume(t, d, "vol", "container"), "/")
+	want := []string{"mount tmpfs " + tmpfsdir, "mount overlay " + mountpoint}
+	if calls := m.takeCalls(); !slices.Equal(calls, want) {
+		t.Errorf("Mount made the calls %q, want %q", calls, want)
+	}
+	if data, ok := m.Mounted(tmpfsdir); !ok || data != "size=268435456,mode=0755" {
+		t.Errorf("The tmpfs is mounted with %q (%v), want %q", data, ok, "size=268435456,mode=0755")
+	}
+	options, _ := m.Mounted(mountpoint)
+	for _, want := range []string{"upperdir=" + tmpfsdir + "/upper/", "workdir=" + tmpfsdir + "/work/"} {
+		if !containsOption(options, want) {
+			t.Errorf("The overlay is mounted with %q, want %q among the options", options, want)
+		}
+	}
+
+	// The second container reuses both
+	MustMountVolume(t, d, "vol", "another")
+	MustUnmountVolume(t, d, "vol", "another")
+	if calls := m.takeCalls(); len(calls) != 0 {
+		t.Errorf("The second container made the calls %q, want none", calls)
+	}
+
+	MustUnmountVolume(t, d, "vol", "container")
+	// The tmpfs is kept busy by the overlay
+	want = []string{"unmount " + mountpoint, "unmount " + tmpfsdir}
+	if calls := m.takeCalls(); !slices.Equal(calls, want) {
+		t.Errorf("Unmount made the calls %q, want %q", calls, want)
+	}
+	if _, ok := m.Mounted(tmpfsdir); ok {
+		t.Error("The tmpfs is still mounted")
+	}
+
+	// If the overlay fails to mount, the tmpfs isn't left mounted
+	m.failFstype = "overlay"
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"}); err == nil {
+		t.Fatal("Mount succeeded")
+	}
+	if _, ok := m.Mounted(tmpfsdir); ok {
+		t.Error("After the overlay failed to mount, the tmpfs is still mounted")
+	}
+	m.failFstype = "tmpfs"
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "container"}); err == nil {
+		t.Fatal("Mount succeeded")
+	}
+	if _, ok := m.Mounted(mountpoint); ok {
+		t.Error("The overlay was mounted without the tmpfs")
+	}
+}
+
+func TestTmpfsUpperOnBoot(t *testing.T) {
+	m := NewMockSyscallMount()
+	d := NewTestDockerOnTop(t, WithMockSyscallMount(m))
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":       t.TempDir(),
+		"volatile":   "true",
+		"tmpfs-size": "1M",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	MustMountVolume(t, d, "vol", "container")
+	// The plugin crashed, and the overlay is gone (the mock mounts aren't in the mount table anyway)
+	if err := m.Unmount(d.mountpointdir("vol"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if stillMounted, err := d.bootResetVolume("vol", false); err != nil || stillMounted {
+		t.Errorf("bootResetVolume = %v, %v; want false", stillMounted, err)
+	}
+	if _, ok := m.Mounted(d.tmpfsdir("vol")); ok {
+		t.Error("The stale tmpfs was not unmounted on boot")
+	}
+}
+
+func TestTmpfsUpperValidation(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	storage := t.TempDir()
+	for name, options := range map[string]map[string]string{
+		"invalid size":     {"volatile": "true", "tmpfs-size": "lots"},
+		"zero size":        {"volatile": "true", "tmpfs-size": "0"},
+		"not volatile":     {"tmpfs-size": "1M"},
+		"volatile false":   {"volatile": "false", "tmpfs-size": "1M"},
+		"custom upperdir":  {"volatile": "true", "tmpfs-size": "1M", "upper": storage + "/u", "work": storage + "/w"},
+		"with write-iops":  {"volatile": "true", "tmpfs-size": "1M", "write-iops": "100"},
+		"with a quota":     {"volatile": "true", "tmpfs-size": "1M", "quota": "1M"},
+		"negative size":    {"volatile": "true", "tmpfs-size": "-1M"},
+		"fractional size":  {"volatile": "true", "tmpfs-size": "0.5G"},
+		"read-only volume": {"readonly": "true", "tmpfs-size": "1M"},
+	} {
+		options["base"] = t.TempDir()
+		if err := d.Create(&volume.CreateRequest{Name: "vol", Options: options}); err == nil {
+			t.Errorf("%s: the volume was created", name)
+			if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestTmpfsUpperWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"base-file": "base"})
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{
+		"base":       base,
+		"volatile":   "true",
+		"tmpfs-size": "1M",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfsdir := strings.TrimSuffix(d.tmpfsdir("vol"), "/")
+
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	contents, err := os.ReadFile(procMounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tmpfsLine, overlayLine string
+	for _, line := range strings.Split(string(contents), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 4 && fields[1] == tmpfsdir {
+			tmpfsLine = line
+		} else if len(fields) >= 4 && fields[1] == strings.TrimSuffix(mountpoint, "/") {
+			overlayLine = line
+		}
+	}
+	if fields := strings.Fields(tmpfsLine); len(fields) < 4 || fields[0] != tmpfsSource("vol") ||
+		fields[2] != "tmpfs" || !containsOption(fields[3], "size=1024k") {
+		t.Errorf("The tmpfs in /proc/mounts is %q, want a tmpfs of 1M at %s", tmpfsLine, tmpfsdir)
+	}
+	if fields := strings.Fields(overlayLine); len(fields) < 4 || fields[2] != "overlay" ||
+		!containsOption(fields[3], "upperdir="+tmpfsdir+"/upper/") {
+		t.Errorf("The overlay in /proc/mounts is %q, want the upperdir in the tmpfs", overlayLine)
+	}
+
+	// The changes are in memory, limited by the size of the tmpfs
+	writeFiles(t, mountpoint, map[string]string{"added": "added"})
+	if contents, err := os.ReadFile(d.upperdir("vol", &vol) + "added"); err != nil || string(contents) != "added" {
+		t.Errorf("In the tmpfs, the added file = %q, %v; want %q", contents, err, "added")
+	}
+	err = os.WriteFile(mountpoint+"large", bytes.Repeat([]byte("x"), 2<<20), 0o644)
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("Writing more than the tmpfs size = %v, want %v", err, syscall.ENOSPC)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+
+	if mounted, err := d.checkMountInFile(procMounts, "vol"); err != nil || mounted {
+		t.Errorf("After Unmount, the overlay is mounted: %v, %v", mounted, err)
+	}
+	if contents, err := os.ReadFile(procMounts); err != nil || strings.Contains(string(contents), tmpfsSource("vol")) {
+		t.Errorf("After Unmount, the tmpfs is still mounted (%v)", err)
+	}
+	if entries, err := os.ReadDir(d.upperdir("vol", &vol)); err != nil || len(entries) != 0 {
+		t.Errorf("After Unmount, the upperdir has %v, %v; want an empty directory", entries, err)
+	}
+
+	mountpoint = MustMountVolume(t, d, "vol", "container")
+	if _, err := os.Stat(mountpoint + "added"); !os.IsNotExist(err) {
+		t.Errorf("After the remount, the in-memory changes are still there (%v)", err)
+	}
+	if contents, err := os.ReadFile(mountpoint + "base-file"); err != nil || string(contents) != "base" {
+		t.Errorf("base-file = %q, %v; want %q", contents, err, "base")
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
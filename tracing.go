@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation name of the driver's tracer
+const tracerName = "github.com/andergnet/docker-on-top"
+
+// Attributes of the driver's spans
+const (
+	attrVolumeName = attribute.Key("volume.name")
+	attrMountID    = attribute.Key("mount.id")
+	attrBaseDir    = attribute.Key("base.dir")
+)
+
+// newNoopTracer returns the tracer used when no tracer provider is given with `WithTracer`.
+func newNoopTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+// startSpan starts a span for the driver operation `name`. The span must be ended with `endSpan`.
+func (d *DockerOnTop) startSpan(name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := d.tracer.Start(context.Background(), name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// endSpan ends the span, marking it as failed if `err` is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// setBaseDirAttr adds the volume's base directory to the span. As it requires reading the volume's metadata, it's
+// only done if the span is recorded.
+func (d *DockerOnTop) setBaseDirAttr(span trace.Span, volumeName string) {
+	if !span.IsRecording() {
+		return
+	}
+	if thisVol, err := d.getVolumeInfo(volumeName); err == nil {
+		span.SetAttributes(attrBaseDir.String(thisVol.BaseDirPath))
+	}
+}
+
+// newTracerProviderFromEnv creates a tracer provider exporting the spans with OTLP over HTTP, configured with the
+// standard `OTEL_EXPORTER_OTLP_*` environment variables. If `OTEL_EXPORTER_OTLP_ENDPOINT` is not set, nil is returned
+// (tracing is disabled).
+func newTracerProviderFromEnv() (*sdktrace.TracerProvider, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return nil, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter)), nil
+}
@@ -0,0 +1,63 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	d := NewTestDockerOnTop(t, WithTracer(provider))
+	base := t.TempDir()
+	MustCreateVolume(t, d, "vol", base)
+	MustMountVolume(t, d, "vol", "container")
+	if _, err := d.Mount(&volume.MountRequest{Name: "missing", ID: "container"}); err == nil {
+		t.Fatal("Mounting a nonexistent volume succeeded")
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+		if span.Parent.IsValid() {
+			t.Errorf("The %s span has a parent", span.Name)
+		}
+		if span.EndTime.IsZero() {
+			t.Errorf("The %s span is not ended", span.Name)
+		}
+	}
+	if want := []string{"Create", "Mount", "Mount", "Unmount"}; !slices.Equal(names, want) {
+		t.Fatalf("The exported spans are %v, want %v", names, want)
+	}
+
+	mount := spans[1]
+	for _, want := range []attribute.KeyValue{
+		attrVolumeName.String("vol"),
+		attrMountID.String("container"),
+		attrBaseDir.String(base),
+	} {
+		if !slices.Contains(mount.Attributes, want) {
+			t.Errorf("The Mount span's attributes %v don't contain %v", mount.Attributes, want)
+		}
+	}
+	if mount.Status.Code == codes.Error {
+		t.Errorf("The successful Mount span has the status %v", mount.Status)
+	}
+	if failed := spans[2]; failed.Status.Code != codes.Error || len(failed.Events) == 0 {
+		t.Errorf("The failed Mount span has the status %v and the events %v", failed.Status, failed.Events)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileFunc copies the regular file `src` to the new file `dst` for `copyTree`. It returns true if `dst` shares
+// the inode with `src` (like a hard link does), so that there's no metadata to copy.
+type copyFileFunc func(src, dst string) (shared bool, err error)
+
+// copyTree copies the directory tree at `src` to `dst`, which is created if needed (and must be empty if it exists).
+// The modes, ownership, timestamps, and extended attributes of the files are preserved, as are symlinks, device files
+// (like overlay whiteouts), and named pipes; the files of other types are skipped with a warning logged with `logger`.
+// The regular files are copied with `copyFile`.
+//
+// If `skip` is not nil, it is called with the path of every entry (and the path relative to `src`); the entries it
+// returns true for (with their contents, for directories) are not copied.
+func copyTree(src, dst string, copyFile copyFileFunc, skip func(path string, rel string) bool,
+	logger *slog.Logger) error {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	type dirTimes struct {
+		path string
+		info fs.FileInfo
+	}
+	var dirs []dirTimes
+
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && skip != nil && skip(path, rel) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if rel != "." {
+				if err := os.Mkdir(target, 0o700); err != nil {
+					return err
+				}
+			}
+			dirs = append(dirs, dirTimes{path: target, info: info})
+		case mode.IsRegular():
+			if shared, err := copyFile(path, target); err != nil {
+				return err
+			} else if shared {
+				return nil
+			}
+		case mode&fs.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(linkTarget, target); err != nil {
+				return err
+			}
+			return os.Lchown(target, int(stat.Uid), int(stat.Gid))
+		case mode&(fs.ModeDevice|fs.ModeNamedPipe) != 0:
+			// Whiteouts are character devices
+			if err := syscall.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+				return err
+			}
+		default:
+			logger.Warn("Skipping file of unsupported type", "path", path, "mode", mode)
+			return nil
+		}
+
+		if err := copyFileMetadata(path, target, info); err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return os.Chtimes(target, statAtime(stat), info.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Directory timestamps are restored last, as creating their contents modifies them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		stat := dirs[i].info.Sys().(*syscall.Stat_t)
+		if err := os.Chtimes(dirs[i].path, statAtime(stat), dirs[i].info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileMetadata applies the ownership, permissions, and extended attributes of `src` (described by `info`) to
+// `dst`.
+func copyFileMetadata(src, dst string, info fs.FileInfo) error {
+	stat := info.Sys().(*syscall.Stat_t)
+	if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil {
+		return err
+	}
+	if err := syscall.Chmod(dst, stat.Mode&0o7777); err != nil {
+		return err
+	}
+	xattrs, err := listXattrs(src)
+	if err != nil && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+	for name, value := range xattrs {
+		if err := syscall.Setxattr(dst, name, []byte(value), 0); err != nil {
+			return fmt.Errorf("failed to copy xattr %s of %s: %w", name, src, err)
+		}
+	}
+	return nil
+}
+
+// statAtime returns the access time from a file's `Stat_t`.
+func statAtime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(stat.Atim.Unix())
+}
+
+// cloneFile is a `copyFileFunc` that makes `dst` a reflink of `src` (sharing the data blocks until either is
+// modified) if the filesystem supports it, and copies the data otherwise. Unlike a hard link, the copy is never
+// affected by later modifications of the source.
+func cloneFile(src, dst string) (bool, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return false, err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		_, err = io.Copy(out, in)
+		if err == nil {
+			err = out.Sync()
+		}
+		if err != nil {
+			return false, errors.Join(err, out.Close())
+		}
+	}
+	return false, out.Close()
+}
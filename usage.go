@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// defaultUsageTimeout is the default limit on the time spent computing the disk usage of a volume for `Get` and `List`
+const defaultUsageTimeout = 5 * time.Second
+
+// upperdirUsage returns the total size of the files in the upperdir of the volume with the metadata `vol` (that is, of
+// the changes made to the volume). The walk stops when `ctx` is done, in which case the context's error is returned.
+func (d *DockerOnTop) upperdirUsage(ctx context.Context, volumeName string, vol *VolumeInfo) (int64, error) {
+	var total int64
+	// The upperdir is a sibling of the workdir, so the latter is not walked
+	err := filepath.WalkDir(d.upperdir(volumeName, vol), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,58 @@
+//go:build dottest
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestUsageReportingWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"big-base-file": strings.Repeat("x", 1<<20)})
+	MustCreateVolume(t, d, "vol", base)
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+	const size = 10000
+	writeFiles(t, mountpoint, map[string]string{"dir/file": strings.Repeat("x", size)})
+
+	response, err := d.Get(&volume.GetRequest{Name: "vol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The base directory's files are not counted, neither is the workdir
+	if usage, ok := response.Volume.Status["upperdirBytes"].(int64); !ok || usage < size || usage > size+4096 {
+		t.Errorf("Get reports upperdirBytes = %v, want about %d", response.Volume.Status["upperdirBytes"], size)
+	}
+	list, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Volumes) != 1 || list.Volumes[0].Status["upperdirBytes"] != response.Volume.Status["upperdirBytes"] {
+		t.Errorf("List reports %v, want the same usage as Get", list.Volumes)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+}
+
+func TestUsageReportingDisabled(t *testing.T) {
+	for name, opts := range map[string][]DockerOnTopOption{
+		"disabled":  {func(d *DockerOnTop) { d.DisableUsageReporting = true }},
+		"timed out": {WithUsageTimeout(time.Nanosecond)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t, opts...)
+			MustCreateVolume(t, d, "vol", t.TempDir())
+			writeFiles(t, d.VolumeUpperDir("vol"), map[string]string{"file": "changed"})
+			response, err := d.Get(&volume.GetRequest{Name: "vol"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage, ok := response.Volume.Status["upperdirBytes"]; ok {
+				t.Errorf("Get reports upperdirBytes = %v", usage)
+			}
+		})
+	}
+}
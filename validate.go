@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ValidationReport is the result of `DockerOnTop.ValidateVolume`
+type ValidationReport struct {
+	// Healthy is set if no errors were found (there may be warnings, though)
+	Healthy bool
+	// Warnings describe the inconsistencies that don't prevent the volume from being used
+	Warnings []string
+	// Errors describe the problems that make the volume unusable
+	Errors []string
+}
+
+func (r *ValidationReport) warnf(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (r *ValidationReport) errorf(format string, args ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// ValidateVolume checks the consistency of the volume without mounting it, which is useful after an unexpected reboot.
+// It checks that the volume's metadata can be read, that its base directory and lower layers are accessible, that
+// its directory tree is complete, and that the active mounts are consistent with the overlay being mounted.
+//
+// Nothing is modified. The volume is locked (see `lockVolume`) during the check, so the volume's state doesn't change in
+// the meantime. An error is only returned if the volume doesn't exist or the check cannot be
+// performed.
+func (d *DockerOnTop) ValidateVolume(volumeName string) (ValidationReport, error) {
+	var report ValidationReport
+
+	if info, err := os.Stat(d.volumeDir(volumeName)); os.IsNotExist(err) {
+		return report, errors.New("no such volume")
+	} else if err != nil {
+		return report, err
+	} else if !info.IsDir() {
+		report.errorf("the volume's main directory is not a directory")
+		return report, nil
+	}
+
+	unlock, err := d.locks.Lock(volumeName)
+	if err != nil {
+		report.errorf("failed to lock the volume: %v", err)
+		return report, nil
+	}
+	defer unlock()
+	activemounts, err := d.readActivemounts(volumeName, -1)
+	if err != nil {
+		report.errorf("the activemounts directory is inaccessible: %v", err)
+		return report, nil
+	}
+
+	thisVol, metadataErr := d.getVolumeInfo(volumeName)
+	if metadataErr != nil {
+		report.errorf("failed to read the volume's metadata: %v", metadataErr)
+	} else {
+		for _, dir := range thisVol.lowerDirs() {
+			if info, err := os.Stat(dir); err != nil {
+				report.errorf("lower directory %s is inaccessible: %v", dir, err)
+			} else if !info.IsDir() {
+				report.errorf("lower directory %s is not a directory", dir)
+			}
+		}
+		if thisVol.Stuck {
+			report.warnf("the volume is marked as stuck: its overlay was not cleaned up on the last unmount")
+		}
+		if info, err := os.Stat(d.upperdir(volumeName, &thisVol)); err != nil {
+			report.errorf("the upperdir is inaccessible: %v", err)
+		} else if !info.IsDir() {
+			report.errorf("the upperdir is not a directory")
+		}
+	}
+
+	mounted, err := d.isOverlayMounted(volumeName)
+	if err != nil {
+		return report, err
+	}
+	switch {
+	case len(activemounts) > 0 && !mounted:
+		report.warnf("%d stale active mount(s): the overlay is not mounted", len(activemounts))
+	case len(activemounts) == 0 && mounted:
+		report.warnf("the overlay is mounted but no active mounts are recorded")
+	}
+	// The workdir and the mountpoint only exist while the volume is mounted. Where the workdir is depends on the
+	// metadata, which may be unreadable
+	dirs := []string{d.mountpointdir(volumeName)}
+	if metadataErr == nil {
+		dirs = append(dirs, d.workdir(volumeName, &thisVol))
+	}
+	for _, dir := range dirs {
+		_, err := os.Stat(dir)
+		if mounted && os.IsNotExist(err) {
+			report.errorf("%s is missing although the overlay is mounted", dir)
+		} else if !mounted && err == nil {
+			report.warnf("%s is left over from a previous mount", dir)
+		} else if err != nil && !os.IsNotExist(err) {
+			report.errorf("%s is inaccessible: %v", dir, err)
+		}
+	}
+
+	report.Healthy = len(report.Errors) == 0
+	return report, nil
+}
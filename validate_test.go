@@ -0,0 +1,138 @@
+//go:build dottest
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateVolume(t *testing.T) {
+	tests := []struct {
+		name string
+		// breakVolume makes the volume `vol`, created with the base directory `base`, inconsistent
+		breakVolume func(t *testing.T, d *DockerOnTop, base string)
+		wantHealthy bool
+		// wantMessage is expected among the errors if the volume is unhealthy, and among the warnings otherwise
+		wantMessage string
+	}{
+		{
+			name:        "consistent",
+			breakVolume: func(t *testing.T, d *DockerOnTop, base string) {},
+			wantHealthy: true,
+		},
+		{
+			name: "broken metadata",
+			breakVolume: func(t *testing.T, d *DockerOnTop, base string) {
+				writeFiles(t, d.volumeDir("vol"), map[string]string{"metadata.json": "{"})
+			},
+			wantMessage: "failed to read the volume's metadata",
+		},
+		{
+			name: "missing base directory",
+			breakVolume: func(t *testing.T, d *DockerOnTop, base string) {
+				if err := os.Remove(base); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMessage: "lower directory",
+		},
+		{
+			name: "missing upperdir",
+			breakVolume: func(t *testing.T, d *DockerOnTop, base string) {
+				if err := os.Remove(d.VolumeUpperDir("vol")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMessage: "the upperdir is inaccessible",
+		},
+		{
+			name: "missing activemounts",
+			breakVolume: func(t *testing.T, d *DockerOnTop, base string) {
+				if err := os.Remove(d.activemountsdir("vol")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMessage: "activemounts/",
+		},
+		{
+			name: "stale active mount",
+			breakVolume: func(t *testing.T, d *DockerOnTop, base string) {
+				if err := createActivemountFile(d.activemountsdir("vol")+"container", activemountInfo{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantHealthy: true,
+			wantMessage: "1 stale active mount(s)",
+		},
+		{
+			name: "leftover mountpoint",
+			breakVolume: func(t *testing.T, d *DockerOnTop, base string) {
+				if err := os.Mkdir(d.VolumeMountpointDir("vol"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantHealthy: true,
+			wantMessage: "is left over from a previous mount",
+		},
+		{
+			name: "stuck",
+			breakVolume: func(t *testing.T, d *DockerOnTop, base string) {
+				if err := d.updateVolumeInfo("vol", func(vol *VolumeInfo) { vol.Stuck = true }); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantHealthy: true,
+			wantMessage: "the volume is marked as stuck",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewTestDockerOnTop(t, WithVolumeInfoCacheTTL(0))
+			base := t.TempDir()
+			MustCreateVolume(t, d, "vol", base)
+			test.breakVolume(t, d, base)
+			d.invalidateVolumeInfo("vol")
+			before := describeTree(t, d.dotRootDir)
+
+			report, err := d.ValidateVolume("vol")
+			if err != nil {
+				t.Fatalf("ValidateVolume failed: %v", err)
+			}
+			if report.Healthy != test.wantHealthy {
+				t.Errorf("Healthy = %v, want %v (the report is %+v)", report.Healthy, test.wantHealthy, report)
+			}
+			messages := report.Warnings
+			if !test.wantHealthy {
+				messages = report.Errors
+			}
+			if test.wantMessage == "" && len(report.Warnings)+len(report.Errors) > 0 {
+				t.Errorf("The consistent volume has the report %+v", report)
+			} else if test.wantMessage != "" && !containsMessage(messages, test.wantMessage) {
+				t.Errorf("The report %+v doesn't mention %q", report, test.wantMessage)
+			}
+			if after := describeTree(t, d.dotRootDir); !reflect.DeepEqual(before, after) {
+				t.Errorf("ValidateVolume modified the dot root directory: %v -> %v", before, after)
+			}
+		})
+	}
+}
+
+func TestValidateNonexistentVolume(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	if _, err := d.ValidateVolume("missing"); err == nil || !strings.Contains(err.Error(), "no such volume") {
+		t.Errorf("ValidateVolume returned %v, want \"no such volume\"", err)
+	}
+}
+
+// containsMessage reports whether any of the messages contains `substring`.
+func containsMessage(messages []string, substring string) bool {
+	for _, message := range messages {
+		if strings.Contains(message, substring) {
+			return true
+		}
+	}
+	return false
+}
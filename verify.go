@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// VerifyResult is the result of verifying a volume with `DockerOnTop.VerifyAll`
+type VerifyResult struct {
+	// Name is the name of the volume
+	Name string `json:"name"`
+	// Healthy is set if the volume passed both the validation and the probe mount
+	Healthy bool `json:"healthy"`
+	// Error tells why the volume is unhealthy (empty if it is healthy)
+	Error string `json:"error,omitempty"`
+}
+
+// VerifyAll checks that every volume can be mounted, e.g. before a maintenance window. `concurrency` volumes are
+// verified in parallel. Each volume is validated with `ValidateVolume`, and a healthy one is then mounted with its
+// options at a scratch directory (see `probeVolumeMount`) and unmounted right away. The volumes whose overlays are
+// mounted already are not probed.
+//
+// The results are in ascending order of the volumes' names. An error is only returned if the volumes cannot be listed.
+func (d *DockerOnTop) VerifyAll(concurrency int) ([]VerifyResult, error) {
+	d.logger.Debug("Request VerifyAll", "concurrency", concurrency)
+	if concurrency < 1 {
+		return nil, fmt.Errorf("the concurrency must be positive, got %d", concurrency)
+	}
+
+	volumeNames, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]VerifyResult, len(volumeNames))
+	indices := make(chan int)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range indices {
+				results[i] = d.verifyVolume(volumeNames[i])
+			}
+		}()
+	}
+	for i := range volumeNames {
+		indices <- i
+	}
+	close(indices)
+	workers.Wait()
+
+	// `listVolumeNames` orders the volumes by their main directories, which differs for the namespaced ones
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results, nil
+}
+
+// verifyVolume is `VerifyAll` for a single volume.
+func (d *DockerOnTop) verifyVolume(volumeName string) VerifyResult {
+	result := VerifyResult{Name: volumeName}
+	report, err := d.ValidateVolume(volumeName)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	} else if !report.Healthy {
+		result.Error = strings.Join(report.Errors, "; ")
+		return result
+	}
+
+	if err := d.probeVolumeMount(volumeName); err != nil {
+		d.logger.Warn("The probe mount of the volume failed", "volume", volumeName, "error", err)
+		result.Error = "probe mount failed: " + err.Error()
+		return result
+	}
+	result.Healthy = true
+	return result
+}
+
+// probeVolumeMount checks that the volume's overlay can be mounted by mounting an overlay with the volume's lower
+// layers, mount flags, and overlay options at a scratch directory in the dot root directory, and unmounting it right
+// away. The volume's upperdir becomes the topmost lower layer of the probe (the probe's upperdir and workdir are empty
+// scratch directories), so that nothing is written to the volume. The volume is locked meanwhile; if its overlay is
+// mounted, nothing is done.
+func (d *DockerOnTop) probeVolumeMount(volumeName string) error {
+	unlock, err := d.locks.Lock(volumeName)
+	if err != nil {
+		return fmt.Errorf("failed to lock the volume: %w", err)
+	}
+	defer unlock()
+
+	if mounted, err := d.isOverlayMounted(volumeName); err != nil {
+		return err
+	} else if mounted {
+		d.logger.Debug("Not probing the volume: its overlay is mounted", "volume", volumeName)
+		return nil
+	}
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	probeDir := d.dotRootDir + scratchDirPrefix + "verify-" + id
+	defer func() {
+		if err := os.RemoveAll(probeDir); err != nil {
+			d.logger.Warn("Failed to remove the probe directory", "path", probeDir, "error", err)
+		}
+	}()
+	for _, dir := range []string{"upper", "work", "merged"} {
+		if err := os.MkdirAll(probeDir+"/"+dir, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to prepare the probe: %w", err)
+		}
+	}
+
+	mo := MountOptions{
+		LowerDirs:    thisVol.lowerDirs(),
+		UpperDir:     probeDir + "/upper",
+		WorkDir:      probeDir + "/work",
+		ExtraOptions: map[string]string{},
+	}
+	if !thisVol.ReadOnly {
+		mo.LowerDirs = append([]string{d.upperdir(volumeName, &thisVol)}, mo.LowerDirs...)
+	}
+	if thisVol.UserXattr {
+		mo.ExtraOptions["userxattr"] = ""
+	}
+	for name, value := range thisVol.OverlayOptions {
+		mo.ExtraOptions[name] = value
+	}
+	options, err := BuildOptionsString(mo)
+	if err != nil {
+		return err
+	}
+
+	err = d.mountSyscall("docker-on-top-verify", probeDir+"/merged", "overlay", thisVol.mountFlags(), options)
+	if os.IsNotExist(err) {
+		return errors.New("something is missing (does the base directory exist?)")
+	} else if err != nil {
+		return err
+	}
+	if err := d.unmountSyscall(probeDir+"/merged", syscall.MNT_DETACH); err != nil {
+		d.logger.Warn("Failed to unmount the probe", "volume", volumeName, "error", err)
+	}
+	return nil
+}
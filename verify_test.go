@@ -0,0 +1,184 @@
+//go:build dottest
+
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestVerifyAll(t *testing.T) {
+	m := NewMockSyscallMount()
+	var mutex sync.Mutex
+	probes := map[string]string{}
+	brokenBase := t.TempDir()
+	d := NewTestDockerOnTop(t, func(d *DockerOnTop) {
+		d.mountSyscall = func(source, target, fstype string, flags uintptr, data string) error {
+			if source == "docker-on-top-verify" {
+				// The kernel would refuse this one
+				if strings.Contains(data, brokenBase) {
+					return syscall.EINVAL
+				}
+				mutex.Lock()
+				probes[target] = data
+				mutex.Unlock()
+			}
+			return m.Mount(source, target, fstype, flags, data)
+		}
+		d.unmountSyscall = m.Unmount
+	})
+
+	bases := map[string]string{}
+	for _, name := range []string{"healthy", "another-healthy", "missing-base", "broken-metadata", "mounted"} {
+		bases[name] = t.TempDir()
+		MustCreateVolume(t, d, name, bases[name])
+	}
+	MustCreateVolume(t, d, "probe-fails", brokenBase)
+	if err := os.Remove(bases["missing-base"]); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, d.volumeDir("broken-metadata"), map[string]string{"metadata.json": "{"})
+	MustMountVolume(t, d, "mounted", "container")
+	healthy, err := d.getVolumeInfo("healthy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrency := range []int{1, 3, 10} {
+		results, err := d.VerifyAll(concurrency)
+		if err != nil {
+			t.Fatalf("Concurrency %d: VerifyAll failed: %v", concurrency, err)
+		}
+		want := []VerifyResult{
+			{Name: "another-healthy", Healthy: true},
+			{Name: "broken-metadata", Error: "failed to read the volume's metadata"},
+			{Name: "healthy", Healthy: true},
+			{Name: "missing-base", Error: "lower directory"},
+			// The mock mounts are not in the mount table, so the mounted volume is probed as well
+			{Name: "mounted", Healthy: true},
+			{Name: "probe-fails", Error: "probe mount failed: invalid argument"},
+		}
+		if len(results) != len(want) {
+			t.Fatalf("Concurrency %d: VerifyAll = %+v, want %+v", concurrency, results, want)
+		}
+		for i, result := range results {
+			if result.Name != want[i].Name || result.Healthy != want[i].Healthy ||
+				!strings.Contains(result.Error, want[i].Error) || result.Healthy && result.Error != "" {
+				t.Errorf("Concurrency %d: result %d is %+v, want %+v", concurrency, i, result, want[i])
+			}
+		}
+	}
+
+	// The probe stacks the upperdir on top of the lower layers, and writes nothing to the volume
+	mutex.Lock()
+	defer mutex.Unlock()
+	found := false
+	for target, data := range probes {
+		if !strings.HasPrefix(target, d.dotRootDir+scratchDirPrefix+"verify-") {
+			t.Errorf("The probe is mounted at %s, outside of a scratch directory", target)
+		}
+		if containsOption(data, "lowerdir="+d.upperdir("healthy", &healthy)+":"+bases["healthy"]) {
+			found = true
+			if containsOption(data, "upperdir="+d.upperdir("healthy", &healthy)) {
+				t.Errorf("The probe is mounted with the volume's upperdir: %q", data)
+			}
+		}
+		if _, ok := m.Mounted(target); ok {
+			t.Errorf("The probe at %s was left mounted", target)
+		}
+	}
+	if !found {
+		t.Errorf("The healthy volume was not probed with its upperdir as a lower layer: %v", probes)
+	}
+	entries, err := os.ReadDir(d.dotRootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if isScratchDir(entry.Name()) {
+			t.Errorf("The probe directory %s was left", entry.Name())
+		}
+	}
+	if entries, err := os.ReadDir(d.upperdir("healthy", &healthy)); err != nil || len(entries) != 0 {
+		t.Errorf("After the probe, the upperdir has %v, %v; want nothing", entries, err)
+	}
+	MustUnmountVolume(t, d, "mounted", "container")
+
+	if _, err := d.VerifyAll(0); err == nil {
+		t.Error("VerifyAll succeeded with no workers")
+	}
+}
+
+func TestVerifyAllSubcommand(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	out, err := captureStdout(t, runVerifyAll, d)
+	if err != nil || out != "" {
+		t.Errorf("Without volumes, verify-all printed %q, %v", out, err)
+	}
+
+	MustCreateVolume(t, d, "healthy", t.TempDir())
+	out, err = captureStdout(t, runVerifyAll, d, "-concurrency", "2")
+	if err != nil || out != "healthy: ok\n" {
+		t.Errorf("verify-all printed %q, %v; want %q", out, err, "healthy: ok\n")
+	}
+
+	base := t.TempDir()
+	MustCreateVolume(t, d, "missing-base", base)
+	if err := os.Remove(base); err != nil {
+		t.Fatal(err)
+	}
+	out, err = captureStdout(t, runVerifyAll, d)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 || lines[0] != "healthy: ok" || !strings.HasPrefix(lines[1], "missing-base: ") ||
+		!strings.Contains(lines[1], "lower directory") {
+		t.Errorf("verify-all printed %q", out)
+	}
+	if err == nil || err.Error() != "1 of 2 volume(s) cannot be mounted" {
+		t.Errorf("verify-all returned %v, want a summary of the unhealthy volumes", err)
+	}
+
+	for _, args := range [][]string{{"extra"}, {"-concurrency", "many"}} {
+		if _, err := captureStdout(t, runVerifyAll, d, args...); !errors.Is(err, errUsage) {
+			t.Errorf("verify-all %q = %v, want %v", args, err, errUsage)
+		}
+	}
+	if _, err := captureStdout(t, runVerifyAll, d, "-concurrency", "0"); err == nil || errors.Is(err, errUsage) {
+		t.Errorf("verify-all -concurrency 0 = %v, want an error", err)
+	}
+}
+
+func TestVerifyAllWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"base-file": "base"})
+	MustCreateVolume(t, d, "vol", base)
+	MustCreateVolume(t, d, "mounted", t.TempDir())
+	mountpoint := MustMountVolume(t, d, "mounted", "container")
+	writeFiles(t, mountpoint, map[string]string{"file": "written"})
+	err := d.Create(&volume.CreateRequest{Name: "readonly", Options: map[string]string{
+		"base":     t.TempDir(),
+		"readonly": "true",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := d.VerifyAll(2)
+	want := []VerifyResult{{Name: "mounted", Healthy: true}, {Name: "readonly", Healthy: true},
+		{Name: "vol", Healthy: true}}
+	if err != nil || !reflect.DeepEqual(results, want) {
+		t.Errorf("VerifyAll = %+v, %v; want %+v", results, err, want)
+	}
+	// The mounted volume is left alone
+	if contents, err := os.ReadFile(mountpoint + "file"); err != nil || string(contents) != "written" {
+		t.Errorf("After VerifyAll, the mounted volume's file = %q, %v; want %q", contents, err, "written")
+	}
+	MustUnmountVolume(t, d, "mounted", "container")
+}
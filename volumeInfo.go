@@ -2,35 +2,306 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"syscall"
+	"time"
 )
 
+// volumeInfoSchemaVersion is the version of the `VolumeInfo` format this binary writes (see `migrateVolumeInfo`)
+const volumeInfoSchemaVersion = 1
+
+// volumeInfoMigrations upgrade the metadata of a volume from version N (the index) to N+1. They work on the JSON
+// object, as the old formats may not fit `VolumeInfo`
+var volumeInfoMigrations = []func(fields map[string]json.RawMessage) error{
+	// 0 -> 1: version 0 is the metadata without a `SchemaVersion`. The fields added before it was introduced (like
+	// `LowerLayers` or `Tags`) are simply absent there, and their zero values are the right defaults
+	func(fields map[string]json.RawMessage) error { return nil },
+}
+
 type VolumeInfo struct {
+	// SchemaVersion is the version of the format the metadata was written in (see `volumeInfoSchemaVersion`)
+	SchemaVersion int
+
 	BaseDirPath string
 	Volatile    bool
+	// ReadOnly volumes are mounted without upperdir, so no changes can be made to them
+	ReadOnly bool
+	// LazyUnmount makes the overlay be detached lazily (with `MNT_DETACH`) if it is busy on unmount
+	LazyUnmount bool
+	// NoExec, NoSuid, and NoDev make the overlay be mounted with the corresponding flags (`MS_NOEXEC`, `MS_NOSUID`,
+	// and `MS_NODEV`)
+	NoExec bool
+	NoSuid bool
+	NoDev  bool
+	// UserXattr makes the overlay be mounted with the `userxattr` option, so that it stores its metadata in the
+	// "user." extended attributes instead of the "trusted." ones. Required when running in a user namespace
+	UserXattr bool
+	// LowerLayers are additional read-only layers stacked below the base directory (from top to bottom)
+	LowerLayers []string
+	// CustomUpperDir and CustomWorkDir are the upperdir and the workdir given with the `upper` and `work` options
+	// (with a trailing slash), if any. Empty if the default ones inside the volume's main directory are used
+	CustomUpperDir string
+	CustomWorkDir  string
+	// OverlayOptions are the overlay tuning options the overlay is mounted with (see `overlayTuningOptions`), like
+	// `index=off`
+	OverlayOptions map[string]string
+	// QuotaBytes limits the size of the changes made to the volume with a project quota (see quota.go). No limit if
+	// it's 0
+	QuotaBytes int64
+	// TmpfsSizeBytes is the size of the tmpfs the changes made to the volume are kept in (see tmpfsUpper.go). Only for
+	// volatile volumes. The changes are stored on disk if it's 0
+	TmpfsSizeBytes int64
+	// WriteIOPS and WriteBPS limit the write operations and bytes per second of the containers in the volume's cgroup
+	// (see ioThrottle.go). No limit if 0
+	WriteIOPS int64
+	WriteBPS  int64
+	// ParentVolume is the template volume this volume is a fork of (see `DockerOnTop.ForkVolume`), if any. Then the
+	// base directory is the template's mountpoint
+	ParentVolume string
+	// PreMountHook and PostMountHook are the executables run before and after the volume's overlay is mounted (see
+	// `DockerOnTop.runHook`), if any. A failing pre-mount hook makes the mount fail
+	PreMountHook  string
+	PostMountHook string
+	// PreUnmountHook and PostUnmountHook are the executables run before and after the volume's overlay is unmounted,
+	// if any. Their failures don't prevent the unmount
+	PreUnmountHook  string
+	PostUnmountHook string
+	// Tags are arbitrary labels of the volume, used to work with groups of volumes (see `DockerOnTop.ListByTag`)
+	Tags []string
+	// Stuck is set if the volume's overlay was still mounted after the last unmount (see `checkOverlayGone`)
+	Stuck bool
+	// CreatedAt is the time the volume was created. For the volumes created before it was recorded, `getVolumeInfo`
+	// fills it with the modification time of the metadata file
+	CreatedAt time.Time
+	// LastUsedAt is the time the volume was last mounted. It is updated in the background on a best-effort basis (see
+	// `DockerOnTop.markVolumeUsed`), so it may lag behind a little or miss some mounts.
+	LastUsedAt time.Time
+	// LastMountedAt and LastUnmountedAt are the times the volume's overlay was last mounted and unmounted. Zero if it
+	// never was (or was before these were recorded)
+	LastMountedAt   time.Time
+	LastUnmountedAt time.Time
+	// TotalMountCount is the number of times the volume's overlay has been mounted (not counting the containers that
+	// reused an already mounted overlay)
+	TotalMountCount int
+	// LastMountOptions are the options the volume's overlay was last mounted with. Informational only (for debugging
+	// and detecting changes of the options between mounts)
+	LastMountOptions string
 }
 
-func (d *DockerOnTop) metadatajson(volumeName string) string {
-	return d.dotRootDir + volumeName + "/metadata.json"
+// lowerDirs returns the lower directories of the volume's overlay, from the topmost to the bottommost one.
+func (vol *VolumeInfo) lowerDirs() []string {
+	return append([]string{vol.BaseDirPath}, vol.LowerLayers...)
 }
 
+// mountFlags returns the flags the volume's overlay is mounted with (except for the ones implied by other options,
+// like `MS_RDONLY` for read-only volumes).
+func (vol *VolumeInfo) mountFlags() uintptr {
+	var flags uintptr
+	if vol.NoExec {
+		flags |= syscall.MS_NOEXEC
+	}
+	if vol.NoSuid {
+		flags |= syscall.MS_NOSUID
+	}
+	if vol.NoDev {
+		flags |= syscall.MS_NODEV
+	}
+	return flags
+}
+
+// Validate checks the invariants of the volume's metadata, so that a corrupted metadata file is reported rather than
+// acted upon.
+func (vol *VolumeInfo) Validate() error {
+	if vol.BaseDirPath == "" {
+		return errors.New("the base directory is not set")
+	} else if !filepath.IsAbs(vol.BaseDirPath) {
+		return fmt.Errorf("the base directory %q is not an absolute path", vol.BaseDirPath)
+	}
+	for _, tag := range vol.Tags {
+		if err := validateTag(tag); err != nil {
+			return fmt.Errorf("invalid tag %q: %w", tag, err)
+		}
+	}
+	for i, layer := range vol.LowerLayers {
+		if !filepath.IsAbs(layer) {
+			return fmt.Errorf("the lower layer %q is not an absolute path", layer)
+		} else if slices.Contains(vol.LowerLayers[:i], layer) {
+			return fmt.Errorf("the lower layer %s is listed more than once", layer)
+		}
+	}
+	for name, value := range vol.OverlayOptions {
+		if err := validateOverlayOption(name, value); err != nil {
+			return err
+		}
+	}
+	if vol.QuotaBytes < 0 {
+		return fmt.Errorf("the quota %d is negative", vol.QuotaBytes)
+	} else if vol.QuotaBytes > 0 && vol.ReadOnly {
+		return errors.New("a read-only volume has a quota")
+	}
+	if vol.TmpfsSizeBytes < 0 {
+		return fmt.Errorf("the tmpfs size %d is negative", vol.TmpfsSizeBytes)
+	} else if vol.TmpfsSizeBytes > 0 && (!vol.Volatile || vol.CustomUpperDir != "") {
+		return errors.New("a volume with an in-memory upper layer is not volatile or has a custom upperdir")
+	}
+	if vol.WriteIOPS < 0 || vol.WriteBPS < 0 {
+		return fmt.Errorf("the write limits (%d IOPS, %d B/s) are negative", vol.WriteIOPS, vol.WriteBPS)
+	} else if vol.hasWriteLimits() && (vol.ReadOnly || vol.TmpfsSizeBytes > 0) {
+		return errors.New("a read-only or in-memory volume has write limits")
+	}
+	if (vol.CustomUpperDir == "") != (vol.CustomWorkDir == "") {
+		return errors.New("only one of the custom upperdir and workdir is set")
+	}
+	for _, hook := range []string{vol.PreMountHook, vol.PostMountHook, vol.PreUnmountHook, vol.PostUnmountHook} {
+		if hook != "" && !filepath.IsAbs(hook) {
+			return fmt.Errorf("the hook %q is not an absolute path", hook)
+		}
+	}
+	if vol.CreatedAt.IsZero() {
+		return errors.New("the creation time is not set")
+	}
+	if vol.ParentVolume != "" && !volNameFormat.MatchString(vol.ParentVolume) {
+		return fmt.Errorf("the parent volume name %q is invalid", vol.ParentVolume)
+	}
+	return nil
+}
+
+// getVolumeInfo reads the volume's metadata from the metadata backend (see `MetadataBackend`). Corrupted metadata (see
+// `VolumeInfo.Validate`) is reported as an error.
 func (d *DockerOnTop) getVolumeInfo(volumeName string) (VolumeInfo, error) {
-	var vol VolumeInfo
+	vol, err := d.metadata.Read(volumeName)
+	if err != nil {
+		return vol, err
+	}
+	if vol.SchemaVersion > volumeInfoSchemaVersion {
+		d.logger.Warn("The volume's metadata was written by a newer version of the plugin. The information this "+
+			"version doesn't know about is ignored (and lost if the metadata is updated)", "volume", volumeName,
+			"schemaVersion", vol.SchemaVersion, "supportedSchemaVersion", volumeInfoSchemaVersion)
+	}
+	if err := vol.Validate(); err != nil {
+		return vol, fmt.Errorf("invalid metadata: %w", err)
+	}
+	return vol, nil
+}
 
-	payload, err := os.ReadFile(d.metadatajson(volumeName))
-	if err == nil {
-		err = json.Unmarshal(payload, &vol)
+// listVolumesWhere returns the names of the volumes whose metadata satisfies `match`, in ascending order. Volumes with
+// unreadable metadata are skipped (with a warning).
+func (d *DockerOnTop) listVolumesWhere(match func(vol *VolumeInfo) bool) ([]string, error) {
+	volumeNames, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range volumeNames {
+		thisVol, err := d.getVolumeInfo(name)
+		if err != nil {
+			d.logger.Warn("Failed to retrieve metadata for the volume. Skipping it", "volume", name, "error", err)
+			continue
+		}
+		if match(&thisVol) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// getVolumeInfoOrNotFound is `getVolumeInfo` for the public methods: if the volume doesn't exist, the "no such volume"
+// error is returned, other errors are logged and wrapped with `internalError`.
+func (d *DockerOnTop) getVolumeInfoOrNotFound(volumeName string) (VolumeInfo, error) {
+	vol, err := d.getVolumeInfo(volumeName)
+	if os.IsNotExist(err) {
+		return vol, errors.New("no such volume")
+	} else if err != nil {
+		return vol, d.internalError("failed to retrieve the volume's metadata", err, "volume", volumeName)
+	}
+	return vol, nil
+}
+
+// migrateVolumeInfo decodes the volume's metadata, upgrading it to the `targetVersion` of the format by applying the
+// `volumeInfoMigrations` one by one. The metadata of a newer version than `targetVersion` is decoded as is (the fields
+// unknown to `VolumeInfo` are dropped), keeping its `SchemaVersion`.
+func migrateVolumeInfo(old json.RawMessage, targetVersion int) (VolumeInfo, error) {
+	var vol VolumeInfo
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(old, &fields); err != nil {
+		return vol, err
+	}
+	version := 0
+	if raw, ok := fields["SchemaVersion"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return vol, fmt.Errorf("invalid schema version: %w", err)
+		}
 	}
 
+	if version < targetVersion {
+		for ; version < targetVersion; version++ {
+			if version < 0 || version >= len(volumeInfoMigrations) {
+				return vol, fmt.Errorf("cannot migrate the metadata from schema version %d", version)
+			}
+			if err := volumeInfoMigrations[version](fields); err != nil {
+				return vol, fmt.Errorf("failed to migrate the metadata from schema version %d: %w", version, err)
+			}
+		}
+		fields["SchemaVersion"], _ = json.Marshal(version) // Can't fail
+		var err error
+		if old, err = json.Marshal(fields); err != nil {
+			return vol, err
+		}
+	}
+	err := json.Unmarshal(old, &vol)
 	return vol, err
 }
 
+// writeVolumeInfo writes the volume's metadata to the metadata backend, in the current format (see
+// `volumeInfoSchemaVersion`).
 func (d *DockerOnTop) writeVolumeInfo(volumeName string, vol VolumeInfo) error {
-	payload, err := json.Marshal(vol)
+	defer d.invalidateVolumeInfo(volumeName)
+	return d.metadata.Write(volumeName, vol)
+}
 
-	if err == nil {
-		err = os.WriteFile(d.metadatajson(volumeName), payload, 0o666)
+// updateVolumeInfo reads the volume's metadata, applies `update` to it, and writes it back. The caller is expected to
+// hold the volume's lock (see `lockVolume`), so that concurrent updates are not lost.
+func (d *DockerOnTop) updateVolumeInfo(volumeName string, update func(vol *VolumeInfo)) error {
+	vol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		return err
 	}
+	update(&vol)
+	return d.writeVolumeInfo(volumeName, vol)
+}
 
+// atomicWriteFile is like `os.WriteFile` but a crash never leaves the file partially written: the data is written to
+// a temporary file in the same directory, which is then renamed over `path`. Thus, `path` holds either the old or the
+// new contents.
+func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
+	nonce, err := newUUID()
+	if err != nil {
+		return err
+	}
+	tmpPath := path + "." + nonce + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
 	return err
 }
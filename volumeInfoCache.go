@@ -0,0 +1,54 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// defaultVolumeInfoCacheTTL is the default time the metadata cached by `GetVolumeInfo` is trusted for
+const defaultVolumeInfoCacheTTL = 60 * time.Second
+
+// cachedVolumeInfo is an entry of `DockerOnTop.volumeInfoCache`
+type cachedVolumeInfo struct {
+	vol    VolumeInfo
+	readAt time.Time
+}
+
+// GetVolumeInfo returns the volume's metadata. It is safe for concurrent use.
+//
+// The metadata is cached in memory for `volumeInfoCacheTTL` (see `WithVolumeInfoCacheTTL`), so that the repeated calls
+// don't touch the filesystem. The cache entry is invalidated when this `DockerOnTop` object changes the volume's
+// metadata or removes the volume, but not when another process (like a subcommand) does: then the outdated metadata
+// is returned until the entry expires.
+func (d *DockerOnTop) GetVolumeInfo(volumeName string) (VolumeInfo, error) {
+	if entry, ok := d.volumeInfoCache.Load(volumeName); ok {
+		cached := entry.(cachedVolumeInfo)
+		if time.Since(cached.readAt) < d.volumeInfoCacheTTL {
+			return cached.vol.clone(), nil
+		}
+	}
+
+	readAt := time.Now()
+	vol, err := d.getVolumeInfoOrNotFound(volumeName)
+	if err != nil {
+		return vol, err
+	}
+	// An invalidation racing with this read may be overwritten, so the entry is only kept for the TTL anyway
+	d.volumeInfoCache.Store(volumeName, cachedVolumeInfo{vol: vol.clone(), readAt: readAt})
+	return vol, nil
+}
+
+// invalidateVolumeInfo drops the volume's metadata from the cache of `GetVolumeInfo`.
+func (d *DockerOnTop) invalidateVolumeInfo(volumeName string) {
+	d.volumeInfoCache.Delete(volumeName)
+}
+
+// clone returns a deep copy of the metadata, so that the cached copy is not modified through the lists and maps
+// shared with the returned one.
+func (vol VolumeInfo) clone() VolumeInfo {
+	vol.LowerLayers = slices.Clone(vol.LowerLayers)
+	vol.OverlayOptions = maps.Clone(vol.OverlayOptions)
+	vol.Tags = slices.Clone(vol.Tags)
+	return vol
+}
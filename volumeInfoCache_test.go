@@ -0,0 +1,127 @@
+//go:build dottest
+
+package main
+
+import (
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// countingMetadataBackend counts the reads of the metadata backend it wraps.
+type countingMetadataBackend struct {
+	MetadataBackend
+	reads atomic.Int64
+}
+
+func (b *countingMetadataBackend) Read(volumeName string) (VolumeInfo, error) {
+	b.reads.Add(1)
+	return b.MetadataBackend.Read(volumeName)
+}
+
+// withCountingMetadataBackend wraps the driver's metadata backend in `b`.
+func withCountingMetadataBackend(b *countingMetadataBackend) DockerOnTopOption {
+	return func(d *DockerOnTop) {
+		b.MetadataBackend = d.metadata
+		d.metadata = b
+	}
+}
+
+func TestGetVolumeInfoCache(t *testing.T) {
+	var backend countingMetadataBackend
+	d := NewTestDockerOnTop(t, withCountingMetadataBackend(&backend))
+	mustCreateTaggedVolume(t, d, "vol", "team")
+
+	expectReads := func(when string, want int64) {
+		t.Helper()
+		if reads := backend.reads.Swap(0); reads != want {
+			t.Errorf("%s, the metadata was read %d times, want %d", when, reads, want)
+		}
+	}
+	getTags := func() []string {
+		t.Helper()
+		vol, err := d.GetVolumeInfo("vol")
+		if err != nil {
+			t.Fatalf("GetVolumeInfo failed: %v", err)
+		}
+		return vol.Tags
+	}
+
+	backend.reads.Store(0)
+	tags := getTags()
+	expectReads("On the first call", 1)
+	// Modifying the returned metadata doesn't modify the cached one
+	tags[0] = "modified"
+	if tags := getTags(); !slices.Equal(tags, []string{"team"}) {
+		t.Errorf("The cached tags are %v", tags)
+	}
+	expectReads("On a cache hit", 0)
+
+	// The changes made by the driver invalidate the cache
+	if err := d.AddTag("vol", "ci"); err != nil {
+		t.Fatal(err)
+	}
+	backend.reads.Store(0)
+	if tags := getTags(); !slices.Equal(tags, []string{"team", "ci"}) {
+		t.Errorf("After AddTag, the tags are %v", tags)
+	}
+	expectReads("After AddTag", 1)
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetVolumeInfo("vol"); err == nil {
+		t.Error("After Remove, GetVolumeInfo returned the cached metadata")
+	}
+}
+
+func TestGetVolumeInfoCacheTTL(t *testing.T) {
+	const ttl = 200 * time.Millisecond
+	var backend countingMetadataBackend
+	d := NewTestDockerOnTop(t, withCountingMetadataBackend(&backend), WithVolumeInfoCacheTTL(ttl))
+	mustCreateTaggedVolume(t, d, "vol", "team")
+	if _, err := d.GetVolumeInfo("vol"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changed behind the driver's back, so the cache is not invalidated
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol.Tags = []string{"changed"}
+	if err := d.metadata.Write("vol", vol); err != nil {
+		t.Fatal(err)
+	}
+	backend.reads.Store(0)
+	if vol, err := d.GetVolumeInfo("vol"); err != nil || !slices.Equal(vol.Tags, []string{"team"}) ||
+		backend.reads.Load() != 0 {
+		t.Errorf("Before the TTL, GetVolumeInfo = %v, %v after %d reads; want the cached tags and no reads", vol.Tags,
+			err, backend.reads.Load())
+	}
+
+	time.Sleep(ttl)
+	if vol, err := d.GetVolumeInfo("vol"); err != nil || !slices.Equal(vol.Tags, []string{"changed"}) ||
+		backend.reads.Load() != 1 {
+		t.Errorf("After the TTL, GetVolumeInfo = %v, %v after %d reads; want the changed tags and a read", vol.Tags,
+			err, backend.reads.Load())
+	}
+}
+
+func TestGetVolumeInfoCacheDisabled(t *testing.T) {
+	var backend countingMetadataBackend
+	d := NewTestDockerOnTop(t, withCountingMetadataBackend(&backend), WithVolumeInfoCacheTTL(0))
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	backend.reads.Store(0)
+	for i := 0; i < 3; i++ {
+		if _, err := d.GetVolumeInfo("vol"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reads := backend.reads.Load(); reads != 3 {
+		t.Errorf("Without the cache, the metadata was read %d times, want 3", reads)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// atomicWriteFileCrashEnv makes `TestAtomicWriteFileCrash` rewrite the file it names until the process is killed
+const atomicWriteFileCrashEnv = "DOCKER_ON_TOP_TEST_ATOMIC_WRITE_PATH"
+
+func TestAtomicWriteFileCrash(t *testing.T) {
+	// Large enough for the writes to take a while, so that the process is likely killed in the middle of one
+	contents := [][]byte{bytes.Repeat([]byte("old "), 1<<18), bytes.Repeat([]byte("new "), 1<<18)}
+	if path := os.Getenv(atomicWriteFileCrashEnv); path != "" {
+		for i := 1; ; i++ {
+			if err := atomicWriteFile(path, contents[i%2], 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	path := t.TempDir() + "/file"
+	if err := atomicWriteFile(path, contents[0], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestAtomicWriteFileCrash$")
+		cmd.Env = append(os.Environ(), atomicWriteFileCrashEnv+"="+path)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Duration(20+i*7) * time.Millisecond)
+		if err := cmd.Process.Kill(); err != nil {
+			t.Fatal(err)
+		}
+		_ = cmd.Wait()
+
+		written, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(written, contents[0]) && !bytes.Equal(written, contents[1]) {
+			t.Fatalf("After the writer was killed, the file has %d bytes of neither the old nor the new contents",
+				len(written))
+		}
+	}
+}
+
+func TestAtomicWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/file"
+	for _, contents := range []string{"first", "second"} {
+		if err := atomicWriteFile(path, []byte(contents), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		if written, err := os.ReadFile(path); err != nil || string(written) != contents {
+			t.Errorf("The file contains %q, %v; want %q", written, err, contents)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("The file's mode is %v, %v; want 0640", info.Mode(), err)
+	}
+	// The temporary files don't stay around
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("The directory contains %v, %v; want only the file", entries, err)
+	}
+}
+
+func TestVolumeInfoValidate(t *testing.T) {
+	valid := VolumeInfo{BaseDirPath: "/data", CreatedAt: time.Now()}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate failed for valid metadata: %v", err)
+	}
+	tests := []struct {
+		name   string
+		modify func(vol *VolumeInfo)
+		want   string
+	}{
+		{"no base", func(vol *VolumeInfo) { vol.BaseDirPath = "" }, "the base directory is not set"},
+		{"relative base", func(vol *VolumeInfo) { vol.BaseDirPath = "data" }, "not an absolute path"},
+		{"empty tag", func(vol *VolumeInfo) { vol.Tags = []string{"ok", ""} }, `invalid tag ""`},
+		{"tag with a comma", func(vol *VolumeInfo) { vol.Tags = []string{"a,b"} }, `invalid tag "a,b"`},
+		{"relative lower layer", func(vol *VolumeInfo) { vol.LowerLayers = []string{"/l1", "l2"} }, `"l2"`},
+		{"duplicate lower layer", func(vol *VolumeInfo) { vol.LowerLayers = []string{"/l1", "/l2", "/l1"} },
+			"listed more than once"},
+		{"only a custom upperdir", func(vol *VolumeInfo) { vol.CustomUpperDir = "/upper" }, "only one of"},
+		{"only a custom workdir", func(vol *VolumeInfo) { vol.CustomWorkDir = "/work" }, "only one of"},
+		{"no creation time", func(vol *VolumeInfo) { vol.CreatedAt = time.Time{} }, "creation time"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vol := valid
+			test.modify(&vol)
+			if err := vol.Validate(); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Validate = %v; want an error containing %q", err, test.want)
+			}
+		})
+	}
+
+	both := valid
+	both.CustomUpperDir, both.CustomWorkDir = "/upper", "/work"
+	both.LowerLayers = []string{"/l1", "/l2"}
+	both.Tags = []string{"team"}
+	if err := both.Validate(); err != nil {
+		t.Errorf("Validate failed with custom directories, lower layers, and tags: %v", err)
+	}
+}
+
+func TestMigrateVolumeInfo(t *testing.T) {
+	// Version 0 has no `SchemaVersion` and none of the fields added since
+	old := json.RawMessage(`{"BaseDirPath": "/data", "Volatile": true}`)
+	vol, err := migrateVolumeInfo(old, volumeInfoSchemaVersion)
+	if err != nil {
+		t.Fatalf("migrateVolumeInfo failed for version 0: %v", err)
+	}
+	want := VolumeInfo{SchemaVersion: volumeInfoSchemaVersion, BaseDirPath: "/data", Volatile: true}
+	if !reflect.DeepEqual(vol, want) {
+		t.Errorf("migrateVolumeInfo = %+v, want %+v", vol, want)
+	}
+
+	// A newer version is decoded as is
+	vol, err = migrateVolumeInfo(json.RawMessage(`{"SchemaVersion": 7, "BaseDirPath": "/data", "FutureField": 1}`),
+		volumeInfoSchemaVersion)
+	if err != nil || vol.SchemaVersion != 7 || vol.BaseDirPath != "/data" {
+		t.Errorf("migrateVolumeInfo = %+v, %v; want version 7 with the base /data", vol, err)
+	}
+
+	for _, old := range []string{`{"SchemaVersion": "one"}`, `{"SchemaVersion": -1}`, `[]`} {
+		if _, err := migrateVolumeInfo(json.RawMessage(old), volumeInfoSchemaVersion); err == nil {
+			t.Errorf("migrateVolumeInfo accepted %s", old)
+		}
+	}
+	// No migration to a version that doesn't exist yet
+	if _, err := migrateVolumeInfo(json.RawMessage(`{}`), len(volumeInfoMigrations)+1); err == nil {
+		t.Error("migrateVolumeInfo migrated to an unknown version")
+	}
+}
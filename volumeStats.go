@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// VolumeStatistics describes the disk usage of a volume's upperdir, as reported by `VolumeStats`
+type VolumeStatistics struct {
+	// UsedInodes, FreeInodes, UsedBytes, and FreeBytes describe the filesystem the upperdir is located on (the changes
+	// of all the volumes sharing it are counted together). The free bytes are the ones available to unprivileged users
+	UsedInodes uint64
+	FreeInodes uint64
+	UsedBytes  uint64
+	FreeBytes  uint64
+	// ChangedFileCount is the number of entries in the upperdir other than directories and whiteouts, that is, of the
+	// files added or modified in the volume
+	ChangedFileCount uint64
+}
+
+// VolumeStats returns the inode and block usage of the filesystem the volume's upperdir is on and the number of files
+// changed in the volume. The upperdir is reached through the merged view (see `upperdirWalk`) and only read, so it
+// works whether the volume is mounted or not.
+func (d *DockerOnTop) VolumeStats(volumeName string) (VolumeStatistics, error) {
+	var stats VolumeStatistics
+	thisVol, err := d.getVolumeInfo(volumeName)
+	if os.IsNotExist(err) {
+		return stats, errors.New("no such volume")
+	} else if err != nil {
+		return stats, err
+	}
+
+	upperdir := d.upperdir(volumeName, &thisVol)
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(upperdir, &statfs); err != nil {
+		return stats, err
+	}
+	stats.UsedInodes = statfs.Files - statfs.Ffree
+	stats.FreeInodes = statfs.Ffree
+	stats.UsedBytes = (statfs.Blocks - statfs.Bfree) * uint64(statfs.Bsize)
+	stats.FreeBytes = statfs.Bavail * uint64(statfs.Bsize)
+
+	err = d.upperdirWalk(volumeName, upperdir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if whiteout, _ := isWhiteout(info.Name(), info); !whiteout {
+			stats.ChangedFileCount++
+		}
+		return nil
+	})
+	return stats, err
+}
@@ -0,0 +1,90 @@
+//go:build dottest
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestVolumeStats(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	vol, err := d.getVolumeInfo("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upperdir := d.upperdir("vol", &vol)
+
+	if stats, err := d.VolumeStats("vol"); err != nil || stats.ChangedFileCount != 0 {
+		t.Errorf("For a new volume, VolumeStats = %+v, %v; want no changed files", stats, err)
+	}
+
+	files := map[string]string{"dir/nested/file": "x"}
+	for i := 0; i < 7; i++ {
+		files[fmt.Sprintf("file%d", i)] = "x"
+	}
+	writeFiles(t, upperdir, files)
+	if err := os.Symlink("file0", upperdir+"/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(upperdir+"/empty", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	makeWhiteouts(t, upperdir, "deleted", "dir/also-deleted")
+
+	stats, err := d.VolumeStats("vol")
+	if err != nil {
+		t.Fatalf("VolumeStats failed: %v", err)
+	}
+	// The files and the symlink; not the directories or the whiteouts
+	if stats.ChangedFileCount != 9 {
+		t.Errorf("ChangedFileCount = %d, want 9", stats.ChangedFileCount)
+	}
+
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(upperdir, &statfs); err != nil {
+		t.Fatal(err)
+	}
+	if stats.UsedInodes+stats.FreeInodes != statfs.Files {
+		t.Errorf("UsedInodes + FreeInodes = %d, want the filesystem's %d inodes", stats.UsedInodes+stats.FreeInodes,
+			statfs.Files)
+	}
+	if total := statfs.Blocks * uint64(statfs.Bsize); stats.UsedBytes > total || stats.FreeBytes > total {
+		t.Errorf("UsedBytes = %d, FreeBytes = %d; want at most the filesystem's %d bytes", stats.UsedBytes,
+			stats.FreeBytes, total)
+	}
+
+	if _, err := d.VolumeStats("missing"); err == nil || err.Error() != "no such volume" {
+		t.Errorf("For a missing volume, VolumeStats returned %v, want \"no such volume\"", err)
+	}
+}
+
+func TestVolumeStatsWithOverlay(t *testing.T) {
+	d := NewTestDockerOnTopWithOverlay(t)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"modified": "old", "deleted": "old", "untouched": "old"})
+	MustCreateVolume(t, d, "vol", base)
+	mountpoint := MustMountVolume(t, d, "vol", "container")
+
+	const added = 5
+	files := map[string]string{"modified": "new"}
+	for i := 0; i < added; i++ {
+		files[fmt.Sprintf("dir/file%d", i)] = "new"
+	}
+	writeFiles(t, mountpoint, files)
+	if err := os.Remove(mountpoint + "deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Counting works while the volume is mounted
+	if stats, err := d.VolumeStats("vol"); err != nil || stats.ChangedFileCount != added+1 {
+		t.Errorf("VolumeStats = %+v, %v; want %d changed files", stats, err, added+1)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+	if stats, err := d.VolumeStats("vol"); err != nil || stats.ChangedFileCount != added+1 {
+		t.Errorf("After unmounting, VolumeStats = %+v, %v; want %d changed files", stats, err, added+1)
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"syscall"
 )
 
 /*
@@ -10,12 +15,16 @@ Here is how the internal volume management works.
 
 Each existing volume has a corresponding "main directory" named the same as the volume name and located inside the
 "dot root directory". For example, if the dot root directory is /var/lib/docker-on-top/ and a volume with name FooBar is
-created, that volume's main directory is /var/lib/docker-on-top/FooBar/
+created, that volume's main directory is /var/lib/docker-on-top/FooBar/ (the main directories of namespaced volumes
+are nested instead, see namespaces.go; use `volumeDir` to get the path)
 The volume's main directory is created when a volume is created and removed (together with *all* of its contents)
 when the volume is removed.
+To make the creation atomic, the main directory is first prepared under a temporary name starting with `.tmp-` and then
+renamed. Such scratch directories left after a crash are removed when the plugin starts.
 
 Inside a volume's main directory there are the following files/directories:
-	- metadata.json  - stores the volume's metadata, which comprises the options it was created with. Exists always.
+	- metadata.json  - stores the volume's metadata, which comprises the options it was created with. Exists always
+		(unless the metadata is stored elsewhere, see `MetadataBackend`).
 	- activemounts/  - stores information about containers currently using the volume. Exists always. Each file in it
 		uniquely corresponds to a container.
 		On mount/unmount operations, an exclusive lock (via `flock`) is taken on this directory until all the
@@ -23,27 +32,128 @@ Inside a volume's main directory there are the following files/directories:
 	- upper/  - the upperdir of an overlay mount. Exists always. For volatile mounts, recreated from scratch on every
 		mount (unless the volume is already mounted to another container). On unmount no special action occurs.
 	- workdir/  - the workdir of an overlay mount. Exists only when the volume is mounted.
+	(if the volume was created with the `upper` and/or `work` options, the corresponding directories are located at the
+	given paths instead and upper/ is left empty. They are not removed together with the volume)
+	- tmpfs/  - where the in-memory upper layer is mounted, if the volume was created with `tmpfs-size` (see
+		tmpfsUpper.go). The upperdir and workdir are tmpfs/upper/ and tmpfs/work/ then, and upper/ is left empty.
 	- mountpoint/  - the directory where the overlay is to be mounted to. Exists only when the volume is mounted.
+	- upper.bak.<timestamp>/  - the previous upperdirs of the volume, kept by `Reset` (unless purged).
+	- access.log, access.log.1  - the log of the volume's mounts and unmounts (see `TailAccessLog`), and its rotated
+		part. Only written to by the plugin.
+	- checkpoints/<name>/  - the named copies of the upperdir made by `CreateCheckpoint`. Exists only if a checkpoint
+		was ever created.
+	- lease  - the container the volume is leased to and the lease's expiry time (see `AcquireLease`). Exists only
+		while the volume is leased (expired leases may be left behind; they are ignored).
 */
 
 func (d *DockerOnTop) activemountsdir(volumeName string) string {
-	return d.dotRootDir + volumeName + "/activemounts/"
+	return d.volumeDir(volumeName) + "/activemounts/"
 }
 
-func (d *DockerOnTop) upperdir(volumeName string) string {
-	return d.dotRootDir + volumeName + "/upper/"
+// upperdir returns the upperdir of the volume with the metadata `vol`: the custom one if the volume was created with
+// the `upper` option, the one in the volume's tmpfs if it has one, the default one inside the volume's main directory
+// otherwise.
+func (d *DockerOnTop) upperdir(volumeName string, vol *VolumeInfo) string {
+	if vol.CustomUpperDir != "" {
+		return vol.CustomUpperDir
+	} else if vol.TmpfsSizeBytes > 0 {
+		return d.tmpfsdir(volumeName) + "upper/"
+	}
+	return d.volumeDir(volumeName) + "/upper/"
 }
 
-func (d *DockerOnTop) workdir(volumeName string) string {
-	return d.dotRootDir + volumeName + "/workdir/"
+// workdir is like `upperdir` for the workdir (see the `work` option).
+func (d *DockerOnTop) workdir(volumeName string, vol *VolumeInfo) string {
+	if vol.CustomWorkDir != "" {
+		return vol.CustomWorkDir
+	} else if vol.TmpfsSizeBytes > 0 {
+		return d.tmpfsdir(volumeName) + "work/"
+	}
+	return d.volumeDir(volumeName) + "/workdir/"
 }
 
 func (d *DockerOnTop) mountpointdir(volumeName string) string {
-	return d.dotRootDir + volumeName + "/mountpoint/"
+	return d.volumeDir(volumeName) + "/mountpoint/"
+}
+
+// DotRootDirPath returns the dot root directory the driver keeps the volumes in, with a trailing slash.
+func (d *DockerOnTop) DotRootDirPath() string {
+	return d.dotRootDir
+}
+
+// VolumeMountpointDir returns the directory the volume's overlay is mounted at (the path given to the containers). It
+// only exists while the volume is mounted.
+func (d *DockerOnTop) VolumeMountpointDir(volumeName string) string {
+	return d.mountpointdir(volumeName)
 }
 
-// volumeTreeOnBootReset resets the volume's tree, which is useful in case the plugin was restarted or the system
-// rebooted without proper volume cleanup.
+// VolumeUpperDir returns the volume's upperdir, which holds the changes made to the volume (see `upperdir`). An empty
+// string is returned if the volume's metadata cannot be read (e.g. the volume doesn't exist).
+func (d *DockerOnTop) VolumeUpperDir(volumeName string) string {
+	vol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		return ""
+	}
+	return d.upperdir(volumeName, &vol)
+}
+
+// VolumeWorkDir returns the volume's workdir (see `workdir`). It only exists while the volume is mounted. An empty
+// string is returned if the volume's metadata cannot be read (e.g. the volume doesn't exist).
+func (d *DockerOnTop) VolumeWorkDir(volumeName string) string {
+	vol, err := d.getVolumeInfo(volumeName)
+	if err != nil {
+		return ""
+	}
+	return d.workdir(volumeName, &vol)
+}
+
+// volumeIsMounted reports whether the volume is currently in use by any container, judging by the contents of its
+// activemounts/ directory.
+//
+// No lock is taken, so the result is only a snapshot that may get outdated at any moment. If the volume's state must
+// not change while it is being worked with, the caller should lock the volume on its own.
+func (d *DockerOnTop) volumeIsMounted(volumeName string) (bool, error) {
+	dir, err := os.Open(d.activemountsdir(volumeName))
+	if err != nil {
+		return false, err
+	}
+	defer dir.Close()
+
+	_, err = dir.ReadDir(1)
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lockIdleVolume takes the volume's lock (see `lockVolume`), making sure that the volume is not in use, so that it can
+// be modified while it is held. `action` is used in the error messages. The returned function releases the lock.
+func (d *DockerOnTop) lockIdleVolume(volumeName string, action string) (func(), error) {
+	unlock, err := d.lockVolume(volumeName)
+	if err != nil {
+		// The error is already logged and wrapped in `internalError` by `lockVolume`
+		return nil, err
+	}
+	if _, err := d.readActivemounts(volumeName, 1); !errors.Is(err, io.EOF) {
+		unlock()
+		if err == nil {
+			return nil, fmt.Errorf("the volume is mounted: cannot %s while it is in use", action)
+		}
+		return nil, d.internalError("failed to list activemounts/", err)
+	}
+	if mounted, err := d.isOverlayMounted(volumeName); err != nil {
+		d.logger.Warn("Failed to check whether the overlay is mounted", "volume", volumeName, "error", err)
+	} else if mounted {
+		unlock()
+		return nil, fmt.Errorf("the volume's overlay is still mounted: cannot %s while it is in use", action)
+	}
+	return unlock, nil
+}
+
+// volumeTreeOnBootReset resets the tree of the volume with the metadata `vol`, which is useful in case the plugin was
+// restarted or the system rebooted without proper volume cleanup.
 //
 // The function first attempts to remove mountpoint/, then recreates the activemounts/ directory (all previous active
 // mounts are discarded), then recursively removes the workdir/ directory.
@@ -57,7 +167,7 @@ func (d *DockerOnTop) mountpointdir(volumeName string) string {
 // Note that in case an overlay is mounted for the volume (e.g. if the plugin is restarted without a machine reboot),
 // the first operation fails with `syscall.EBUSY` and further actions are not performed, so the volume state remains
 // valid.
-func (d *DockerOnTop) volumeTreeOnBootReset(volumeName string) error {
+func (d *DockerOnTop) volumeTreeOnBootReset(volumeName string, vol *VolumeInfo) error {
 	// For the strict compliance with the doc, I check for `os.IsNotExist(err)` for all errors, even though for some
 	// operations this error is either impossible (`os.RemoveAll`) or extremely unlikely in our case (`os.Mkdir`)
 
@@ -65,6 +175,10 @@ func (d *DockerOnTop) volumeTreeOnBootReset(volumeName string) error {
 	if err != nil {
 		return err
 	}
+	// The overlay is not mounted (the mountpoint would be busy otherwise), so its in-memory upper layer can go
+	if err := d.unmountUpperTmpfs(volumeName); err != nil {
+		return err
+	}
 	activemountsdir := d.activemountsdir(volumeName)
 	err = os.RemoveAll(activemountsdir)
 	if err != nil && !os.IsNotExist(err) {
@@ -74,7 +188,7 @@ func (d *DockerOnTop) volumeTreeOnBootReset(volumeName string) error {
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	err = os.RemoveAll(d.workdir(volumeName))
+	err = os.RemoveAll(d.workdir(volumeName, vol))
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
@@ -82,29 +196,63 @@ func (d *DockerOnTop) volumeTreeOnBootReset(volumeName string) error {
 	return nil
 }
 
+// scratchDirPrefix is the prefix of the names of temporary directories inside the dot root directory. Such names
+// can never clash with volume names, as the latter cannot start with a dot.
+const scratchDirPrefix = ".tmp-"
+
+// isScratchDir reports whether the given entry of the dot root directory is a temporary directory rather than a
+// volume's main directory.
+func isScratchDir(name string) bool {
+	return strings.HasPrefix(name, scratchDirPrefix)
+}
+
 // volumeTreeCreate creates a directory tree for the specified volume (but not metadata.json).
 //
+// The tree is first created in a scratch directory inside the dot root directory and then renamed to become the
+// volume's main directory, so that either the complete tree exists or none of it does.
+//
 // If errors occur, they are logged and the returned error is wrapped with `internalError`, except when volume already
 // exists. In that case, nothing is logged and an error such that `os.IsExist(err)` is returned (without additional
 // wrapping).
 func (d *DockerOnTop) volumeTreeCreate(volumeName string) error {
-	if err := os.Mkdir(d.dotRootDir+volumeName, os.ModePerm); err != nil {
-		if os.IsExist(err) {
-			return err
-		} else {
-			log.Errorf("Failed to Mkdir main directory: %v", err)
-			return internalError("failed to Mkdir volume main directory", err)
+	return d.volumeTreeCreateAt(volumeName, d.volumeDir(volumeName))
+}
+
+// volumeTreeCreateAt is `volumeTreeCreate` with the volume's main directory given explicitly (for namespaced volumes,
+// see `prepareNamespacedDir`).
+func (d *DockerOnTop) volumeTreeCreateAt(volumeName string, mainDir string) error {
+	if _, err := os.Lstat(mainDir); err == nil {
+		return &os.PathError{Op: "mkdir", Path: mainDir, Err: os.ErrExist}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return d.internalError("failed to generate a name for the scratch directory", err, "volume", volumeName)
+	}
+	scratchDir := d.dotRootDir + scratchDirPrefix + id
+	if err := os.Mkdir(scratchDir, os.ModePerm); err != nil {
+		return d.internalError("failed to Mkdir scratch directory", err, "volume", volumeName)
+	}
+
+	// Try to create internal directories. On failure, remove the scratch directory
+	for _, dir := range []string{"/upper/", "/activemounts/"} {
+		if err := os.Mkdir(scratchDir+dir, os.ModePerm); err != nil {
+			if cleanupErr := os.RemoveAll(scratchDir); cleanupErr != nil {
+				d.logger.Error("Failed to RemoveAll scratch directory", "error", cleanupErr)
+			}
+			return d.internalError("failed to Mkdir internal directories", err, "volume", volumeName)
 		}
 	}
 
-	// Try to create internal directories. On failure, revert the creation of the volume main directory
-	for _, dir := range []string{d.upperdir(volumeName), d.activemountsdir(volumeName)} {
-		if err := os.Mkdir(dir, os.ModePerm); err != nil {
-			log.Errorf("Failed to Mkdir internal directory: %v. Aborting volume creation (attempting "+
-				"to destroy the volume's tree)", volumeName, err)
-			_ = d.volumeTreeDestroy(volumeName) // The errors are logged, if any
-			return internalError("failed to Mkdir internal directories", err)
+	// Note: renaming a directory onto a non-empty one fails with ENOTEMPTY, which satisfies `os.IsExist`
+	if err := os.Rename(scratchDir, mainDir); err != nil {
+		if cleanupErr := os.RemoveAll(scratchDir); cleanupErr != nil {
+			d.logger.Error("Failed to RemoveAll scratch directory", "error", cleanupErr)
+		}
+		if os.IsExist(err) {
+			return err
 		}
+		return d.internalError("failed to rename scratch directory to volume main directory", err, "volume", volumeName)
 	}
 
 	return nil
@@ -116,29 +264,69 @@ func (d *DockerOnTop) volumeTreeCreate(volumeName string) error {
 // If errors occur, they are logged and the returned error is wrapped with `internalError`.
 // Note that if the volume doesn't exist, the function call is considered successful (`nil` is returned).
 func (d *DockerOnTop) volumeTreeDestroy(volumeName string) error {
-	err := os.RemoveAll(d.dotRootDir + volumeName)
+	mainDir := d.volumeDir(volumeName)
+	defer d.invalidateVolumeInfo(volumeName)
+	err := os.RemoveAll(mainDir)
+	if err != nil {
+		return d.internalError("failed to RemoveAll volume main directory", err, "volume", volumeName)
+	}
+	d.removeEmptyNamespaceDirs(mainDir)
+	// With the default backend, the metadata was in the main directory, but other backends store it elsewhere
+	if err := d.metadata.Delete(volumeName); err != nil {
+		return d.internalError("failed to delete the volume's metadata", err, "volume", volumeName)
+	}
+	return nil
+}
+
+// checkActivemountsDirIsFlushed verifies that the volume's activemounts/ directory is empty, which must be the case
+// right after the volume is unmounted. Otherwise, the leftover ("ghost") active mount files would make the next
+// `Mount` believe the overlay is still mounted and skip mounting it.
+//
+// The leftover files, if any, are logged and removed. Errors are logged and the returned error is wrapped with
+// `internalError`. The volume's lock is expected to be held by the caller.
+func (d *DockerOnTop) checkActivemountsDirIsFlushed(volumeName string) error {
+	entries, err := os.ReadDir(d.activemountsdir(volumeName))
 	if err != nil {
-		log.Errorf("Failed to RemoveAll main directory: %v", err)
-		return internalError("failed to RemoveAll volume main directory", err)
+		return d.internalError("failed to list activemounts/ after unmount", err, "volume", volumeName)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	d.logger.Warn("Volume is unmounted but active mount files remain. Removing them", "volume", volumeName,
+		"activeMounts", names)
+
+	var errs []error
+	for _, name := range names {
+		if err := os.Remove(d.activemountsdir(volumeName) + name); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return d.internalError("failed to remove leftover active mount files", err, "volume", volumeName)
 	}
 	return nil
 }
 
 // volumeTreePreMount creates the directories in the volume's directory tree that should only exist when the volume
-// is mounted.
+// is mounted. If the volume (with the metadata `vol`) is volatile, its previous changes are discarded.
 //
 // If either the mountpoint or the workdir directory already exists, it is logged as a warning but not considered
 // an error.
 //
 // If errors occur, they are logged and the returned error is wrapped with `internalError`.
-func (d *DockerOnTop) volumeTreePreMount(volumeName string, discardUpper bool) error {
+func (d *DockerOnTop) volumeTreePreMount(volumeName string, vol *VolumeInfo) error {
 	mountpoint := d.mountpointdir(volumeName)
-	workdir := d.workdir(volumeName)
+	workdir := d.workdir(volumeName, vol)
 
 	err1 := os.Mkdir(mountpoint, os.ModePerm)
 	if os.IsExist(err1) {
-		log.Warningf("Mountpoint of %s already exists. It might mean that the overlay is already mounted "+
-			"but the plugin failed to detect it...", volumeName)
+		d.logger.Warn("Mountpoint already exists. It might mean that the overlay is already mounted but the plugin "+
+			"failed to detect it...", "volume", volumeName)
 		// A possible thing to do here is try to `os.Remove` mountpoint/ and create it again. In case there's no funny
 		// business going on, there's not much difference: either way it will work.
 		//
@@ -152,67 +340,122 @@ func (d *DockerOnTop) volumeTreePreMount(volumeName string, discardUpper bool) e
 	}
 	err2 := os.Mkdir(workdir, os.ModePerm)
 	if os.IsExist(err2) {
-		log.Warningf("Workdir of %s already exists. It might mean that the overlay is already mounted but "+
-			"the plugin failed to detect it...", volumeName)
+		d.logger.Warn("Workdir already exists. It might mean that the overlay is already mounted but the plugin "+
+			"failed to detect it...", "volume", volumeName)
 	}
 	err := errors.Join(err1, err2)
 	if (err1 != nil && !os.IsExist(err1)) || (err2 != nil && !os.IsExist(err2)) {
-		log.Errorf("Failed to Mkdir mountpoint, workdir: %v, %v", err1, err2)
-
 		// Attempt to clean up. Only remove the directories that we created just now
 
 		if err1 == nil {
 			cleanupErr := os.Remove(mountpoint)
 			if cleanupErr != nil {
-				log.Errorf("Failed to cleanup mountpoint: %v", cleanupErr)
+				d.logger.Error("Failed to cleanup mountpoint", "error", cleanupErr)
 			}
 		}
 		if err2 == nil {
 			cleanupErr := os.Remove(workdir)
 			if cleanupErr != nil {
-				log.Errorf("Failed to cleanup workdir: %v", cleanupErr)
+				d.logger.Error("Failed to cleanup workdir", "error", cleanupErr)
 			}
 		}
 
-		return internalError("failed to prepare internal directories", err)
+		return d.internalError("failed to prepare internal directories", err, "volume", volumeName, "mountpointError",
+			err1, "workdirError", err2)
 	}
 
 	// For volatile volume, discard previous changes
-	if discardUpper {
-		upperdir := d.upperdir(volumeName)
+	if vol.Volatile {
+		upperdir := d.upperdir(volumeName, vol)
 
 		err = os.RemoveAll(upperdir)
 		if err != nil {
-			log.Errorf("Failed to RemoveAll upperdir (for volatile): %v", err)
-			return internalError("failed to discard previous changes", err)
+			return d.internalError("failed to discard previous changes", err, "volume", volumeName)
 		}
 		err = os.Mkdir(upperdir, os.ModePerm)
 		if err != nil {
-			log.Errorf("Failed to Mkdir upperdir (for volatile): %v", err)
-			return internalError("failed to create upperdir after discarding changes", err)
+			return d.internalError("failed to create upperdir after discarding changes", err, "volume", volumeName)
 		}
 	}
 
 	return nil
 }
 
-// volumeTreePostUnmount removes the directories in the volume's directory tree that should only exist when the volume
-// is mounted.
+// volumeTreePostUnmount removes the directories in the directory tree of the volume with the metadata `vol` that should
+// only exist when the volume is mounted.
 //
 // It removes the mountpoint directory (non-recursively: must be empty) and the workdir directory (recursively: all of
-// its contents is deleted). No action is taken regarding upperdir, regardless of the volume's volatility.
+// its contents is deleted). No action is taken regarding upperdir, regardless of the volume's volatility, unless it is
+// an in-memory one: then its tmpfs is unmounted (see tmpfsUpper.go).
 //
 // Removal of both directories is attempted regardless of errors with the other directory. Errors, if any, are logged,
 // combined with `errors.Join` and returned (wrapped with `internalError`).
 //
 // Note: for technical reasons, the absence of the workdir directory is not considered an error.
-func (d *DockerOnTop) volumeTreePostUnmount(volumeName string) error {
+func (d *DockerOnTop) volumeTreePostUnmount(volumeName string, vol *VolumeInfo) error {
 	err1 := os.Remove(d.mountpointdir(volumeName))
-	err2 := os.RemoveAll(d.workdir(volumeName))
-	err := errors.Join(err1, err2)
+	err2 := os.RemoveAll(d.workdir(volumeName, vol))
+	err3 := d.unmountUpperTmpfs(volumeName)
+	err := errors.Join(err1, err2, err3)
+	if err != nil {
+		return d.internalError("failed to cleanup on unmount", err, "volume", volumeName, "mountpointError", err1,
+			"workdirError", err2, "tmpfsError", err3)
+	}
+	return nil
+}
+
+// testWriteToUpper checks that the upperdir of the volume with the metadata `vol` is writable: a small test file is
+// written to it, read back, and removed. This catches the cases like a read-only or full filesystem before a container
+// starts, rather than having the container fail at runtime.
+//
+// The returned error (if any) is meant to be shown to the end user. Nothing is logged.
+func (d *DockerOnTop) testWriteToUpper(volumeName string, vol *VolumeInfo) error {
+	id, err := newUUID()
+	if err != nil {
+		return d.internalError("failed to generate a name for the write test file", err)
+	}
+	testFile := d.upperdir(volumeName, vol) + ".docker-on-top-write-test-" + id
+	payload := []byte("docker-on-top write test")
+
+	err = os.WriteFile(testFile, payload, 0o600)
+	if err == nil {
+		var readBack []byte
+		readBack, err = os.ReadFile(testFile)
+		if err == nil && !bytes.Equal(readBack, payload) {
+			err = errors.New("the data read back differs from the data written")
+		}
+	}
+	// Attempt removal even if the previous steps failed, as the file might have been (partially) created
+	removeErr := os.Remove(testFile)
+	if err == nil && removeErr != nil {
+		err = removeErr
+	}
+
 	if err != nil {
-		log.Errorf("Cleanup of %s failed. Errors for mountpoint, workdir: %v, %v", volumeName, err1, err2)
-		return internalError("failed to cleanup on unmount", err)
+		return fmt.Errorf("cannot write to upper directory: %s: %w", errnoName(err), err)
 	}
 	return nil
 }
+
+// errnoName returns the symbolic name (like "EROFS") of the errno the error is caused by, for the most common causes
+// of write failures. For other errors, the error message is returned.
+func errnoName(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EROFS:
+			return "EROFS"
+		case syscall.ENOSPC:
+			return "ENOSPC"
+		case syscall.EDQUOT:
+			return "EDQUOT"
+		case syscall.EACCES:
+			return "EACCES"
+		case syscall.EPERM:
+			return "EPERM"
+		case syscall.EIO:
+			return "EIO"
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,85 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestPathGetters(t *testing.T) {
+	m := NewMockSyscallMount()
+	root := t.TempDir()
+	d, err := NewDockerOnTop(context.Background(), root, WithoutOverlayProbe(), WithLogger(newTestLogger(t)),
+		WithMockSyscallMount(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Errorf("Failed to close the driver: %v", err)
+		}
+	}()
+	if dotRootDir := d.DotRootDirPath(); dotRootDir != root+"/" {
+		t.Errorf("DotRootDirPath = %s, want %s", dotRootDir, root+"/")
+	}
+
+	storage := t.TempDir()
+	for name, options := range map[string]map[string]string{
+		"default":              {},
+		"custom":               {"upper": storage + "/upper", "work": storage + "/work"},
+		"in-memory":            {"volatile": "true", "tmpfs-size": "1M"},
+		"namespace.namespaced": {"namespaced": "true"},
+	} {
+		options["base"] = t.TempDir()
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		upperdir, workdir, mountpoint := d.VolumeUpperDir(name), d.VolumeWorkDir(name), d.VolumeMountpointDir(name)
+		for _, dir := range []string{upperdir, workdir, mountpoint} {
+			if !strings.HasSuffix(dir, "/") || filepath.Clean(dir) == dir {
+				t.Errorf("%s: %q is not a clean path with a trailing slash", name, dir)
+			}
+		}
+		if !strings.HasPrefix(mountpoint, d.DotRootDirPath()) {
+			t.Errorf("%s: the mountpoint %s is outside of the dot root directory", name, mountpoint)
+		}
+
+		response, err := d.Mount(&volume.MountRequest{Name: name, ID: "container"})
+		if err != nil {
+			t.Fatalf("%s: Mount failed: %v", name, err)
+		}
+		if response.Mountpoint != mountpoint {
+			t.Errorf("%s: Mount returned %s, VolumeMountpointDir %s", name, response.Mountpoint, mountpoint)
+		}
+		options, ok := m.Mounted(mountpoint)
+		if !ok {
+			t.Errorf("%s: the overlay is not mounted at %s", name, mountpoint)
+		}
+		for _, want := range []string{"upperdir=" + upperdir, "workdir=" + workdir} {
+			if !containsOption(options, want) {
+				t.Errorf("%s: the overlay is mounted with %q, want %q among the options", name, options, want)
+			}
+		}
+		if info, err := os.Stat(workdir); err != nil || !info.IsDir() {
+			t.Errorf("%s: while mounted, the workdir is %v, %v; want a directory", name, info, err)
+		}
+		MustUnmountVolume(t, d, name, "container")
+	}
+	if upperdir := d.VolumeUpperDir("custom"); upperdir != storage+"/upper/" {
+		t.Errorf("VolumeUpperDir of the volume with a custom upperdir = %s, want %s", upperdir, storage+"/upper/")
+	}
+	want := root + "/namespace/namespaced/mountpoint/"
+	if mountpoint := d.VolumeMountpointDir("namespace.namespaced"); mountpoint != want {
+		t.Errorf("VolumeMountpointDir of the namespaced volume = %s, want %s", mountpoint, want)
+	}
+
+	if upperdir, workdir := d.VolumeUpperDir("missing"), d.VolumeWorkDir("missing"); upperdir != "" || workdir != "" {
+		t.Errorf("For a missing volume, VolumeUpperDir, VolumeWorkDir = %q, %q; want empty strings", upperdir, workdir)
+	}
+}
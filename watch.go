@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// VolumeEventType is the kind of a `VolumeEvent`
+type VolumeEventType string
+
+const (
+	// MountedEvent is sent when a container starts using the volume
+	MountedEvent VolumeEventType = "mounted"
+	// UnmountedEvent is sent when a container stops using the volume
+	UnmountedEvent VolumeEventType = "unmounted"
+)
+
+// VolumeEvent is a change of a volume's state, as reported by `DockerOnTop.WatchVolume` and `DockerOnTop.WatchAll`
+type VolumeEvent struct {
+	Type   VolumeEventType
+	Volume string
+	// ContainerID is the ID of the container that started or stopped using the volume
+	ContainerID string
+	// Timestamp is the time the plugin noticed the event
+	Timestamp time.Time
+}
+
+// WatchVolume sends an event on the returned channel every time a container starts or stops using the volume (that is,
+// when an active mount file is created or removed; see volumeTreeManagement.go). The events are detected with inotify,
+// so the mounts made by any process serving the same dot root directory are reported.
+//
+// The channel is closed when `ctx` is cancelled, the volume is removed, or the driver is closed.
+func (d *DockerOnTop) WatchVolume(ctx context.Context, volumeName string) (<-chan VolumeEvent, error) {
+	if _, err := d.getVolumeInfo(volumeName); os.IsNotExist(err) {
+		return nil, errors.New("no such volume")
+	} else if err != nil {
+		return nil, err
+	}
+
+	w, err := d.newVolumeWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.addVolume(volumeName); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	d.goBackground(func() { w.run(ctx) })
+	return w.events, nil
+}
+
+// WatchAll is like `WatchVolume` for all the volumes, including the ones created after the call (except for the
+// namespaced ones, which are only watched if they exist at the time of the call). The mounts of a new volume made
+// before it started being watched are reported once it is. The channel is only closed when `ctx` is cancelled or the
+// driver is closed (or the dot root directory is removed).
+func (d *DockerOnTop) WatchAll(ctx context.Context) (<-chan VolumeEvent, error) {
+	w, err := d.newVolumeWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// New volumes' main directories appear by renaming (see `volumeTreeCreate`)
+	w.dotRootWd, err = unix.InotifyAddWatch(w.fd, d.dotRootDir, unix.IN_CREATE|unix.IN_MOVED_TO|unix.IN_ONLYDIR)
+	if err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	volumeNames, err := d.listVolumeNames()
+	if err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	for _, volumeName := range volumeNames {
+		if _, err := w.addVolume(volumeName); err != nil {
+			d.logger.Warn("Failed to watch the volume", "volume", volumeName, "error", err)
+		}
+	}
+	d.goBackground(func() { w.run(ctx) })
+	return w.events, nil
+}
+
+// volumeWatcher is an inotify instance watching the activemounts/ directories of the volumes (and, optionally, the dot
+// root directory for new volumes).
+type volumeWatcher struct {
+	d *DockerOnTop
+	// fd is the inotify file descriptor. `file` wraps it for reading, so that the reads can be interrupted by closing
+	fd   int
+	file *os.File
+	// dotRootWd is the watch descriptor of the dot root directory, or -1 if it's not watched
+	dotRootWd int
+	// volumes maps the watch descriptors of the activemounts/ directories to the volume names
+	volumes map[int]string
+	// preexisting are the active mounts (by watch descriptor) reported by `sendExistingMounts`, whose creation events
+	// may still be queued and must not be reported again
+	preexisting map[int]map[string]bool
+	events      chan VolumeEvent
+}
+
+func (d *DockerOnTop) newVolumeWatcher() (*volumeWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	return &volumeWatcher{
+		d:           d,
+		fd:          fd,
+		file:        os.NewFile(uintptr(fd), "inotify"),
+		dotRootWd:   -1,
+		volumes:     map[int]string{},
+		preexisting: map[int]map[string]bool{},
+		events:      make(chan VolumeEvent),
+	}, nil
+}
+
+func (w *volumeWatcher) addVolume(volumeName string) (int, error) {
+	wd, err := unix.InotifyAddWatch(w.fd, w.d.activemountsdir(volumeName), unix.IN_CREATE|unix.IN_DELETE|unix.IN_ONLYDIR)
+	if err != nil {
+		return -1, err
+	}
+	w.volumes[wd] = volumeName
+	return wd, nil
+}
+
+// run reads the inotify events and sends the corresponding volume events until `ctx` (or the driver's context) is
+// cancelled or there's nothing left to watch. Then the inotify instance and the events channel are closed.
+func (w *volumeWatcher) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(w.d.ctx, cancel)
+	defer stop()
+
+	defer close(w.events)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// Closing the file interrupts the pending read
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		w.file.Close()
+	}()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.d.logger.Error("Failed to read inotify events", "error", err)
+			}
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+			if !w.handle(ctx, int(event.Wd), event.Mask, name) {
+				return
+			}
+		}
+	}
+}
+
+// sendExistingMounts sends a `MountedEvent` for each active mount of a volume that has just started being watched: the
+// new volume may have been mounted before its watch was added. It returns false if the watching must stop.
+func (w *volumeWatcher) sendExistingMounts(ctx context.Context, wd int, volumeName string) bool {
+	dir, err := os.Open(w.d.activemountsdir(volumeName))
+	if err != nil {
+		return true
+	}
+	ids, err := dir.Readdirnames(-1)
+	_ = dir.Close()
+	if err != nil {
+		return true
+	}
+	w.preexisting[wd] = map[string]bool{}
+	for _, id := range ids {
+		w.preexisting[wd][id] = true
+		select {
+		case w.events <- VolumeEvent{Type: MountedEvent, Volume: volumeName, ContainerID: id, Timestamp: time.Now()}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// handle processes an inotify event. It returns false if the watching must stop.
+func (w *volumeWatcher) handle(ctx context.Context, wd int, mask uint32, name string) bool {
+	if wd == w.dotRootWd {
+		if mask&unix.IN_IGNORED != 0 {
+			return false
+		}
+		if mask&unix.IN_ISDIR != 0 && !isScratchDir(name) {
+			wd, err := w.addVolume(name)
+			if err != nil {
+				w.d.logger.Warn("Failed to watch the new volume", "volume", name, "error", err)
+				return true
+			}
+			return w.sendExistingMounts(ctx, wd, name)
+		}
+		return true
+	}
+
+	volumeName, ok := w.volumes[wd]
+	if !ok {
+		return true
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		// The volume is removed (or its activemounts/ directory is recreated on boot)
+		delete(w.volumes, wd)
+		delete(w.preexisting, wd)
+		return w.dotRootWd != -1 || len(w.volumes) > 0
+	}
+
+	event := VolumeEvent{Volume: volumeName, ContainerID: name, Timestamp: time.Now()}
+	switch {
+	case mask&unix.IN_CREATE != 0:
+		if w.preexisting[wd][name] {
+			// Already reported by `sendExistingMounts`
+			delete(w.preexisting[wd], name)
+			return true
+		}
+		event.Type = MountedEvent
+	case mask&unix.IN_DELETE != 0:
+		delete(w.preexisting[wd], name)
+		event.Type = UnmountedEvent
+	default:
+		return true
+	}
+	select {
+	case w.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,115 @@
+//go:build dottest
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// watchTimeout is how long the tests wait for a volume event
+const watchTimeout = 5 * time.Second
+
+// receiveEvent returns the next event from `events`, failing the test if there's none within `watchTimeout`.
+func receiveEvent(t *testing.T, events <-chan VolumeEvent) VolumeEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("The event channel is closed")
+		}
+		return event
+	case <-time.After(watchTimeout):
+		t.Fatal("No event was received")
+	}
+	return VolumeEvent{}
+}
+
+// expectClosed fails the test unless `events` is closed within `watchTimeout` (without sending more events).
+func expectClosed(t *testing.T, events <-chan VolumeEvent) {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Errorf("Received %+v instead of the channel being closed", event)
+		}
+	case <-time.After(watchTimeout):
+		t.Error("The event channel is not closed")
+	}
+}
+
+func TestWatchVolume(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	events, err := d.WatchVolume(context.Background(), "vol")
+	if err != nil {
+		t.Fatalf("WatchVolume failed: %v", err)
+	}
+
+	start := time.Now()
+	MustMountVolume(t, d, "vol", "container")
+	event := receiveEvent(t, events)
+	if event.Type != MountedEvent || event.Volume != "vol" || event.ContainerID != "container" ||
+		event.Timestamp.Before(start) {
+		t.Errorf("After the mount, received %+v", event)
+	}
+	MustUnmountVolume(t, d, "vol", "container")
+	if event := receiveEvent(t, events); event.Type != UnmountedEvent || event.ContainerID != "container" {
+		t.Errorf("After the unmount, received %+v", event)
+	}
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, events)
+}
+
+func TestWatchVolumeCancelled(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "vol", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := d.WatchVolume(ctx, "vol")
+	if err != nil {
+		t.Fatalf("WatchVolume failed: %v", err)
+	}
+	cancel()
+	expectClosed(t, events)
+
+	if _, err := d.WatchVolume(context.Background(), "missing"); err == nil {
+		t.Error("Watching a nonexistent volume succeeded")
+	}
+}
+
+func TestWatchAll(t *testing.T) {
+	d := NewTestDockerOnTop(t)
+	MustCreateVolume(t, d, "existing", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := d.WatchAll(ctx)
+	if err != nil {
+		t.Fatalf("WatchAll failed: %v", err)
+	}
+
+	MustMountVolume(t, d, "existing", "first")
+	// The volumes created after the call are watched as well, even if they are mounted before the watcher (blocked on
+	// sending the event of the first mount) gets to them
+	MustCreateVolume(t, d, "new", t.TempDir())
+	MustMountVolume(t, d, "new", "second")
+	if event := receiveEvent(t, events); event.Type != MountedEvent || event.Volume != "existing" {
+		t.Errorf("After mounting the existing volume, received %+v", event)
+	}
+	if event := receiveEvent(t, events); event.Type != MountedEvent || event.Volume != "new" ||
+		event.ContainerID != "second" {
+		t.Errorf("After mounting the new volume, received %+v", event)
+	}
+	// The mount is reported only once
+	MustUnmountVolume(t, d, "new", "second")
+	if event := receiveEvent(t, events); event.Type != UnmountedEvent || event.Volume != "new" {
+		t.Errorf("After unmounting the new volume, received %+v", event)
+	}
+
+	cancel()
+	expectClosed(t, events)
+}